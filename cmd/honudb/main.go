@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"text/tabwriter"
@@ -8,6 +9,7 @@ import (
 	"go.rtnl.ai/honu/pkg"
 	"go.rtnl.ai/honu/pkg/config"
 	"go.rtnl.ai/honu/pkg/server"
+	"go.rtnl.ai/honu/pkg/store"
 
 	"github.com/joho/godotenv"
 	confire "github.com/rotationalio/confire/usage"
@@ -45,6 +47,19 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:     "check",
+			Usage:    "check the integrity of the database while the replica is offline",
+			Category: "store",
+			Action:   check,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "repair",
+					Aliases: []string{"r"},
+					Usage:   "repair the problems that can be safely fixed",
+				},
+			},
+		},
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -88,3 +103,37 @@ func usage(c *cli.Context) error {
 	tabs.Flush()
 	return nil
 }
+
+//===========================================================================
+// Store Commands
+//===========================================================================
+
+func check(c *cli.Context) (err error) {
+	var conf config.Config
+	if conf, err = config.New(); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	var report *store.Report
+	if report, err = store.Check(c.Context, conf, c.Bool("repair")); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	if len(report.Problems) > 0 {
+		tabs := tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
+		fmt.Fprintln(tabs, "Problem\tCollection\tKey\tDetail\tRepaired")
+		for _, problem := range report.Problems {
+			fmt.Fprintf(tabs, "%s\t%s\t%x\t%s\t%t\n", problem.Type, problem.Collection, problem.Key, problem.Detail, problem.Repaired)
+		}
+		tabs.Flush()
+		fmt.Println()
+	}
+
+	unrepaired := len(report.Unrepaired())
+	fmt.Printf("checked %d collections and %d objects: found %d problems, %d repaired\n", report.Collections, report.Objects, len(report.Problems), len(report.Problems)-unrepaired)
+
+	if unrepaired > 0 {
+		return cli.Exit(fmt.Sprintf("%d problems were not repaired", unrepaired), 1)
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/rotationalio/confire"
@@ -31,9 +33,23 @@ type Config struct {
 }
 
 type StoreConfig struct {
-	ReadOnly    bool   `default:"false" split_words:"false" desc:"open the the underlying data store in read-only mode"`
-	DataPath    string `required:"true" split_words:"true" desc:"path to directory where data is stored (created if it doesn't exist)"`
-	Concurrency uint32 `default:"1024" desc:"number of concurrent read/write locks allowed for managing transactions"`
+	ReadOnly             bool          `default:"false" split_words:"false" desc:"open the the underlying data store in read-only mode"`
+	Engine               string        `default:"bolt" desc:"the storage engine used to persist data; either bolt (on disk) or memory (ephemeral)"`
+	DataPath             string        `required:"true" split_words:"true" desc:"path to directory where data is stored (created if it doesn't exist)"`
+	Concurrency          uint32        `default:"1024" desc:"number of concurrent read transactions admitted by the store; writers are admitted one at a time (0 is unlimited)"`
+	AdmissionTimeout     time.Duration `split_words:"true" default:"0" desc:"maximum time a transaction waits to be admitted before it is rejected (0 waits until the request is canceled)"`
+	CompactionInterval   time.Duration `split_words:"true" default:"1h" desc:"how often superseded versions are pruned based on collection retention policies (0 disables compaction)"`
+	ReapInterval         time.Duration `split_words:"true" default:"1m" desc:"how often objects whose time-to-live has passed are tombstoned (0 disables the reaper)"`
+	GCInterval           time.Duration `split_words:"true" default:"1h" desc:"how often tombstones that have been replicated to all peers are permanently removed (0 disables tombstone collection)"`
+	TombstoneGracePeriod time.Duration `split_words:"true" default:"0" desc:"if no peers are tracked, tombstones older than this are considered replicated (0 keeps tombstones until peers acknowledge them)"`
+	LockTimeout          time.Duration `split_words:"true" default:"0" desc:"how long to wait for the lock on the bolt data file when the store is opened (0 waits indefinitely)"`
+	NoSync               bool          `split_words:"true" default:"false" desc:"skip the fsync after each bolt commit; unsafe, committed writes may be lost if the host crashes"`
+	NoFreelistSync       bool          `split_words:"true" default:"false" desc:"do not sync the bolt freelist to disk; faster writes but slower startup after an unclean shutdown"`
+	InitialMmapSize      int           `split_words:"true" default:"0" desc:"initial size in bytes of the bolt memory map; avoids remapping as the data file grows (0 uses the bolt default)"`
+	FreelistType         string        `split_words:"true" default:"array" desc:"the bolt freelist backend; either array or map (faster for large, fragmented data files)"`
+	PageSize             int           `split_words:"true" default:"0" desc:"page size in bytes of new bolt data files (0 uses the OS page size)"`
+	MaxBatchSize         int           `split_words:"true" default:"0" desc:"maximum number of writes committed together by a group commit (0 uses the bolt default)"`
+	MaxBatchDelay        time.Duration `split_words:"true" default:"0" desc:"maximum time a group commit waits for more writes before committing (0 uses the bolt default)"`
 }
 
 func New() (conf Config, err error) {
@@ -54,10 +70,45 @@ func (c Config) IsZero() bool {
 	return !c.processed
 }
 
+// Storage engines that can be specified in the store configuration.
+const (
+	EngineBolt   = "bolt"
+	EngineMemory = "memory"
+)
+
+// Freelist types of the bolt storage engine.
+const (
+	FreelistArray = "array"
+	FreelistMap   = "map"
+)
+
+var (
+	ErrInvalidEngine   = errors.New("invalid configuration: unknown storage engine")
+	ErrInvalidFreelist = errors.New("invalid configuration: unknown freelist type")
+)
+
 // Custom validations are added here, particularly validations that require one or more
 // fields to be processed before the validation occurs.
 func (c Config) Validate() (err error) {
-	return err
+	if err = c.Store.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c StoreConfig) Validate() error {
+	switch c.Engine {
+	case "", EngineBolt, EngineMemory:
+	default:
+		return fmt.Errorf("%w %q", ErrInvalidEngine, c.Engine)
+	}
+
+	switch c.FreelistType {
+	case "", FreelistArray, FreelistMap:
+	default:
+		return fmt.Errorf("%w %q", ErrInvalidFreelist, c.FreelistType)
+	}
+	return nil
 }
 
 func (c Config) GetLogLevel() zerolog.Level {
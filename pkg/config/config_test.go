@@ -3,6 +3,7 @@ package config_test
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/require"
@@ -10,13 +11,19 @@ import (
 )
 
 var testEnv = map[string]string{
-	"HONU_PID":             "24",
-	"HONU_MAINTENANCE":     "true",
-	"HONU_LOG_LEVEL":       "debug",
-	"HONU_CONSOLE_LOG":     "true",
-	"HONU_BIND_ADDR":       "127.0.0.1:443",
-	"HONU_STORE_READONLY":  "true",
-	"HONU_STORE_DATA_PATH": "/tmp/honu",
+	"HONU_PID":                    "24",
+	"HONU_MAINTENANCE":            "true",
+	"HONU_LOG_LEVEL":              "debug",
+	"HONU_CONSOLE_LOG":            "true",
+	"HONU_BIND_ADDR":              "127.0.0.1:443",
+	"HONU_STORE_READONLY":         "true",
+	"HONU_STORE_DATA_PATH":        "/tmp/honu",
+	"HONU_STORE_ENGINE":           "memory",
+	"HONU_STORE_LOCK_TIMEOUT":     "5s",
+	"HONU_STORE_NO_FREELIST_SYNC": "true",
+	"HONU_STORE_FREELIST_TYPE":    "map",
+	"HONU_STORE_PAGE_SIZE":        "16384",
+	"HONU_STORE_MAX_BATCH_DELAY":  "2ms",
 }
 
 func TestConfig(t *testing.T) {
@@ -36,6 +43,32 @@ func TestConfig(t *testing.T) {
 	require.Equal(t, testEnv["HONU_BIND_ADDR"], conf.BindAddr)
 	require.True(t, conf.Store.ReadOnly)
 	require.Equal(t, testEnv["HONU_STORE_DATA_PATH"], conf.Store.DataPath)
+	require.Equal(t, config.EngineMemory, conf.Store.Engine)
+	require.Equal(t, 5*time.Second, conf.Store.LockTimeout)
+	require.False(t, conf.Store.NoSync)
+	require.True(t, conf.Store.NoFreelistSync)
+	require.Equal(t, config.FreelistMap, conf.Store.FreelistType)
+	require.Equal(t, 16384, conf.Store.PageSize)
+	require.Equal(t, 2*time.Millisecond, conf.Store.MaxBatchDelay)
+	require.Zero(t, conf.Store.MaxBatchSize)
+}
+
+func TestInvalidEngine(t *testing.T) {
+	t.Cleanup(cleanupEnv())
+	setEnv()
+	os.Setenv("HONU_STORE_ENGINE", "lsm")
+
+	_, err := config.New()
+	require.ErrorIs(t, err, config.ErrInvalidEngine)
+}
+
+func TestInvalidFreelist(t *testing.T) {
+	t.Cleanup(cleanupEnv())
+	setEnv()
+	os.Setenv("HONU_STORE_FREELIST_TYPE", "hashmap")
+
+	_, err := config.New()
+	require.ErrorIs(t, err, config.ErrInvalidFreelist)
 }
 
 // Returns the current environment for the specified keys, or if no keys are specified
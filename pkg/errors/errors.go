@@ -12,20 +12,37 @@ var ErrNotImplemented = Status(http.StatusNotImplemented, "not feature has not b
 // Storage and query errors directly related to database operations.
 var (
 	ErrNotFound             = Status(http.StatusNotFound, "object not found")
+	ErrVersionNotFound      = Status(http.StatusNotFound, "specified version of object does not exist")
 	ErrReadOnlyDB           = Status(http.StatusUnprocessableEntity, "cannot execute operation in readonly mode")
 	ErrReadOnlyTx           = Status(http.StatusUnprocessableEntity, "cannot execute operation: transaction is read only")
+	ErrPinnedWriteTx        = Status(http.StatusBadRequest, "point-in-time transactions must be read only")
 	ErrClosed               = Status(http.StatusGone, "database engine has been closed")
 	ErrTxClosed             = Status(http.StatusGone, "transaction has already been committed or rolled back")
+	ErrEmptyInTx            = Status(http.StatusUnprocessableEntity, "cannot empty a collection in a transaction: use Store.Empty to empty it in batches")
 	ErrAlreadyExists        = Status(http.StatusConflict, "specified key already exists")
+	ErrVersionConflict      = Status(http.StatusConflict, "latest version of object does not match expected version")
+	ErrMissingBlob          = Status(http.StatusInternalServerError, "object payload is missing from the collection")
+	ErrChecksum             = Status(http.StatusInternalServerError, "object data does not match its length or checksum")
 	ErrNoCollection         = Status(http.StatusNotFound, "collection with specified ID or name does not exist")
 	ErrCollectionExists     = Status(http.StatusConflict, "collection with specified name already exists")
 	ErrCollectionIdentifier = Status(http.StatusBadRequest, "collection identifier must be a name or ULID")
 	ErrRepairCollection     = Status(http.StatusInternalServerError, "collection is malformed or incorrectly initialized requiring repair")
 	ErrNotSupported         = Status(http.StatusNotImplemented, "operation not supported")
 	ErrCreateID             = Status(http.StatusBadRequest, "cannot specify ID when creating new object")
+	ErrMissingObjectID      = Status(http.StatusBadRequest, "object ID is required to update an object")
+	ErrMissingVersion       = Status(http.StatusBadRequest, "replicated object must have a version")
+	ErrInvalidRetention     = Status(http.StatusBadRequest, "retention policy must specify a positive number of versions or duration")
+	ErrInvalidTTL           = Status(http.StatusBadRequest, "time-to-live must not be negative")
 	ErrIDMismatch           = Status(http.StatusBadRequest, "specified ID does not match resource ID")
 	ErrNameMismatch         = Status(http.StatusBadRequest, "specified name does not match resource name")
 	ErrNotInitialized       = Status(http.StatusInternalServerError, "store has not been properly initialized with system state")
+	ErrInvalidBackup        = Status(http.StatusBadRequest, "backup is malformed, truncated, or does not match its checksum")
+	ErrIncompatibleBackup   = Status(http.StatusConflict, "backup is not compatible with this version of the store")
+	ErrBackupPruned         = Status(http.StatusConflict, "removals since the previous backup were pruned by a later backup: take a full backup")
+	ErrAdmissionTimeout     = Status(http.StatusServiceUnavailable, "timed out waiting for the store to admit the transaction")
+	ErrManagedTx            = Status(http.StatusBadRequest, "managed transactions cannot be committed or rolled back")
+	ErrChunkedObject        = Status(http.StatusBadRequest, "chunked object data must be replicated with the object stream")
+	ErrUniqueIndex          = Status(http.StatusConflict, "another object already has the value of a unique indexed field")
 )
 
 // Access control errors
@@ -9,6 +9,7 @@ import (
 	"go.rtnl.ai/honu/pkg/errors"
 	"go.rtnl.ai/honu/pkg/mime"
 	"go.rtnl.ai/honu/pkg/server/render"
+	"go.rtnl.ai/honu/pkg/store"
 	"go.rtnl.ai/honu/pkg/store/metadata"
 	"go.rtnl.ai/ulid"
 )
@@ -19,7 +20,7 @@ func (s *Server) ListCollections(w http.ResponseWriter, r *http.Request, _ httpr
 		collections []*metadata.Collection
 	)
 
-	if collections, err = s.db.Collections(); err != nil {
+	if collections, err = s.db.Collections(r.Context()); err != nil {
 		render.Error(w, r, err)
 		return
 	}
@@ -40,7 +41,7 @@ func (s *Server) CreateCollection(w http.ResponseWriter, r *http.Request, _ http
 
 	// TODO: validate collection name and fields
 
-	if err = s.db.New(collection); err != nil {
+	if err = s.db.New(r.Context(), collection); err != nil {
 		render.Error(w, r, err)
 		return
 	}
@@ -57,7 +58,7 @@ func (s *Server) RetrieveCollection(w http.ResponseWriter, r *http.Request, q ht
 
 	// Attempt to parse the identifier as a ULID first, otherwise use it as a name string.
 	identifier = parseIdentifier(q[0])
-	if collection, err = s.db.Collection(identifier); err != nil {
+	if collection, err = s.db.Collection(r.Context(), identifier); err != nil {
 		render.Error(w, r, err)
 		return
 	}
@@ -102,7 +103,7 @@ func (s *Server) UpdateCollection(w http.ResponseWriter, r *http.Request, q http
 
 	// TODO: validate collection name and fields
 
-	if err = s.db.Modify(collection); err != nil {
+	if err = s.db.Modify(r.Context(), collection); err != nil {
 		render.Error(w, r, err)
 		return
 	}
@@ -130,12 +131,12 @@ func (s *Server) DeleteCollection(w http.ResponseWriter, r *http.Request, q http
 	operation = strings.ToLower(strings.TrimSpace(params.Get("operation")))
 	switch operation {
 	case "drop":
-		if err = s.db.Drop(identifier); err != nil {
+		if err = s.db.Drop(r.Context(), identifier); err != nil {
 			render.Error(w, r, err)
 			return
 		}
 	case "truncate":
-		if err = s.db.Truncate(identifier); err != nil {
+		if err = s.db.Truncate(r.Context(), identifier); err != nil {
 			render.Error(w, r, err)
 			return
 		}
@@ -146,6 +147,38 @@ func (s *Server) DeleteCollection(w http.ResponseWriter, r *http.Request, q http
 		render.Error(w, r, errors.Status(http.StatusBadRequest, "invalid operation parameter"))
 		return
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) CollectionStats(w http.ResponseWriter, r *http.Request, q httprouter.Params) {
+	var (
+		err        error
+		identifier any
+		tx         *store.Tx
+		collection *store.Collection
+		stats      *store.CollectionStats
+	)
+
+	// Attempt to parse the identifier as a ULID first, otherwise use it as a name string.
+	identifier = parseIdentifier(q[0])
+
+	if tx, err = s.db.Begin(r.Context(), &store.TxOptions{ReadOnly: true}); err != nil {
+		render.Error(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if collection, err = tx.Collection(identifier); err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	if stats, err = collection.Stats(); err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	render.Negotiate(r).Render(http.StatusOK, w, stats)
 }
 
 func (s *Server) ListIndexes(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {}
@@ -21,6 +21,7 @@ func (s *Server) setupRoutes() (err error) {
 	// API Routes
 	// Status/Heartbeat endpoint
 	s.addRoute(http.MethodGet, "/v1/status", s.Status, middleware...)
+	s.addRoute(http.MethodGet, "/v1/admission", s.Admission, middleware...)
 
 	// Collections resource
 	s.addRoute(http.MethodGet, "/v1/collections", s.ListCollections, middleware...)
@@ -28,6 +29,7 @@ func (s *Server) setupRoutes() (err error) {
 	s.addRoute(http.MethodGet, "/v1/collections/:collectionID", s.RetrieveCollection, middleware...)
 	s.addRoute(http.MethodPut, "/v1/collections/:collectionID", s.UpdateCollection, middleware...)
 	s.addRoute(http.MethodDelete, "/v1/collections/:collectionID", s.DeleteCollection, middleware...)
+	s.addRoute(http.MethodGet, "/v1/collections/:collectionID/stats", s.CollectionStats, middleware...)
 
 	// Indexes resource
 	s.addRoute(http.MethodGet, "/v1/collections/:collectionID/indexes", s.ListIndexes, middleware...)
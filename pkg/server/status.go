@@ -38,6 +38,12 @@ func (s *Server) Status(w http.ResponseWriter, r *http.Request, _ httprouter.Par
 	})
 }
 
+// Admission reports the transaction admission queues of the store so that operators
+// can detect when the store is saturated.
+func (s *Server) Admission(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	render.Negotiate(r).Render(http.StatusOK, w, s.db.Admission())
+}
+
 // Healthz is used to alert k8s to the health/liveness status of the server.
 func (s *Server) Healthz(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	s.RLock()
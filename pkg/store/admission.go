@@ -0,0 +1,277 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store/engine"
+)
+
+//===========================================================================
+// Transaction Admission Control
+//===========================================================================
+
+// AdmissionStats reports the state of the transaction admission queues of the store so
+// that operators can detect when the store is saturated. Readers and writers are
+// queued separately: up to StoreConfig.Concurrency readers are admitted at a time and
+// writers are admitted one at a time (since the storage engine serializes writers) in
+// the order that they began. Batched writes (see Store.Batch) are committed together by
+// the engine, so up to StoreConfig.Concurrency batch calls are admitted at a time.
+type AdmissionStats struct {
+	ActiveReaders    int           `json:"active_readers"`     // read transactions that are open
+	ActiveWriters    int           `json:"active_writers"`     // write transactions that are open (at most one)
+	QueuedReaders    int           `json:"queued_readers"`     // read transactions waiting to be admitted
+	QueuedWriters    int           `json:"queued_writers"`     // write transactions waiting to be admitted
+	ActiveBatches    int           `json:"active_batches"`     // batch calls waiting to be committed
+	QueuedBatches    int           `json:"queued_batches"`     // batch calls waiting to be admitted
+	MaxQueuedReaders int           `json:"max_queued_readers"` // the largest reader queue depth observed
+	MaxQueuedWriters int           `json:"max_queued_writers"` // the largest writer queue depth observed
+	MaxQueuedBatches int           `json:"max_queued_batches"` // the largest batch queue depth observed
+	Admitted         uint64        `json:"admitted"`           // transactions admitted, including those that waited
+	Waited           uint64        `json:"waited"`             // transactions that were queued before being admitted
+	Timeouts         uint64        `json:"timeouts"`           // transactions rejected by the admission timeout
+	Canceled         uint64        `json:"canceled"`           // transactions whose context ended while queued
+	WaitTime         time.Duration `json:"wait_time"`          // total time transactions spent in the queues
+}
+
+// Admission returns the current admission statistics of the store.
+func (s *Store) Admission() AdmissionStats {
+	if s.admit == nil {
+		return AdmissionStats{}
+	}
+	return s.admit.stats()
+}
+
+// Admits transactions to the storage engine. Waiting transactions are kept in FIFO
+// queues so that writers are admitted fairly rather than in the arbitrary order that
+// goroutines acquire the engine's writer lock, and so that waiting can be abandoned
+// when the context of the transaction is canceled or the admission timeout passes.
+type admission struct {
+	sync.Mutex
+	limit   int
+	timeout time.Duration
+	readers *lane
+	writers *lane
+	batches *lane
+	metrics AdmissionStats
+}
+
+// A lane admits up to limit transactions at a time (or any number if limit is zero).
+type lane struct {
+	limit  int
+	active int
+	queue  *list.List
+	peak   int
+}
+
+type waiter struct {
+	ready    chan struct{}
+	admitted bool
+}
+
+func newAdmission(concurrency uint32, timeout time.Duration) *admission {
+	return &admission{
+		timeout: timeout,
+		readers: &lane{limit: int(concurrency), queue: list.New()},
+		writers: &lane{limit: 1, queue: list.New()},
+		batches: &lane{limit: int(concurrency), queue: list.New()},
+	}
+}
+
+// Blocks until the transaction is admitted, returning ErrAdmissionTimeout if it waited
+// longer than the admission timeout or the error of the context if it was canceled.
+// Every successful call must be paired with exactly one call to release.
+func (a *admission) acquire(ctx context.Context, lane *lane) (err error) {
+	a.Lock()
+	if lane.queue.Len() == 0 && lane.available() {
+		lane.active++
+		a.metrics.Admitted++
+		a.Unlock()
+		return nil
+	}
+
+	w := &waiter{ready: make(chan struct{})}
+	elem := lane.queue.PushBack(w)
+	if n := lane.queue.Len(); n > lane.peak {
+		lane.peak = n
+	}
+	a.Unlock()
+
+	var timeout <-chan time.Time
+	if a.timeout > 0 {
+		timer := time.NewTimer(a.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	started := time.Now()
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = errors.ErrAdmissionTimeout
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	a.metrics.WaitTime += time.Since(started)
+
+	if err != nil {
+		// The transaction may have been admitted while the wait was abandoned, in which
+		// case the slot is handed to the next waiter.
+		if w.admitted {
+			lane.active--
+			lane.admit(&a.metrics)
+		} else {
+			lane.queue.Remove(elem)
+		}
+
+		if errors.Is(err, errors.ErrAdmissionTimeout) {
+			a.metrics.Timeouts++
+		} else {
+			a.metrics.Canceled++
+		}
+		return err
+	}
+
+	a.metrics.Waited++
+	return nil
+}
+
+// Releases the slot of an admitted transaction and admits the next waiting transaction.
+func (a *admission) release(lane *lane) {
+	a.Lock()
+	defer a.Unlock()
+
+	lane.active--
+	lane.admit(&a.metrics)
+}
+
+func (a *admission) stats() AdmissionStats {
+	a.Lock()
+	defer a.Unlock()
+
+	stats := a.metrics
+	stats.ActiveReaders, stats.QueuedReaders, stats.MaxQueuedReaders = a.readers.active, a.readers.queue.Len(), a.readers.peak
+	stats.ActiveWriters, stats.QueuedWriters, stats.MaxQueuedWriters = a.writers.active, a.writers.queue.Len(), a.writers.peak
+	stats.ActiveBatches, stats.QueuedBatches, stats.MaxQueuedBatches = a.batches.active, a.batches.queue.Len(), a.batches.peak
+	return stats
+}
+
+func (a *admission) lane(writable bool) *lane {
+	if writable {
+		return a.writers
+	}
+	return a.readers
+}
+
+func (l *lane) available() bool {
+	return l.limit <= 0 || l.active < l.limit
+}
+
+// Admits waiters from the front of the queue while there are slots available; must be
+// called while the admission lock is held.
+func (l *lane) admit(metrics *AdmissionStats) {
+	for l.queue.Len() > 0 && l.available() {
+		w := l.queue.Remove(l.queue.Front()).(*waiter)
+		w.admitted = true
+		l.active++
+		metrics.Admitted++
+		close(w.ready)
+	}
+}
+
+//===========================================================================
+// Admitted Engine Transactions
+//===========================================================================
+
+// Begins an engine transaction once it has been admitted; the admission slot is
+// released when the transaction is committed or rolled back.
+func (s *Store) begin(ctx context.Context, writable bool) (_ engine.Tx, err error) {
+	if s.db == nil {
+		return nil, errors.ErrClosed
+	}
+
+	if s.admit == nil {
+		return s.db.Begin(writable)
+	}
+
+	lane := s.admit.lane(writable)
+	if err = s.admit.acquire(ctx, lane); err != nil {
+		return nil, err
+	}
+
+	var tx engine.Tx
+	if tx, err = s.db.Begin(writable); err != nil {
+		s.admit.release(lane)
+		return nil, err
+	}
+	return &admittedTx{Tx: tx, release: func() { s.admit.release(lane) }}, nil
+}
+
+// Executes the function in a managed read-only transaction once it has been admitted.
+func (s *Store) view(ctx context.Context, fn func(engine.Tx) error) (err error) {
+	var tx engine.Tx
+	if tx, err = s.begin(ctx, false); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return fn(tx)
+}
+
+// Executes the function in a managed writable transaction once it has been admitted;
+// the transaction is committed if the function returns nil and rolled back otherwise.
+func (s *Store) update(ctx context.Context, fn func(engine.Tx) error) (err error) {
+	var tx engine.Tx
+	if tx, err = s.begin(ctx, true); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Executes the function in a writable transaction that the engine may share with other
+// concurrent calls (group commit) once the call has been admitted to the batch lane.
+func (s *Store) batch(ctx context.Context, fn func(engine.Tx) error) (err error) {
+	batcher, ok := s.db.(engine.Batcher)
+	if !ok {
+		return s.update(ctx, fn)
+	}
+
+	if s.admit != nil {
+		if err = s.admit.acquire(ctx, s.admit.batches); err != nil {
+			return err
+		}
+		defer s.admit.release(s.admit.batches)
+	}
+	return batcher.Batch(fn)
+}
+
+// Wraps an engine transaction to release its admission slot when it is closed.
+type admittedTx struct {
+	engine.Tx
+	release func()
+	once    sync.Once
+}
+
+func (t *admittedTx) Commit() (err error) {
+	// Read-only transactions cannot be committed and are still open after the error.
+	if err = t.Tx.Commit(); !errors.Is(err, engine.ErrTxNotWritable) {
+		t.once.Do(t.release)
+	}
+	return err
+}
+
+func (t *admittedTx) Rollback() (err error) {
+	err = t.Tx.Rollback()
+	t.once.Do(t.release)
+	return err
+}
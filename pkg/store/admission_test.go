@@ -0,0 +1,88 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.rtnl.ai/honu/pkg/config"
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store"
+)
+
+func TestAdmission(t *testing.T) {
+	db := openTestStore(t, config.StoreConfig{Concurrency: 2, AdmissionTimeout: 50 * time.Millisecond})
+	ctx := context.Background()
+	readOnly := &store.TxOptions{ReadOnly: true}
+
+	// Readers beyond the concurrency limit wait to be admitted.
+	alpha, err := db.Begin(ctx, readOnly)
+	require.NoError(t, err)
+	bravo, err := db.Begin(ctx, readOnly)
+	require.NoError(t, err)
+
+	_, err = db.Begin(ctx, readOnly)
+	require.ErrorIs(t, err, errors.ErrAdmissionTimeout)
+
+	canceled, cancel := context.WithCancel(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = db.Begin(canceled, readOnly)
+	require.ErrorIs(t, err, context.Canceled)
+
+	admitted := make(chan *store.Tx, 1)
+	go func() {
+		tx, err := db.Begin(ctx, &store.TxOptions{ReadOnly: true})
+		require.NoError(t, err)
+		admitted <- tx
+	}()
+
+	require.Eventually(t, func() bool { return db.Admission().QueuedReaders == 1 }, time.Second, time.Millisecond)
+	require.NoError(t, alpha.Rollback())
+
+	charlie := <-admitted
+	stats := db.Admission()
+	require.Equal(t, 2, stats.ActiveReaders)
+	require.Equal(t, 0, stats.QueuedReaders)
+	require.Equal(t, 1, stats.MaxQueuedReaders)
+	require.Equal(t, uint64(1), stats.Timeouts)
+	require.Equal(t, uint64(1), stats.Canceled)
+	require.Equal(t, uint64(1), stats.Waited)
+
+	// Rolling back a transaction more than once only releases its slot once.
+	require.NoError(t, alpha.Rollback())
+	require.Equal(t, 2, db.Admission().ActiveReaders)
+	require.NoError(t, bravo.Rollback())
+	require.NoError(t, charlie.Rollback())
+
+	// Writers are admitted one at a time in the order that they began.
+	writer, err := db.Begin(ctx, nil)
+	require.NoError(t, err)
+
+	order := make(chan int, 2)
+	for i := 1; i <= 2; i++ {
+		go func(i int) {
+			tx, err := db.Begin(context.Background(), nil)
+			require.NoError(t, err)
+			order <- i
+			require.NoError(t, tx.Commit())
+		}(i)
+		require.Eventually(t, func() bool { return db.Admission().QueuedWriters == i }, time.Second, time.Millisecond)
+	}
+
+	// Readers are not blocked by writers.
+	reader, err := db.Begin(ctx, readOnly)
+	require.NoError(t, err)
+	require.NoError(t, reader.Rollback())
+
+	require.NoError(t, writer.Commit())
+	require.Equal(t, 1, <-order)
+	require.Equal(t, 2, <-order)
+
+	require.Eventually(t, func() bool { return db.Admission().ActiveWriters == 0 }, time.Second, time.Millisecond)
+	stats = db.Admission()
+	require.Equal(t, 0, stats.ActiveReaders)
+	require.Equal(t, 0, stats.QueuedWriters)
+	require.Equal(t, 2, stats.MaxQueuedWriters)
+	require.Equal(t, uint64(3), stats.Waited)
+}
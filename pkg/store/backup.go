@@ -0,0 +1,1202 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"go.rtnl.ai/honu/pkg"
+	"go.rtnl.ai/honu/pkg/config"
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/region"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/engine/bolt"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/lamport"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/object"
+	"go.rtnl.ai/ulid"
+)
+
+//===========================================================================
+// Hot Backups
+//===========================================================================
+
+// A backup is a stream that starts with the magic bytes, the backup format, and a length
+// prefixed JSON header followed by a record for every bucket and key in the database
+// in the order they are stored. Bucket records are closed by an end record once all of
+// their keys and nested buckets have been written. The stream is terminated by a record
+// with the change vector of the backup, an EOF record, and a SHA-256 checksum of
+// everything that preceded it. Because the records are independent of the storage
+// engine, a backup can be restored to any engine.
+//
+// Incremental backups only contain the changes since the previous backup. Collections
+// that were in the previous backup are written as delta records that hold the versions
+// stored since the previous backup (with their payloads, chunks, and change log
+// entries) and delete records for the versions removed since; indexes, blob reference
+// counts, and the change index are derived from the versions and are maintained by the
+// restore. System buckets that have not changed are written as retain records, and
+// top-level buckets that are in the restored database but are neither written nor
+// retained by an incremental backup were dropped after the previous backup.
+const (
+	backupFormat     uint8 = 1
+	backupCheckEvery       = 1024
+	restoreBatchSize       = 10000
+)
+
+var backupMagic = []byte("HONUBKUP")
+
+// Backup record types.
+const (
+	recordBucket byte = 'b'
+	recordKey    byte = 'k'
+	recordDelta  byte = 'd'
+	recordDelete byte = 'x'
+	recordRetain byte = 'r'
+	recordEnd    byte = 'e'
+	recordVector byte = 'v'
+	recordEOF    byte = 'z'
+)
+
+// BackupHeader describes the replica and the storage versions that a backup was taken
+// from so that a restore can check that the backup is compatible before applying it.
+// Incremental backups contain the changes since the vector of the previous backup.
+type BackupHeader struct {
+	Version        string        `json:"version"`
+	PID            uint32        `json:"pid"`
+	Region         region.Region `json:"region"`
+	KeyVersion     uint8         `json:"key_version"`
+	StorageVersion uint8         `json:"storage_version"`
+	Engine         string        `json:"engine"`
+	Created        time.Time     `json:"created"`
+	Incremental    bool          `json:"incremental,omitempty"`
+	Since          ChangeVector  `json:"since,omitempty"`
+
+	// The vector is written at the end of the backup and is only set once the backup
+	// has been completely read.
+	Vector ChangeVector `json:"-"`
+}
+
+// ChangeVector records how far each collection and system bucket had changed when a
+// backup was taken. For collections, the vector holds the sequence of the last entry in
+// the change log of the collection: every version stored in a collection is appended
+// to its change log and every version deleted from it is recorded in its removal log
+// under the current change log sequence, so the sequences identify exactly which
+// versions were stored and removed since a backup. A vector of the latest Lamport
+// version seen from each PID cannot be used instead because Lamport scalars are
+// assigned per object (each version is the VID of its parent plus one and the first
+// version of every object is VID 1), so a version created after a backup can have a
+// lower VID than versions of other objects that were already backed up, and deletions
+// are not versions at all. System buckets (collection metadata, replicas, and job
+// cursors) have no change log, so the vector holds a digest of their contents instead
+// and they are only written again when their digest has changed.
+type ChangeVector map[ulid.ULID]uint64
+
+// Backup writes a full, consistent snapshot of the database to the writer and returns
+// the change vector of the snapshot so that incremental backups can continue from it.
+// The backup is read from a single read-only transaction so writers can continue to
+// modify the store while the backup is in progress; none of their changes are
+// included. If the context is canceled the backup is stopped and the context error is
+// returned, in which case the data written so far is not a valid backup.
+func (s *Store) Backup(ctx context.Context, w io.Writer) (ChangeVector, error) {
+	return s.backup(ctx, w, nil)
+}
+
+// IncrementalBackup writes the object and collection versions that were created since
+// the change vector of the previous backup (full or incremental) to the writer and
+// returns the change vector that the next incremental backup should continue from.
+// Removals are only kept since the last backup once the store has been compacted, so an
+// incremental backup must continue from the latest backup or ErrBackupPruned may be
+// returned.
+func (s *Store) IncrementalBackup(ctx context.Context, w io.Writer, since ChangeVector) (ChangeVector, error) {
+	if since == nil {
+		since = make(ChangeVector)
+	}
+	return s.backup(ctx, w, since)
+}
+
+func (s *Store) backup(ctx context.Context, w io.Writer, since ChangeVector) (_ ChangeVector, err error) {
+	if s.db == nil {
+		return nil, errors.ErrClosed
+	}
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var tx engine.Tx
+	if tx, err = s.begin(ctx, false); err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	header := &BackupHeader{
+		Version:        pkg.Version(),
+		PID:            uint32(lamport.ProcessID()),
+		Region:         region.ProcessRegion(),
+		KeyVersion:     keys.CurrentVersion(),
+		StorageVersion: object.StorageVersion,
+		Engine:         s.conf.Engine,
+		Created:        time.Now(),
+		Incremental:    since != nil,
+		Since:          since,
+	}
+
+	if header.Engine == "" {
+		header.Engine = config.EngineBolt
+	}
+
+	bw := newBackupWriter(ctx, w, since, tx.Bucket(SystemCollections[:]))
+	if err = bw.header(header); err != nil {
+		return nil, err
+	}
+
+	if err = tx.ForEach(bw.topLevel); err != nil {
+		return nil, err
+	}
+
+	if err = bw.close(); err != nil {
+		return nil, err
+	}
+
+	// The removals before the backup are pruned by the next compaction pass.
+	s.mu.Lock()
+	s.lastBackup = bw.vector
+	s.mu.Unlock()
+	return bw.vector, nil
+}
+
+type backupWriter struct {
+	ctx         context.Context
+	buf         *bufio.Writer
+	hash        hash.Hash
+	out         io.Writer
+	n           int
+	since       ChangeVector
+	vector      ChangeVector
+	collections engine.Bucket
+}
+
+func newBackupWriter(ctx context.Context, w io.Writer, since ChangeVector, collections engine.Bucket) *backupWriter {
+	bw := &backupWriter{
+		ctx:         ctx,
+		buf:         bufio.NewWriter(w),
+		hash:        sha256.New(),
+		since:       since,
+		vector:      make(ChangeVector),
+		collections: collections,
+	}
+	bw.out = io.MultiWriter(bw.buf, bw.hash)
+	return bw
+}
+
+func (w *backupWriter) header(header *BackupHeader) (err error) {
+	var data []byte
+	if data, err = json.Marshal(header); err != nil {
+		return fmt.Errorf("could not marshal backup header: %w", err)
+	}
+
+	prefix := append(bytes.Clone(backupMagic), backupFormat)
+	prefix = binary.BigEndian.AppendUint32(prefix, uint32(len(data)))
+	return w.write(prefix, data)
+}
+
+// Writes a top-level bucket and records it in the change vector. For incremental
+// backups, collections that were in the previous backup are written as a delta and
+// system buckets that have not changed are retained; all other buckets are written in
+// full.
+func (w *backupWriter) topLevel(name []byte, bkt engine.Bucket) (err error) {
+	if len(name) != 16 {
+		return w.bucket([][]byte{name}, bkt)
+	}
+
+	var id ulid.ULID
+	copy(id[:], name)
+
+	if !bytes.HasPrefix(name, SystemPrefix[:]) {
+		if log := bkt.Bucket(changesBucket); log != nil {
+			w.vector[id] = log.Sequence()
+		} else {
+			w.vector[id] = 0
+		}
+
+		if sequence, ok := w.since[id]; ok {
+			return w.delta(id, bkt, sequence)
+		}
+		return w.bucket([][]byte{name}, bkt)
+	}
+
+	var digest uint64
+	if digest, err = bucketDigest(bkt); err != nil {
+		return err
+	}
+	w.vector[id] = digest
+
+	if prev, ok := w.since[id]; ok && prev == digest {
+		return w.record(recordRetain, name, nil)
+	}
+	return w.bucket([][]byte{name}, bkt)
+}
+
+// Writes the bucket record, all of the keys and nested buckets in the bucket, and the
+// end record that closes the bucket.
+func (w *backupWriter) bucket(path [][]byte, bkt engine.Bucket) (err error) {
+	name := path[len(path)-1]
+	if err = w.record(recordBucket, name, binary.AppendUvarint(nil, bkt.Sequence())); err != nil {
+		return err
+	}
+
+	cursor := bkt.Cursor()
+	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		if value == nil {
+			err = w.bucket(append(path[:len(path):len(path)], key), bkt.Bucket(key))
+		} else {
+			err = w.record(recordKey, key, value)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.write([]byte{recordEnd})
+}
+
+// Writes the changes to a collection since the change log sequence of the previous
+// backup: delete records for the versions and index buckets that were removed, the
+// payloads and chunks of the versions that were stored, the new entries of the change
+// and removal logs, and finally the stored versions themselves. Only the keys found
+// through the logs are read so the delta is proportional to the number of changes
+// rather than to the size of the collection. Indexes that are maintained by the store,
+// blob reference counts, and the change index are derived from the versions and are
+// rebuilt by the restore, so only their buckets are recorded; any other index buckets
+// are written in full.
+func (w *backupWriter) delta(collectionID ulid.ULID, bkt engine.Bucket, sequence uint64) (err error) {
+	var versions []*metadata.Collection
+	if versions, err = collectionVersions(w.collections, collectionID); err != nil {
+		return err
+	}
+
+	// The removals before the sequence that the removal log was pruned to after a later
+	// backup are no longer stored, so the delta cannot be written.
+	if removals := bkt.Bucket(removalsBucket); removals != nil && removals.Sequence() > sequence {
+		return fmt.Errorf("could not back up collection %s: %w", collectionID, errors.ErrBackupPruned)
+	}
+
+	path := [][]byte{collectionID[:]}
+	if err = w.record(recordDelta, collectionID[:], binary.AppendUvarint(nil, bkt.Sequence())); err != nil {
+		return err
+	}
+
+	// Versions that were removed since the previous backup; a version that was removed
+	// and then stored again is restored by its key record after the deletion.
+	if removals := bkt.Bucket(removalsBucket); removals != nil {
+		cursor := removals.Cursor()
+		for key, value := cursor.Seek(sequenceKey(sequence)); key != nil; key, value = cursor.Next() {
+			if err = w.record(recordDelete, value, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Versions that were stored since the previous backup and have not been removed.
+	var stored, blobs [][]byte
+	if log := bkt.Bucket(changesBucket); log != nil {
+		cursor := log.Cursor()
+		for key, value := cursor.Seek(sequenceKey(sequence + 1)); key != nil; key, value = cursor.Next() {
+			var meta *metadata.Metadata
+			if meta, err = object.Object(value).Metadata(); err != nil {
+				return fmt.Errorf("could not parse change %x: %w", key, err)
+			}
+
+			version := keys.New(meta.ObjectID, &meta.Version.Scalar)
+			if bkt.Get(version) == nil {
+				continue
+			}
+
+			stored = append(stored, version)
+			if len(meta.Blob) > 0 {
+				blobs = append(blobs, meta.Blob)
+			}
+		}
+	}
+
+	// Index buckets of indexes that were dropped from the collection are deleted.
+	indexes := make(map[ulid.ULID]*metadata.Index)
+	for i, version := range versions {
+		for _, idx := range version.Indexes {
+			if idx == nil {
+				continue
+			}
+
+			if i == 0 {
+				indexes[idx.ID] = idx
+			} else if _, ok := indexes[idx.ID]; !ok && bkt.Bucket(idx.ID[:]) == nil {
+				if err = w.record(recordDelete, idx.ID[:], nil); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Nested buckets are written before the versions so that the payloads of the
+	// versions are restored before the versions that refer to them.
+	nested := [][]byte{blobsBucket, blobRefsBucket, chunksBucket, stagingBucket, changesBucket, changeIndexBucket, removalsBucket}
+	for id := range indexes {
+		nested = append(nested, bytes.Clone(id[:]))
+	}
+	slices.SortFunc(nested, bytes.Compare)
+
+	for _, name := range nested {
+		var child engine.Bucket
+		if child = bkt.Bucket(name); child == nil {
+			continue
+		}
+
+		var idx *metadata.Index
+		if len(name) == 16 {
+			idx = indexes[ulid.ULID(name)]
+		}
+
+		switch {
+		case bytes.Equal(name, blobsBucket):
+			err = w.subset(path, name, child, blobs)
+		case bytes.Equal(name, chunksBucket):
+			err = w.subset(path, name, child, stored)
+		case bytes.Equal(name, changesBucket):
+			err = w.tail(path, name, child, sequenceKey(sequence+1))
+		case bytes.Equal(name, removalsBucket):
+			err = w.tail(path, name, child, sequenceKey(sequence))
+		case idx != nil && !maintained(idx):
+			err = w.bucket(append(path, name), child)
+		default:
+			err = w.subset(path, name, child, nil)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range stored {
+		if err = w.record(recordKey, key, bkt.Get(key)); err != nil {
+			return err
+		}
+	}
+
+	return w.write([]byte{recordEnd})
+}
+
+// Writes a delta record for the nested bucket with the specified keys of the bucket;
+// keys that are nested buckets are written in full.
+func (w *backupWriter) subset(path [][]byte, name []byte, bkt engine.Bucket, keys [][]byte) (err error) {
+	if err = w.record(recordDelta, name, binary.AppendUvarint(nil, bkt.Sequence())); err != nil {
+		return err
+	}
+
+	path = append(path[:len(path):len(path)], name)
+	for _, key := range keys {
+		if child := bkt.Bucket(key); child != nil {
+			err = w.bucket(append(path[:len(path):len(path)], key), child)
+		} else if value := bkt.Get(key); value != nil {
+			err = w.record(recordKey, key, value)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.write([]byte{recordEnd})
+}
+
+// Writes a delta record for the nested bucket with all of the keys from the specified
+// key to the end of the bucket.
+func (w *backupWriter) tail(path [][]byte, name []byte, bkt engine.Bucket, from []byte) (err error) {
+	if err = w.record(recordDelta, name, binary.AppendUvarint(nil, bkt.Sequence())); err != nil {
+		return err
+	}
+
+	cursor := bkt.Cursor()
+	for key, value := cursor.Seek(from); key != nil; key, value = cursor.Next() {
+		if err = w.record(recordKey, key, value); err != nil {
+			return err
+		}
+	}
+
+	return w.write([]byte{recordEnd})
+}
+
+// Returns a digest of the keys, values, nested buckets, and sequences of the bucket
+// that is used to detect if a system bucket has changed since the previous backup.
+func bucketDigest(bkt engine.Bucket) (_ uint64, err error) {
+	digest := sha256.New()
+
+	var walk func(bkt engine.Bucket) error
+	walk = func(bkt engine.Bucket) error {
+		digest.Write(binary.AppendUvarint(nil, bkt.Sequence()))
+		return bkt.ForEach(func(key, value []byte) error {
+			digest.Write(binary.AppendUvarint(nil, uint64(len(key))))
+			digest.Write(key)
+
+			if value == nil {
+				digest.Write([]byte{recordBucket})
+				if err := walk(bkt.Bucket(key)); err != nil {
+					return err
+				}
+				digest.Write([]byte{recordEnd})
+				return nil
+			}
+
+			digest.Write([]byte{recordKey})
+			digest.Write(binary.AppendUvarint(nil, uint64(len(value))))
+			digest.Write(value)
+			return nil
+		})
+	}
+
+	if err = walk(bkt); err != nil {
+		return 0, fmt.Errorf("could not compute bucket digest: %w", err)
+	}
+	return binary.BigEndian.Uint64(digest.Sum(nil)), nil
+}
+
+// Writes a record with the length prefixed key and value, checking periodically if the
+// context has been canceled.
+func (w *backupWriter) record(kind byte, key, value []byte) (err error) {
+	if w.n++; w.n%backupCheckEvery == 0 {
+		if err = w.ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	prefix := []byte{kind}
+	prefix = binary.AppendUvarint(prefix, uint64(len(key)))
+	suffix := binary.AppendUvarint(nil, uint64(len(value)))
+	return w.write(prefix, key, suffix, value)
+}
+
+func (w *backupWriter) write(parts ...[]byte) (err error) {
+	for _, part := range parts {
+		if _, err = w.out.Write(part); err != nil {
+			return fmt.Errorf("could not write backup: %w", err)
+		}
+	}
+	return nil
+}
+
+// Writes the change vector, the EOF record, and the checksum then flushes the backup.
+func (w *backupWriter) close() (err error) {
+	var vector []byte
+	if vector, err = json.Marshal(w.vector); err != nil {
+		return fmt.Errorf("could not marshal change vector: %w", err)
+	}
+
+	if err = w.record(recordVector, nil, vector); err != nil {
+		return err
+	}
+
+	if err = w.write([]byte{recordEOF}); err != nil {
+		return err
+	}
+
+	if _, err = w.buf.Write(w.hash.Sum(nil)); err != nil {
+		return fmt.Errorf("could not write backup: %w", err)
+	}
+	return w.buf.Flush()
+}
+
+//===========================================================================
+// Restore
+//===========================================================================
+
+// Restore replaces the database at the configured data path with a chain of backups:
+// a full backup followed by zero or more incremental backups that each continue from
+// the previous backup in the chain. The store must be closed and must not be opened by
+// another process until the restore is complete. Every backup is checked for
+// compatibility with the store and the chain is restored to a temporary file which only
+// replaces the data file once every backup has been completely written and its checksum
+// verified, so an invalid or incompatible chain leaves the existing data file
+// untouched. The header of the last backup in the chain is returned. The restored store
+// is migrated to the current key version the next time it is opened.
+func Restore(ctx context.Context, conf config.Config, chain ...io.Reader) (header *BackupHeader, err error) {
+	switch {
+	case conf.Store.ReadOnly:
+		return nil, errors.ErrReadOnlyDB
+	case conf.Store.Engine != "" && conf.Store.Engine != config.EngineBolt:
+		return nil, fmt.Errorf("cannot restore to the %s engine: %w", conf.Store.Engine, errors.ErrNotSupported)
+	case len(chain) == 0:
+		return nil, fmt.Errorf("%w: no backups to restore", errors.ErrInvalidBackup)
+	}
+
+	// Restore the backup to a temporary file next to the data file so that it can be
+	// atomically renamed to replace the data file.
+	path := conf.Store.DataPath + ".restore"
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not remove previous restore: %w", err)
+	}
+
+	// The restored data file is created with the configured page size and freelist, but
+	// commits are always synced since the restore is only durable once it is renamed.
+	opts := boltOptions(conf.Store)
+	opts.NoSync = false
+
+	var db *bolt.Engine
+	if db, err = bolt.Open(path, opts); err != nil {
+		return nil, fmt.Errorf("could not open restore database: %w", err)
+	}
+
+	rs := &restorer{ctx: ctx, db: db}
+	for _, r := range chain {
+		if header, err = rs.restore(r, header); err != nil {
+			rs.rollback()
+			db.Close()
+			os.Remove(path)
+			return nil, err
+		}
+	}
+
+	if err = db.Close(); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("could not close restore database: %w", err)
+	}
+
+	if err = os.Rename(path, conf.Store.DataPath); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("could not replace data file: %w", err)
+	}
+
+	// Sync the directory to ensure the rename is durable.
+	var dir *os.File
+	if dir, err = os.Open(filepath.Dir(conf.Store.DataPath)); err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	if err = dir.Sync(); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// VerifyBackup reads the entire backup, checking that it is well formed and that it
+// matches its checksum, and returns its header including the change vector.
+func VerifyBackup(r io.Reader) (header *BackupHeader, err error) {
+	br := newBackupReader(r)
+	if header, err = br.header(); err != nil {
+		return nil, err
+	}
+
+	if err = br.each(func(byte, []byte, []byte) error { return nil }); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// Compatible returns an error if the backup cannot be restored by this version of the
+// store (e.g. if it was taken from a newer version with a different key layout).
+func (h *BackupHeader) Compatible() error {
+	if !keys.Supported(h.KeyVersion) {
+		return fmt.Errorf("%w: unsupported key version %d", errors.ErrIncompatibleBackup, h.KeyVersion)
+	}
+
+	if h.StorageVersion != object.StorageVersion {
+		return fmt.Errorf("%w: unsupported storage version %d", errors.ErrIncompatibleBackup, h.StorageVersion)
+	}
+	return nil
+}
+
+type backupReader struct {
+	buf  *bufio.Reader
+	hash hash.Hash
+	hdr  *BackupHeader
+}
+
+func newBackupReader(r io.Reader) *backupReader {
+	return &backupReader{
+		buf:  bufio.NewReader(r),
+		hash: sha256.New(),
+	}
+}
+
+func (r *backupReader) header() (header *BackupHeader, err error) {
+	var prefix []byte
+	if prefix, err = r.read(len(backupMagic) + 5); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(prefix[:len(backupMagic)], backupMagic) {
+		return nil, fmt.Errorf("%w: not a honu backup", errors.ErrInvalidBackup)
+	}
+
+	if format := prefix[len(backupMagic)]; format != backupFormat {
+		return nil, fmt.Errorf("%w: unsupported backup format %d", errors.ErrIncompatibleBackup, format)
+	}
+
+	var data []byte
+	if data, err = r.read(int(binary.BigEndian.Uint32(prefix[len(backupMagic)+1:]))); err != nil {
+		return nil, err
+	}
+
+	header = &BackupHeader{}
+	if err = json.Unmarshal(data, header); err != nil {
+		return nil, fmt.Errorf("%w: could not parse header: %w", errors.ErrInvalidBackup, err)
+	}
+
+	r.hdr = header
+	return header, nil
+}
+
+// Calls the function for every bucket, key, delete, retain, and end record in the backup after
+// checking that the record is well formed. The change vector is read into the header
+// and the checksum is verified once the EOF record is reached.
+func (r *backupReader) each(fn func(kind byte, key, value []byte) error) (err error) {
+	var depth int
+	for {
+		var (
+			kind       byte
+			key, value []byte
+		)
+
+		if kind, key, value, err = r.record(); err != nil {
+			return err
+		}
+
+		switch kind {
+		case recordBucket, recordDelta:
+			depth++
+		case recordKey, recordDelete:
+			if depth == 0 {
+				return fmt.Errorf("%w: key is not in a bucket", errors.ErrInvalidBackup)
+			}
+		case recordRetain:
+			if depth != 0 {
+				return fmt.Errorf("%w: retained bucket is not at the top level", errors.ErrInvalidBackup)
+			}
+		case recordEnd:
+			if depth--; depth < 0 {
+				return fmt.Errorf("%w: unexpected end of bucket", errors.ErrInvalidBackup)
+			}
+		case recordVector:
+			if err = json.Unmarshal(value, &r.hdr.Vector); err != nil {
+				return fmt.Errorf("%w: could not parse change vector: %w", errors.ErrInvalidBackup, err)
+			}
+			continue
+		case recordEOF:
+			if depth > 0 {
+				return fmt.Errorf("%w: unexpected end of backup", errors.ErrInvalidBackup)
+			}
+			return r.verify()
+		}
+
+		if err = fn(kind, key, value); err != nil {
+			return err
+		}
+	}
+}
+
+// Returns the kind of the next record and its key and value (if any).
+func (r *backupReader) record() (kind byte, key, value []byte, err error) {
+	var data []byte
+	if data, err = r.read(1); err != nil {
+		return 0, nil, nil, err
+	}
+
+	switch kind = data[0]; kind {
+	case recordEnd, recordEOF:
+		return kind, nil, nil, nil
+	case recordBucket, recordDelta, recordKey, recordDelete, recordRetain, recordVector:
+		if key, err = r.field(); err != nil {
+			return 0, nil, nil, err
+		}
+
+		if value, err = r.field(); err != nil {
+			return 0, nil, nil, err
+		}
+		return kind, key, value, nil
+	default:
+		return 0, nil, nil, fmt.Errorf("%w: unknown record type %q", errors.ErrInvalidBackup, kind)
+	}
+}
+
+// Reads a length prefixed field from the backup.
+func (r *backupReader) field() (_ []byte, err error) {
+	var size uint64
+	if size, err = binary.ReadUvarint(r); err != nil {
+		return nil, r.invalid(err)
+	}
+
+	if size > math.MaxInt32 {
+		return nil, fmt.Errorf("%w: field is too large", errors.ErrInvalidBackup)
+	}
+	return r.read(int(size))
+}
+
+// Verifies the checksum at the end of the backup against the records that were read.
+func (r *backupReader) verify() (err error) {
+	expected := r.hash.Sum(nil)
+	checksum := make([]byte, len(expected))
+	if _, err = io.ReadFull(r.buf, checksum); err != nil {
+		return r.invalid(err)
+	}
+
+	if !bytes.Equal(checksum, expected) {
+		return fmt.Errorf("%w: checksum mismatch", errors.ErrInvalidBackup)
+	}
+	return nil
+}
+
+func (r *backupReader) read(n int) (data []byte, err error) {
+	data = make([]byte, n)
+	if _, err = io.ReadFull(r.buf, data); err != nil {
+		return nil, r.invalid(err)
+	}
+
+	r.hash.Write(data)
+	return data, nil
+}
+
+// ReadByte implements io.ByteReader so that varints can be read from the backup.
+func (r *backupReader) ReadByte() (c byte, err error) {
+	if c, err = r.buf.ReadByte(); err != nil {
+		return 0, err
+	}
+
+	r.hash.Write([]byte{c})
+	return c, nil
+}
+
+func (r *backupReader) invalid(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: unexpected end of backup", errors.ErrInvalidBackup)
+	}
+	return fmt.Errorf("could not read backup: %w", err)
+}
+
+// The restorer writes the records of a backup to the database, committing the write
+// transaction in batches to limit the size of each transaction. When an incremental
+// backup is restored, the top-level buckets that are neither written nor retained by
+// the backup are deleted from the database and the deltas of the collections are
+// applied to the collections restored by the previous backups in the chain.
+type restorer struct {
+	ctx    context.Context
+	db     engine.Engine
+	tx     engine.Tx
+	merge  bool
+	levels []*restoring
+	n      int
+}
+
+// The bucket that is currently being restored at each level of nesting; the first
+// level holds the top-level buckets of the transaction and has no bucket.
+type restoring struct {
+	name  []byte
+	bkt   engine.Bucket
+	last  []byte
+	delta bool
+
+	// If the bucket is a collection that is restored from a delta, the index entries of
+	// the objects that the delta modified are collected from before they were modified
+	// so that their indexes are updated once the delta is applied, along with the
+	// indexes that were added to the collection since the previous backup.
+	collection *Collection
+	indexed    map[ulid.ULID][]indexEntry
+	added      []*metadata.Index
+}
+
+func (rs *restorer) restore(r io.Reader, prev *BackupHeader) (header *BackupHeader, err error) {
+	br := newBackupReader(r)
+	if header, err = br.header(); err != nil {
+		return nil, err
+	}
+
+	if err = header.Compatible(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case prev == nil && header.Incremental:
+		return nil, fmt.Errorf("%w: the first backup in the chain must be a full backup", errors.ErrIncompatibleBackup)
+	case prev != nil && !header.Incremental:
+		return nil, fmt.Errorf("%w: only incremental backups can follow the first backup", errors.ErrIncompatibleBackup)
+	case prev != nil && !maps.Equal(prev.Vector, header.Since):
+		return nil, fmt.Errorf("%w: incremental backup does not continue from the previous backup", errors.ErrIncompatibleBackup)
+	}
+
+	rs.merge = header.Incremental
+	rs.levels = []*restoring{{}}
+	if rs.tx, err = rs.db.Begin(true); err != nil {
+		return nil, err
+	}
+
+	if err = br.each(rs.apply); err != nil {
+		return nil, err
+	}
+
+	if err = rs.prune(nil); err != nil {
+		return nil, err
+	}
+
+	if err = rs.tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	rs.tx = nil
+	return header, nil
+}
+
+func (rs *restorer) apply(kind byte, key, value []byte) (err error) {
+	switch kind {
+	case recordBucket:
+		err = rs.bucket(key, value, false)
+	case recordDelta:
+		err = rs.bucket(key, value, true)
+	case recordKey:
+		err = rs.put(key, value)
+	case recordDelete:
+		err = rs.delete(key)
+	case recordRetain:
+		err = rs.retain(key)
+	case recordEnd:
+		err = rs.end()
+	}
+
+	if err != nil {
+		return err
+	}
+	return rs.batch()
+}
+
+func (rs *restorer) bucket(name, value []byte, delta bool) (err error) {
+	seq, n := binary.Uvarint(value)
+	if n <= 0 || n != len(value) {
+		return fmt.Errorf("%w: could not parse bucket sequence", errors.ErrInvalidBackup)
+	}
+
+	if err = rs.prune(name); err != nil {
+		return err
+	}
+
+	var (
+		bkt     engine.Bucket
+		created bool
+	)
+
+	parent := rs.current()
+	if parent.bkt == nil {
+		created = rs.tx.Bucket(name) == nil
+		bkt, err = rs.tx.CreateBucketIfNotExists(name)
+	} else {
+		created = parent.bkt.Bucket(name) == nil
+		bkt, err = parent.bkt.CreateBucketIfNotExists(name)
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not restore bucket %x: %w", name, err)
+	}
+
+	if err = bkt.SetSequence(seq); err != nil {
+		return err
+	}
+
+	level := &restoring{name: name, bkt: bkt, delta: delta}
+	switch {
+	case delta && parent.bkt == nil:
+		if level.collection, err = rs.collection(name, bkt); err != nil {
+			return err
+		}
+		level.indexed = make(map[ulid.ULID][]indexEntry)
+	case parent.collection != nil && created:
+		for _, idx := range parent.collection.Indexes {
+			if idx != nil && bytes.Equal(idx.ID[:], name) {
+				parent.added = append(parent.added, idx)
+			}
+		}
+	}
+
+	parent.last = name
+	rs.levels = append(rs.levels, level)
+	return nil
+}
+
+// Returns the collection with the specified bucket name from the collection metadata
+// that has already been restored so that a delta can be applied to the collection.
+func (rs *restorer) collection(name []byte, bkt engine.Bucket) (_ *Collection, err error) {
+	collections := rs.tx.Bucket(SystemCollections[:])
+	if len(name) != 16 || collections == nil {
+		return nil, fmt.Errorf("%w: delta of bucket %x is not a collection", errors.ErrInvalidBackup, name)
+	}
+
+	var info *metadata.Collection
+	if info, err = latestCollection(collections, ulid.ULID(name)); err != nil {
+		return nil, fmt.Errorf("%w: delta of collection %x: %w", errors.ErrInvalidBackup, name, err)
+	}
+	return &Collection{Collection: *info, ctx: rs.ctx, bkt: bkt}, nil
+}
+
+func (rs *restorer) put(key, value []byte) (err error) {
+	if err = rs.prune(key); err != nil {
+		return err
+	}
+
+	cur := rs.current()
+	switch {
+	case cur.collection != nil:
+		err = rs.restoreVersion(cur, key, value)
+	case cur.delta && bytes.Equal(cur.name, changesBucket):
+		err = rs.restoreChange(rs.levels[len(rs.levels)-2].collection, cur.bkt, key, value)
+	default:
+		err = cur.bkt.Put(key, value)
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not restore key %x: %w", key, err)
+	}
+
+	cur.last = key
+	return nil
+}
+
+// Stores a version of an object in a collection that is restored from a delta, adding a
+// reference to its payload. Versions are immutable, so a version that was already
+// restored is not modified.
+func (rs *restorer) restoreVersion(level *restoring, key, value []byte) (err error) {
+	if err = keys.Key(key).Check(); err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInvalidBackup, err)
+	}
+
+	c := level.collection
+	if c.bkt.Get(key) != nil {
+		return nil
+	}
+
+	if err = level.modified(keys.Key(key).ObjectID()); err != nil {
+		return err
+	}
+
+	var meta *metadata.Metadata
+	if meta, err = object.Object(value).Metadata(); err != nil {
+		return fmt.Errorf("%w: %w", errors.ErrInvalidBackup, err)
+	}
+
+	if err = c.bkt.Put(key, value); err != nil {
+		return err
+	}
+
+	if len(meta.Blob) > 0 {
+		return c.linkBlob(meta.Blob)
+	}
+	return nil
+}
+
+// Stores an entry of the change log of a collection that is restored from a delta,
+// adding the entry to the change index of the collection.
+func (rs *restorer) restoreChange(c *Collection, log engine.Bucket, key, value []byte) (err error) {
+	if c == nil {
+		return fmt.Errorf("%w: change log is not in a collection", errors.ErrInvalidBackup)
+	}
+
+	var meta *metadata.Metadata
+	if meta, err = object.Object(value).Metadata(); err != nil || meta.Version == nil {
+		return fmt.Errorf("%w: could not parse change %x", errors.ErrInvalidBackup, key)
+	}
+
+	if err = log.Put(key, value); err != nil {
+		return err
+	}
+
+	var index engine.Bucket
+	if index, err = c.bkt.CreateBucketIfNotExists(changeIndexBucket); err != nil {
+		return err
+	}
+	return index.Put(keys.New(meta.ObjectID, &meta.Version.Scalar), key)
+}
+
+// Deletes a version that was removed from a collection since the previous backup along
+// with its chunks, payload reference, and change log entry, or the bucket of an index
+// that was dropped from the collection.
+func (rs *restorer) delete(key []byte) (err error) {
+	cur := rs.current()
+	if cur.collection == nil {
+		return fmt.Errorf("%w: deleted key %x is not in a collection", errors.ErrInvalidBackup, key)
+	}
+
+	c := cur.collection
+	if keys.Key(key).Check() != nil {
+		if err = c.bkt.DeleteBucket(key); err != nil && !errors.Is(err, engine.ErrBucketNotFound) {
+			return fmt.Errorf("could not delete bucket %x: %w", key, err)
+		}
+		return nil
+	}
+
+	if err = cur.modified(keys.Key(key).ObjectID()); err != nil {
+		return err
+	}
+
+	if err = c.dropVersion(key); err != nil {
+		return fmt.Errorf("could not delete key %x: %w", key, err)
+	}
+	return c.forget(key)
+}
+
+// Retained buckets must have been restored by a previous backup in the chain.
+func (rs *restorer) retain(name []byte) (err error) {
+	if err = rs.prune(name); err != nil {
+		return err
+	}
+
+	if rs.tx.Bucket(name) == nil {
+		return fmt.Errorf("%w: retained bucket %x was not in a previous backup", errors.ErrIncompatibleBackup, name)
+	}
+
+	rs.current().last = name
+	return nil
+}
+
+func (rs *restorer) end() (err error) {
+	if err = rs.prune(nil); err != nil {
+		return err
+	}
+
+	// Update the indexes of the objects modified by the delta of a collection.
+	if cur := rs.current(); cur.collection != nil {
+		if err = cur.collection.reindexObjects(cur.indexed); err != nil {
+			return err
+		}
+
+		for _, idx := range cur.added {
+			if err = cur.collection.buildIndex(idx); err != nil {
+				return err
+			}
+		}
+	}
+
+	rs.levels = rs.levels[:len(rs.levels)-1]
+	return nil
+}
+
+func (rs *restorer) current() *restoring {
+	return rs.levels[len(rs.levels)-1]
+}
+
+// Records the index entries of the object before it is first modified by the delta.
+func (l *restoring) modified(objectID ulid.ULID) (err error) {
+	if _, ok := l.indexed[objectID]; ok {
+		return nil
+	}
+
+	l.indexed[objectID], err = l.collection.indexEntries(objectID)
+	return err
+}
+
+// Deletes the keys and nested buckets of the current bucket that are after the last
+// key that was restored and before upto (or the end of the bucket if upto is nil);
+// because records are in key order, these keys are not in the backup that is being
+// merged, e.g. object versions that were removed after the previous backup was taken.
+func (rs *restorer) prune(upto []byte) (err error) {
+	if !rs.merge || rs.current().delta {
+		return nil
+	}
+
+	var (
+		cur     = rs.current()
+		stale   [][]byte
+		buckets []bool
+	)
+
+	stop := func(key []byte) bool {
+		return upto != nil && bytes.Compare(key, upto) >= 0
+	}
+
+	if cur.bkt == nil {
+		if err = rs.tx.ForEach(func(name []byte, _ engine.Bucket) error {
+			if !stop(name) && (cur.last == nil || bytes.Compare(name, cur.last) > 0) {
+				stale = append(stale, bytes.Clone(name))
+				buckets = append(buckets, true)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	} else {
+		var key, value []byte
+		cursor := cur.bkt.Cursor()
+		if cur.last == nil {
+			key, value = cursor.First()
+		} else if key, value = cursor.Seek(cur.last); bytes.Equal(key, cur.last) {
+			key, value = cursor.Next()
+		}
+
+		for ; key != nil && !stop(key); key, value = cursor.Next() {
+			stale = append(stale, bytes.Clone(key))
+			buckets = append(buckets, value == nil)
+		}
+	}
+
+	for i, key := range stale {
+		switch {
+		case cur.bkt == nil:
+			err = rs.tx.DeleteBucket(key)
+		case buckets[i]:
+			err = cur.bkt.DeleteBucket(key)
+		default:
+			err = cur.bkt.Delete(key)
+		}
+
+		if err != nil {
+			return fmt.Errorf("could not delete key %x: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// Commits the current transaction once the batch size is reached and begins a new one,
+// resolving the path of the buckets that are currently being restored.
+func (rs *restorer) batch() (err error) {
+	if rs.n++; rs.n%restoreBatchSize != 0 {
+		return nil
+	}
+
+	if err = rs.ctx.Err(); err != nil {
+		return err
+	}
+
+	if err = rs.tx.Commit(); err != nil {
+		return err
+	}
+
+	if rs.tx, err = rs.db.Begin(true); err != nil {
+		return err
+	}
+
+	for i := 1; i < len(rs.levels); i++ {
+		if i == 1 {
+			rs.levels[i].bkt = rs.tx.Bucket(rs.levels[i].name)
+		} else {
+			rs.levels[i].bkt = rs.levels[i-1].bkt.Bucket(rs.levels[i].name)
+		}
+
+		if rs.levels[i].collection != nil {
+			rs.levels[i].collection.bkt = rs.levels[i].bkt
+		}
+	}
+	return nil
+}
+
+func (rs *restorer) rollback() {
+	if rs.tx != nil {
+		rs.tx.Rollback()
+	}
+}
@@ -0,0 +1,338 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.rtnl.ai/honu/pkg/config"
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+)
+
+func (s *honuTestSuite) TestBackup() {
+	require := s.Require()
+	info := s.createCollection()
+
+	tx, c := s.openCollection(info.ID, false)
+	alpha, bravo := &metadata.Metadata{}, &metadata.Metadata{}
+	require.NoError(c.Create(alpha, []byte("alpha-1"), nil))
+	require.NoError(c.Update(alpha, []byte("alpha-2"), nil))
+	require.NoError(tx.Commit())
+
+	_, err := s.store.PutStream(context.Background(), info.ID, bravo, bytes.NewReader(bytes.Repeat([]byte("bravo"), store.DefaultChunkSize)), nil)
+	require.NoError(err)
+
+	// Writers can continue while the backup is taken but their changes are excluded.
+	tx, c = s.openCollection(info.ID, false)
+	charlie := &metadata.Metadata{}
+	require.NoError(c.Create(charlie, []byte("charlie-1"), nil))
+
+	backup := &bytes.Buffer{}
+	vector, err := s.store.Backup(context.Background(), backup)
+	require.NoError(err)
+	require.NoError(tx.Commit())
+
+	// Restore the backup to a new data file and open it as a store.
+	conf := s.restoreConfig()
+	header, err := store.Restore(context.Background(), conf, bytes.NewReader(backup.Bytes()))
+	require.NoError(err, "could not restore backup")
+	require.Equal(uint32(1), header.PID)
+	require.Equal(uint8(2), header.KeyVersion)
+	require.False(header.Incremental)
+	require.Equal(vector, header.Vector)
+	require.Equal(uint64(3), header.Vector[info.ID])
+
+	restored, err := store.Open(conf)
+	require.NoError(err, "could not open restored store")
+	defer restored.Close()
+
+	expected, err := s.store.Collection(context.Background(), info.ID)
+	require.NoError(err)
+	actual, err := restored.Collection(context.Background(), info.Name)
+	require.NoError(err)
+	require.Equal(expected.ID, actual.ID)
+	require.Equal(expected.Version, actual.Version)
+
+	rtx, err := restored.Begin(context.Background(), &store.TxOptions{ReadOnly: true})
+	require.NoError(err)
+	defer rtx.Rollback()
+
+	rc, err := rtx.Collection(info.ID)
+	require.NoError(err)
+
+	obj, err := rc.Retrieve(alpha.Key(), nil)
+	require.NoError(err)
+	data, err := obj.Data()
+	require.NoError(err)
+	require.Equal([]byte("alpha-2"), data)
+
+	r, err := rc.GetStream(bravo.Key(), nil)
+	require.NoError(err)
+	data, err = io.ReadAll(r)
+	require.NoError(err)
+	require.Equal(bytes.Repeat([]byte("bravo"), store.DefaultChunkSize), data)
+
+	_, err = rc.Retrieve(keys.New(charlie.ObjectID, nil), nil)
+	require.ErrorIs(err, errors.ErrNotFound)
+	require.NoError(rtx.Rollback())
+
+	// Bucket sequences are restored so the change log continues where it left off.
+	w, err := restored.Watch(context.Background(), info.ID, 0)
+	require.NoError(err)
+	defer w.Close()
+
+	for i := uint64(1); i <= 3; i++ {
+		require.Equal(i, receive(s.T(), w).Sequence)
+	}
+}
+
+func (s *honuTestSuite) TestRestoreInvalid() {
+	require := s.Require()
+	s.createCollection()
+
+	backup := &bytes.Buffer{}
+	_, err := s.store.Backup(context.Background(), backup)
+	require.NoError(err)
+
+	// Write a data file that must not be replaced by a failed restore.
+	conf := s.restoreConfig()
+	require.NoError(os.WriteFile(conf.Store.DataPath, []byte("original"), 0600))
+
+	corrupted := bytes.Clone(backup.Bytes())
+	corrupted[len(corrupted)-40] ^= 0xff
+
+	incompatible := bytes.Replace(backup.Bytes(), []byte(`"storage_version":1`), []byte(`"storage_version":9`), 1)
+
+	testCases := []struct {
+		name   string
+		backup []byte
+		err    error
+	}{
+		{"Empty", nil, errors.ErrInvalidBackup},
+		{"NotBackup", []byte("this is not a honu backup"), errors.ErrInvalidBackup},
+		{"Truncated", backup.Bytes()[:backup.Len()/2], errors.ErrInvalidBackup},
+		{"Corrupted", corrupted, errors.ErrInvalidBackup},
+		{"Incompatible", incompatible, errors.ErrIncompatibleBackup},
+	}
+
+	for _, tc := range testCases {
+		_, err := store.Restore(context.Background(), conf, bytes.NewReader(tc.backup))
+		require.ErrorIs(err, tc.err, "expected error for %s backup", tc.name)
+
+		data, err := os.ReadFile(conf.Store.DataPath)
+		require.NoError(err)
+		require.Equal([]byte("original"), data, "data file was modified by %s backup", tc.name)
+
+		_, err = os.Stat(conf.Store.DataPath + ".restore")
+		require.ErrorIs(err, os.ErrNotExist, "temporary restore file was not removed")
+	}
+
+	// The memory engine does not have a data file to restore to.
+	conf.Store.Engine = config.EngineMemory
+	_, err = store.Restore(context.Background(), conf, bytes.NewReader(backup.Bytes()))
+	require.ErrorIs(err, errors.ErrNotSupported)
+
+	// Backups are not written when the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.store.Backup(ctx, io.Discard)
+	require.ErrorIs(err, context.Canceled)
+}
+
+func (s *honuTestSuite) TestIncrementalBackup() {
+	require := s.Require()
+	ctx := context.Background()
+	info, dropped := s.createCollection(), s.createCollection()
+	info.Indexes = []*metadata.Index{{Name: "by_name", Type: metadata.UNIQUE, Field: &metadata.Field{Name: "name", Type: metadata.StringField}}}
+	require.NoError(s.store.Modify(ctx, info))
+
+	tx, c := s.openCollection(info.ID, false)
+	alpha, bravo := &metadata.Metadata{}, &metadata.Metadata{}
+	require.NoError(c.Create(alpha, []byte(`{"name": "alpha"}`), nil))
+	require.NoError(tx.Commit())
+
+	_, err := s.store.PutStream(context.Background(), info.ID, bravo, bytes.NewReader(bytes.Repeat([]byte("bravo"), store.DefaultChunkSize)), nil)
+	require.NoError(err)
+
+	full := &bytes.Buffer{}
+	vector, err := s.store.Backup(ctx, full)
+	require.NoError(err)
+
+	// Create, update, and destroy objects and collections after the full backup.
+	tx, c = s.openCollection(info.ID, false)
+	charlie := &metadata.Metadata{}
+	require.NoError(c.Update(alpha, []byte(`{"name": "alpha-2"}`), nil))
+	require.NoError(c.Create(charlie, []byte(`{"name": "alpha"}`), nil))
+	require.NoError(c.Destroy(bravo.Key()))
+	require.NoError(tx.Commit())
+
+	require.NoError(s.store.Drop(context.Background(), dropped.ID))
+	created := s.createCollection()
+
+	first := &bytes.Buffer{}
+	since := vector
+	vector, err = s.store.IncrementalBackup(ctx, first, since)
+	require.NoError(err)
+	require.Less(first.Len(), full.Len()/2, "incremental backup should not contain unchanged data")
+	require.Equal(since[info.ID]+3, vector[info.ID])
+	require.Contains(vector, created.ID)
+	require.NotContains(vector, dropped.ID)
+
+	tx, c = s.openCollection(created.ID, false)
+	require.NoError(c.Create(&metadata.Metadata{}, []byte("delta-1"), nil))
+	require.NoError(tx.Commit())
+
+	// Indexes added since the previous backup are rebuilt when the delta is restored.
+	info.Indexes = append(info.Indexes, &metadata.Index{Name: "by_size", Type: metadata.INDEX, Field: &metadata.Field{Name: "size", Type: metadata.IntField}})
+	require.NoError(s.store.Modify(ctx, info))
+
+	tx, c = s.openCollection(info.ID, false)
+	require.NoError(c.Update(charlie, []byte(`{"name": "charlie", "size": 2}`), nil))
+	require.NoError(tx.Commit())
+
+	second := &bytes.Buffer{}
+	latest, err := s.store.IncrementalBackup(ctx, second, vector)
+	require.NoError(err)
+
+	// Backups without changes only contain the header and the vector.
+	unchanged := &bytes.Buffer{}
+	_, err = s.store.IncrementalBackup(ctx, unchanged, latest)
+	require.NoError(err)
+	require.Less(unchanged.Len(), second.Len()/2, "incremental backup should only contain changes")
+
+	header, err := store.VerifyBackup(bytes.NewReader(first.Bytes()))
+	require.NoError(err)
+	require.True(header.Incremental)
+	require.Equal(since, header.Since)
+	require.Equal(vector, header.Vector)
+
+	// Restoring the chain rebuilds the same database.
+	conf := s.restoreConfig()
+	_, err = store.Restore(ctx, conf, bytes.NewReader(full.Bytes()), bytes.NewReader(first.Bytes()), bytes.NewReader(second.Bytes()), bytes.NewReader(unchanged.Bytes()))
+	require.NoError(err, "could not restore backup chain")
+
+	restored, err := store.Open(conf)
+	require.NoError(err, "could not open restored store")
+	defer restored.Close()
+	require.Equal(dumpEngine(s.T(), s.store.Engine()), dumpEngine(s.T(), restored.Engine()))
+
+	// Chains must start with a full backup and each incremental must continue from the
+	// previous backup.
+	testCases := []struct {
+		name  string
+		chain []*bytes.Buffer
+	}{
+		{"Incremental", []*bytes.Buffer{first}},
+		{"Skipped", []*bytes.Buffer{full, second}},
+		{"Reordered", []*bytes.Buffer{full, second, first}},
+		{"Full", []*bytes.Buffer{full, full}},
+	}
+
+	for _, tc := range testCases {
+		chain := make([]io.Reader, 0, len(tc.chain))
+		for _, backup := range tc.chain {
+			chain = append(chain, bytes.NewReader(backup.Bytes()))
+		}
+
+		_, err = store.Restore(ctx, s.restoreConfig(), chain...)
+		require.ErrorIs(err, errors.ErrIncompatibleBackup, "expected error for %s chain", tc.name)
+	}
+}
+
+func (s *honuTestSuite) TestIncrementalBackupPruned() {
+	require := s.Require()
+	ctx := context.Background()
+	info := s.createCollection()
+	objects := s.createObjects(info, 4)
+
+	removals := func() (n int) {
+		require.NoError(s.store.Engine().View(func(tx engine.Tx) error {
+			if bkt := s.nestedBucket(tx, info.ID, "removals"); bkt != nil {
+				n = bucketKeys(bkt)
+			}
+			return nil
+		}))
+		return n
+	}
+
+	destroy := func(objects ...*metadata.Metadata) {
+		tx, c := s.openCollection(info.ID, false)
+		for _, meta := range objects {
+			require.NoError(c.Destroy(meta.Key()))
+		}
+		require.NoError(tx.Commit())
+	}
+
+	destroy(objects[0], objects[1])
+	require.Equal(2, removals())
+
+	vector, err := s.store.Backup(ctx, io.Discard)
+	require.NoError(err)
+	destroy(objects[2])
+	require.Equal(3, removals())
+
+	// The removals before the backup are pruned when the store is compacted; the
+	// removals since the backup are kept for the next incremental backup.
+	_, err = s.store.Compact(ctx)
+	require.NoError(err)
+	require.Equal(1, removals())
+
+	latest, err := s.store.IncrementalBackup(ctx, io.Discard, vector)
+	require.NoError(err)
+	destroy(objects[3])
+
+	_, err = s.store.Compact(ctx)
+	require.NoError(err)
+	require.Equal(1, removals())
+
+	// Incremental backups cannot continue from a backup before the pruned removals.
+	_, err = s.store.IncrementalBackup(ctx, io.Discard, vector)
+	require.ErrorIs(err, errors.ErrBackupPruned)
+
+	_, err = s.store.IncrementalBackup(ctx, io.Discard, latest)
+	require.NoError(err)
+}
+
+// Returns a configuration for an on-disk store in a new temporary directory.
+func (s *honuTestSuite) restoreConfig() config.Config {
+	conf := s.conf
+	conf.Store.Engine = config.EngineBolt
+	conf.Store.DataPath = filepath.Join(s.T().TempDir(), "honu-restore.db")
+	return conf
+}
+
+// Returns every key, value, and bucket sequence in the database keyed by its path.
+func dumpEngine(t *testing.T, db engine.Engine) map[string]string {
+	dump := make(map[string]string)
+
+	var walk func(path string, bkt engine.Bucket)
+	walk = func(path string, bkt engine.Bucket) {
+		dump[path] = fmt.Sprintf("sequence %d", bkt.Sequence())
+		bkt.ForEach(func(key, value []byte) error {
+			if value == nil {
+				walk(fmt.Sprintf("%s/%x", path, key), bkt.Bucket(key))
+			} else {
+				dump[fmt.Sprintf("%s/%x", path, key)] = string(value)
+			}
+			return nil
+		})
+	}
+
+	require.NoError(t, db.View(func(tx engine.Tx) error {
+		return tx.ForEach(func(name []byte, bkt engine.Bucket) error {
+			walk(fmt.Sprintf("%x", name), bkt)
+			return nil
+		})
+	}))
+	return dump
+}
@@ -0,0 +1,105 @@
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.rtnl.ai/honu/pkg/config"
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store"
+	"go.rtnl.ai/honu/pkg/store/engine/bolt"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+)
+
+func (s *honuTestSuite) TestBatch() {
+	require := s.Require()
+	info := s.createCollection()
+
+	// Concurrent batched creates are all committed, even if some of them fail.
+	const writers = 32
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, writers)
+	)
+
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.store.Batch(context.Background(), func(tx *store.Tx) error {
+				if i%8 == 0 {
+					return errors.ErrNotFound
+				}
+				return tx.Create(info.ID, &metadata.Metadata{}, []byte(fmt.Sprintf("object %d", i)), nil)
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if i%8 == 0 {
+			require.ErrorIs(err, errors.ErrNotFound)
+		} else {
+			require.NoError(err)
+		}
+	}
+
+	require.Equal(uint64(writers-writers/8), s.countObjects(info))
+
+	// The batch transaction cannot be committed or rolled back by the function.
+	err := s.store.Batch(context.Background(), func(tx *store.Tx) error {
+		require.ErrorIs(tx.Commit(), errors.ErrManagedTx)
+		require.ErrorIs(tx.Rollback(), errors.ErrManagedTx)
+		return nil
+	})
+	require.NoError(err)
+
+	// Canceled batches are not committed.
+	ctx, cancel := context.WithCancel(context.Background())
+	err = s.store.Batch(ctx, func(tx *store.Tx) error {
+		cancel()
+		return tx.Create(info.ID, &metadata.Metadata{}, []byte("canceled"), nil)
+	})
+	require.ErrorIs(err, context.Canceled)
+
+	require.Equal(uint64(writers-writers/8), s.countObjects(info))
+}
+
+// Returns the number of objects in the collection.
+func (s *honuTestSuite) countObjects(info *metadata.Collection) uint64 {
+	tx, c := s.openCollection(info.ID, true)
+	defer tx.Rollback()
+
+	stats, err := c.Stats()
+	s.Require().NoError(err)
+	return stats.Objects
+}
+
+func TestBoltOptions(t *testing.T) {
+	db := openTestStore(t, config.StoreConfig{
+		LockTimeout:    time.Second,
+		NoFreelistSync: true,
+		FreelistType:   config.FreelistMap,
+		PageSize:       16384,
+		MaxBatchSize:   64,
+		MaxBatchDelay:  2 * time.Millisecond,
+	})
+
+	bdb := db.Engine().(*bolt.Engine).DB()
+	require.Equal(t, 16384, bdb.Info().PageSize)
+	require.True(t, bdb.NoFreelistSync)
+	require.Equal(t, 64, bdb.MaxBatchSize)
+	require.Equal(t, 2*time.Millisecond, bdb.MaxBatchDelay)
+
+	// Unknown freelist types are rejected when the engine is opened.
+	_, err := bolt.Open(t.TempDir()+"/invalid.db", &bolt.Options{FreelistType: "hashmap"})
+	require.Error(t, err)
+
+	// Closed stores cannot batch writes.
+	closed := &store.Store{}
+	require.ErrorIs(t, closed.Batch(context.Background(), func(*store.Tx) error { return nil }), errors.ErrClosed)
+}
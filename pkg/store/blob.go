@@ -0,0 +1,218 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/iterator"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/object"
+)
+
+// The keys of the nested buckets in each collection that hold deduplicated object
+// payloads and their reference counts. Payloads are content-addressed by the SHA-256
+// hash of the data so that each unique payload is stored once per collection no matter
+// how many object versions refer to it. The keys are prefixed like the system IDs so
+// that they are never valid object keys.
+var (
+	blobsBucket    = []byte{0x00, 0x68, 0x6f, 0x6e, 0x75, 0x05, 0x6f, 0x62, 0x6a, 0x62, 0x6c, 0x6f, 0x62, 0x73, 0x00, 0x00}
+	blobRefsBucket = []byte{0x00, 0x68, 0x6f, 0x6e, 0x75, 0x05, 0x62, 0x6c, 0x6f, 0x62, 0x72, 0x65, 0x66, 0x73, 0x00, 0x00}
+)
+
+//===========================================================================
+// Content-Addressed Payloads
+//===========================================================================
+
+// Stores the payload in the blob bucket if it is not already stored and increments its
+// reference count, returning the content address of the payload.
+func (c *Collection) putBlob(data []byte) (digest []byte, err error) {
+	sum := sha256.Sum256(data)
+	digest = sum[:]
+
+	var blobs, refs engine.Bucket
+	if blobs, err = c.bkt.CreateBucketIfNotExists(blobsBucket); err != nil {
+		return nil, fmt.Errorf("could not create blobs bucket: %w", err)
+	}
+
+	if refs, err = c.bkt.CreateBucketIfNotExists(blobRefsBucket); err != nil {
+		return nil, fmt.Errorf("could not create blob references bucket: %w", err)
+	}
+
+	if blobs.Get(digest) == nil {
+		if err = blobs.Put(digest, data); err != nil {
+			return nil, fmt.Errorf("could not store blob: %w", err)
+		}
+	}
+
+	if err = c.refBlob(refs, digest, 1); err != nil {
+		return nil, err
+	}
+	return digest, nil
+}
+
+// Adds a reference to a blob that is already stored in the collection, e.g. when a
+// replicated object version refers to a payload by its content address.
+func (c *Collection) linkBlob(digest []byte) (err error) {
+	blobs, refs := c.bkt.Bucket(blobsBucket), c.bkt.Bucket(blobRefsBucket)
+	if blobs == nil || refs == nil || blobs.Get(digest) == nil {
+		return errors.ErrMissingBlob
+	}
+	return c.refBlob(refs, digest, 1)
+}
+
+// Returns the payload with the specified content address or nil if it is not stored.
+func (c *Collection) getBlob(digest []byte) []byte {
+	if blobs := c.bkt.Bucket(blobsBucket); blobs != nil {
+		return blobs.Get(digest)
+	}
+	return nil
+}
+
+// Removes a reference to the blob, deleting the payload if nothing refers to it.
+func (c *Collection) releaseBlob(digest []byte) (err error) {
+	blobs, refs := c.bkt.Bucket(blobsBucket), c.bkt.Bucket(blobRefsBucket)
+	if blobs == nil || refs == nil {
+		return errors.ErrMissingBlob
+	}
+
+	if err = c.refBlob(refs, digest, -1); err != nil {
+		return err
+	}
+
+	if refs.Get(digest) == nil {
+		if err = blobs.Delete(digest); err != nil {
+			return fmt.Errorf("could not delete blob: %w", err)
+		}
+	}
+	return nil
+}
+
+// Adjusts the reference count of the blob by delta; the count is removed when it
+// reaches zero.
+func (c *Collection) refBlob(refs engine.Bucket, digest []byte, delta int64) (err error) {
+	var count int64
+	if val := refs.Get(digest); len(val) == 8 {
+		count = int64(binary.BigEndian.Uint64(val))
+	}
+
+	if count += delta; count <= 0 {
+		if err = refs.Delete(digest); err != nil {
+			return fmt.Errorf("could not delete blob reference count: %w", err)
+		}
+		return nil
+	}
+
+	val := make([]byte, 8)
+	binary.BigEndian.PutUint64(val, uint64(count))
+	if err = refs.Put(digest, val); err != nil {
+		return fmt.Errorf("could not store blob reference count: %w", err)
+	}
+	return nil
+}
+
+// Returns the object with its payload loaded from the blob bucket if the object refers
+// to a blob, otherwise the object is copied out of the transaction as is.
+func (c *Collection) resolve(data []byte) (_ object.Object, err error) {
+	obj := object.Object(data)
+
+	var meta *metadata.Metadata
+	if meta, err = obj.Metadata(); err != nil {
+		return nil, fmt.Errorf("could not parse object metadata: %w", err)
+	}
+
+	if len(meta.Blob) == 0 {
+		return copyObject(data), nil
+	}
+
+	var payload []byte
+	if payload = c.getBlob(meta.Blob); payload == nil {
+		return nil, errors.ErrMissingBlob
+	}
+
+	// Marshal copies the payload out of the transaction.
+	if obj, err = object.Marshal(meta, payload); err != nil {
+		return nil, fmt.Errorf("could not marshal object: %w", err)
+	}
+	return obj, nil
+}
+
+// Wraps collection iterators so that the objects they return include their payloads
+// when the payload is stored in the blob bucket and so that iteration stops when the
+// context of the transaction is canceled.
+type resolver struct {
+	c   *Collection
+	err error
+}
+
+func (r *resolver) resolve(obj object.Object) object.Object {
+	if obj == nil {
+		return nil
+	}
+
+	var err error
+	if obj, err = r.c.resolve(obj); err != nil {
+		r.err = err
+		return nil
+	}
+	return obj
+}
+
+// Returns true if the transaction was canceled, recording the error of the context.
+func (r *resolver) canceled() bool {
+	if err := r.c.canceled(); err != nil {
+		r.err = err
+		return true
+	}
+	return false
+}
+
+type resolvedIterator struct {
+	iterator.Iterator
+	resolver
+}
+
+func (i *resolvedIterator) Next() bool {
+	return !i.canceled() && i.Iterator.Next()
+}
+
+func (i *resolvedIterator) Prev() bool {
+	return !i.canceled() && i.Iterator.Prev()
+}
+
+func (i *resolvedIterator) Object() object.Object {
+	return i.resolve(i.Iterator.Object())
+}
+
+func (i *resolvedIterator) Error() error {
+	if i.err != nil {
+		return i.err
+	}
+	return i.Iterator.Error()
+}
+
+type resolvedVersions struct {
+	iterator.VersionIterator
+	resolver
+}
+
+func (i *resolvedVersions) Next() bool {
+	return !i.canceled() && i.VersionIterator.Next()
+}
+
+func (i *resolvedVersions) Prev() bool {
+	return !i.canceled() && i.VersionIterator.Prev()
+}
+
+func (i *resolvedVersions) Object() object.Object {
+	return i.resolve(i.VersionIterator.Object())
+}
+
+func (i *resolvedVersions) Error() error {
+	if i.err != nil {
+		return i.err
+	}
+	return i.VersionIterator.Error()
+}
@@ -0,0 +1,147 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/lamport"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/object"
+	"go.rtnl.ai/ulid"
+)
+
+func (s *honuTestSuite) TestDeduplication() {
+	require := s.Require()
+	info := s.createCollection()
+	payload := []byte(`{"label": "cat", "confidence": 0.92}`)
+	digest := sha256.Sum256(payload)
+
+	// Versions that only change the metadata share the same payload.
+	tx, c := s.openCollection(info.ID, false)
+	alpha, bravo := &metadata.Metadata{}, &metadata.Metadata{}
+	require.NoError(c.Create(alpha, payload, nil))
+	for i := 0; i < 3; i++ {
+		alpha.Permissions = uint8(i)
+		require.NoError(c.Update(alpha, payload, nil))
+	}
+	require.NoError(c.Create(bravo, payload, nil))
+	require.NoError(c.Update(bravo, []byte("other"), nil))
+	require.NoError(tx.Commit())
+
+	require.Equal(digest[:], alpha.Blob)
+	require.Equal(map[string]uint64{string(digest[:]): 5, string(sha256Sum("other")): 1}, s.blobRefs(info.ID))
+
+	// Every version still returns its payload.
+	tx, c = s.openCollection(info.ID, true)
+	iter := c.Versions(alpha.ObjectID)
+	for iter.Next() {
+		data, err := iter.Object().Data()
+		require.NoError(err)
+		require.Equal(payload, data)
+	}
+	require.NoError(iter.Error())
+	iter.Release()
+
+	obj, err := c.Retrieve(keys.New(bravo.ObjectID, nil), nil)
+	require.NoError(err)
+	data, err := obj.Data()
+	require.NoError(err)
+	require.Equal([]byte("other"), data)
+
+	// Read transactions must be closed before writing in case bolt needs to remap.
+	require.NoError(tx.Rollback())
+
+	// Compaction releases the references of pruned versions.
+	info.Retention = &metadata.Retention{Policy: metadata.KEEP_VERSIONS, Versions: 1}
+	require.NoError(s.store.Modify(context.Background(), info))
+	_, err = s.store.Compact(context.Background())
+	require.NoError(err)
+	require.Equal(map[string]uint64{string(digest[:]): 1, string(sha256Sum("other")): 1}, s.blobRefs(info.ID))
+	require.Equal(2, s.countBlobs(info.ID))
+
+	// Destroying the objects frees the payloads that nothing refers to.
+	tx, c = s.openCollection(info.ID, false)
+	require.NoError(c.Destroy(alpha.Key()))
+	require.NoError(tx.Commit())
+	require.Equal(map[string]uint64{string(sha256Sum("other")): 1}, s.blobRefs(info.ID))
+	require.Equal(1, s.countBlobs(info.ID))
+
+	tx, c = s.openCollection(info.ID, false)
+	require.NoError(c.Destroy(bravo.Key()))
+	require.NoError(tx.Commit())
+	require.Empty(s.blobRefs(info.ID))
+	require.Equal(0, s.countBlobs(info.ID))
+}
+
+func (s *honuTestSuite) TestApplyDeduplication() {
+	require := s.Require()
+	info := s.createCollection()
+	payload := []byte("replicated payload")
+
+	tx, c := s.openCollection(info.ID, false)
+	for i := 0; i < 2; i++ {
+		meta := &metadata.Metadata{
+			ObjectID:     ulid.MakeSecure(),
+			CollectionID: info.ID,
+			Version:      &metadata.Version{Scalar: lamport.Scalar{PID: 2, VID: 1}},
+		}
+
+		obj, err := object.Marshal(meta, payload)
+		require.NoError(err)
+		require.NoError(c.Apply(obj))
+
+		stored, err := c.Retrieve(keys.New(meta.ObjectID, nil), nil)
+		require.NoError(err)
+		data, err := stored.Data()
+		require.NoError(err)
+		require.Equal(payload, data)
+	}
+	require.NoError(tx.Commit())
+
+	require.Equal(map[string]uint64{string(sha256Sum(string(payload))): 2}, s.blobRefs(info.ID))
+}
+
+func sha256Sum(data string) []byte {
+	sum := sha256.Sum256([]byte(data))
+	return sum[:]
+}
+
+// Returns the reference counts of the payloads stored in the collection.
+func (s *honuTestSuite) blobRefs(collectionID ulid.ULID) map[string]uint64 {
+	refs := make(map[string]uint64)
+	s.Require().NoError(s.store.Engine().View(func(tx engine.Tx) error {
+		if bkt := s.nestedBucket(tx, collectionID, "blobrefs"); bkt != nil {
+			return bkt.ForEach(func(k, v []byte) error {
+				refs[string(k)] = binary.BigEndian.Uint64(v)
+				return nil
+			})
+		}
+		return nil
+	}))
+	return refs
+}
+
+// Returns the number of unique payloads stored in the collection.
+func (s *honuTestSuite) countBlobs(collectionID ulid.ULID) (n int) {
+	s.Require().NoError(s.store.Engine().View(func(tx engine.Tx) error {
+		if bkt := s.nestedBucket(tx, collectionID, "objblobs"); bkt != nil {
+			n = bucketKeys(bkt)
+		}
+		return nil
+	}))
+	return n
+}
+
+func (s *honuTestSuite) nestedBucket(tx engine.Tx, collectionID ulid.ULID, name string) engine.Bucket {
+	cursor := tx.Bucket(collectionID[:]).Cursor()
+	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		if value == nil && bytes.Contains(key, []byte(name)) {
+			return tx.Bucket(collectionID[:]).Bucket(key)
+		}
+	}
+	return nil
+}
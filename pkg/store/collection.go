@@ -2,15 +2,19 @@ package store
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"time"
 
-	"go.etcd.io/bbolt"
 	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/region"
+	"go.rtnl.ai/honu/pkg/store/engine"
 	"go.rtnl.ai/honu/pkg/store/iterator"
 	"go.rtnl.ai/honu/pkg/store/keys"
 	"go.rtnl.ai/honu/pkg/store/lamport"
 	"go.rtnl.ai/honu/pkg/store/metadata"
 	"go.rtnl.ai/honu/pkg/store/object"
+	"go.rtnl.ai/honu/pkg/store/opts"
 	"go.rtnl.ai/ulid"
 )
 
@@ -19,7 +23,9 @@ import (
 // are grouped together and can be accessed efficiently.
 type Collection struct {
 	metadata.Collection
-	bkt *bbolt.Bucket `json:"-" msg:"-"`
+	ctx context.Context `json:"-" msg:"-"`
+	bkt engine.Bucket   `json:"-" msg:"-"`
+	pin *TxOptions      `json:"-" msg:"-"`
 }
 
 //===========================================================================
@@ -28,48 +34,61 @@ type Collection struct {
 
 // List all of the objects in the collection, returning an iterator that will allow the
 // caller to either simply iterate over the keys or to actually retreive the objects in
-// a memory-efficient manner.
+// a memory-efficient manner. Only the latest version of each object is returned and
+// objects that are deleted or expired are skipped, as with Retrieve.
+//
+// If the collection was opened in a point-in-time transaction, the latest version of
+// each object visible at that time is returned and objects that were deleted or had
+// expired at that time are skipped.
 func (c *Collection) List() iterator.Iterator {
-	// Iterator.New expects an uninitialized cursor, so we don't call First() here.
-	return iterator.New(c.bkt.Cursor())
+	// The iterator expects an uninitialized cursor, so we don't call First() here.
+	// Nested buckets (e.g. indexes and object payloads) are skipped by the iterator.
+	return &resolvedIterator{
+		Iterator: iterator.Latest(c.bkt.Cursor(), c.pin.visibleObject, c.deleted),
+		resolver: resolver{c: c},
+	}
 }
 
 // List all of the objects in the collection that match the specified query. An iterator
 // is returned that will allow the caller to either simply iterate over the keys
 // or to actually retrieve the objects in a memory-efficient manner.
+//
+// Query predicates are not supported yet so every object in the collection matches;
+// as with List, objects are resolved to the point in time of a pinned transaction.
 func (c *Collection) Query() iterator.Iterator {
-	return iterator.Empty(errors.ErrNotImplemented)
+	return c.List()
 }
 
 // Has returns true if the object with the specified ID has any version (including
 // tombstones) stored in the collection. See Exists() for checking if the latest version
 // of the object is not a tombstone.
 func (c *Collection) Has(id ulid.ULID) bool {
-	cursor := c.bkt.Cursor()
-	key, _ := cursor.Seek(id[:])
-	return key != nil && bytes.HasPrefix(key, id[:])
+	key, _ := c.seekLatest(id)
+	return key != nil
 }
 
 // Exists returns true if the object with the specified ID exists in the collection
-// and the latest version is not a tombstone.
+// and the latest version is neither a tombstone nor expired.
 func (c *Collection) Exists(id ulid.ULID) bool {
-	cursor := c.bkt.Cursor()
-	key, data := cursor.Seek(id[:])
-
-	if key == nil || !bytes.HasPrefix(key, id[:]) {
+	key, data := c.seekLatest(id)
+	if key == nil {
 		return false
 	}
 
-	obj := object.Object(data)
-	return !obj.Tombstone()
+	return !c.deleted(keys.Key(key), data)
 }
 
 // Empty the collection by adding a tombstone version to all of the objects in the
 // collection. These objects cannot be accessed directly any longer but their version
-// history is preserved. This is different from the Collection.Truncate method which
+// history is preserved. This is different from the Store.Truncate method which
 // removes all objects and their versions from the collection.
+//
+// NOTE: tombstoning every object in a single transaction would block all other writers
+// until it was committed, so collections cannot be emptied from inside a transaction;
+// ErrEmptyInTx is always returned. Use Store.Empty instead, which tombstones objects in
+// bounded batches of separate write transactions and resumes after a crash.
 func (c *Collection) Empty() error {
-	return errors.ErrNotImplemented
+	return errors.ErrEmptyInTx
 }
 
 // Create a new object in the collection with the given key and value. The key must be
@@ -78,46 +97,134 @@ func (c *Collection) Empty() error {
 // object was uniquely created, however it will guarantee that the object version
 // history starts from the current object and branches can be detected later.
 //
+// If the metadata does not specify an expiration and the collection has a TTL, the
+// object expires after the collection TTL has passed.
+//
 // NOTE: the metadata pointer will be modified to include the assigned version and
 // ID, and timestamps, so the caller can use the modified instance after the call.
-func (c *Collection) Create(meta *metadata.Metadata, data []byte) error {
-	// Override the ObjectID, CollectionID, Version, and timestamps.
+func (c *Collection) Create(meta *metadata.Metadata, data []byte, wo *opts.WriteOptions) (err error) {
+	if !c.writable() {
+		return errors.ErrReadOnlyTx
+	}
+
+	if err = c.canceled(); err != nil {
+		return err
+	}
+
+	// Override the ObjectID and CollectionID; the version is assigned by put.
 	meta.ObjectID = ulid.MakeSecure()
 	meta.CollectionID = c.ID
-	meta.Version = &metadata.Version{
-		Scalar:  lamport.Scalar{PID: 0, VID: 1},
-		Created: time.Now(),
+
+	// The object ID was just generated so there should be no previous version, but
+	// we check anyway to guarantee the NoOverwrite semantics of Create.
+	if c.Has(meta.ObjectID) {
+		return errors.ErrAlreadyExists
 	}
-	meta.Created = meta.Version.Created
-	meta.Modified = meta.Version.Created
 
-	return nil
+	return c.put(meta, data, nil, metadata.LIVE)
 }
 
 // Retrieve the latest version of the object with the given key from the collection. If
-// the object is a tombstone record or if the key is not in the store, then a not found
-// error will be returned. If a version is specified that version will be retrieved,
-// even if it is a tombstone record; version does not exist is returned instead of
-// not found in this case.
-func (c *Collection) Retrieve(key keys.Key) (object.Object, error) {
-	return nil, nil
+// the object is a tombstone record, has expired, or if the key is not in the store, then
+// a not found error will be returned. Expired objects are treated as deleted even if
+// they have not yet been tombstoned by the reaper. If a version is specified that
+// version will be retrieved, even if it is a tombstone record or expired; version does
+// not exist is returned instead of not found in this case.
+//
+// Objects written with PutStream store their data in chunks rather than inline; the
+// returned object has no data and its metadata describes the chunks. Use GetStream to
+// read the data of these objects.
+func (c *Collection) Retrieve(key keys.Key, ro *opts.ReadOptions) (_ object.Object, err error) {
+	if err = c.canceled(); err != nil {
+		return nil, err
+	}
+
+	if err = key.Check(); err != nil {
+		return nil, err
+	}
+
+	// If a version is specified, fetch that exact version of the object (so long as the
+	// version existed at the pinned point in time, if any).
+	if key.HasVersion() {
+		vers := key.Version()
+		vkey := keys.New(key.ObjectID(), &vers)
+		if data := c.bkt.Get(vkey); data != nil && c.pin.visibleObject(vkey, data) {
+			return c.resolve(data)
+		}
+		return nil, errors.ErrVersionNotFound
+	}
+
+	// Otherwise fetch the latest version of the object, skipping tombstones.
+	var data []byte
+	if key, data = c.seekLatest(key.ObjectID()); data == nil {
+		return nil, errors.ErrNotFound
+	}
+
+	obj := object.Object(data)
+	if c.deleted(keys.Key(key), obj) && !ro.GetTombstones() {
+		return nil, errors.ErrNotFound
+	}
+	return c.resolve(obj)
 }
 
 // Returns an iterator of all versions of the object; iterating from the most recent
-// version to the oldest. Tombstone versions are included by the iterator.
-func (c *Collection) Versions() iterator.Iterator {
-	return iterator.Empty(errors.ErrNotImplemented)
+// version to the oldest. Tombstone versions are included by the iterator. The iterator
+// also exposes the parent of each version and any forks in the version history, e.g.
+// where two replicas concurrently created a new version from the same parent.
+func (c *Collection) Versions(id ulid.ULID) iterator.VersionIterator {
+	return &resolvedVersions{
+		VersionIterator: iterator.Versions(c.bkt.Cursor(), keys.New(id, nil).ObjectPrefix()),
+		resolver:        resolver{c: c},
+	}
 }
 
 // Create a new version record of the object for the given key. If the object does not
-// already exist, it will return an error. Because of the replicated nature of Honu,
-// we can't guarantee that the object doesn't exist somewhere else in the cluster but
-// this will prevent updates locally until that created version is replicated.
+// already exist and CheckUpdate is specified, it will return an error. Because of the
+// replicated nature of Honu, we can't guarantee that the object doesn't exist
+// somewhere else in the cluster but this will prevent updates locally until that
+// created version is replicated.
+//
+// If an expected version is specified in the write options, the update is only applied
+// if the latest version of the object matches, otherwise a version conflict error is
+// returned; this allows concurrent writers to safely read-modify-write an object.
+//
+// The expiration of the object is not carried over from the previous version: if the
+// metadata does not specify an expiration the collection TTL (if any) is applied again.
 //
 // NOTE: the metadata pointer will be modified to include the assigned version and
 // ID, and timestamps, so the caller can use the modified instance after the call.
-func (c *Collection) Update(meta *metadata.Metadata, data []byte) error {
-	return nil
+func (c *Collection) Update(meta *metadata.Metadata, data []byte, wo *opts.WriteOptions) (err error) {
+	if !c.writable() {
+		return errors.ErrReadOnlyTx
+	}
+
+	if err = c.canceled(); err != nil {
+		return err
+	}
+
+	if meta.ObjectID.IsZero() {
+		return errors.ErrMissingObjectID
+	}
+	meta.CollectionID = c.ID
+
+	var prev *metadata.Metadata
+	if prev, err = c.latest(meta.ObjectID); err != nil {
+		return err
+	}
+
+	if !expected(prev, wo) {
+		return errors.ErrVersionConflict
+	}
+
+	if prev == nil || prev.IsTombstone() {
+		if wo.GetCheckUpdate() {
+			return errors.ErrNotFound
+		}
+	} else if wo.GetNoOverwrite() {
+		return errors.ErrAlreadyExists
+	}
+
+	return c.put(meta, data, prev, metadata.LIVE)
 }
 
 // Merge performs an upsert operation on the object, creating a new version of the key
@@ -125,29 +232,741 @@ func (c *Collection) Update(meta *metadata.Metadata, data []byte) error {
 // simpler semantics than Create or Update as the caller does not need to worry about
 // whether the object exists on the cluster or not, and in single replica queries its
 // better to use Merge.
-func (c *Collection) Merge(meta *metadata.Metadata, data []byte) error {
-	return nil
+//
+// As with Update, an expected version in the write options is checked against the
+// latest version of the object before the write is applied.
+//
+// NOTE: if the metadata does not have an object ID, a new one is assigned; the
+// metadata pointer is modified in the same manner as Create and Update.
+func (c *Collection) Merge(meta *metadata.Metadata, data []byte, wo *opts.WriteOptions) (err error) {
+	if !c.writable() {
+		return errors.ErrReadOnlyTx
+	}
+
+	if err = c.canceled(); err != nil {
+		return err
+	}
+
+	if meta.ObjectID.IsZero() {
+		meta.ObjectID = ulid.MakeSecure()
+	}
+	meta.CollectionID = c.ID
+
+	var prev *metadata.Metadata
+	if prev, err = c.latest(meta.ObjectID); err != nil {
+		return err
+	}
+
+	if err = mergeable(prev, wo); err != nil {
+		return err
+	}
+
+	return c.put(meta, data, prev, metadata.LIVE)
 }
 
 // Delete an object from the collection by adding a tombstone version; the object will
 // not be returned in list queries or retrieval but the version history of the object
 // will be preserved.
-func (c *Collection) Delete(key keys.Key) error {
-	return nil
+//
+// If the object does not exist or has already been deleted, no error is returned unless
+// CheckDelete is specified, in which case a not found error is returned. If an expected
+// version is specified and does not match the latest version, a version conflict error
+// is returned and the object is not deleted.
+func (c *Collection) Delete(key keys.Key, wo *opts.WriteOptions) (err error) {
+	if !c.writable() {
+		return errors.ErrReadOnlyTx
+	}
+
+	if err = c.canceled(); err != nil {
+		return err
+	}
+
+	if err = key.Check(); err != nil {
+		return err
+	}
+
+	var prev *metadata.Metadata
+	if prev, err = c.latest(key.ObjectID()); err != nil {
+		return err
+	}
+
+	if !expected(prev, wo) {
+		return errors.ErrVersionConflict
+	}
+
+	if prev == nil || prev.IsTombstone() {
+		if wo.GetCheckDelete() {
+			return errors.ErrNotFound
+		}
+		return nil
+	}
+
+	// The tombstone keeps the previous metadata so that ownership and permissions are
+	// replicated with the deletion.
+	return c.put(prev, nil, prev, metadata.TOMBSTONE)
 }
 
 // Destroy the object and all of its versions from the collection. This method adds a
 // truncated record to the object, which is replicated to all replicas. Any object that
 // gets created with the same key in the future will start from version 1, even if
 // the truncation happens concurrently with the creation of the new object.
-func (c *Collection) Destroy(key keys.Key) error {
-	return errors.ErrNotImplemented
+func (c *Collection) Destroy(key keys.Key) (err error) {
+	if !c.writable() {
+		return errors.ErrReadOnlyTx
+	}
+
+	if err = c.canceled(); err != nil {
+		return err
+	}
+
+	if err = key.Check(); err != nil {
+		return err
+	}
+
+	var prev *metadata.Metadata
+	if prev, err = c.latest(key.ObjectID()); err != nil {
+		return err
+	}
+
+	if prev == nil {
+		return errors.ErrNotFound
+	}
+
+	// The object has already been destroyed and has no history to remove.
+	if prev.Kind() == metadata.TRUNCATED {
+		return nil
+	}
+
+	return c.truncate(prev)
+}
+
+// Apply stores a version of an object that was received from a remote replica. Unlike
+// the other write methods, the version is not assigned locally but is taken from the
+// object metadata. If the version is already stored, this is a no-op. Versions with
+// chunked data must be applied with Store.ApplyStream so that the data is replicated
+// along with the metadata; ErrChunkedObject is returned if they are passed to Apply.
+//
+// When a truncated record is applied, all versions of the object created before the
+// truncation are dropped from the collection. Versions created after the truncation
+// are kept, since they start a new version history for the object (and they also
+// replace the truncated record if they are applied after it).
+func (c *Collection) Apply(obj object.Object) (err error) {
+	if !c.writable() {
+		return errors.ErrReadOnlyTx
+	}
+
+	if err = c.canceled(); err != nil {
+		return err
+	}
+
+	var meta *metadata.Metadata
+	if meta, err = obj.Metadata(); err != nil {
+		return fmt.Errorf("could not parse replicated object metadata: %w", err)
+	}
+
+	if meta.ObjectID.IsZero() {
+		return errors.ErrMissingObjectID
+	}
+
+	if meta.Version == nil {
+		return errors.ErrMissingVersion
+	}
+
+	if !meta.CollectionID.IsZero() && meta.CollectionID != c.ID {
+		return errors.ErrIDMismatch
+	}
+
+	// The data of chunked objects is not carried by the object.
+	if meta.Chunks != nil {
+		return errors.ErrChunkedObject
+	}
+
+	var data []byte
+	if data, err = obj.Data(); err != nil {
+		return fmt.Errorf("could not parse replicated object data: %w", err)
+	}
+	return c.apply(meta, data)
+}
+
+// Stores the replicated version of the object with its data unless the version is
+// already stored or was superseded by a truncation of the object.
+func (c *Collection) apply(meta *metadata.Metadata, data []byte) (err error) {
+	key := keys.New(meta.ObjectID, &meta.Version.Scalar)
+	if c.bkt.Get(key) != nil {
+		return nil
+	}
+
+	var prev *metadata.Metadata
+	if prev, err = c.latest(meta.ObjectID); err != nil {
+		return err
+	}
+
+	switch {
+	case meta.Kind() == metadata.TRUNCATED:
+		// Drop all versions of the object that were created before the truncation.
+		created := meta.Version.Created
+		if _, err = c.purge(meta.ObjectID, func(vers *metadata.Metadata) bool {
+			return !vers.Version.Created.After(created)
+		}); err != nil {
+			return err
+		}
+
+		// If a newer version history already exists, the truncated record is not needed.
+		if prev != nil && prev.Version.Created.After(created) {
+			return nil
+		}
+
+	case prev != nil && prev.Kind() == metadata.TRUNCATED:
+		// Versions created before the truncation were removed by the truncation.
+		if !meta.Version.Created.After(prev.Version.Created) {
+			return nil
+		}
+
+		// Otherwise the version starts a new history and replaces the truncated record.
+		if _, err = c.purge(meta.ObjectID, nil); err != nil {
+			return err
+		}
+	}
+
+	// The payload is deduplicated in the same manner as locally written versions.
+	if err = c.store(meta, data); err != nil {
+		return fmt.Errorf("could not store replicated object: %w", err)
+	}
+	return nil
 }
 
 //===========================================================================
 // Collection Helper Methods
 //===========================================================================
 
+// Writes a new version of the object to the collection bucket. If prev is not nil then
+// the new version is linked to the previous version as its parent and the creation
+// timestamp of the object is preserved. The kind marks the version as a live, tombstone,
+// or truncated record. The version is assigned from the process PID and region so the
+// caller does not need to (and cannot) specify the version.
+func (c *Collection) put(meta *metadata.Metadata, data []byte, prev *metadata.Metadata, kind metadata.Kind) (err error) {
+	if err = c.assign(meta, prev, kind); err != nil {
+		return err
+	}
+
+	// Inline data replaces any chunked or deduplicated data described by the metadata.
+	meta.Chunks = nil
+	meta.Blob = nil
+	return c.store(meta, data)
+}
+
+// Assigns the next version of the object to the metadata along with its timestamps and
+// expiration, but does not store the version; see put for details.
+func (c *Collection) assign(meta *metadata.Metadata, prev *metadata.Metadata, kind metadata.Kind) (err error) {
+	// Objects written after a truncation restart their version history from version 1
+	// so the truncated record is removed and the object is treated as a new object.
+	if prev != nil && prev.Kind() == metadata.TRUNCATED && kind != metadata.TRUNCATED {
+		if _, err = c.purge(meta.ObjectID, nil); err != nil {
+			return err
+		}
+		prev = nil
+	}
+
+	now := time.Now()
+
+	// Only live versions can expire; tombstones and truncated records must be kept so
+	// that the deletion is replicated. Live objects default to the collection TTL.
+	if kind.Live() {
+		if meta.Expires.IsZero() && c.TTL > 0 {
+			meta.Expires = now.Add(c.TTL)
+		}
+	} else {
+		meta.Expires = time.Time{}
+	}
+
+	version := &metadata.Version{
+		Region:  region.ProcessRegion(),
+		Kind:    kind,
+		Created: now,
+	}
+
+	if prev != nil && prev.Version != nil {
+		parent := prev.Version.Scalar
+		version.Scalar = lamport.Next(&parent)
+		version.Parent = &parent
+		meta.Created = prev.Created
+	} else {
+		version.Scalar = lamport.Next(nil)
+		meta.Created = now
+	}
+	meta.Version = version
+	meta.Modified = now
+	return nil
+}
+
+// Marshals the object and stores it in the collection bucket under its assigned version,
+// updating the indexes of the collection if the version is the latest version of the
+// object, and recording the change in the change log of the collection. Payloads are
+// deduplicated: the data is stored once in the blob bucket of the collection and the
+// version holds a reference to it by its content address.
+func (c *Collection) store(meta *metadata.Metadata, data []byte) (err error) {
+	// The index entries of the latest version are replaced if this version supersedes it.
+	var indexed []indexEntry
+	if indexed, err = c.indexEntries(meta.ObjectID); err != nil {
+		return err
+	}
+
+	switch {
+	case len(data) > 0:
+		if meta.Blob, err = c.putBlob(data); err != nil {
+			return err
+		}
+		data = nil
+	case len(meta.Blob) > 0:
+		if err = c.linkBlob(meta.Blob); err != nil {
+			return err
+		}
+	}
+
+	var obj object.Object
+	if obj, err = object.Marshal(meta, data); err != nil {
+		return fmt.Errorf("could not marshal object: %w", err)
+	}
+
+	// NOTE: the key is created directly rather than using meta.Key() since the
+	// metadata may have cached a key from a previous version.
+	key := keys.New(meta.ObjectID, &meta.Version.Scalar)
+	if err = c.bkt.Put(key, obj); err != nil {
+		return fmt.Errorf("could not store object: %w", err)
+	}
+
+	if err = c.reindex(meta.ObjectID, indexed); err != nil {
+		return err
+	}
+
+	// Every stored version is appended to the change log for watchers.
+	return c.record(meta)
+}
+
+// Adds a tombstone version to up to limit objects in the collection whose keys sort
+// after the specified key (or from the start of the collection if after is nil). If
+// filter is not nil, only objects whose latest version it returns true for are
+// tombstoned. Returns the key of the last object scanned, which should be passed to the
+// next call to continue tombstoning the collection, or nil if the end of the collection
+// was reached, along with the number of objects that were tombstoned.
+func (c *Collection) tombstone(after keys.Key, limit int, filter func(*metadata.Metadata) bool) (last keys.Key, n int, err error) {
+	// Deleted objects do not need another tombstone.
+	var latest []*metadata.Metadata
+	if last, latest, err = c.scanLatest(after, limit, func(meta *metadata.Metadata) bool {
+		return meta.Kind().Live() && (filter == nil || filter(meta))
+	}); err != nil {
+		return nil, 0, err
+	}
+
+	for _, meta := range latest {
+		if err = c.put(meta, nil, meta, metadata.TOMBSTONE); err != nil {
+			return nil, n, err
+		}
+		n++
+	}
+	return last, n, nil
+}
+
+// Replaces the version history of up to limit objects in the collection whose keys sort
+// after the specified key with a truncated record in the same manner as tombstone; if
+// filter is not nil, only objects whose latest version it returns true for are
+// truncated. Objects that are already truncated are skipped.
+func (c *Collection) truncateObjects(after keys.Key, limit int, filter func(*metadata.Metadata) bool) (last keys.Key, n int, err error) {
+	var latest []*metadata.Metadata
+	if last, latest, err = c.scanLatest(after, limit, func(meta *metadata.Metadata) bool {
+		return meta.Kind() != metadata.TRUNCATED && (filter == nil || filter(meta))
+	}); err != nil {
+		return nil, 0, err
+	}
+
+	for _, meta := range latest {
+		if err = c.truncate(meta); err != nil {
+			return nil, n, fmt.Errorf("could not truncate object %s: %w", meta.ObjectID, err)
+		}
+		n++
+	}
+	return last, n, nil
+}
+
+// Scans up to limit objects in the collection whose keys sort after the specified key
+// (or from the start of the collection if after is nil) and returns the metadata of
+// the latest version of each object that filter returns true for. The latest versions
+// are collected before the caller writes new versions since writing while iterating
+// with a bolt cursor will cause keys to be skipped. Returns the key of the last object
+// scanned, or nil if the end of the collection was reached.
+func (c *Collection) scanLatest(after keys.Key, limit int, filter func(*metadata.Metadata) bool) (last keys.Key, latest []*metadata.Metadata, err error) {
+	var (
+		key, value []byte
+		scanned    int
+	)
+
+	cursor := c.bkt.Cursor()
+	if after == nil {
+		key, value = cursor.First()
+	} else {
+		key, value = cursor.Seek(after.ObjectLimit())
+	}
+
+	for key != nil && scanned < limit {
+		// Skip nested buckets (e.g. indexes) and any keys that are not object keys.
+		if value == nil || keys.Key(key).Check() != nil {
+			key, value = cursor.Next()
+			continue
+		}
+
+		if err = c.canceled(); err != nil {
+			return nil, nil, err
+		}
+
+		// The first key of each object is its latest version since keys are sorted
+		// latest version first.
+		scanned++
+		last = bytes.Clone(key)
+
+		var meta *metadata.Metadata
+		if meta, err = object.Object(value).Metadata(); err != nil {
+			return nil, nil, fmt.Errorf("could not parse object metadata: %w", err)
+		}
+
+		if filter(meta) {
+			latest = append(latest, meta)
+		}
+
+		key, value = cursor.Seek(keys.Key(key).ObjectLimit())
+	}
+
+	// If the end of the collection was reached, there is nothing left to scan.
+	if key == nil {
+		last = nil
+	}
+	return last, latest, nil
+}
+
+// Prunes the superseded versions of up to limit objects in the collection whose keys
+// sort after the specified key (or from the start of the collection if after is nil)
+// according to the retention policy. The latest version of each object is always kept,
+// as are superseded tombstones that have not been replicated past the horizon, so that
+// peers that have not yet received the deletion do not resurrect the object. Returns
+// the key of the last object scanned, which should be passed to the next call to
+// continue compacting, or nil if the end of the collection was reached, along with the
+// number of versions that were pruned.
+func (c *Collection) compact(after keys.Key, limit int, retention *metadata.Retention, h horizon, now time.Time) (last keys.Key, n int, err error) {
+	// Collect the keys to prune first since deleting while iterating with a bolt cursor
+	// will cause keys to be skipped.
+	var (
+		key, value []byte
+		prune      [][]byte
+		scanned    int
+	)
+
+	cursor := c.bkt.Cursor()
+	if after == nil {
+		key, value = cursor.First()
+	} else {
+		key, value = cursor.Seek(after.ObjectLimit())
+	}
+
+	for key != nil && scanned < limit {
+		// Skip nested buckets (e.g. indexes) and any keys that are not object keys.
+		if value == nil || keys.Key(key).Check() != nil {
+			key, value = cursor.Next()
+			continue
+		}
+
+		if err = c.canceled(); err != nil {
+			return nil, 0, err
+		}
+
+		scanned++
+		last = bytes.Clone(key)
+
+		// Versions are sorted latest first, so the position is the number of newer versions.
+		prefix := keys.Key(key).ObjectPrefix()
+		for position := 0; key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+			var meta *metadata.Metadata
+			if meta, err = object.Object(value).Metadata(); err != nil {
+				return nil, 0, fmt.Errorf("could not parse object metadata: %w", err)
+			}
+
+			if meta.Version != nil && !retention.Keep(position, meta.Version.Created, now) {
+				if !meta.Version.IsTombstone() || c.replicated(h, key, meta.Version) {
+					prune = append(prune, bytes.Clone(key))
+				}
+			}
+			position++
+		}
+	}
+
+	// If the end of the collection was reached, there is nothing left to compact.
+	if key == nil {
+		last = nil
+	}
+
+	for _, key := range prune {
+		if err = c.deleteVersion(key); err != nil {
+			return nil, n, fmt.Errorf("could not prune object version: %w", err)
+		}
+		n++
+	}
+	return last, n, nil
+}
+
+// Permanently removes tombstoned objects (and truncated records) along with all of
+// their versions when the latest version has been replicated past the horizon. Up to
+// limit objects whose keys sort after the specified key (or from the start of the
+// collection if after is nil) are scanned. Returns the key of the last object scanned,
+// which should be passed to the next call to continue collecting, or nil if the end of
+// the collection was reached, along with the number of objects that were removed.
+func (c *Collection) collect(after keys.Key, limit int, h horizon) (last keys.Key, n int, err error) {
+	// Collect the object IDs first since deleting while iterating with a bolt cursor
+	// will cause keys to be skipped.
+	var (
+		key, value []byte
+		remove     []ulid.ULID
+		scanned    int
+	)
+
+	cursor := c.bkt.Cursor()
+	if after == nil {
+		key, value = cursor.First()
+	} else {
+		key, value = cursor.Seek(after.ObjectLimit())
+	}
+
+	for key != nil && scanned < limit {
+		// Skip nested buckets (e.g. indexes) and any keys that are not object keys.
+		if value == nil || keys.Key(key).Check() != nil {
+			key, value = cursor.Next()
+			continue
+		}
+
+		if err = c.canceled(); err != nil {
+			return nil, 0, err
+		}
+
+		// The first key of each object is its latest version since keys are sorted
+		// latest version first.
+		scanned++
+		last = bytes.Clone(key)
+		if obj := object.Object(value); obj.Tombstone() {
+			var meta *metadata.Metadata
+			if meta, err = obj.Metadata(); err != nil {
+				return nil, 0, fmt.Errorf("could not parse object metadata: %w", err)
+			}
+
+			if c.replicated(h, key, meta.Version) {
+				remove = append(remove, meta.ObjectID)
+			}
+		}
+
+		key, value = cursor.Seek(keys.Key(key).ObjectLimit())
+	}
+
+	// If the end of the collection was reached, there is nothing left to collect.
+	if key == nil {
+		last = nil
+	}
+
+	for _, id := range remove {
+		if _, err = c.purge(id, nil); err != nil {
+			return nil, n, err
+		}
+		n++
+	}
+	return last, n, nil
+}
+
+// Removes all versions of the object from the collection and replaces them with a
+// truncated record whose parent is the latest version, so that the truncation can be
+// replicated. The truncated record only contains the identifying metadata of the object.
+func (c *Collection) truncate(prev *metadata.Metadata) (err error) {
+	if _, err = c.purge(prev.ObjectID, nil); err != nil {
+		return err
+	}
+
+	marker := &metadata.Metadata{
+		ObjectID:     prev.ObjectID,
+		CollectionID: c.ID,
+	}
+	return c.put(marker, nil, prev, metadata.TRUNCATED)
+}
+
+// Deletes versions of the object from the collection bucket and returns the number of
+// versions deleted. If filter is not nil, only versions that it returns true for are
+// deleted; versions whose metadata cannot be decoded are not passed to the filter.
+func (c *Collection) purge(id ulid.ULID, filter func(*metadata.Metadata) bool) (n int, err error) {
+	// Keys are collected first since deleting while iterating with a bolt cursor will
+	// cause keys to be skipped.
+	var versions [][]byte
+	prefix := keys.New(id, nil).ObjectPrefix()
+	cursor := c.bkt.Cursor()
+	for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+		if filter != nil {
+			meta, err := object.Object(value).Metadata()
+			if err != nil || meta.Version == nil || !filter(meta) {
+				continue
+			}
+		}
+		versions = append(versions, bytes.Clone(key))
+	}
+
+	for _, key := range versions {
+		if err = c.canceled(); err != nil {
+			return n, err
+		}
+
+		if err = c.deleteVersion(key); err != nil {
+			return n, fmt.Errorf("could not delete object version: %w", err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Deletes the object version with the specified key along with its chunked data, its
+// reference to a deduplicated payload, its index entries, and its entry in the change
+// log. The deletion is recorded in the removal log for incremental backups.
+func (c *Collection) deleteVersion(key []byte) (err error) {
+	objectID := keys.Key(key).ObjectID()
+
+	var indexed []indexEntry
+	if indexed, err = c.indexEntries(objectID); err != nil {
+		return err
+	}
+
+	if err = c.dropVersion(key); err != nil {
+		return err
+	}
+
+	if err = c.reindex(objectID, indexed); err != nil {
+		return err
+	}
+
+	if err = c.forget(key); err != nil {
+		return err
+	}
+	return c.remove(key)
+}
+
+// Deletes the object version with the specified key along with its chunked data and
+// its reference to a deduplicated payload without updating the indexes or logs.
+func (c *Collection) dropVersion(key []byte) (err error) {
+	if data := c.bkt.Get(key); data != nil {
+		if meta, merr := object.Object(data).Metadata(); merr == nil && len(meta.Blob) > 0 {
+			if err = c.releaseBlob(meta.Blob); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = c.bkt.Delete(key); err != nil {
+		return err
+	}
+
+	if chunks := c.bkt.Bucket(chunksBucket); chunks != nil {
+		if err = chunks.DeleteBucket(key); err != nil && !errors.Is(err, engine.ErrBucketNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the metadata of the latest version of the object with the specified ID or
+// nil if no version of the object exists in the collection (tombstones are returned).
+func (c *Collection) latest(id ulid.ULID) (_ *metadata.Metadata, err error) {
+	key, data := c.seekLatest(id)
+	if key == nil {
+		return nil, nil
+	}
+
+	var meta *metadata.Metadata
+	if meta, err = object.Object(data).Metadata(); err != nil {
+		return nil, fmt.Errorf("could not parse object metadata: %w", err)
+	}
+	return meta, nil
+}
+
+// Positions a cursor on the latest version of the object with the specified ID and
+// returns the key and value, or nil if the object does not exist in the collection.
+func (c *Collection) seekLatest(id ulid.ULID) (key, value []byte) {
+	// Versions are sorted from newest to oldest, so seeking to the object prefix will
+	// position the cursor on the latest version of the object. If the collection is
+	// pinned to a point in time, the first version visible at that time is returned.
+	prefix := keys.New(id, nil).ObjectPrefix()
+	cursor := c.bkt.Cursor()
+	for key, value = cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+		if c.pin.visibleObject(keys.Key(key), value) {
+			return key, value
+		}
+	}
+	return nil, nil
+}
+
+// Checks the write options of an upsert against the latest version of the object.
+func mergeable(prev *metadata.Metadata, wo *opts.WriteOptions) error {
+	if !expected(prev, wo) {
+		return errors.ErrVersionConflict
+	}
+
+	exists := prev != nil && !prev.IsTombstone()
+	if exists && wo.GetNoOverwrite() {
+		return errors.ErrAlreadyExists
+	}
+
+	if !exists && wo.GetCheckUpdate() {
+		return errors.ErrNotFound
+	}
+	return nil
+}
+
+// Returns true if the latest version of the object satisfies the expected version
+// precondition of the write options (if any).
+func expected(prev *metadata.Metadata, wo *opts.WriteOptions) bool {
+	if prev == nil || prev.Version == nil {
+		return wo.GetExpect().Match(nil, false)
+	}
+	return wo.GetExpect().Match(&prev.Version.Scalar, prev.IsTombstone())
+}
+
+// Returns true if the version is a tombstone or had expired at the point in time the
+// collection is pinned to (or now if the collection is not pinned).
+func (c *Collection) deleted(_ keys.Key, obj object.Object) bool {
+	return obj.Tombstone() || expired(obj, c.pin.now())
+}
+
+// Returns true if the object has an expiration that has passed. Objects whose metadata
+// cannot be parsed are not considered expired.
+func expired(obj object.Object, now time.Time) bool {
+	meta, err := obj.Metadata()
+	if err != nil {
+		return false
+	}
+	return meta.Expired(now)
+}
+
+// Bolt values are only valid for the life of the transaction so objects returned to the
+// caller must be copied out of the underlying memory map.
+func copyObject(data []byte) object.Object {
+	obj := make(object.Object, len(data))
+	copy(obj, data)
+	return obj
+}
+
+// Returns true if the underlying bucket belongs to a writable transaction.
+func (c *Collection) writable() bool {
+	return c.bkt.Writable()
+}
+
+// Returns the error of the context of the transaction the collection was opened in if
+// the context was canceled or its deadline has passed so that long running scans and
+// writes can be aborted; the caller should roll back the transaction.
+func (c *Collection) canceled() error {
+	if c.ctx == nil {
+		return nil
+	}
+	return c.ctx.Err()
+}
+
 // Returns either an ULID or a name from the specified identifier, returning an error
 // if the identifier is not valid (e.g. zero valued or not a collection name).
 // NOTE: this method will not return a system collection ID or name.
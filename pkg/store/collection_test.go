@@ -1 +1,508 @@
 package store_test
+
+import (
+	"time"
+
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/lamport"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/object"
+	"go.rtnl.ai/honu/pkg/store/opts"
+	"go.rtnl.ai/ulid"
+)
+
+func (s *honuTestSuite) TestCreate() {
+	require := s.Require()
+	info := s.createCollection()
+
+	tx, c := s.openCollection(info.ID, false)
+	meta := &metadata.Metadata{MIME: "application/json"}
+	require.NoError(c.Create(meta, []byte(`{"color":"red"}`), nil))
+	require.NoError(tx.Commit())
+
+	require.False(meta.ObjectID.IsZero(), "expected object ID to be assigned")
+	require.Equal(info.ID, meta.CollectionID)
+	require.Equal(uint64(1), meta.Version.Scalar.VID)
+	require.Equal(s.conf.PID, meta.Version.Scalar.PID)
+	require.Nil(meta.Version.Parent)
+
+	// The object should be persisted to disk after the commit.
+	_, c = s.openCollection(info.ID, true)
+	require.True(c.Has(meta.ObjectID))
+	require.True(c.Exists(meta.ObjectID))
+
+	iter := c.List()
+	defer iter.Release()
+	require.True(iter.Next())
+	require.Equal(meta.Key(), iter.Key())
+
+	data, err := iter.Object().Data()
+	require.NoError(err)
+	require.Equal([]byte(`{"color":"red"}`), data)
+}
+
+func (s *honuTestSuite) TestCreateReadOnly() {
+	info := s.createCollection()
+	_, c := s.openCollection(info.ID, true)
+	err := c.Create(&metadata.Metadata{}, []byte("foo"), nil)
+	s.Require().ErrorIs(err, errors.ErrReadOnlyTx)
+}
+
+func (s *honuTestSuite) TestCreateEmpty() {
+	require := s.Require()
+	info := s.createCollection()
+
+	// Objects with empty data are live records and must not be treated as deleted.
+	tx, c := s.openCollection(info.ID, false)
+	meta := &metadata.Metadata{}
+	require.NoError(c.Create(meta, nil, nil))
+	require.NoError(tx.Commit())
+	require.Equal(metadata.LIVE, meta.Kind())
+
+	_, c = s.openCollection(info.ID, true)
+	require.True(c.Exists(meta.ObjectID))
+
+	obj, err := c.Retrieve(keys.New(meta.ObjectID, nil), nil)
+	require.NoError(err, "could not retrieve empty object")
+	require.False(obj.Tombstone())
+
+	iter := c.List()
+	defer iter.Release()
+	require.True(iter.Next(), "expected empty object to be listed")
+	require.Equal(meta.ObjectID, iter.Key().ObjectID())
+}
+
+func (s *honuTestSuite) TestUpdate() {
+	require := s.Require()
+	info := s.createCollection()
+
+	tx, c := s.openCollection(info.ID, false)
+	meta := &metadata.Metadata{}
+	require.NoError(c.Create(meta, []byte("first"), nil))
+	created := meta.Version.Scalar
+
+	require.NoError(c.Update(meta, []byte("second"), &opts.WriteOptions{CheckUpdate: true}))
+	require.NoError(tx.Commit())
+
+	require.Equal(created.VID+1, meta.Version.Scalar.VID)
+	require.NotNil(meta.Version.Parent)
+	require.Equal(created, *meta.Version.Parent)
+
+	// Two versions of the object should be stored, latest version first.
+	_, c = s.openCollection(info.ID, true)
+	iter := c.Versions(meta.ObjectID)
+	defer iter.Release()
+
+	var versions []*metadata.Metadata
+	for iter.Next() {
+		m, err := iter.Object().Metadata()
+		require.NoError(err)
+		versions = append(versions, m)
+	}
+	require.NoError(iter.Error())
+	require.Len(versions, 2)
+	require.Equal(meta.Version.Scalar, versions[0].Version.Scalar)
+	require.Equal(created, versions[1].Version.Scalar)
+	require.Equal(versions[0].Created, versions[1].Created, "expected created timestamp to be preserved")
+}
+
+func (s *honuTestSuite) TestUpdateOptions() {
+	require := s.Require()
+	info := s.createCollection()
+	tx, c := s.openCollection(info.ID, false)
+
+	s.Run("MissingID", func() {
+		err := c.Update(&metadata.Metadata{}, []byte("foo"), nil)
+		require.ErrorIs(err, errors.ErrMissingObjectID)
+	})
+
+	s.Run("CheckUpdate", func() {
+		meta := &metadata.Metadata{ObjectID: ulid.MakeSecure()}
+		err := c.Update(meta, []byte("foo"), &opts.WriteOptions{CheckUpdate: true})
+		require.ErrorIs(err, errors.ErrNotFound)
+		require.False(c.Has(meta.ObjectID))
+	})
+
+	s.Run("Upsert", func() {
+		meta := &metadata.Metadata{ObjectID: ulid.MakeSecure()}
+		require.NoError(c.Update(meta, []byte("foo"), nil))
+		require.True(c.Exists(meta.ObjectID))
+		require.Equal(uint64(1), meta.Version.Scalar.VID)
+	})
+
+	s.Run("NoOverwrite", func() {
+		meta := &metadata.Metadata{}
+		require.NoError(c.Create(meta, []byte("foo"), nil))
+		err := c.Update(meta, []byte("bar"), &opts.WriteOptions{NoOverwrite: true})
+		require.ErrorIs(err, errors.ErrAlreadyExists)
+	})
+
+	require.NoError(tx.Commit())
+}
+
+func (s *honuTestSuite) TestMerge() {
+	require := s.Require()
+	info := s.createCollection()
+	tx, c := s.openCollection(info.ID, false)
+
+	// Merge without an ID should create a new object.
+	meta := &metadata.Metadata{}
+	require.NoError(c.Merge(meta, []byte("first"), nil))
+	require.False(meta.ObjectID.IsZero())
+	require.Equal(uint64(1), meta.Version.Scalar.VID)
+
+	// Merge with an ID should create a new version of the object.
+	require.NoError(c.Merge(meta, []byte("second"), nil))
+	require.Equal(uint64(2), meta.Version.Scalar.VID)
+	require.Equal(uint64(1), meta.Version.Parent.VID)
+
+	// NoOverwrite should prevent the merge if the object exists.
+	err := c.Merge(meta, []byte("third"), &opts.WriteOptions{NoOverwrite: true})
+	require.ErrorIs(err, errors.ErrAlreadyExists)
+
+	// CheckUpdate should prevent the merge if the object does not exist.
+	err = c.Merge(&metadata.Metadata{}, []byte("fourth"), &opts.WriteOptions{CheckUpdate: true})
+	require.ErrorIs(err, errors.ErrNotFound)
+
+	require.NoError(tx.Commit())
+
+	_, c = s.openCollection(info.ID, true)
+	iter := c.Versions(meta.ObjectID)
+	defer iter.Release()
+
+	nvers := 0
+	for iter.Next() {
+		nvers++
+	}
+	require.NoError(iter.Error())
+	require.Equal(2, nvers, "expected only the two merged versions to be stored")
+}
+
+func (s *honuTestSuite) TestConditionalWrites() {
+	require := s.Require()
+	info := s.createCollection()
+	tx, c := s.openCollection(info.ID, false)
+	defer tx.Rollback()
+
+	s.Run("Update", func() {
+		meta := &metadata.Metadata{}
+		require.NoError(c.Create(meta, []byte("v1"), nil))
+		v1 := meta.Version.Scalar
+
+		// Two workers read version 1; the first update wins.
+		require.NoError(c.Update(meta, []byte("v2"), &opts.WriteOptions{Expect: opts.ExpectVersion(v1)}))
+		err := c.Update(meta, []byte("v2'"), &opts.WriteOptions{Expect: opts.ExpectVersion(v1)})
+		require.ErrorIs(err, errors.ErrVersionConflict)
+		require.Equal(2, s.countVersions(c, meta.ObjectID), "expected conflicting update not to be written")
+
+		// The second worker can retry with the latest version.
+		require.NoError(c.Update(meta, []byte("v3"), &opts.WriteOptions{Expect: opts.ExpectVersion(meta.Version.Scalar)}))
+		require.Equal(uint64(3), meta.Version.Scalar.VID)
+
+		// An existing object does not satisfy must not exist.
+		err = c.Update(meta, []byte("v4"), &opts.WriteOptions{Expect: opts.ExpectNotExists()})
+		require.ErrorIs(err, errors.ErrVersionConflict)
+
+		// An object that does not exist does not match any version.
+		missing := &metadata.Metadata{ObjectID: ulid.MakeSecure()}
+		err = c.Update(missing, []byte("v1"), &opts.WriteOptions{Expect: opts.ExpectVersion(v1)})
+		require.ErrorIs(err, errors.ErrVersionConflict)
+		require.False(c.Has(missing.ObjectID))
+	})
+
+	s.Run("Merge", func() {
+		// Only one of two workers can create the object when it must not exist.
+		meta := &metadata.Metadata{ObjectID: ulid.MakeSecure()}
+		require.NoError(c.Merge(meta, []byte("first"), &opts.WriteOptions{Expect: opts.ExpectNotExists()}))
+		err := c.Merge(meta, []byte("second"), &opts.WriteOptions{Expect: opts.ExpectNotExists()})
+		require.ErrorIs(err, errors.ErrVersionConflict)
+
+		err = c.Merge(meta, []byte("third"), &opts.WriteOptions{Expect: opts.ExpectVersion(lamport.Scalar{PID: 42, VID: 1})})
+		require.ErrorIs(err, errors.ErrVersionConflict)
+
+		require.NoError(c.Merge(meta, []byte("fourth"), &opts.WriteOptions{Expect: opts.ExpectVersion(meta.Version.Scalar)}))
+		require.Equal(2, s.countVersions(c, meta.ObjectID))
+	})
+
+	s.Run("Delete", func() {
+		meta := &metadata.Metadata{}
+		require.NoError(c.Create(meta, []byte("v1"), nil))
+		v1 := meta.Version.Scalar
+		require.NoError(c.Update(meta, []byte("v2"), nil))
+
+		// Deleting a stale version is a conflict and does not delete the object.
+		err := c.Delete(meta.Key(), &opts.WriteOptions{Expect: opts.ExpectVersion(v1)})
+		require.ErrorIs(err, errors.ErrVersionConflict)
+		require.True(c.Exists(meta.ObjectID))
+
+		require.NoError(c.Delete(meta.Key(), &opts.WriteOptions{Expect: opts.ExpectVersion(meta.Version.Scalar)}))
+		require.False(c.Exists(meta.ObjectID))
+
+		// A deleted object satisfies must not exist, so it can be recreated.
+		require.NoError(c.Update(meta, []byte("v4"), &opts.WriteOptions{Expect: opts.ExpectNotExists()}))
+		require.True(c.Exists(meta.ObjectID))
+		require.Equal(uint64(4), meta.Version.Scalar.VID)
+	})
+}
+
+func (s *honuTestSuite) TestRetrieve() {
+	require := s.Require()
+	info := s.createCollection()
+	tx, c := s.openCollection(info.ID, false)
+
+	meta := &metadata.Metadata{}
+	require.NoError(c.Create(meta, []byte("first"), nil))
+	first := meta.Version.Scalar
+
+	require.NoError(c.Update(meta, []byte("second"), nil))
+	second := meta.Version.Scalar
+
+	s.Run("Latest", func() {
+		obj, err := c.Retrieve(keys.New(meta.ObjectID, nil), nil)
+		require.NoError(err)
+
+		data, err := obj.Data()
+		require.NoError(err)
+		require.Equal([]byte("second"), data)
+	})
+
+	s.Run("Version", func() {
+		obj, err := c.Retrieve(keys.New(meta.ObjectID, &first), nil)
+		require.NoError(err)
+
+		data, err := obj.Data()
+		require.NoError(err)
+		require.Equal([]byte("first"), data)
+	})
+
+	s.Run("NotFound", func() {
+		_, err := c.Retrieve(keys.New(ulid.MakeSecure(), nil), nil)
+		require.ErrorIs(err, errors.ErrNotFound)
+	})
+
+	s.Run("VersionNotFound", func() {
+		_, err := c.Retrieve(keys.New(meta.ObjectID, &lamport.Scalar{PID: 42, VID: 1}), nil)
+		require.ErrorIs(err, errors.ErrVersionNotFound)
+	})
+
+	s.Run("BadKey", func() {
+		_, err := c.Retrieve(keys.Key([]byte("foo")), nil)
+		require.ErrorIs(err, keys.ErrBadSize)
+	})
+
+	// Delete the object to create a tombstone version.
+	require.NoError(c.Delete(keys.New(meta.ObjectID, nil), nil))
+	var tombstone lamport.Scalar
+
+	s.Run("Tombstone", func() {
+		_, err := c.Retrieve(keys.New(meta.ObjectID, nil), nil)
+		require.ErrorIs(err, errors.ErrNotFound)
+
+		obj, err := c.Retrieve(keys.New(meta.ObjectID, nil), &opts.ReadOptions{Tombstones: true})
+		require.NoError(err)
+		require.True(obj.Tombstone())
+
+		tmeta, err := obj.Metadata()
+		require.NoError(err)
+		require.True(tmeta.IsTombstone())
+		require.Equal(second, *tmeta.Version.Parent)
+
+		tombstone = tmeta.Version.Scalar
+		require.True(tombstone.After(&second))
+	})
+
+	s.Run("TombstoneVersion", func() {
+		obj, err := c.Retrieve(keys.New(meta.ObjectID, &tombstone), nil)
+		require.NoError(err)
+		require.True(obj.Tombstone())
+
+		obj, err = c.Retrieve(keys.New(meta.ObjectID, &second), nil)
+		require.NoError(err)
+		require.False(obj.Tombstone())
+	})
+
+	require.NoError(tx.Commit())
+}
+
+func (s *honuTestSuite) TestDelete() {
+	require := s.Require()
+	info := s.createCollection()
+	tx, c := s.openCollection(info.ID, false)
+
+	meta := &metadata.Metadata{}
+	require.NoError(c.Create(meta, []byte("foo"), nil))
+	require.True(c.Exists(meta.ObjectID))
+
+	require.NoError(c.Delete(meta.Key(), &opts.WriteOptions{CheckDelete: true}))
+	require.True(c.Has(meta.ObjectID))
+	require.False(c.Exists(meta.ObjectID))
+
+	// Deleting a deleted object is a no-op unless CheckDelete is specified.
+	require.NoError(c.Delete(keys.New(meta.ObjectID, nil), nil))
+	err := c.Delete(keys.New(meta.ObjectID, nil), &opts.WriteOptions{CheckDelete: true})
+	require.ErrorIs(err, errors.ErrNotFound)
+
+	err = c.Delete(keys.New(ulid.MakeSecure(), nil), &opts.WriteOptions{CheckDelete: true})
+	require.ErrorIs(err, errors.ErrNotFound)
+
+	require.NoError(tx.Commit())
+}
+
+func (s *honuTestSuite) TestVersions() {
+	require := s.Require()
+	info := s.createCollection()
+	tx, c := s.openCollection(info.ID, false)
+
+	meta := &metadata.Metadata{}
+	require.NoError(c.Create(meta, []byte("first"), nil))
+	require.NoError(c.Update(meta, []byte("second"), nil))
+	require.NoError(c.Update(meta, []byte("third"), nil))
+	require.NoError(c.Delete(meta.Key(), nil))
+
+	// Create another object to ensure only the versions of the object are returned.
+	require.NoError(c.Create(&metadata.Metadata{}, []byte("other"), nil))
+	require.NoError(tx.Commit())
+
+	_, c = s.openCollection(info.ID, true)
+	iter := c.Versions(meta.ObjectID)
+	defer iter.Release()
+
+	var versions []uint64
+	var tombstones int
+	for iter.Next() {
+		vers := iter.Key().Version()
+		versions = append(versions, vers.VID)
+
+		if iter.Object().Tombstone() {
+			tombstones++
+		}
+
+		require.False(iter.Forked())
+		if parent := iter.Parent(); parent != nil {
+			require.Equal(vers.VID-1, parent.VID)
+		}
+	}
+
+	require.NoError(iter.Error())
+	require.Equal([]uint64{4, 3, 2, 1}, versions)
+	require.Equal(1, tombstones)
+	require.Empty(iter.Forks())
+}
+
+func (s *honuTestSuite) TestDestroy() {
+	require := s.Require()
+	info := s.createCollection()
+	tx, c := s.openCollection(info.ID, false)
+
+	meta := &metadata.Metadata{}
+	require.NoError(c.Create(meta, []byte("first"), nil))
+	require.NoError(c.Update(meta, []byte("second"), nil))
+	require.NoError(c.Destroy(keys.New(meta.ObjectID, nil)))
+
+	// Only the truncated record should remain in the version history.
+	require.True(c.Has(meta.ObjectID))
+	require.False(c.Exists(meta.ObjectID))
+
+	obj, err := c.Retrieve(keys.New(meta.ObjectID, nil), &opts.ReadOptions{Tombstones: true})
+	require.NoError(err, "could not retrieve truncated record")
+	kind, err := obj.Kind()
+	require.NoError(err)
+	require.Equal(metadata.TRUNCATED, kind)
+
+	iter := c.Versions(meta.ObjectID)
+	n := 0
+	for iter.Next() {
+		n++
+	}
+	iter.Release()
+	require.Equal(1, n, "expected only the truncated record to remain")
+
+	// Destroying a destroyed object is a no-op; destroying a missing object is an error.
+	require.NoError(c.Destroy(keys.New(meta.ObjectID, nil)))
+	require.ErrorIs(c.Destroy(keys.New(ulid.MakeSecure(), nil)), errors.ErrNotFound)
+
+	// Writing the object after it was destroyed restarts the version history.
+	require.NoError(c.Update(meta, []byte("restart"), nil))
+	require.Equal(uint64(1), meta.Version.Scalar.VID)
+	require.Nil(meta.Version.Parent)
+	require.True(c.Exists(meta.ObjectID))
+
+	_, err = c.Retrieve(keys.New(meta.ObjectID, nil), nil)
+	require.NoError(err)
+	require.NoError(tx.Commit())
+}
+
+func (s *honuTestSuite) TestApplyTruncated() {
+	require := s.Require()
+	info := s.createCollection()
+	tx, c := s.openCollection(info.ID, false)
+
+	// Create an object with several versions as though it was replicated.
+	meta := &metadata.Metadata{}
+	require.NoError(c.Create(meta, []byte("first"), nil))
+	require.NoError(c.Update(meta, []byte("second"), nil))
+
+	// Build a truncated record as a remote replica would have after a truncation.
+	parent := meta.Version.Scalar
+	marker := &metadata.Metadata{
+		ObjectID:     meta.ObjectID,
+		CollectionID: info.ID,
+		Version: &metadata.Version{
+			Scalar:  lamport.Scalar{PID: 42, VID: parent.VID + 1},
+			Parent:  &parent,
+			Kind:    metadata.TRUNCATED,
+			Created: time.Now(),
+		},
+	}
+
+	obj, err := object.Marshal(marker, nil)
+	require.NoError(err)
+	require.NoError(c.Apply(obj), "could not apply truncated record")
+
+	// All of the older versions should have been dropped.
+	iter := c.Versions(meta.ObjectID)
+	var versions []lamport.Scalar
+	for iter.Next() {
+		versions = append(versions, iter.Key().Version())
+	}
+	iter.Release()
+	require.Equal([]lamport.Scalar{marker.Version.Scalar}, versions)
+	require.False(c.Exists(meta.ObjectID))
+
+	// Applying a version created before the truncation should not restore the object.
+	old := &metadata.Metadata{
+		ObjectID:     meta.ObjectID,
+		CollectionID: info.ID,
+		Version: &metadata.Version{
+			Scalar:  lamport.Scalar{PID: 7, VID: 1},
+			Created: marker.Version.Created.Add(-time.Minute),
+		},
+	}
+	obj, err = object.Marshal(old, []byte("stale"))
+	require.NoError(err)
+	require.NoError(c.Apply(obj))
+	require.False(c.Exists(meta.ObjectID))
+
+	// Applying a version created after the truncation replaces the truncated record.
+	fresh := &metadata.Metadata{
+		ObjectID:     meta.ObjectID,
+		CollectionID: info.ID,
+		Version: &metadata.Version{
+			Scalar:  lamport.Scalar{PID: 7, VID: 1},
+			Created: marker.Version.Created.Add(time.Minute),
+		},
+	}
+	obj, err = object.Marshal(fresh, []byte("fresh"))
+	require.NoError(err)
+	require.NoError(c.Apply(obj))
+	require.True(c.Exists(meta.ObjectID))
+	require.False(c.Has(ulid.MakeSecure()))
+
+	// Replicated versions must have a version and belong to the collection.
+	obj, err = object.Marshal(&metadata.Metadata{ObjectID: meta.ObjectID}, nil)
+	require.NoError(err)
+	require.ErrorIs(c.Apply(obj), errors.ErrMissingVersion)
+
+	require.NoError(tx.Commit())
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/ulid"
+)
+
+// The default number of objects whose versions are compacted in each transaction; this
+// bounds how long other writers are blocked by the background compactor.
+const DefaultCompactBatchSize = 1000
+
+//===========================================================================
+// Version Compaction
+//===========================================================================
+
+// Compact prunes superseded object versions from every collection that has a retention
+// policy, returning the total number of versions pruned. The latest version of every
+// object (including tombstones and truncated records that are needed for replication)
+// is always kept, as are superseded tombstones that have not yet been replicated past
+// the replication horizon of the peers (see CollectTombstones). Each collection is
+// compacted in bounded batches of separate write transactions so that compaction does
+// not block other writers for long periods. The removal logs of every collection are
+// also pruned up to the change vector of the last backup (see IncrementalBackup).
+func (s *Store) Compact(ctx context.Context) (pruned int, err error) {
+	if s.conf.ReadOnly {
+		return 0, errors.ErrReadOnlyDB
+	}
+
+	var h horizon
+	if err = s.view(ctx, func(tx engine.Tx) (err error) {
+		h, err = replicationHorizon(tx, s.conf.TombstoneGracePeriod, time.Now())
+		return err
+	}); err != nil {
+		return 0, err
+	}
+
+	var collections []*metadata.Collection
+	if collections, err = s.Collections(ctx); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	backup := s.lastBackup
+	s.mu.Unlock()
+
+	for _, info := range collections {
+		if sequence, ok := backup[info.ID]; ok {
+			if err = s.pruneRemovals(ctx, info.ID, sequence); err != nil && !errors.Is(err, errors.ErrNoCollection) {
+				return pruned, fmt.Errorf("could not prune removals of collection %s: %w", info, err)
+			}
+		}
+
+		if info.Retention == nil || info.Retention.Policy == metadata.KEEP_ALL {
+			continue
+		}
+
+		var n int
+		n, err = s.compactCollection(ctx, info.ID, h)
+		pruned += n
+
+		if err != nil {
+			// The collection may have been dropped while compacting.
+			if errors.Is(err, errors.ErrNoCollection) {
+				continue
+			}
+			return pruned, fmt.Errorf("could not compact collection %s: %w", info, err)
+		}
+	}
+
+	return pruned, nil
+}
+
+// Compacts a single collection in batches, reloading the collection metadata in each
+// batch in case the retention policy was modified or the collection was dropped.
+func (s *Store) compactCollection(ctx context.Context, collectionID ulid.ULID, h horizon) (pruned int, err error) {
+	var last keys.Key
+	now := time.Now()
+
+	for {
+		if err = s.update(ctx, func(tx engine.Tx) (err error) {
+			var info *metadata.Collection
+			if info, err = latestCollection(tx.Bucket(SystemCollections[:]), collectionID); err != nil {
+				return err
+			}
+
+			c := &Collection{Collection: *info, ctx: ctx}
+			if c.bkt = tx.Bucket(collectionID[:]); c.bkt == nil {
+				return errors.ErrRepairCollection
+			}
+
+			var n int
+			last, n, err = c.compact(last, DefaultCompactBatchSize, info.Retention, h, now)
+			pruned += n
+			return err
+		}); err != nil {
+			return pruned, err
+		}
+
+		if last == nil {
+			return pruned, nil
+		}
+	}
+}
+
+// Prunes the removal log of the collection up to the change log sequence of the last
+// backup; the log is pruned in a single transaction since it only holds the removals
+// since the previous backup.
+func (s *Store) pruneRemovals(ctx context.Context, collectionID ulid.ULID, sequence uint64) error {
+	return s.update(ctx, func(tx engine.Tx) error {
+		c := &Collection{ctx: ctx}
+		if c.bkt = tx.Bucket(collectionID[:]); c.bkt == nil {
+			return errors.ErrNoCollection
+		}
+		return c.pruneRemovals(sequence)
+	})
+}
+
+// Runs a compaction pass from the background scheduler; errors are logged rather than
+// returned since there is no caller to handle them.
+func (s *Store) compactor(ctx context.Context) {
+	pruned, err := s.Compact(ctx)
+	if err != nil {
+		// The pass was aborted because the store is closing.
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+
+		log.Warn().Err(err).Int("pruned", pruned).Msg("background compaction failed")
+		return
+	}
+
+	if pruned > 0 {
+		log.Info().Int("pruned", pruned).Msg("compacted superseded object versions")
+	}
+}
@@ -0,0 +1,315 @@
+package store_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.rtnl.ai/honu/pkg/config"
+	"go.rtnl.ai/honu/pkg/region"
+	"go.rtnl.ai/honu/pkg/store"
+	"go.rtnl.ai/honu/pkg/store/lamport"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/object"
+	"go.rtnl.ai/ulid"
+)
+
+func (s *honuTestSuite) TestCompactVersions() {
+	require := s.Require()
+	info := s.createCollection()
+	info.Retention = &metadata.Retention{Policy: metadata.KEEP_VERSIONS, Versions: 2}
+	require.NoError(s.store.Modify(context.Background(), info), "could not set retention policy")
+
+	// Collections without a retention policy should not be compacted.
+	other := s.createCollection()
+
+	live, deleted := s.writeVersions(info, 5, false), s.writeVersions(info, 5, true)
+	otherLive, otherDeleted := s.writeVersions(other, 5, false), s.writeVersions(other, 5, true)
+
+	pruned, err := s.store.Compact(context.Background())
+	require.NoError(err, "could not compact store")
+	require.GreaterOrEqual(pruned, 7)
+
+	_, c := s.openCollection(info.ID, true)
+	require.Equal(2, s.countVersions(c, live.ObjectID), "expected only the last two versions to be retained")
+	require.Equal(2, s.countVersions(c, deleted.ObjectID), "expected only the last two versions to be retained")
+	require.True(c.Exists(live.ObjectID))
+
+	// The tombstone must be retained as the latest version of the deleted object.
+	require.True(c.Has(deleted.ObjectID))
+	require.False(c.Exists(deleted.ObjectID))
+
+	// The other collection should have all of its versions.
+	_, c = s.openCollection(other.ID, true)
+	require.Equal(5, s.countVersions(c, otherLive.ObjectID))
+	require.Equal(6, s.countVersions(c, otherDeleted.ObjectID))
+}
+
+func (s *honuTestSuite) TestCompactDuration() {
+	require := s.Require()
+	info := s.createCollection()
+	info.Retention = &metadata.Retention{Policy: metadata.KEEP_DURATION, Duration: time.Hour}
+	require.NoError(s.store.Modify(context.Background(), info), "could not set retention policy")
+
+	// Apply versions with old timestamps as though they were replicated.
+	oid := ulid.Make()
+	tx, c := s.openCollection(info.ID, false)
+	ages := []time.Duration{72 * time.Hour, 48 * time.Hour, 30 * time.Minute, 3 * time.Hour}
+	for i, age := range ages {
+		meta := &metadata.Metadata{
+			ObjectID:     oid,
+			CollectionID: info.ID,
+			Version: &metadata.Version{
+				Scalar:  lamport.Scalar{PID: 1, VID: uint64(i + 1)},
+				Created: time.Now().Add(-age),
+			},
+		}
+		obj, err := object.Marshal(meta, []byte("foo"))
+		require.NoError(err)
+		require.NoError(c.Apply(obj))
+	}
+	require.NoError(tx.Commit())
+
+	_, err := s.store.Compact(context.Background())
+	require.NoError(err, "could not compact store")
+
+	// The latest version (3 hours old) and the version younger than an hour are kept.
+	_, c = s.openCollection(info.ID, true)
+	iter := c.Versions(oid)
+	defer iter.Release()
+
+	var versions []uint64
+	for iter.Next() {
+		versions = append(versions, iter.Key().Version().VID)
+	}
+	require.Equal([]uint64{4, 3}, versions)
+}
+
+// Writes an object with the specified number of versions and optionally deletes it.
+func (s *honuTestSuite) writeVersions(info *metadata.Collection, versions int, deleted bool) *metadata.Metadata {
+	require := s.Require()
+	tx, c := s.openCollection(info.ID, false)
+
+	meta := &metadata.Metadata{}
+	require.NoError(c.Create(meta, []byte("v1"), nil))
+	for i := 1; i < versions; i++ {
+		require.NoError(c.Update(meta, []byte("vN"), nil))
+	}
+
+	if deleted {
+		require.NoError(c.Delete(meta.Key(), nil))
+	}
+
+	require.NoError(tx.Commit())
+	return meta
+}
+
+func (s *honuTestSuite) countVersions(c *store.Collection, oid ulid.ULID) (n int) {
+	iter := c.Versions(oid)
+	defer iter.Release()
+	for iter.Next() {
+		n++
+	}
+	s.Require().NoError(iter.Error())
+	return n
+}
+
+func TestBackgroundCompaction(t *testing.T) {
+	lamport.SetProcessID(8)
+	region.SetProcessRegion(region.TESTING)
+
+	conf := config.Config{
+		PID: uint32(8),
+		Store: config.StoreConfig{
+			DataPath:           filepath.Join(t.TempDir(), "honu-test.db"),
+			Concurrency:        16,
+			CompactionInterval: 10 * time.Millisecond,
+		},
+	}
+
+	db, err := store.Open(conf)
+	require.NoError(t, err, "could not open store")
+	defer db.Close()
+
+	info := &metadata.Collection{
+		Name:      "compacted",
+		Retention: &metadata.Retention{Policy: metadata.KEEP_VERSIONS, Versions: 1},
+	}
+	require.NoError(t, db.New(context.Background(), info), "could not create collection")
+
+	tx, err := db.Begin(context.Background(), nil)
+	require.NoError(t, err)
+	c, err := tx.Collection(info.ID)
+	require.NoError(t, err)
+
+	meta := &metadata.Metadata{}
+	require.NoError(t, c.Create(meta, []byte("v1"), nil))
+	require.NoError(t, c.Update(meta, []byte("v2"), nil))
+	require.NoError(t, c.Update(meta, []byte("v3"), nil))
+	require.NoError(t, tx.Commit())
+
+	require.Eventually(t, func() bool {
+		tx, err := db.Begin(context.Background(), &store.TxOptions{ReadOnly: true})
+		if err != nil {
+			return false
+		}
+		defer tx.Rollback()
+
+		c, err := tx.Collection(info.ID)
+		if err != nil {
+			return false
+		}
+
+		iter := c.Versions(meta.ObjectID)
+		defer iter.Release()
+
+		n := 0
+		for iter.Next() {
+			n++
+		}
+		return n == 1
+	}, time.Second, 10*time.Millisecond, "expected background compactor to prune versions")
+}
+
+func TestCompactTombstones(t *testing.T) {
+	db := openTestStore(t, config.StoreConfig{})
+
+	info := &metadata.Collection{
+		Name:      "compacted",
+		Retention: &metadata.Retention{Policy: metadata.KEEP_VERSIONS, Versions: 1},
+	}
+	require.NoError(t, db.New(context.Background(), info), "could not create collection")
+
+	// Apply a history in which the object was deleted and then recreated by a peer.
+	oid := ulid.Make()
+	kinds := []metadata.Kind{metadata.LIVE, metadata.TOMBSTONE, metadata.LIVE, metadata.LIVE}
+
+	tx, err := db.Begin(context.Background(), nil)
+	require.NoError(t, err)
+	c, err := tx.Collection(info.ID)
+	require.NoError(t, err)
+
+	for i, kind := range kinds {
+		meta := &metadata.Metadata{
+			ObjectID:     oid,
+			CollectionID: info.ID,
+			Version: &metadata.Version{
+				Scalar:  lamport.Scalar{PID: 1, VID: uint64(i + 1)},
+				Kind:    kind,
+				Created: time.Now(),
+			},
+		}
+		obj, err := object.Marshal(meta, nil)
+		require.NoError(t, err)
+		require.NoError(t, c.Apply(obj))
+	}
+	require.NoError(t, tx.Commit())
+
+	versions := func() (vids []uint64) {
+		tx, err := db.Begin(context.Background(), &store.TxOptions{ReadOnly: true})
+		require.NoError(t, err)
+		defer tx.Rollback()
+
+		c, err := tx.Collection(info.ID)
+		require.NoError(t, err)
+
+		iter := c.Versions(oid)
+		defer iter.Release()
+		for iter.Next() {
+			vids = append(vids, iter.Key().Version().VID)
+		}
+		return vids
+	}
+
+	// The superseded tombstone is kept until every peer has acknowledged it.
+	_, err = db.Compact(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []uint64{4, 2}, versions())
+
+	require.NoError(t, db.Acknowledge(context.Background(), 2, region.TESTING, store.ChangeVector{info.ID: 1}))
+	_, err = db.Compact(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []uint64{4, 2}, versions())
+
+	require.NoError(t, db.Acknowledge(context.Background(), 2, region.TESTING, store.ChangeVector{info.ID: 2}))
+	_, err = db.Compact(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []uint64{4}, versions())
+}
+
+func TestCompactTombstonesInterleaved(t *testing.T) {
+	db := openTestStore(t, config.StoreConfig{})
+
+	info := &metadata.Collection{
+		Name:      "interleaved",
+		Retention: &metadata.Retention{Policy: metadata.KEEP_VERSIONS, Versions: 1},
+	}
+	require.NoError(t, db.New(context.Background(), info), "could not create collection")
+
+	// Versions are numbered per object, so the superseded tombstone of alpha (VID 2)
+	// has a lower version than bravo's versions even though it was applied after them.
+	alpha, bravo := ulid.Make(), ulid.Make()
+	history := []struct {
+		oid  ulid.ULID
+		vid  uint64
+		kind metadata.Kind
+	}{
+		{bravo, 1, metadata.LIVE},
+		{bravo, 2, metadata.LIVE},
+		{bravo, 3, metadata.LIVE},
+		{alpha, 1, metadata.LIVE},
+		{alpha, 2, metadata.TOMBSTONE},
+		{alpha, 3, metadata.LIVE},
+	}
+
+	tx, err := db.Begin(context.Background(), nil)
+	require.NoError(t, err)
+	c, err := tx.Collection(info.ID)
+	require.NoError(t, err)
+
+	for _, vers := range history {
+		meta := &metadata.Metadata{
+			ObjectID:     vers.oid,
+			CollectionID: info.ID,
+			Version: &metadata.Version{
+				Scalar:  lamport.Scalar{PID: 1, VID: vers.vid},
+				Kind:    vers.kind,
+				Created: time.Now(),
+			},
+		}
+		obj, err := object.Marshal(meta, nil)
+		require.NoError(t, err)
+		require.NoError(t, c.Apply(obj))
+	}
+	require.NoError(t, tx.Commit())
+
+	versions := func(oid ulid.ULID) (vids []uint64) {
+		tx, err := db.Begin(context.Background(), &store.TxOptions{ReadOnly: true})
+		require.NoError(t, err)
+		defer tx.Rollback()
+
+		c, err := tx.Collection(info.ID)
+		require.NoError(t, err)
+
+		iter := c.Versions(oid)
+		defer iter.Release()
+		for iter.Next() {
+			vids = append(vids, iter.Key().Version().VID)
+		}
+		return vids
+	}
+
+	// A peer that has received every version of bravo has not received the deletion.
+	require.NoError(t, db.Acknowledge(context.Background(), 2, region.TESTING, store.ChangeVector{info.ID: 4}))
+	_, err = db.Compact(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3}, versions(bravo))
+	require.Equal(t, []uint64{3, 2}, versions(alpha))
+
+	require.NoError(t, db.Acknowledge(context.Background(), 2, region.TESTING, store.ChangeVector{info.ID: 5}))
+	_, err = db.Compact(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3}, versions(alpha))
+}
@@ -0,0 +1,271 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/ulid"
+)
+
+// The default number of objects that are scanned in each transaction when emptying a
+// collection; this bounds how long other writers are blocked by each batch.
+const DefaultEmptyBatchSize = 1000
+
+// Prefixes of the keys in the maintenance bucket that hold the cursors of jobs.
+var (
+	emptyJobPrefix    = []byte("empty:")
+	truncateJobPrefix = []byte("truncate:")
+)
+
+// EmptyOptions configure how Store.Empty works through a collection.
+type EmptyOptions struct {
+	// The maximum number of objects scanned in each write transaction; if zero the
+	// DefaultEmptyBatchSize is used.
+	BatchSize int
+
+	// If specified, Progress is called after each batch is committed.
+	Progress func(EmptyProgress)
+}
+
+// EmptyProgress reports the progress of emptying a collection. Progress is cumulative
+// across restarts: if an empty job is resumed after a crash the counts include the
+// batches that were committed before the crash.
+type EmptyProgress struct {
+	CollectionID ulid.ULID // The ID of the collection being emptied
+	Batches      uint64    // The number of batches that have been committed
+	Tombstoned   uint64    // The number of objects that have been tombstoned
+	Started      time.Time // When the empty job was first started
+	Done         bool      // True when the entire collection has been emptied
+}
+
+// Empty a collection by adding a tombstone version to all of its objects while
+// preserving their version history. These objects cannot be accessed directly any
+// longer; this is different from Store.Truncate which removes all objects and their
+// versions from the collection. The collection is emptied in bounded batches of
+// separate write transactions so that other writers are not blocked while very large
+// collections are emptied.
+//
+// The position of the job is persisted in the maintenance bucket after each batch so
+// that if the process crashes, calling Empty again will resume from the last committed
+// batch. Objects that were created after the empty job was first started are not
+// tombstoned by the job, but objects that existed before the job started are
+// tombstoned even if they are updated while the job is running. If the collection is
+// dropped while the job is running, the job is abandoned and ErrNoCollection is
+// returned. If the context is canceled, the batch in progress is rolled back and the
+// job can be resumed later from the last committed batch.
+// TODO: check permissions and ACLs to ensure the user is allowed to empty the collection.
+func (s *Store) Empty(ctx context.Context, identifier any, opts *EmptyOptions) (err error) {
+	if s.conf.ReadOnly {
+		return errors.ErrReadOnlyDB
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if opts == nil {
+		opts = &EmptyOptions{}
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultEmptyBatchSize
+	}
+	return s.run(ctx, identifier, emptyJob, batchSize, opts.Progress)
+}
+
+//===========================================================================
+// Collection Maintenance Jobs
+//===========================================================================
+
+// A maintenance job works through all of the objects in a collection in bounded
+// batches of separate write transactions. The cursor of the job is persisted in the
+// maintenance bucket in the same transaction as each batch so that an interrupted job
+// resumes from the last committed batch when it is run again.
+type maintenanceJob struct {
+	name   string
+	prefix []byte
+
+	// Applies the job to up to limit objects after the specified key and returns the
+	// key of the last object scanned (or nil at the end of the collection) along with
+	// the number of objects that were modified.
+	batch func(c *Collection, after keys.Key, limit int, started time.Time) (keys.Key, int, error)
+
+	// If not nil, done is called in the transaction of the final batch.
+	done func(c *Collection) error
+}
+
+var emptyJob = &maintenanceJob{
+	name:   "empty",
+	prefix: emptyJobPrefix,
+	batch: func(c *Collection, after keys.Key, limit int, started time.Time) (keys.Key, int, error) {
+		// Objects created after the job was started are not tombstoned. The creation time
+		// of the object is carried by all of its versions, so objects that existed when
+		// the job started are tombstoned even if they were updated after it started.
+		return c.tombstone(after, limit, func(meta *metadata.Metadata) bool {
+			return !meta.Created.After(started)
+		})
+	},
+}
+
+var truncateJob = &maintenanceJob{
+	name:   "truncate",
+	prefix: truncateJobPrefix,
+	batch: func(c *Collection, after keys.Key, limit int, started time.Time) (keys.Key, int, error) {
+		return c.truncateObjects(after, limit, func(meta *metadata.Metadata) bool {
+			return !meta.Created.After(started)
+		})
+	},
+	done: func(c *Collection) error {
+		return c.clearIndexes()
+	},
+}
+
+// Runs the maintenance job on the collection until it is done, resuming the job from
+// its persisted cursor if it was previously interrupted.
+func (s *Store) run(ctx context.Context, identifier any, job *maintenanceJob, batchSize int, progress func(EmptyProgress)) (err error) {
+	// Resolve the collection ID and load the cursor of any interrupted job.
+	var cursor *jobCursor
+	if err = s.view(ctx, func(tx engine.Tx) (err error) {
+		collections := tx.Bucket(SystemCollections[:])
+
+		var collectionID ulid.ULID
+		if collectionID, err = resolveCollection(collections, identifier); err != nil {
+			return err
+		}
+
+		if _, err = latestCollection(collections, collectionID); err != nil {
+			return err
+		}
+
+		cursor = loadJobCursor(tx.Bucket(SystemMaintenance[:]), job, collectionID)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if cursor.Batches > 0 {
+		log.Info().Str("collection", cursor.CollectionID.String()).Str("job", job.name).Uint64("modified", cursor.Tombstoned).Msg("resuming interrupted collection maintenance job")
+	}
+
+	for !cursor.Done {
+		if err = s.update(ctx, func(tx engine.Tx) (err error) {
+			return cursor.next(ctx, tx, batchSize)
+		}); err != nil {
+			return fmt.Errorf("could not %s collection %s: %w", job.name, cursor.CollectionID, err)
+		}
+
+		if cursor.dropped {
+			return fmt.Errorf("could not %s collection %s: %w", job.name, cursor.CollectionID, errors.ErrNoCollection)
+		}
+		s.notify()
+
+		if progress != nil {
+			progress(cursor.EmptyProgress)
+		}
+	}
+
+	return nil
+}
+
+// The persisted state of a maintenance job: the progress and the key of the last
+// object that was scanned in the previous batch.
+type jobCursor struct {
+	EmptyProgress
+	job     *maintenanceJob
+	last    keys.Key
+	dropped bool
+}
+
+// Returns the cursor of an existing job for the collection or a new cursor.
+func loadJobCursor(maintenance engine.Bucket, job *maintenanceJob, collectionID ulid.ULID) *jobCursor {
+	cursor := &jobCursor{
+		EmptyProgress: EmptyProgress{
+			CollectionID: collectionID,
+			Started:      time.Now(),
+		},
+		job: job,
+	}
+
+	if maintenance == nil {
+		return cursor
+	}
+
+	// The cursor is encoded as the started timestamp, the number of batches, the number
+	// of objects modified, and the key of the last object scanned (if any).
+	if data := maintenance.Get(cursor.key()); len(data) >= 24 {
+		cursor.Started = time.Unix(0, int64(binary.BigEndian.Uint64(data[0:8])))
+		cursor.Batches = binary.BigEndian.Uint64(data[8:16])
+		cursor.Tombstoned = binary.BigEndian.Uint64(data[16:24])
+		if len(data) > 24 {
+			cursor.last = keys.Key(bytes.Clone(data[24:]))
+		}
+	}
+	return cursor
+}
+
+// Applies the job to the next batch of objects in the collection and persists the
+// cursor in the same transaction so that the progress is committed along with the
+// batch.
+func (j *jobCursor) next(ctx context.Context, tx engine.Tx, batchSize int) (err error) {
+	maintenance := tx.Bucket(SystemMaintenance[:])
+	if maintenance == nil {
+		return errors.ErrNotInitialized
+	}
+
+	// If the collection was dropped while the job was running, the job is abandoned;
+	// the cursor is removed in this transaction so it must be committed.
+	var info *metadata.Collection
+	if info, err = latestCollection(tx.Bucket(SystemCollections[:]), j.CollectionID); err != nil {
+		if errors.Is(err, errors.ErrNoCollection) {
+			j.Done, j.dropped = true, true
+			return maintenance.Delete(j.key())
+		}
+		return err
+	}
+
+	c := &Collection{Collection: *info, ctx: ctx}
+	if c.bkt = tx.Bucket(j.CollectionID[:]); c.bkt == nil {
+		return errors.ErrRepairCollection
+	}
+
+	var n int
+	if j.last, n, err = j.job.batch(c, j.last, batchSize, j.Started); err != nil {
+		return err
+	}
+
+	j.Batches++
+	j.Tombstoned += uint64(n)
+
+	if j.last == nil {
+		if j.job.done != nil {
+			if err = j.job.done(c); err != nil {
+				return err
+			}
+		}
+
+		j.Done = true
+		return maintenance.Delete(j.key())
+	}
+
+	data := make([]byte, 24, 24+len(j.last))
+	binary.BigEndian.PutUint64(data[0:8], uint64(j.Started.UnixNano()))
+	binary.BigEndian.PutUint64(data[8:16], j.Batches)
+	binary.BigEndian.PutUint64(data[16:24], j.Tombstoned)
+	data = append(data, j.last...)
+	return maintenance.Put(j.key(), data)
+}
+
+func (j *jobCursor) key() []byte {
+	key := make([]byte, 0, len(j.job.prefix)+len(j.CollectionID))
+	key = append(key, j.job.prefix...)
+	return append(key, j.CollectionID[:]...)
+}
@@ -0,0 +1,186 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+)
+
+func (s *honuTestSuite) TestCollectionEmpty() {
+	require := s.Require()
+	info := s.createCollection()
+	objects := s.createObjects(info, 5)
+
+	// Collections cannot be emptied in a transaction; no objects are tombstoned.
+	tx, c := s.openCollection(info.ID, false)
+	require.ErrorIs(c.Empty(), errors.ErrEmptyInTx)
+	require.NoError(tx.Rollback())
+
+	tx, c = s.openCollection(info.ID, true)
+	for _, meta := range objects {
+		require.True(c.Exists(meta.ObjectID), "expected object not to be tombstoned")
+	}
+	require.NoError(tx.Rollback())
+
+	// The collection is emptied in batches by the store instead.
+	require.NoError(s.store.Empty(context.Background(), info.ID, nil))
+
+	_, c = s.openCollection(info.ID, true)
+	for _, meta := range objects {
+		require.True(c.Has(meta.ObjectID), "expected version history to be preserved")
+		require.False(c.Exists(meta.ObjectID), "expected object to be tombstoned")
+	}
+}
+
+func (s *honuTestSuite) TestStoreEmpty() {
+	require := s.Require()
+	info := s.createCollection()
+	objects := s.createObjects(info, 7)
+
+	var progress []store.EmptyProgress
+	err := s.store.Empty(context.Background(), info.Name, &store.EmptyOptions{
+		BatchSize: 3,
+		Progress:  func(p store.EmptyProgress) { progress = append(progress, p) },
+	})
+	require.NoError(err, "could not empty collection")
+
+	// 7 objects in batches of 3 requires 3 batches.
+	require.Len(progress, 3)
+	require.Equal(uint64(3), progress[0].Tombstoned)
+	require.False(progress[0].Done)
+	require.Equal(uint64(7), progress[2].Tombstoned)
+	require.Equal(uint64(3), progress[2].Batches)
+	require.True(progress[2].Done)
+
+	_, c := s.openCollection(info.ID, true)
+	for _, meta := range objects {
+		require.False(c.Exists(meta.ObjectID), "expected object to be tombstoned")
+	}
+
+	// Emptying an empty collection does not add more tombstones.
+	progress = nil
+	require.NoError(s.store.Empty(context.Background(), info.ID, &store.EmptyOptions{Progress: func(p store.EmptyProgress) { progress = append(progress, p) }}))
+	require.Len(progress, 1)
+	require.Equal(uint64(0), progress[0].Tombstoned)
+
+	require.ErrorIs(s.store.Empty(context.Background(), "does_not_exist", nil), errors.ErrNoCollection)
+}
+
+func (s *honuTestSuite) TestStoreEmptyResume() {
+	require := s.Require()
+	info := s.createCollection()
+	objects := s.createObjects(info, 5)
+
+	// Simulate a crash after the first batch has been committed.
+	require.Panics(func() {
+		s.store.Empty(context.Background(), info.ID, &store.EmptyOptions{
+			BatchSize: 2,
+			Progress:  func(p store.EmptyProgress) { panic("crash") },
+		})
+	})
+
+	// The cursor of the job should be persisted in the maintenance bucket.
+	require.NoError(s.store.Engine().View(func(tx engine.Tx) error {
+		require.Equal(1, bucketKeys(tx.Bucket(store.SystemMaintenance[:])), "expected job cursor to be persisted")
+		return nil
+	}))
+
+	// Resuming should continue from the previous batch.
+	var last store.EmptyProgress
+	require.NoError(s.store.Empty(context.Background(), info.ID, &store.EmptyOptions{
+		BatchSize: 2,
+		Progress:  func(p store.EmptyProgress) { last = p },
+	}))
+	require.True(last.Done)
+	require.Equal(uint64(3), last.Batches)
+	require.Equal(uint64(5), last.Tombstoned)
+
+	_, c := s.openCollection(info.ID, true)
+	for _, meta := range objects {
+		require.False(c.Exists(meta.ObjectID), "expected object to be tombstoned")
+	}
+
+	// The cursor should be removed when the job completes.
+	require.NoError(s.store.Engine().View(func(tx engine.Tx) error {
+		require.Equal(0, bucketKeys(tx.Bucket(store.SystemMaintenance[:])), "expected job cursor to be removed")
+		return nil
+	}))
+}
+
+func (s *honuTestSuite) TestStoreEmptyConcurrent() {
+	require := s.Require()
+	info := s.createCollection()
+	objects := s.createObjects(info, 5)
+
+	// Objects that existed when the job started are tombstoned even if they are updated
+	// while the job is running, but objects created after it started are not. The last
+	// object in key order is updated so that it has not been scanned by the first batch.
+	last := objects[0]
+	for _, meta := range objects[1:] {
+		if bytes.Compare(meta.ObjectID[:], last.ObjectID[:]) > 0 {
+			last = meta
+		}
+	}
+
+	var created []*metadata.Metadata
+	err := s.store.Empty(context.Background(), info.ID, &store.EmptyOptions{
+		BatchSize: 2,
+		Progress: func(p store.EmptyProgress) {
+			if p.Batches != 1 {
+				return
+			}
+
+			tx, c := s.openCollection(info.ID, false)
+			require.NoError(c.Update(last, []byte("bar"), nil))
+			require.NoError(tx.Commit())
+			created = s.createObjects(info, 1)
+		},
+	})
+	require.NoError(err, "could not empty collection")
+
+	tx, c := s.openCollection(info.ID, true)
+	for _, meta := range objects {
+		require.True(c.Has(meta.ObjectID), "expected version history to be preserved")
+		require.False(c.Exists(meta.ObjectID), "expected object to be tombstoned")
+	}
+	require.True(c.Exists(created[0].ObjectID), "expected object created during the job to be kept")
+	require.NoError(tx.Rollback())
+
+	// If the collection is dropped while the job is running, the job is abandoned.
+	info = s.createCollection()
+	s.createObjects(info, 5)
+
+	err = s.store.Empty(context.Background(), info.ID, &store.EmptyOptions{
+		BatchSize: 2,
+		Progress: func(p store.EmptyProgress) {
+			if p.Batches == 1 {
+				require.NoError(s.store.Drop(context.Background(), info.ID))
+			}
+		},
+	})
+	require.ErrorIs(err, errors.ErrNoCollection)
+
+	require.NoError(s.store.Engine().View(func(tx engine.Tx) error {
+		require.Equal(0, bucketKeys(tx.Bucket(store.SystemMaintenance[:])), "expected job cursor to be removed")
+		return nil
+	}))
+}
+
+// Creates the specified number of objects in the collection and returns their metadata.
+func (s *honuTestSuite) createObjects(info *metadata.Collection, n int) []*metadata.Metadata {
+	require := s.Require()
+	tx, c := s.openCollection(info.ID, false)
+
+	objects := make([]*metadata.Metadata, n)
+	for i := range objects {
+		objects[i] = &metadata.Metadata{}
+		require.NoError(c.Create(objects[i], []byte("foo"), nil), "could not create object")
+	}
+
+	require.NoError(tx.Commit(), "could not commit objects")
+	return objects
+}
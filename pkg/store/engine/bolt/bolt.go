@@ -0,0 +1,337 @@
+/*
+Package bolt implements the storage engine interface with bbolt, an on-disk B+tree that
+memory maps the database file. It is the default engine of the Honu store.
+*/
+package bolt
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.rtnl.ai/honu/pkg/store/engine"
+)
+
+// Freelist types that can be specified in the options.
+const (
+	FreelistArray = "array"
+	FreelistMap   = "map"
+)
+
+// Options tune how the bbolt database is opened; the zero value uses the bbolt defaults.
+type Options struct {
+	// Open the database in read-only mode with a shared file lock.
+	ReadOnly bool
+
+	// The amount of time to wait to obtain the file lock; zero waits indefinitely.
+	Timeout time.Duration
+
+	// Skip the fsync after each commit. This is unsafe: committed transactions may be
+	// lost or the database corrupted if the host crashes.
+	NoSync bool
+
+	// Do not sync the freelist to disk; improves write performance at the cost of
+	// rebuilding the freelist by scanning the database when it is opened.
+	NoFreelistSync bool
+
+	// The initial size in bytes of the memory map of the database file; if the database
+	// file grows larger than this, readers block writers while it is remapped.
+	InitialMmapSize int
+
+	// The backend of the freelist, either array (the default) or map, which is faster
+	// for large and fragmented databases.
+	FreelistType string
+
+	// The page size of a new database file; zero uses the OS page size. The page size
+	// of an existing database file cannot be changed.
+	PageSize int
+
+	// The maximum number of functions in a batch and the maximum delay before a batch
+	// is committed; zero uses the bbolt defaults. See Engine.Batch for details.
+	MaxBatchSize  int
+	MaxBatchDelay time.Duration
+}
+
+// Open the bbolt database at the specified path, creating it if it does not exist
+// (unless the database is opened in read-only mode). If opts is nil, the defaults
+// are used.
+func Open(path string, opts *Options) (_ *Engine, err error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	var freelist bbolt.FreelistType
+	switch opts.FreelistType {
+	case "", FreelistArray:
+		freelist = bbolt.FreelistArrayType
+	case FreelistMap:
+		freelist = bbolt.FreelistMapType
+	default:
+		return nil, fmt.Errorf("unknown bbolt freelist type %q", opts.FreelistType)
+	}
+
+	var db *bbolt.DB
+	if db, err = bbolt.Open(path, 0600, &bbolt.Options{
+		ReadOnly:        opts.ReadOnly,
+		Timeout:         opts.Timeout,
+		NoSync:          opts.NoSync,
+		NoFreelistSync:  opts.NoFreelistSync,
+		InitialMmapSize: opts.InitialMmapSize,
+		FreelistType:    freelist,
+		PageSize:        opts.PageSize,
+	}); err != nil {
+		return nil, convert(err)
+	}
+
+	if opts.MaxBatchSize > 0 {
+		db.MaxBatchSize = opts.MaxBatchSize
+	}
+
+	if opts.MaxBatchDelay > 0 {
+		db.MaxBatchDelay = opts.MaxBatchDelay
+	}
+	return &Engine{db: db}, nil
+}
+
+// Engine wraps a bbolt database to implement the engine.Engine interface.
+type Engine struct {
+	db *bbolt.DB
+}
+
+var (
+	_ engine.Engine  = &Engine{}
+	_ engine.Batcher = &Engine{}
+)
+
+func (e *Engine) Begin(writable bool) (_ engine.Tx, err error) {
+	var tx *bbolt.Tx
+	if tx, err = e.db.Begin(writable); err != nil {
+		return nil, convert(err)
+	}
+	return &Tx{tx: tx}, nil
+}
+
+func (e *Engine) View(fn func(engine.Tx) error) error {
+	return convert(e.db.View(func(tx *bbolt.Tx) error {
+		return fn(&Tx{tx: tx})
+	}))
+}
+
+func (e *Engine) Update(fn func(engine.Tx) error) error {
+	return convert(e.db.Update(func(tx *bbolt.Tx) error {
+		return fn(&Tx{tx: tx})
+	}))
+}
+
+// Batch executes the function in a writable transaction that is shared with other
+// concurrent calls to Batch, so that the batch is committed with a single fsync. If
+// the function returns an error, the batch is rolled back and the other functions are
+// retried without it, so the function may be called more than once.
+func (e *Engine) Batch(fn func(engine.Tx) error) error {
+	return convert(e.db.Batch(func(tx *bbolt.Tx) error {
+		return fn(&Tx{tx: tx})
+	}))
+}
+
+func (e *Engine) Close() error {
+	return convert(e.db.Close())
+}
+
+// DB returns the underlying bbolt database for engine specific operations.
+func (e *Engine) DB() *bbolt.DB {
+	return e.db
+}
+
+//===========================================================================
+// Transactions
+//===========================================================================
+
+// Tx wraps a bbolt transaction to implement the engine.Tx interface.
+type Tx struct {
+	tx *bbolt.Tx
+}
+
+var _ engine.Tx = &Tx{}
+
+func (t *Tx) Bucket(name []byte) engine.Bucket {
+	return wrap(t.tx.Bucket(name))
+}
+
+func (t *Tx) CreateBucket(name []byte) (_ engine.Bucket, err error) {
+	var bkt *bbolt.Bucket
+	if bkt, err = t.tx.CreateBucket(name); err != nil {
+		return nil, convert(err)
+	}
+	return wrap(bkt), nil
+}
+
+func (t *Tx) CreateBucketIfNotExists(name []byte) (_ engine.Bucket, err error) {
+	var bkt *bbolt.Bucket
+	if bkt, err = t.tx.CreateBucketIfNotExists(name); err != nil {
+		return nil, convert(err)
+	}
+	return wrap(bkt), nil
+}
+
+func (t *Tx) DeleteBucket(name []byte) error {
+	return convert(t.tx.DeleteBucket(name))
+}
+
+func (t *Tx) ForEach(fn func(name []byte, b engine.Bucket) error) error {
+	return convert(t.tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+		return fn(name, wrap(b))
+	}))
+}
+
+func (t *Tx) Writable() bool {
+	return t.tx.Writable()
+}
+
+func (t *Tx) Commit() error {
+	return convert(t.tx.Commit())
+}
+
+func (t *Tx) Rollback() error {
+	return convert(t.tx.Rollback())
+}
+
+//===========================================================================
+// Buckets and Cursors
+//===========================================================================
+
+// Bucket wraps a bbolt bucket to implement the engine.Bucket interface.
+type Bucket struct {
+	bkt *bbolt.Bucket
+}
+
+var (
+	_ engine.Bucket      = &Bucket{}
+	_ engine.PageStatter = &Bucket{}
+)
+
+// Returns a nil interface rather than a non-nil interface holding a nil bucket so that
+// callers can check if the bucket exists.
+func wrap(bkt *bbolt.Bucket) engine.Bucket {
+	if bkt == nil {
+		return nil
+	}
+	return &Bucket{bkt: bkt}
+}
+
+func (b *Bucket) Get(key []byte) []byte {
+	return b.bkt.Get(key)
+}
+
+func (b *Bucket) Put(key, value []byte) error {
+	return convert(b.bkt.Put(key, value))
+}
+
+func (b *Bucket) Delete(key []byte) error {
+	return convert(b.bkt.Delete(key))
+}
+
+func (b *Bucket) Cursor() engine.Cursor {
+	return b.bkt.Cursor()
+}
+
+func (b *Bucket) Bucket(name []byte) engine.Bucket {
+	return wrap(b.bkt.Bucket(name))
+}
+
+func (b *Bucket) CreateBucket(name []byte) (_ engine.Bucket, err error) {
+	var bkt *bbolt.Bucket
+	if bkt, err = b.bkt.CreateBucket(name); err != nil {
+		return nil, convert(err)
+	}
+	return wrap(bkt), nil
+}
+
+func (b *Bucket) CreateBucketIfNotExists(name []byte) (_ engine.Bucket, err error) {
+	var bkt *bbolt.Bucket
+	if bkt, err = b.bkt.CreateBucketIfNotExists(name); err != nil {
+		return nil, convert(err)
+	}
+	return wrap(bkt), nil
+}
+
+func (b *Bucket) DeleteBucket(name []byte) error {
+	return convert(b.bkt.DeleteBucket(name))
+}
+
+func (b *Bucket) ForEach(fn func(key, value []byte) error) error {
+	return convert(b.bkt.ForEach(fn))
+}
+
+func (b *Bucket) NextSequence() (_ uint64, err error) {
+	var seq uint64
+	if seq, err = b.bkt.NextSequence(); err != nil {
+		return 0, convert(err)
+	}
+	return seq, nil
+}
+
+func (b *Bucket) Sequence() uint64 {
+	return b.bkt.Sequence()
+}
+
+func (b *Bucket) SetSequence(seq uint64) error {
+	return convert(b.bkt.SetSequence(seq))
+}
+
+func (b *Bucket) Writable() bool {
+	return b.bkt.Writable()
+}
+
+func (b *Bucket) PageStats() engine.PageStats {
+	stats := b.bkt.Stats()
+	return engine.PageStats{
+		BranchPages:         stats.BranchPageN,
+		BranchOverflowPages: stats.BranchOverflowN,
+		LeafPages:           stats.LeafPageN,
+		LeafOverflowPages:   stats.LeafOverflowN,
+		Keys:                stats.KeyN,
+		Depth:               stats.Depth,
+		BranchAlloc:         stats.BranchAlloc,
+		BranchInuse:         stats.BranchInuse,
+		LeafAlloc:           stats.LeafAlloc,
+		LeafInuse:           stats.LeafInuse,
+		Buckets:             stats.BucketN,
+		InlineBuckets:       stats.InlineBucketN,
+		InlineBucketInuse:   stats.InlineBucketInuse,
+	}
+}
+
+//===========================================================================
+// Errors
+//===========================================================================
+
+// Converts bbolt errors into the equivalent engine errors; other errors (e.g. errors
+// returned by callbacks or I/O errors) are returned as is.
+func convert(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, berrors.ErrDatabaseNotOpen):
+		return engine.ErrClosed
+	case errors.Is(err, berrors.ErrTxClosed):
+		return engine.ErrTxClosed
+	case errors.Is(err, berrors.ErrTxNotWritable):
+		return engine.ErrTxNotWritable
+	case errors.Is(err, berrors.ErrDatabaseReadOnly):
+		return engine.ErrReadOnly
+	case errors.Is(err, berrors.ErrBucketNotFound):
+		return engine.ErrBucketNotFound
+	case errors.Is(err, berrors.ErrBucketExists):
+		return engine.ErrBucketExists
+	case errors.Is(err, berrors.ErrBucketNameRequired):
+		return engine.ErrBucketNameRequired
+	case errors.Is(err, berrors.ErrKeyRequired):
+		return engine.ErrKeyRequired
+	case errors.Is(err, berrors.ErrIncompatibleValue):
+		return engine.ErrIncompatibleValue
+	default:
+		return err
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Package engine defines the interface between the Honu store and the underlying
+key/value storage engine that persists it. The store only requires an ordered key/value
+store with nested buckets, cursors that can seek and iterate in both directions, and
+serializable transactions where a single writer can run concurrently with many readers
+that each see a consistent snapshot of the database.
+
+The bolt engine (an on-disk B+tree) is the default engine; the memory engine keeps the
+entire database in memory and is suitable for tests and ephemeral caches.
+*/
+package engine
+
+import "errors"
+
+// Engine errors are returned by all engine implementations so that the store can handle
+// them without depending on a specific engine.
+var (
+	ErrClosed             = errors.New("engine: database is closed")
+	ErrTxClosed           = errors.New("engine: transaction is closed")
+	ErrTxNotWritable      = errors.New("engine: transaction is not writable")
+	ErrReadOnly           = errors.New("engine: database is in read-only mode")
+	ErrBucketNotFound     = errors.New("engine: bucket not found")
+	ErrBucketExists       = errors.New("engine: bucket already exists")
+	ErrBucketNameRequired = errors.New("engine: bucket name required")
+	ErrKeyRequired        = errors.New("engine: key required")
+	ErrIncompatibleValue  = errors.New("engine: incompatible value")
+)
+
+// Engine is an ordered key/value store whose keys are organized into nested buckets.
+type Engine interface {
+	// Begin starts a new transaction. Only one writable transaction can be open at a
+	// time; beginning another writable transaction blocks until the current one is
+	// committed or rolled back. Any number of read-only transactions can be open.
+	Begin(writable bool) (Tx, error)
+
+	// View executes the function in a managed read-only transaction.
+	View(fn func(Tx) error) error
+
+	// Update executes the function in a managed writable transaction that is committed
+	// if the function returns nil and rolled back otherwise.
+	Update(fn func(Tx) error) error
+
+	// Close releases all resources held by the engine; all transactions must be closed.
+	Close() error
+}
+
+// Batcher is implemented by engines that can commit the writes of many concurrent
+// callers together (group commit), e.g. so that they share a single fsync. The function
+// may be called more than once if it or another function in the batch returns an error,
+// so it must only modify the database through the transaction.
+type Batcher interface {
+	Batch(fn func(Tx) error) error
+}
+
+// Tx is a transaction on the engine that provides access to the top-level buckets.
+// Values returned by a transaction are only valid for the life of the transaction.
+type Tx interface {
+	// Bucket returns the top-level bucket with the name or nil if it does not exist.
+	Bucket(name []byte) Bucket
+
+	// CreateBucket creates a new top-level bucket, returning an error if it exists.
+	CreateBucket(name []byte) (Bucket, error)
+
+	// CreateBucketIfNotExists creates a new top-level bucket if it does not exist.
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+
+	// DeleteBucket deletes the top-level bucket and all of its keys and nested buckets.
+	DeleteBucket(name []byte) error
+
+	// ForEach calls the function for each top-level bucket in key order.
+	ForEach(fn func(name []byte, b Bucket) error) error
+
+	// Writable returns true if the transaction can modify the database.
+	Writable() bool
+
+	// Commit writes all changes made in a writable transaction; read-only transactions
+	// return ErrTxNotWritable and must be rolled back.
+	Commit() error
+
+	// Rollback discards all changes and closes the transaction.
+	Rollback() error
+}
+
+// Bucket is an ordered collection of keys and nested buckets. Nested buckets are stored
+// alongside the keys in the bucket and are returned by cursors with a nil value.
+type Bucket interface {
+	// Get returns the value of the key or nil if the key does not exist or is a bucket.
+	Get(key []byte) []byte
+
+	// Put sets the value of the key; the key and value must not be modified until the
+	// transaction is closed.
+	Put(key, value []byte) error
+
+	// Delete removes the key from the bucket; deleting a missing key is not an error.
+	Delete(key []byte) error
+
+	// Cursor returns a cursor to iterate over the keys and nested buckets in order.
+	Cursor() Cursor
+
+	// Bucket returns the nested bucket with the name or nil if it does not exist.
+	Bucket(name []byte) Bucket
+
+	// CreateBucket creates a new nested bucket, returning an error if it exists.
+	CreateBucket(name []byte) (Bucket, error)
+
+	// CreateBucketIfNotExists creates a new nested bucket if it does not exist.
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+
+	// DeleteBucket deletes the nested bucket and all of its keys and nested buckets.
+	DeleteBucket(name []byte) error
+
+	// ForEach calls the function for each key in the bucket in order; nested buckets
+	// are passed with a nil value.
+	ForEach(fn func(key, value []byte) error) error
+
+	// NextSequence returns an autoincrementing integer for the bucket.
+	NextSequence() (uint64, error)
+
+	// Sequence returns the current integer of the bucket without incrementing it.
+	Sequence() uint64
+
+	// SetSequence updates the sequence number of the bucket.
+	SetSequence(seq uint64) error
+
+	// Writable returns true if the bucket belongs to a writable transaction.
+	Writable() bool
+}
+
+// Cursor iterates over the keys of a bucket in byte-sorted order. Each method returns
+// the key and value at the new position of the cursor, or a nil key if the cursor has
+// moved past the first or last key. Nested buckets are returned with a nil value.
+type Cursor interface {
+	First() (key, value []byte)
+	Last() (key, value []byte)
+	Next() (key, value []byte)
+	Prev() (key, value []byte)
+
+	// Seek moves the cursor to the key or to the next key if the key does not exist.
+	Seek(seek []byte) (key, value []byte)
+}
+
+// PageStatter is implemented by the buckets of engines that store keys in fixed size
+// pages (e.g. the bolt engine) so that the store can report how much of the database
+// a bucket uses. Engines without pages (e.g. the memory engine) do not implement it.
+type PageStatter interface {
+	// PageStats returns the page usage of the bucket including its nested buckets.
+	PageStats() PageStats
+}
+
+// PageStats describes the pages allocated to a bucket and its nested buckets.
+type PageStats struct {
+	BranchPages         int `json:"branch_pages"`          // number of logical branch pages
+	BranchOverflowPages int `json:"branch_overflow_pages"` // number of physical branch overflow pages
+	LeafPages           int `json:"leaf_pages"`            // number of logical leaf pages
+	LeafOverflowPages   int `json:"leaf_overflow_pages"`   // number of physical leaf overflow pages
+	Keys                int `json:"keys"`                  // number of keys and nested buckets
+	Depth               int `json:"depth"`                 // number of levels in the B+tree
+	BranchAlloc         int `json:"branch_alloc"`          // bytes allocated for branch pages
+	BranchInuse         int `json:"branch_inuse"`          // bytes used by branch pages
+	LeafAlloc           int `json:"leaf_alloc"`            // bytes allocated for leaf pages
+	LeafInuse           int `json:"leaf_inuse"`            // bytes used by leaf pages
+	Buckets             int `json:"buckets"`               // number of buckets including this one
+	InlineBuckets       int `json:"inline_buckets"`        // number of buckets stored inline in a leaf
+	InlineBucketInuse   int `json:"inline_bucket_inuse"`   // bytes used by inline buckets
+}
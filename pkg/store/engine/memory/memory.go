@@ -0,0 +1,521 @@
+/*
+Package memory implements the storage engine interface entirely in memory so that the
+Honu store can be used without touching disk, e.g. for unit tests or ephemeral caches.
+
+The database is a tree of sorted buckets that is never modified once it is committed.
+Writable transactions copy each bucket on the path to a modified bucket the first time
+it is modified (path copying) and the new tree replaces the committed tree on commit,
+so read-only transactions always see a consistent snapshot without any locking. As
+with bolt, only one writable transaction can be open at a time.
+*/
+package memory
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+	"sync"
+
+	"go.rtnl.ai/honu/pkg/store/engine"
+)
+
+// Open a new empty in-memory database.
+func Open() *Engine {
+	return &Engine{root: &node{}}
+}
+
+// Engine is an in-memory implementation of the engine.Engine interface.
+type Engine struct {
+	writer sync.Mutex   // serializes writable transactions
+	mu     sync.RWMutex // protects the committed root and closed flag
+	root   *node
+	txid   uint64
+	closed bool
+}
+
+var _ engine.Engine = &Engine{}
+
+func (e *Engine) Begin(writable bool) (engine.Tx, error) {
+	tx, err := e.begin(writable)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (e *Engine) View(fn func(engine.Tx) error) (err error) {
+	var tx *Tx
+	if tx, err = e.begin(false); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return fn(tx)
+}
+
+func (e *Engine) Update(fn func(engine.Tx) error) (err error) {
+	var tx *Tx
+	if tx, err = e.begin(true); err != nil {
+		return err
+	}
+
+	// Ensure the writer lock is released even if the function panics.
+	defer func() {
+		if !tx.closed {
+			tx.Rollback()
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (e *Engine) begin(writable bool) (_ *Tx, err error) {
+	if writable {
+		e.writer.Lock()
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		if writable {
+			e.writer.Unlock()
+		}
+		return nil, engine.ErrClosed
+	}
+
+	tx := &Tx{engine: e, root: e.root, writable: writable}
+	if writable {
+		// Only the writer modifies txid and it holds the writer lock.
+		e.txid++
+		tx.id = e.txid
+		tx.root = tx.root.clone(tx.id)
+	}
+	return tx, nil
+}
+
+// Close the database, waiting for any open writable transaction to complete. All of
+// the data in the database is discarded.
+func (e *Engine) Close() error {
+	e.writer.Lock()
+	defer e.writer.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return engine.ErrClosed
+	}
+
+	e.closed = true
+	e.root = nil
+	return nil
+}
+
+//===========================================================================
+// Transactions
+//===========================================================================
+
+// Tx is a transaction on an in-memory database; read-only transactions hold the root
+// of the tree that was committed when the transaction began, writable transactions
+// hold a copy of the root that is committed in place of the current root.
+type Tx struct {
+	engine   *Engine
+	root     *node
+	id       uint64
+	writable bool
+	closed   bool
+}
+
+var _ engine.Tx = &Tx{}
+
+func (t *Tx) Bucket(name []byte) engine.Bucket {
+	return t.bucket().Bucket(name)
+}
+
+func (t *Tx) CreateBucket(name []byte) (engine.Bucket, error) {
+	return t.bucket().CreateBucket(name)
+}
+
+func (t *Tx) CreateBucketIfNotExists(name []byte) (engine.Bucket, error) {
+	return t.bucket().CreateBucketIfNotExists(name)
+}
+
+func (t *Tx) DeleteBucket(name []byte) error {
+	return t.bucket().DeleteBucket(name)
+}
+
+func (t *Tx) ForEach(fn func(name []byte, b engine.Bucket) error) error {
+	root := t.bucket()
+	return root.ForEach(func(name, _ []byte) error {
+		return fn(name, root.Bucket(name))
+	})
+}
+
+func (t *Tx) Writable() bool {
+	return t.writable
+}
+
+func (t *Tx) Commit() error {
+	if t.closed {
+		return engine.ErrTxClosed
+	}
+
+	if !t.writable {
+		return engine.ErrTxNotWritable
+	}
+
+	t.engine.mu.Lock()
+	t.engine.root = t.root
+	t.engine.mu.Unlock()
+
+	t.close()
+	return nil
+}
+
+func (t *Tx) Rollback() error {
+	if t.closed {
+		return engine.ErrTxClosed
+	}
+
+	t.close()
+	return nil
+}
+
+func (t *Tx) close() {
+	if t.writable {
+		t.engine.writer.Unlock()
+	}
+	t.closed = true
+	t.root = nil
+}
+
+// Returns a handle to the root of the tree, which holds the top-level buckets.
+func (t *Tx) bucket() *Bucket {
+	return &Bucket{tx: t}
+}
+
+//===========================================================================
+// Buckets
+//===========================================================================
+
+// Bucket is a handle to a bucket in a transaction. Rather than holding the node of
+// the bucket, the node is looked up from the root of the transaction on every access
+// since modifying a bucket replaces its node (and the nodes of its parents) with a copy.
+type Bucket struct {
+	tx     *Tx
+	parent *Bucket
+	name   []byte
+}
+
+var _ engine.Bucket = &Bucket{}
+
+func (b *Bucket) Get(key []byte) []byte {
+	if n := b.node(); n != nil {
+		if i, ok := n.search(key); ok {
+			return n.items[i].value
+		}
+	}
+	return nil
+}
+
+func (b *Bucket) Put(key, value []byte) (err error) {
+	if len(key) == 0 {
+		return engine.ErrKeyRequired
+	}
+
+	var n *node
+	if n, err = b.mutable(); err != nil {
+		return err
+	}
+
+	// The key and value are copied so that the caller can reuse them after the write;
+	// values are never nil so that they can be distinguished from nested buckets.
+	i, ok := n.search(key)
+	switch {
+	case ok && n.items[i].child != nil:
+		return engine.ErrIncompatibleValue
+	case ok:
+		n.items[i].value = append(make([]byte, 0, len(value)), value...)
+	default:
+		n.items = slices.Insert(n.items, i, item{
+			key:   bytes.Clone(key),
+			value: append(make([]byte, 0, len(value)), value...),
+		})
+	}
+	return nil
+}
+
+func (b *Bucket) Delete(key []byte) (err error) {
+	var n *node
+	if n, err = b.mutable(); err != nil {
+		return err
+	}
+
+	if i, ok := n.search(key); ok {
+		if n.items[i].child != nil {
+			return engine.ErrIncompatibleValue
+		}
+		n.items = slices.Delete(n.items, i, i+1)
+	}
+	return nil
+}
+
+func (b *Bucket) Cursor() engine.Cursor {
+	return &Cursor{bkt: b}
+}
+
+func (b *Bucket) Bucket(name []byte) engine.Bucket {
+	if n := b.node(); n != nil {
+		if i, ok := n.search(name); ok && n.items[i].child != nil {
+			return &Bucket{tx: b.tx, parent: b, name: n.items[i].key}
+		}
+	}
+	return nil
+}
+
+func (b *Bucket) CreateBucket(name []byte) (_ engine.Bucket, err error) {
+	if len(name) == 0 {
+		return nil, engine.ErrBucketNameRequired
+	}
+
+	var n *node
+	if n, err = b.mutable(); err != nil {
+		return nil, err
+	}
+
+	i, ok := n.search(name)
+	switch {
+	case ok && n.items[i].child != nil:
+		return nil, engine.ErrBucketExists
+	case ok:
+		return nil, engine.ErrIncompatibleValue
+	}
+
+	key := bytes.Clone(name)
+	n.items = slices.Insert(n.items, i, item{key: key, child: &node{owner: b.tx.id}})
+	return &Bucket{tx: b.tx, parent: b, name: key}, nil
+}
+
+func (b *Bucket) CreateBucketIfNotExists(name []byte) (_ engine.Bucket, err error) {
+	var bkt engine.Bucket
+	if bkt, err = b.CreateBucket(name); err == engine.ErrBucketExists {
+		return b.Bucket(name), nil
+	}
+	return bkt, err
+}
+
+func (b *Bucket) DeleteBucket(name []byte) (err error) {
+	var n *node
+	if n, err = b.mutable(); err != nil {
+		return err
+	}
+
+	i, ok := n.search(name)
+	switch {
+	case !ok:
+		return engine.ErrBucketNotFound
+	case n.items[i].child == nil:
+		return engine.ErrIncompatibleValue
+	}
+
+	n.items = slices.Delete(n.items, i, i+1)
+	return nil
+}
+
+// ForEach iterates over the keys in the bucket as they were when ForEach was called;
+// the bucket must not be modified by the function.
+func (b *Bucket) ForEach(fn func(key, value []byte) error) (err error) {
+	var n *node
+	if n = b.node(); n == nil {
+		return nil
+	}
+
+	for _, item := range n.items {
+		if err = fn(item.key, item.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bucket) NextSequence() (_ uint64, err error) {
+	var n *node
+	if n, err = b.mutable(); err != nil {
+		return 0, err
+	}
+
+	n.seq++
+	return n.seq, nil
+}
+
+func (b *Bucket) Sequence() uint64 {
+	var n *node
+	if n = b.node(); n == nil {
+		return 0
+	}
+	return n.seq
+}
+
+func (b *Bucket) SetSequence(seq uint64) (err error) {
+	var n *node
+	if n, err = b.mutable(); err != nil {
+		return err
+	}
+
+	n.seq = seq
+	return nil
+}
+
+func (b *Bucket) Writable() bool {
+	return b.tx.writable
+}
+
+// Returns the node of the bucket in the transaction or nil if the bucket was deleted.
+func (b *Bucket) node() *node {
+	if b.parent == nil {
+		return b.tx.root
+	}
+
+	if parent := b.parent.node(); parent != nil {
+		if i, ok := parent.search(b.name); ok {
+			return parent.items[i].child
+		}
+	}
+	return nil
+}
+
+// Returns the node of the bucket after ensuring that the node and all of its parents
+// are owned by the transaction and can therefore be modified in place.
+func (b *Bucket) mutable() (_ *node, err error) {
+	switch {
+	case b.tx.closed:
+		return nil, engine.ErrTxClosed
+	case !b.tx.writable:
+		return nil, engine.ErrTxNotWritable
+	case b.parent == nil:
+		return b.tx.root, nil
+	}
+
+	var parent *node
+	if parent, err = b.parent.mutable(); err != nil {
+		return nil, err
+	}
+
+	i, ok := parent.search(b.name)
+	if !ok || parent.items[i].child == nil {
+		return nil, engine.ErrBucketNotFound
+	}
+
+	if parent.items[i].child.owner != b.tx.id {
+		parent.items[i].child = parent.items[i].child.clone(b.tx.id)
+	}
+	return parent.items[i].child, nil
+}
+
+//===========================================================================
+// Cursors
+//===========================================================================
+
+// Cursor iterates over a bucket by searching for the next or previous key relative to
+// the key at the current position, so it remains valid if the bucket is modified.
+type Cursor struct {
+	bkt *Bucket
+	key []byte
+}
+
+var _ engine.Cursor = &Cursor{}
+
+func (c *Cursor) First() (key, value []byte) {
+	return c.move(func(n *node) int { return 0 })
+}
+
+func (c *Cursor) Last() (key, value []byte) {
+	return c.move(func(n *node) int { return len(n.items) - 1 })
+}
+
+func (c *Cursor) Next() (key, value []byte) {
+	if c.key == nil {
+		return nil, nil
+	}
+
+	return c.move(func(n *node) int {
+		i, ok := n.search(c.key)
+		if ok {
+			i++
+		}
+		return i
+	})
+}
+
+func (c *Cursor) Prev() (key, value []byte) {
+	if c.key == nil {
+		return nil, nil
+	}
+
+	return c.move(func(n *node) int {
+		i, _ := n.search(c.key)
+		return i - 1
+	})
+}
+
+func (c *Cursor) Seek(seek []byte) (key, value []byte) {
+	return c.move(func(n *node) int {
+		i, _ := n.search(seek)
+		return i
+	})
+}
+
+// Moves the cursor to the index in the bucket's node returned by the function.
+func (c *Cursor) move(index func(*node) int) (key, value []byte) {
+	var n *node
+	if n = c.bkt.node(); n == nil {
+		c.key = nil
+		return nil, nil
+	}
+
+	i := index(n)
+	if i < 0 || i >= len(n.items) {
+		c.key = nil
+		return nil, nil
+	}
+
+	c.key = n.items[i].key
+	return n.items[i].key, n.items[i].value
+}
+
+//===========================================================================
+// Tree
+//===========================================================================
+
+// A node holds the sorted keys and nested buckets of a bucket. A node is only modified
+// by the writable transaction that owns it; nodes of committed trees are immutable.
+type node struct {
+	items []item
+	seq   uint64
+	owner uint64
+}
+
+// An item is either a key/value pair or a nested bucket (with a nil value).
+type item struct {
+	key   []byte
+	value []byte
+	child *node
+}
+
+// Returns a copy of the node that is owned by the transaction; the items are copied
+// but nested buckets are shared until they are modified.
+func (n *node) clone(owner uint64) *node {
+	return &node{items: slices.Clone(n.items), seq: n.seq, owner: owner}
+}
+
+// Returns the index of the key in the node and true if the key exists, otherwise the
+// index where the key would be inserted and false.
+func (n *node) search(key []byte) (int, bool) {
+	i := sort.Search(len(n.items), func(i int) bool {
+		return bytes.Compare(n.items[i].key, key) >= 0
+	})
+	return i, i < len(n.items) && bytes.Equal(n.items[i].key, key)
+}
@@ -0,0 +1,196 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/engine/memory"
+)
+
+func TestSnapshotIsolation(t *testing.T) {
+	db := memory.Open()
+	defer db.Close()
+
+	require.NoError(t, db.Update(func(tx engine.Tx) error {
+		bkt, err := tx.CreateBucket([]byte("objects"))
+		require.NoError(t, err)
+		require.NoError(t, bkt.Put([]byte("alpha"), []byte("1")))
+
+		nested, err := bkt.CreateBucket([]byte("index"))
+		require.NoError(t, err)
+		return nested.Put([]byte("a"), []byte("alpha"))
+	}))
+
+	// Begin a read transaction before modifying the database.
+	snapshot, err := db.Begin(false)
+	require.NoError(t, err)
+	defer snapshot.Rollback()
+
+	writer, err := db.Begin(true)
+	require.NoError(t, err)
+
+	bkt := writer.Bucket([]byte("objects"))
+	require.NoError(t, bkt.Put([]byte("alpha"), []byte("2")))
+	require.NoError(t, bkt.Put([]byte("bravo"), []byte("1")))
+	require.NoError(t, bkt.Bucket([]byte("index")).Put([]byte("b"), []byte("bravo")))
+
+	// The writer sees its own changes, the reader does not.
+	require.Equal(t, []byte("2"), bkt.Get([]byte("alpha")))
+	require.Equal(t, []byte("1"), snapshot.Bucket([]byte("objects")).Get([]byte("alpha")))
+	require.NoError(t, writer.Commit())
+
+	// The reader still sees the snapshot after the commit, a new reader sees the commit.
+	objects := snapshot.Bucket([]byte("objects"))
+	require.Equal(t, []byte("1"), objects.Get([]byte("alpha")))
+	require.Nil(t, objects.Get([]byte("bravo")))
+	require.Nil(t, objects.Bucket([]byte("index")).Get([]byte("b")))
+
+	require.NoError(t, db.View(func(tx engine.Tx) error {
+		objects := tx.Bucket([]byte("objects"))
+		require.Equal(t, []byte("2"), objects.Get([]byte("alpha")))
+		require.Equal(t, []byte("1"), objects.Get([]byte("bravo")))
+		require.Equal(t, []byte("bravo"), objects.Bucket([]byte("index")).Get([]byte("b")))
+		return nil
+	}))
+
+	// Rolled back changes are discarded.
+	writer, err = db.Begin(true)
+	require.NoError(t, err)
+	require.NoError(t, writer.DeleteBucket([]byte("objects")))
+	require.Nil(t, writer.Bucket([]byte("objects")))
+	require.NoError(t, writer.Rollback())
+
+	require.NoError(t, db.View(func(tx engine.Tx) error {
+		require.NotNil(t, tx.Bucket([]byte("objects")))
+		return nil
+	}))
+}
+
+func TestCursor(t *testing.T) {
+	db := memory.Open()
+	defer db.Close()
+
+	require.NoError(t, db.Update(func(tx engine.Tx) error {
+		bkt, err := tx.CreateBucket([]byte("objects"))
+		require.NoError(t, err)
+
+		for _, key := range []string{"d", "b", "a", "e"} {
+			require.NoError(t, bkt.Put([]byte(key), []byte(key+key)))
+		}
+		_, err = bkt.CreateBucket([]byte("c"))
+		return err
+	}))
+
+	require.NoError(t, db.View(func(tx engine.Tx) error {
+		cursor := tx.Bucket([]byte("objects")).Cursor()
+
+		var keys []string
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			if value == nil {
+				// Nested buckets are returned with nil values.
+				require.Equal(t, "c", string(key))
+			}
+			keys = append(keys, string(key))
+		}
+		require.Equal(t, []string{"a", "b", "c", "d", "e"}, keys)
+
+		keys = keys[:0]
+		for key, _ := cursor.Last(); key != nil; key, _ = cursor.Prev() {
+			keys = append(keys, string(key))
+		}
+		require.Equal(t, []string{"e", "d", "c", "b", "a"}, keys)
+
+		key, value := cursor.Seek([]byte("bb"))
+		require.Equal(t, []byte("c"), key)
+		require.Nil(t, value)
+
+		key, value = cursor.Seek([]byte("d"))
+		require.Equal(t, []byte("d"), key)
+		require.Equal(t, []byte("dd"), value)
+
+		key, _ = cursor.Seek([]byte("f"))
+		require.Nil(t, key)
+		return nil
+	}))
+
+	// Cursors continue from the current key if the bucket is modified.
+	require.NoError(t, db.Update(func(tx engine.Tx) error {
+		bkt := tx.Bucket([]byte("objects"))
+		cursor := bkt.Cursor()
+
+		key, _ := cursor.Seek([]byte("b"))
+		require.Equal(t, []byte("b"), key)
+		require.NoError(t, bkt.Delete([]byte("b")))
+
+		key, _ = cursor.Next()
+		require.Equal(t, []byte("c"), key)
+		return nil
+	}))
+}
+
+func TestErrors(t *testing.T) {
+	db := memory.Open()
+
+	require.NoError(t, db.Update(func(tx engine.Tx) error {
+		bkt, err := tx.CreateBucket([]byte("objects"))
+		require.NoError(t, err)
+
+		_, err = tx.CreateBucket([]byte("objects"))
+		require.ErrorIs(t, err, engine.ErrBucketExists)
+
+		other, err := tx.CreateBucketIfNotExists([]byte("objects"))
+		require.NoError(t, err)
+		require.NotNil(t, other)
+
+		_, err = bkt.CreateBucket(nil)
+		require.ErrorIs(t, err, engine.ErrBucketNameRequired)
+		require.ErrorIs(t, bkt.Put(nil, []byte("foo")), engine.ErrKeyRequired)
+
+		// Keys and nested buckets cannot be used interchangeably.
+		require.NoError(t, bkt.Put([]byte("key"), []byte("value")))
+		_, err = bkt.CreateBucket([]byte("key"))
+		require.ErrorIs(t, err, engine.ErrIncompatibleValue)
+		require.ErrorIs(t, bkt.DeleteBucket([]byte("key")), engine.ErrIncompatibleValue)
+
+		_, err = bkt.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+		require.ErrorIs(t, bkt.Put([]byte("nested"), []byte("value")), engine.ErrIncompatibleValue)
+		require.ErrorIs(t, bkt.Delete([]byte("nested")), engine.ErrIncompatibleValue)
+		require.Nil(t, bkt.Get([]byte("nested")))
+
+		require.ErrorIs(t, tx.DeleteBucket([]byte("missing")), engine.ErrBucketNotFound)
+		require.Nil(t, tx.Bucket([]byte("missing")))
+
+		seq, err := bkt.NextSequence()
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), seq)
+		return nil
+	}))
+
+	tx, err := db.Begin(false)
+	require.NoError(t, err)
+
+	bkt := tx.Bucket([]byte("objects"))
+	require.False(t, bkt.Writable())
+	require.ErrorIs(t, bkt.Put([]byte("foo"), []byte("bar")), engine.ErrTxNotWritable)
+	_, err = bkt.NextSequence()
+	require.ErrorIs(t, err, engine.ErrTxNotWritable)
+	require.ErrorIs(t, tx.Commit(), engine.ErrTxNotWritable)
+
+	require.NoError(t, tx.Rollback())
+	require.ErrorIs(t, tx.Rollback(), engine.ErrTxClosed)
+
+	// Sequences are committed with the bucket.
+	require.NoError(t, db.Update(func(tx engine.Tx) error {
+		seq, err := tx.Bucket([]byte("objects")).NextSequence()
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), seq)
+		return nil
+	}))
+
+	require.NoError(t, db.Close())
+	_, err = db.Begin(false)
+	require.ErrorIs(t, err, engine.ErrClosed)
+	require.ErrorIs(t, db.Close(), engine.ErrClosed)
+}
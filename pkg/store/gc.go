@@ -0,0 +1,206 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/object"
+	"go.rtnl.ai/ulid"
+)
+
+// The default number of objects that are scanned for tombstones in each transaction;
+// this bounds how long other writers are blocked by the tombstone collector.
+const DefaultGCBatchSize = 1000
+
+//===========================================================================
+// Tombstone Garbage Collection
+//===========================================================================
+
+// CollectTombstones permanently removes tombstones (and truncated records) along with
+// all of the versions beneath them once every known replica has acknowledged the change
+// log sequence of the tombstone in its collection, returning the number of objects and
+// collections removed.
+//
+// The replication horizon is computed from the peers recorded with Acknowledge; if no
+// peers are tracked the configured tombstone grace period is used instead. If neither
+// is available, no tombstones are removed since they may not have been replicated.
+func (s *Store) CollectTombstones(ctx context.Context) (collected int, err error) {
+	if s.conf.ReadOnly {
+		return 0, errors.ErrReadOnlyDB
+	}
+
+	var h horizon
+	if err = s.view(ctx, func(tx engine.Tx) (err error) {
+		h, err = replicationHorizon(tx, s.conf.TombstoneGracePeriod, time.Now())
+		return err
+	}); err != nil {
+		return 0, err
+	}
+
+	if h.IsZero() {
+		return 0, nil
+	}
+
+	var collections []*metadata.Collection
+	if collections, err = s.Collections(ctx); err != nil {
+		return 0, err
+	}
+
+	for _, info := range collections {
+		var n int
+		n, err = s.collectCollection(ctx, info.ID, h)
+		collected += n
+
+		if err != nil {
+			// The collection may have been dropped while collecting.
+			if errors.Is(err, errors.ErrNoCollection) {
+				continue
+			}
+			return collected, fmt.Errorf("could not collect tombstones in collection %s: %w", info, err)
+		}
+	}
+
+	// Remove the tombstones of dropped collections from the system collections.
+	var n int
+	if err = s.update(ctx, func(tx engine.Tx) (err error) {
+		n, err = collectCollectionTombstones(tx.Bucket(SystemCollections[:]), h)
+		return err
+	}); err != nil {
+		return collected, fmt.Errorf("could not collect dropped collections: %w", err)
+	}
+
+	return collected + n, nil
+}
+
+// Collects the tombstones of a single collection in batches, reloading the collection
+// metadata in each batch in case the collection was dropped.
+func (s *Store) collectCollection(ctx context.Context, collectionID ulid.ULID, h horizon) (collected int, err error) {
+	var last keys.Key
+	for {
+		if err = s.update(ctx, func(tx engine.Tx) (err error) {
+			var info *metadata.Collection
+			if info, err = latestCollection(tx.Bucket(SystemCollections[:]), collectionID); err != nil {
+				return err
+			}
+
+			c := &Collection{Collection: *info, ctx: ctx}
+			if c.bkt = tx.Bucket(collectionID[:]); c.bkt == nil {
+				return errors.ErrRepairCollection
+			}
+
+			var n int
+			last, n, err = c.collect(last, DefaultGCBatchSize, h)
+			collected += n
+			return err
+		}); err != nil {
+			return collected, err
+		}
+
+		if last == nil {
+			return collected, nil
+		}
+	}
+}
+
+// Removes the tombstone versions of dropped collections that have been replicated past
+// the horizon. Drop removes the version history of the collection when it writes the
+// tombstone, so only the tombstone itself needs to be deleted.
+func collectCollectionTombstones(collections engine.Bucket, h horizon) (n int, err error) {
+	if collections == nil {
+		return 0, errors.ErrNotInitialized
+	}
+
+	var (
+		key, value []byte
+		remove     [][]byte
+	)
+
+	cursor := collections.Cursor()
+	for key, value = cursor.First(); key != nil; {
+		if value == nil || keys.Key(key).Check() != nil {
+			key, value = cursor.Next()
+			continue
+		}
+
+		// System collections are never dropped.
+		prefix := keys.Key(key).ObjectPrefix()
+		if !bytes.HasPrefix(key, SystemPrefix[:]) {
+			info := &metadata.Collection{}
+			if err = object.UnmarshalSystem(object.Object(value), info); err != nil {
+				return 0, fmt.Errorf("could not unmarshal collection meta: %w", err)
+			}
+
+			if info.Version.IsTombstone() && h.replicated(SystemCollections, droppedSequence(collections, key), info.Version) {
+				n++
+				for ; key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+					remove = append(remove, bytes.Clone(key))
+				}
+				continue
+			}
+		}
+
+		key, value = cursor.Seek(keys.Key(key).ObjectLimit())
+	}
+
+	index := collections.Bucket(changeIndexBucket)
+	for _, key := range remove {
+		if err = collections.Delete(key); err != nil {
+			return 0, fmt.Errorf("could not delete collection tombstone: %w", err)
+		}
+
+		if index != nil {
+			if err = index.Delete(key); err != nil {
+				return 0, fmt.Errorf("could not delete collection tombstone sequence: %w", err)
+			}
+		}
+	}
+	return n, nil
+}
+
+// Returns the sequence of the collection metadata change that dropped the collection
+// or zero if the tombstone was not assigned a sequence.
+func droppedSequence(collections engine.Bucket, key []byte) uint64 {
+	if index := collections.Bucket(changeIndexBucket); index != nil {
+		if sequence := index.Get(key); len(sequence) == 8 {
+			return binary.BigEndian.Uint64(sequence)
+		}
+	}
+	return 0
+}
+
+// Runs a tombstone collection pass from the background scheduler and removes orphaned
+// staged chunks; errors are logged rather than returned since there is no caller to
+// handle them.
+func (s *Store) collector(ctx context.Context) {
+	collected, err := s.CollectTombstones(ctx)
+	if err != nil {
+		// The pass was aborted because the store is closing.
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+
+		log.Warn().Err(err).Int("collected", collected).Msg("background tombstone collection failed")
+		return
+	}
+
+	if collected > 0 {
+		log.Info().Int("collected", collected).Msg("removed replicated tombstones")
+	}
+
+	// Remove the staged chunks of streams that were interrupted but not cleaned up.
+	if staged, err := s.CollectStaged(ctx); err != nil {
+		if !errors.Is(err, context.Canceled) {
+			log.Warn().Err(err).Msg("background staged object collection failed")
+		}
+	} else if staged > 0 {
+		log.Info().Int("staged", staged).Msg("removed interrupted object streams")
+	}
+}
@@ -0,0 +1,240 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.rtnl.ai/honu/pkg/config"
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/region"
+	"go.rtnl.ai/honu/pkg/store"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/lamport"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/ulid"
+)
+
+func TestCollectTombstones(t *testing.T) {
+	db := openTestStore(t, config.StoreConfig{})
+	info, deleted, live := createTombstone(t, db)
+
+	// Without peers or a grace period, tombstones are never collected.
+	collected, err := db.CollectTombstones(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 0, collected)
+	require.True(t, hasObject(t, db, info.ID, deleted.ObjectID))
+
+	// If any peer has not acknowledged the tombstone, it is not collected.
+	// The tombstone is the third change in the collection.
+	horizon := store.ChangeVector{info.ID: 3}
+	require.NoError(t, db.Acknowledge(context.Background(), 2, region.TESTING, horizon))
+	require.NoError(t, db.Acknowledge(context.Background(), 3, region.TESTING, store.ChangeVector{info.ID: 2}))
+
+	collected, err = db.CollectTombstones(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 0, collected)
+	require.True(t, hasObject(t, db, info.ID, deleted.ObjectID))
+
+	// Acknowledgements never move a peer's horizon backwards.
+	require.NoError(t, db.Acknowledge(context.Background(), 2, region.TESTING, store.ChangeVector{info.ID: 1}))
+	require.NoError(t, db.Acknowledge(context.Background(), 3, region.TESTING, horizon))
+
+	replicas, err := db.Replicas(context.Background())
+	require.NoError(t, err)
+	require.Len(t, replicas, 2)
+	for _, replica := range replicas {
+		require.Equal(t, horizon, replica.Horizon, "expected replica %d horizon to be updated", replica.PID)
+		require.Equal(t, region.TESTING, replica.Region)
+		require.False(t, replica.Modified.IsZero())
+	}
+
+	// Once every peer has acknowledged the tombstone, the object and its history are removed.
+	collected, err = db.CollectTombstones(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, collected)
+	require.False(t, hasObject(t, db, info.ID, deleted.ObjectID))
+	require.True(t, hasObject(t, db, info.ID, live.ObjectID))
+
+	// Removed peers no longer hold back the horizon.
+	require.NoError(t, db.Acknowledge(context.Background(), 4, region.TESTING, store.ChangeVector{}))
+	require.NoError(t, db.RemoveReplica(context.Background(), 4))
+	replicas, err = db.Replicas(context.Background())
+	require.NoError(t, err)
+	require.Len(t, replicas, 2)
+
+	// Each acknowledgement is stored as a new version of the replica record and only the
+	// latest version of the record is kept.
+	require.NoError(t, db.Engine().View(func(tx engine.Tx) error {
+		var records int
+		err := tx.Bucket(store.SystemReplicas[:]).ForEach(func(key, _ []byte) error {
+			require.NoError(t, keys.Key(key).Check())
+			records++
+			return nil
+		})
+		require.Equal(t, 2, records)
+		return err
+	}))
+}
+
+func TestCollectTombstonesInterleaved(t *testing.T) {
+	db := openTestStore(t, config.StoreConfig{})
+	info := &metadata.Collection{Name: "interleaved"}
+	require.NoError(t, db.New(context.Background(), info))
+
+	// Versions are numbered per object, so the tombstone of bravo (VID 2) has a lower
+	// version than the latest version of alpha (VID 3) even though it was written later.
+	tx, err := db.Begin(context.Background(), nil)
+	require.NoError(t, err)
+	c, err := tx.Collection(info.ID)
+	require.NoError(t, err)
+
+	alpha, bravo := &metadata.Metadata{}, &metadata.Metadata{}
+	require.NoError(t, c.Create(alpha, []byte("alpha-1"), nil))
+	require.NoError(t, c.Update(alpha, []byte("alpha-2"), nil))
+	require.NoError(t, c.Update(alpha, []byte("alpha-3"), nil))
+	require.NoError(t, c.Create(bravo, []byte("bravo-1"), nil))
+	require.NoError(t, c.Delete(bravo.Key(), nil))
+	require.NoError(t, tx.Commit())
+	require.Equal(t, uint64(3), alpha.Version.Scalar.VID)
+
+	// A peer that has received every change to alpha has not received the deletion.
+	require.NoError(t, db.Acknowledge(context.Background(), 2, region.TESTING, store.ChangeVector{info.ID: 4}))
+	collected, err := db.CollectTombstones(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 0, collected)
+	require.True(t, hasObject(t, db, info.ID, bravo.ObjectID))
+
+	require.NoError(t, db.Acknowledge(context.Background(), 2, region.TESTING, store.ChangeVector{info.ID: 5}))
+	collected, err = db.CollectTombstones(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, collected)
+	require.False(t, hasObject(t, db, info.ID, bravo.ObjectID))
+	require.True(t, hasObject(t, db, info.ID, alpha.ObjectID))
+
+	// Dropped collections are collected once every peer has acknowledged the change to
+	// the collection metadata that dropped the collection.
+	dropped := &metadata.Collection{Name: "dropped"}
+	require.NoError(t, db.New(context.Background(), dropped))
+	before, err := db.Changes(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, db.Drop(context.Background(), dropped.ID))
+
+	require.NoError(t, db.Acknowledge(context.Background(), 2, region.TESTING, before))
+	collected, err = db.CollectTombstones(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 0, collected)
+	require.True(t, hasCollectionRecord(t, db, dropped.ID))
+
+	after, err := db.Changes(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, before[store.SystemCollections]+1, after[store.SystemCollections])
+	require.NotContains(t, after, dropped.ID)
+
+	require.NoError(t, db.Acknowledge(context.Background(), 2, region.TESTING, after))
+	collected, err = db.CollectTombstones(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, collected)
+	require.False(t, hasCollectionRecord(t, db, dropped.ID))
+}
+
+func TestCollectTombstonesGracePeriod(t *testing.T) {
+	db := openTestStore(t, config.StoreConfig{TombstoneGracePeriod: time.Millisecond})
+	info, deleted, live := createTombstone(t, db)
+
+	// Drop a collection so that its tombstone is collected as well.
+	dropped := &metadata.Collection{Name: "dropped"}
+	require.NoError(t, db.New(context.Background(), dropped))
+	require.NoError(t, db.Drop(context.Background(), dropped.ID))
+	require.True(t, hasCollectionRecord(t, db, dropped.ID))
+
+	time.Sleep(5 * time.Millisecond)
+
+	collected, err := db.CollectTombstones(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, collected)
+	require.False(t, hasObject(t, db, info.ID, deleted.ObjectID))
+	require.True(t, hasObject(t, db, info.ID, live.ObjectID))
+	require.False(t, hasCollectionRecord(t, db, dropped.ID))
+
+	// Live collections are not affected.
+	_, err = db.Collection(context.Background(), info.ID)
+	require.NoError(t, err)
+	_, err = db.Collection(context.Background(), dropped.ID)
+	require.ErrorIs(t, err, errors.ErrNoCollection)
+}
+
+func TestBackgroundCollector(t *testing.T) {
+	db := openTestStore(t, config.StoreConfig{
+		GCInterval:           10 * time.Millisecond,
+		TombstoneGracePeriod: time.Millisecond,
+	})
+	info, deleted, _ := createTombstone(t, db)
+
+	require.Eventually(t, func() bool {
+		return !hasObject(t, db, info.ID, deleted.ObjectID)
+	}, time.Second, 10*time.Millisecond, "expected background collector to remove tombstone")
+}
+
+func openTestStore(t *testing.T, conf config.StoreConfig) *store.Store {
+	lamport.SetProcessID(8)
+	region.SetProcessRegion(region.TESTING)
+
+	conf.DataPath = filepath.Join(t.TempDir(), "honu-test.db")
+	if conf.Concurrency == 0 {
+		conf.Concurrency = 16
+	}
+
+	db, err := store.Open(config.Config{PID: uint32(8), Store: conf})
+	require.NoError(t, err, "could not open store")
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Creates a collection with one deleted object that has some version history and one
+// live object, returning the collection and the metadata of both objects.
+func createTombstone(t *testing.T, db *store.Store) (info *metadata.Collection, deleted, live *metadata.Metadata) {
+	info = &metadata.Collection{Name: "tombstones"}
+	require.NoError(t, db.New(context.Background(), info), "could not create collection")
+
+	tx, err := db.Begin(context.Background(), nil)
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	c, err := tx.Collection(info.ID)
+	require.NoError(t, err)
+
+	deleted, live = &metadata.Metadata{}, &metadata.Metadata{}
+	require.NoError(t, c.Create(deleted, []byte("v1"), nil))
+	require.NoError(t, c.Update(deleted, []byte("v2"), nil))
+	require.NoError(t, c.Delete(deleted.Key(), nil))
+	require.NoError(t, c.Create(live, []byte("v1"), nil))
+	require.NoError(t, tx.Commit())
+	return info, deleted, live
+}
+
+// Returns true if any version of the object is stored in the collection.
+func hasObject(t *testing.T, db *store.Store, collectionID, objectID ulid.ULID) bool {
+	tx, err := db.Begin(context.Background(), &store.TxOptions{ReadOnly: true})
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	c, err := tx.Collection(collectionID)
+	require.NoError(t, err)
+	return c.Has(objectID)
+}
+
+// Returns true if any version of the collection (including tombstones) is stored.
+func hasCollectionRecord(t *testing.T, db *store.Store, collectionID ulid.ULID) (exists bool) {
+	prefix := keys.New(collectionID, nil).ObjectPrefix()
+	require.NoError(t, db.Engine().View(func(tx engine.Tx) error {
+		key, _ := tx.Bucket(store.SystemCollections[:]).Cursor().Seek(prefix)
+		exists = key != nil && bytes.HasPrefix(key, prefix)
+		return nil
+	}))
+	return exists
+}
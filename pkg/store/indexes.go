@@ -0,0 +1,340 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/object"
+	"go.rtnl.ai/ulid"
+)
+
+//===========================================================================
+// Index Maintenance
+//===========================================================================
+
+// Index entries are maintained for the latest live version of each object whose data
+// is a JSON document. The indexed field is looked up by name in the document (nested
+// fields are separated by dots) and encoded so that the entries sort in the order of
+// the field values. UNIQUE indexes map the value to the object ID and reject a second
+// object with the same value; INDEX indexes append the object ID to the value so that
+// many objects can share a value. Objects whose data is not a JSON document, that do
+// not have the field, or whose data is chunked are not indexed. Other index types are
+// not maintained by the store.
+type indexEntry struct {
+	index *metadata.Index
+	key   []byte
+}
+
+// Returns the index entries of the latest version of the object that is stored in the
+// collection, or nil if the latest version is a tombstone or is not indexable. Versions
+// whose metadata or payload cannot be read are not indexable so that corrupt versions
+// can still be deleted or replaced.
+func (c *Collection) indexEntries(id ulid.ULID) (entries []indexEntry, err error) {
+	if !c.indexed() {
+		return nil, nil
+	}
+
+	key, value := c.seekLatest(id)
+	if key == nil {
+		return nil, nil
+	}
+
+	obj := object.Object(value)
+	var meta *metadata.Metadata
+	if meta, err = obj.Metadata(); err != nil {
+		return nil, nil
+	}
+
+	if meta.Version.IsTombstone() || meta.Chunks != nil {
+		return nil, nil
+	}
+
+	var data []byte
+	if len(meta.Blob) > 0 {
+		if data = c.getBlob(meta.Blob); data == nil {
+			return nil, nil
+		}
+	} else if data, err = obj.Data(); err != nil {
+		return nil, nil
+	}
+
+	var doc map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err = decoder.Decode(&doc); err != nil {
+		return nil, nil
+	}
+
+	for _, idx := range c.Indexes {
+		if !maintained(idx) {
+			continue
+		}
+
+		value, ok := indexValue(doc, idx.Field)
+		if !ok {
+			continue
+		}
+
+		if idx.Type == metadata.INDEX {
+			value = append(value, id[:]...)
+		}
+		entries = append(entries, indexEntry{index: idx, key: value})
+	}
+	return entries, nil
+}
+
+// Updates the indexes of the object after versions of it have been stored or deleted:
+// the entries of the previously indexed version that no longer apply are removed and
+// the entries of the latest version are inserted. If a unique index already has an
+// entry for another object, ErrUniqueIndex is returned and the transaction must be
+// rolled back.
+func (c *Collection) reindex(id ulid.ULID, before []indexEntry) (err error) {
+	return c.reindexObjects(map[ulid.ULID][]indexEntry{id: before})
+}
+
+// Updates the indexes of many objects at once, e.g. when a backup is restored, given
+// the entries each object had before it was modified. The stale entries of all of the
+// objects are removed before any new entries are inserted so that an object can take
+// a unique value that was released by another object.
+func (c *Collection) reindexObjects(before map[ulid.ULID][]indexEntry) (err error) {
+	if !c.indexed() {
+		return nil
+	}
+
+	after := make(map[ulid.ULID][]indexEntry, len(before))
+	for id := range before {
+		if after[id], err = c.indexEntries(id); err != nil {
+			return err
+		}
+	}
+
+	for id, entries := range before {
+		for _, entry := range entries {
+			if contains(after[id], entry) {
+				continue
+			}
+
+			if bkt := c.bkt.Bucket(entry.index.ID[:]); bkt != nil {
+				if !bytes.Equal(bkt.Get(entry.key), id[:]) {
+					continue
+				}
+
+				if err = bkt.Delete(entry.key); err != nil {
+					return fmt.Errorf("could not remove entry from index %s: %w", entry.index.Name, err)
+				}
+			}
+		}
+	}
+
+	for id, entries := range after {
+		for _, entry := range entries {
+			if contains(before[id], entry) {
+				continue
+			}
+
+			if err = c.insertEntry(id, entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Adds the entries for the latest version of every object in the collection to the
+// index, e.g. when the index is added to a collection that already has objects.
+func (c *Collection) buildIndex(idx *metadata.Index) (err error) {
+	if !maintained(idx) {
+		return nil
+	}
+
+	// Only the new index is built from the latest version of each object.
+	build := &Collection{Collection: c.Collection, ctx: c.ctx, bkt: c.bkt}
+	build.Indexes = []*metadata.Index{idx}
+
+	cursor := c.bkt.Cursor()
+	for key, value := cursor.First(); key != nil; {
+		// Skip nested buckets (e.g. indexes) and any keys that are not object keys.
+		if value == nil || keys.Key(key).Check() != nil {
+			key, value = cursor.Next()
+			continue
+		}
+
+		if err = c.canceled(); err != nil {
+			return err
+		}
+
+		id := keys.Key(key).ObjectID()
+		var entries []indexEntry
+		if entries, err = build.indexEntries(id); err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if err = build.insertEntry(id, entry); err != nil {
+				return err
+			}
+		}
+
+		// Inserting into the nested index bucket invalidates the cursor position.
+		key, value = cursor.Seek(keys.New(id, nil).ObjectLimit())
+	}
+	return nil
+}
+
+// Clears the indexes of the collection that are not maintained by the store, since
+// their entries may reference objects that were removed from the collection.
+func (c *Collection) clearIndexes() (err error) {
+	for _, idx := range c.Indexes {
+		if idx == nil || maintained(idx) {
+			continue
+		}
+
+		if err = c.bkt.DeleteBucket(idx.ID[:]); err != nil && !errors.Is(err, engine.ErrBucketNotFound) {
+			return fmt.Errorf("could not clear index %s in %s: %w", idx.Name, c.Name, err)
+		}
+
+		if _, err = c.bkt.CreateBucket(idx.ID[:]); err != nil {
+			return fmt.Errorf("could not create index %s in %s: %w", idx.Name, c.Name, err)
+		}
+	}
+	return nil
+}
+
+func (c *Collection) insertEntry(id ulid.ULID, entry indexEntry) (err error) {
+	var bkt engine.Bucket
+	if bkt, err = c.bkt.CreateBucketIfNotExists(entry.index.ID[:]); err != nil {
+		return fmt.Errorf("could not open index %s: %w", entry.index.Name, err)
+	}
+
+	if entry.index.Type == metadata.UNIQUE {
+		if prev := bkt.Get(entry.key); prev != nil && !bytes.Equal(prev, id[:]) {
+			return errors.ErrUniqueIndex
+		}
+	}
+
+	if err = bkt.Put(entry.key, id[:]); err != nil {
+		return fmt.Errorf("could not add entry to index %s: %w", entry.index.Name, err)
+	}
+	return nil
+}
+
+// Returns true if the collection has any indexes that are maintained by the store.
+func (c *Collection) indexed() bool {
+	for _, idx := range c.Indexes {
+		if maintained(idx) {
+			return true
+		}
+	}
+	return false
+}
+
+func maintained(idx *metadata.Index) bool {
+	return idx != nil && idx.Field != nil && (idx.Type == metadata.UNIQUE || idx.Type == metadata.INDEX)
+}
+
+func contains(entries []indexEntry, entry indexEntry) bool {
+	for _, e := range entries {
+		if e.index.ID == entry.index.ID && bytes.Equal(e.key, entry.key) {
+			return true
+		}
+	}
+	return false
+}
+
+//===========================================================================
+// Field Encoding
+//===========================================================================
+
+// Looks up the field in the document and returns its value encoded as an index key,
+// or false if the document does not have the field or the value cannot be encoded as
+// the type of the field.
+func indexValue(doc map[string]any, field *metadata.Field) (_ []byte, ok bool) {
+	var value any = doc
+	for _, name := range strings.Split(field.Name, ".") {
+		var obj map[string]any
+		if obj, ok = value.(map[string]any); !ok {
+			return nil, false
+		}
+
+		if value, ok = obj[name]; !ok || value == nil {
+			return nil, false
+		}
+	}
+
+	switch field.Type {
+	case metadata.StringField:
+		s, ok := value.(string)
+		return []byte(s), ok
+	case metadata.BlobField:
+		if s, ok := value.(string); ok {
+			if blob, err := base64.StdEncoding.DecodeString(s); err == nil {
+				return blob, true
+			}
+		}
+	case metadata.ULIDField:
+		if s, ok := value.(string); ok {
+			if id, err := ulid.Parse(s); err == nil {
+				return id[:], true
+			}
+		}
+	case metadata.UUIDField:
+		if s, ok := value.(string); ok {
+			if uuid, err := hex.DecodeString(strings.ReplaceAll(s, "-", "")); err == nil && len(uuid) == 16 {
+				return uuid, true
+			}
+		}
+	case metadata.IntField:
+		if n, ok := value.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				return sortableInt(i), true
+			}
+		}
+	case metadata.UIntField:
+		if n, ok := value.(json.Number); ok {
+			if u, err := strconv.ParseUint(n.String(), 10, 64); err == nil {
+				return binary.BigEndian.AppendUint64(nil, u), true
+			}
+		}
+	case metadata.FloatField:
+		if n, ok := value.(json.Number); ok {
+			if f, err := n.Float64(); err == nil {
+				return sortableFloat(f), true
+			}
+		}
+	case metadata.TimeField:
+		if s, ok := value.(string); ok {
+			if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return sortableInt(ts.UnixNano()), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Encodes the integer so that negative values sort before positive values.
+func sortableInt(i int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(i)^(1<<63))
+}
+
+// Encodes the float so that the encoded values sort in numeric order.
+func sortableFloat(f float64) []byte {
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits ^= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(nil, bits)
+}
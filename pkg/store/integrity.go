@@ -0,0 +1,558 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"go.rtnl.ai/honu/pkg/config"
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/object"
+	"go.rtnl.ai/ulid"
+)
+
+//===========================================================================
+// Integrity Checks
+//===========================================================================
+
+// ProblemType describes an inconsistency found by an integrity check.
+type ProblemType uint8
+
+const (
+	UnknownProblem     ProblemType = iota
+	MissingCollection              // the metadata of a system collection is missing
+	CorruptCollection              // a collection metadata version cannot be decoded
+	MissingName                    // a live collection is not in the collection names index
+	StaleName                      // a name in the index refers to a missing, dropped, or renamed collection
+	MissingBucket                  // a live collection does not have a bucket for its objects
+	DroppedBucket                  // a dropped collection still has a bucket
+	OrphanedBucket                 // a collection bucket does not have any collection metadata
+	MissingIndex                   // an index of a live collection does not have a bucket
+	StaleIndex                     // an index bucket is not an index of its collection
+	CorruptObject                  // an object version cannot be decoded
+	DanglingIndexEntry             // an index entry does not refer to a live object with its value
+)
+
+// Problem is an inconsistency found by an integrity check and whether it was repaired.
+type Problem struct {
+	Type       ProblemType
+	Collection ulid.ULID
+	Key        []byte
+	Detail     string
+	Repaired   bool
+}
+
+// Report summarizes the results of an integrity check.
+type Report struct {
+	Collections int
+	Objects     int
+	Problems    []*Problem
+}
+
+// Check opens the store described by the configuration without starting any of the
+// background routines or migrating and initializing the database and checks its
+// integrity, optionally repairing any problems found. The check is intended to be run
+// offline, when the replica is not serving requests; if the store cannot be opened
+// because system collection metadata or buckets are missing, Check can repair it.
+func Check(ctx context.Context, conf config.Config, repair bool) (report *Report, err error) {
+	conf.Store.ReadOnly = !repair
+
+	s := &Store{conf: conf.Store}
+	if s.db, err = openEngine(conf.Store); err != nil {
+		return nil, err
+	}
+	defer s.db.Close()
+
+	return s.Check(ctx, repair)
+}
+
+// Check scans the entire store for inconsistencies between the collection metadata, the
+// collection names index, the collection and index buckets, and the objects they hold.
+// Every problem found is reported; if repair is true, the problems that can be safely
+// repaired are fixed and the changes are committed. Problems that would require data
+// that is no longer available (e.g. corrupted collection metadata or buckets of unknown
+// collections) are only reported. Writers are blocked while a repair is in progress.
+func (s *Store) Check(ctx context.Context, repair bool) (report *Report, err error) {
+	if s.db == nil {
+		return nil, errors.ErrClosed
+	}
+
+	if repair && s.conf.ReadOnly {
+		return nil, errors.ErrReadOnlyDB
+	}
+
+	var tx engine.Tx
+	if tx, err = s.begin(ctx, repair); err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	checker := &checker{ctx: ctx, tx: tx, repair: repair, report: &Report{}}
+	if err = checker.check(); err != nil {
+		return nil, err
+	}
+
+	if repair {
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+		s.notify()
+	}
+	return checker.report, nil
+}
+
+// Unrepaired returns the problems in the report that were not repaired.
+func (r *Report) Unrepaired() (problems []*Problem) {
+	for _, problem := range r.Problems {
+		if !problem.Repaired {
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+type checker struct {
+	ctx    context.Context
+	tx     engine.Tx
+	repair bool
+	report *Report
+	n      int
+
+	// The latest version of the metadata of each collection that could be decoded and
+	// the IDs of the collections in the order they are stored.
+	collections map[ulid.ULID]*metadata.Collection
+	order       []ulid.ULID
+}
+
+func (c *checker) check() (err error) {
+	var system engine.Bucket
+	if system = c.tx.Bucket(SystemCollections[:]); system == nil {
+		return errors.ErrNotInitialized
+	}
+
+	if err = c.checkSystem(system); err != nil {
+		return err
+	}
+
+	if err = c.checkCollections(system); err != nil {
+		return err
+	}
+
+	// If the names index is missing it is reported and repaired with the indexes of
+	// the system collections bucket and is checked by the next integrity check.
+	if names := system.Bucket(SystemCollectionNames[:]); names != nil {
+		if err = c.checkNames(names); err != nil {
+			return err
+		}
+	}
+
+	if err = c.checkBuckets(); err != nil {
+		return err
+	}
+
+	c.report.Collections = len(c.collections)
+	return nil
+}
+
+// Checks that the metadata of the system collections exists since the store cannot be
+// opened without it; the metadata is restored from the defaults if it is missing.
+func (c *checker) checkSystem(system engine.Bucket) (err error) {
+	for _, info := range defaultCollections() {
+		key := keys.New(info.ID, &info.Version.Scalar)
+		if system.Get(key) != nil {
+			continue
+		}
+
+		c.problem(MissingCollection, info.ID, key, c.repair, "system collection %s does not have metadata", info.Name)
+		if !c.repair {
+			continue
+		}
+
+		var data object.Object
+		if data, err = object.MarshalSystem(info); err != nil {
+			return fmt.Errorf("could not marshal collection metadata %s: %w", info.Name, err)
+		}
+
+		if err = system.Put(key, data); err != nil {
+			return fmt.Errorf("could not store collection metadata %s: %w", info.Name, err)
+		}
+	}
+	return nil
+}
+
+// Decodes the latest version of every collection; because collection versions are
+// sorted latest first, the first key with a new collection ID is its latest version.
+func (c *checker) checkCollections(system engine.Bucket) (err error) {
+	c.collections = make(map[ulid.ULID]*metadata.Collection)
+	seen := make(map[ulid.ULID]struct{})
+
+	cursor := system.Cursor()
+	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		if value == nil || keys.Key(key).Check() != nil {
+			continue
+		}
+
+		collectionID := keys.Key(key).ObjectID()
+		if _, ok := seen[collectionID]; ok {
+			continue
+		}
+		seen[collectionID] = struct{}{}
+
+		// If the latest version cannot be decoded, the collection is unknown.
+		info := &metadata.Collection{}
+		if err = object.UnmarshalSystem(object.Object(value), info); err != nil {
+			c.problem(CorruptCollection, collectionID, key, false, "could not decode collection metadata: %s", err)
+			continue
+		}
+
+		c.collections[collectionID] = info
+		c.order = append(c.order, collectionID)
+	}
+	return nil
+}
+
+// Checks that every live user collection is in the names index under its latest name
+// and that every name in the index refers to a live collection with that name.
+func (c *checker) checkNames(names engine.Bucket) (err error) {
+	var stale [][]byte
+	if err = names.ForEach(func(name, value []byte) error {
+		var collectionID ulid.ULID
+		copy(collectionID[:], value)
+
+		info, ok := c.collections[collectionID]
+		switch {
+		case len(value) != 16 || !ok:
+			c.problem(StaleName, collectionID, name, c.repair, "name %q refers to an unknown collection", name)
+		case info.Version.IsTombstone():
+			c.problem(StaleName, collectionID, name, c.repair, "name %q refers to a dropped collection", name)
+		case info.Name != string(name):
+			c.problem(StaleName, collectionID, name, c.repair, "name %q refers to collection renamed to %q", name, info.Name)
+		default:
+			return nil
+		}
+
+		stale = append(stale, bytes.Clone(name))
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if c.repair {
+		for _, name := range stale {
+			if err = names.Delete(name); err != nil {
+				return fmt.Errorf("could not delete stale name %q: %w", name, err)
+			}
+		}
+	}
+
+	for _, collectionID := range c.order {
+		info := c.collections[collectionID]
+		if isSystemCollection(collectionID) || info.Version.IsTombstone() {
+			continue
+		}
+
+		current := names.Get([]byte(info.Name))
+		if bytes.Equal(current, collectionID[:]) {
+			continue
+		}
+
+		// The name cannot be repaired if it is used by another live collection.
+		if current != nil {
+			c.problem(MissingName, collectionID, []byte(info.Name), false, "name %q is used by another collection", info.Name)
+			continue
+		}
+
+		c.problem(MissingName, collectionID, []byte(info.Name), c.repair, "collection %s is not in the names index", info.Name)
+		if c.repair {
+			if err = names.Put([]byte(info.Name), bytes.Clone(collectionID[:])); err != nil {
+				return fmt.Errorf("could not index collection name %q: %w", info.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Checks that every live collection has a bucket with a nested bucket for each of its
+// indexes, that dropped and unknown collections do not have buckets, and checks the
+// objects and index entries of user collections.
+func (c *checker) checkBuckets() (err error) {
+	var buckets []ulid.ULID
+	if err = c.tx.ForEach(func(name []byte, _ engine.Bucket) error {
+		if len(name) == 16 {
+			var collectionID ulid.ULID
+			copy(collectionID[:], name)
+			buckets = append(buckets, collectionID)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, collectionID := range buckets {
+		info, ok := c.collections[collectionID]
+		switch {
+		case !ok:
+			c.problem(OrphanedBucket, collectionID, nil, false, "bucket does not have collection metadata")
+		case info.Version.IsTombstone():
+			c.problem(DroppedBucket, collectionID, nil, c.repair, "bucket of dropped collection %s was not deleted", info.Name)
+			if c.repair {
+				if err = c.tx.DeleteBucket(collectionID[:]); err != nil {
+					return fmt.Errorf("could not delete bucket of dropped collection %s: %w", info.Name, err)
+				}
+			}
+		}
+	}
+
+	for _, collectionID := range c.order {
+		info := c.collections[collectionID]
+		if info.Version.IsTombstone() {
+			continue
+		}
+
+		var bkt engine.Bucket
+		if bkt = c.tx.Bucket(collectionID[:]); bkt == nil {
+			c.problem(MissingBucket, collectionID, nil, c.repair, "collection %s does not have a bucket", info.Name)
+			if !c.repair {
+				continue
+			}
+
+			if bkt, err = c.tx.CreateBucket(collectionID[:]); err != nil {
+				return fmt.Errorf("could not create bucket for collection %s: %w", info.Name, err)
+			}
+		}
+
+		if err = c.checkIndexes(info, bkt); err != nil {
+			return err
+		}
+
+		// System collections do not store objects, they store internal state.
+		if isSystemCollection(collectionID) {
+			continue
+		}
+
+		if err = c.checkObjects(info, bkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks that each index of the collection has a bucket and that every nested bucket
+// that is not used internally by the store is an index of the collection.
+func (c *checker) checkIndexes(info *metadata.Collection, bkt engine.Bucket) (err error) {
+	indexes := make(map[string]*metadata.Index, len(info.Indexes))
+	for _, idx := range info.Indexes {
+		indexes[string(idx.ID[:])] = idx
+		if bkt.Bucket(idx.ID[:]) != nil {
+			continue
+		}
+
+		c.problem(MissingIndex, info.ID, idx.ID[:], c.repair, "index %s of collection %s does not have a bucket", idx.Name, info.Name)
+		if c.repair {
+			if _, err = bkt.CreateBucket(idx.ID[:]); err != nil {
+				return fmt.Errorf("could not create index %s in %s: %w", idx.Name, info.Name, err)
+			}
+		}
+	}
+
+	var stale [][]byte
+	cursor := bkt.Cursor()
+	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		if value != nil || bytes.HasPrefix(key, SystemPrefix[:]) {
+			continue
+		}
+
+		if _, ok := indexes[string(key)]; !ok {
+			c.problem(StaleIndex, info.ID, key, c.repair, "bucket is not an index of collection %s", info.Name)
+			stale = append(stale, bytes.Clone(key))
+		}
+	}
+
+	if c.repair {
+		for _, key := range stale {
+			if err = bkt.DeleteBucket(key); err != nil {
+				return fmt.Errorf("could not delete stale index %x in %s: %w", key, info.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Checks that every object version in the collection can be decoded along with its
+// payload and that the entries of the indexes of the collection refer to the ID of a
+// live object whose latest version has the indexed value.
+func (c *checker) checkObjects(info *metadata.Collection, bkt engine.Bucket) (err error) {
+	col := &Collection{Collection: *info, ctx: c.ctx, bkt: bkt}
+
+	var corrupt [][]byte
+	cursor := bkt.Cursor()
+	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		if value == nil {
+			continue
+		}
+
+		if err = c.next(); err != nil {
+			return err
+		}
+
+		c.report.Objects++
+		var meta *metadata.Metadata
+		if err = keys.Key(key).Check(); err == nil {
+			meta, err = object.Object(value).Metadata()
+		}
+
+		if err != nil {
+			c.problem(CorruptObject, info.ID, key, c.repair, "could not decode object: %s", err)
+			corrupt = append(corrupt, bytes.Clone(key))
+			continue
+		}
+
+		if len(meta.Blob) > 0 && col.getBlob(meta.Blob) == nil {
+			c.problem(CorruptObject, info.ID, key, c.repair, "payload %x is missing", meta.Blob)
+			corrupt = append(corrupt, bytes.Clone(key))
+		}
+	}
+
+	// Corrupt versions are deleted along with their chunks, payload references, change
+	// log entries, and index entries; keys that are not object keys are not versions.
+	if c.repair {
+		for _, key := range corrupt {
+			if keys.Key(key).Check() != nil {
+				err = bkt.Delete(key)
+			} else {
+				err = col.deleteVersion(key)
+			}
+
+			if err != nil {
+				return fmt.Errorf("could not delete corrupt object %x: %w", key, err)
+			}
+		}
+	}
+
+	for _, idx := range info.Indexes {
+		var index engine.Bucket
+		if index = bkt.Bucket(idx.ID[:]); index == nil {
+			continue
+		}
+
+		var dangling [][]byte
+		if err = index.ForEach(func(key, value []byte) error {
+			if value == nil {
+				return nil
+			}
+
+			if err := c.next(); err != nil {
+				return err
+			}
+
+			if len(value) != 16 || !liveObject(bkt, ulid.ULID(value)) {
+				c.problem(DanglingIndexEntry, info.ID, key, c.repair, "entry in index %s does not refer to a live object", idx.Name)
+				dangling = append(dangling, bytes.Clone(key))
+				return nil
+			}
+
+			if !maintained(idx) {
+				return nil
+			}
+
+			entries, err := col.indexEntries(ulid.ULID(value))
+			if err != nil {
+				return err
+			}
+
+			if !contains(entries, indexEntry{index: idx, key: key}) {
+				c.problem(DanglingIndexEntry, info.ID, key, c.repair, "entry in index %s is not the value of its object", idx.Name)
+				dangling = append(dangling, bytes.Clone(key))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		if c.repair {
+			for _, key := range dangling {
+				if err = index.Delete(key); err != nil {
+					return fmt.Errorf("could not delete dangling index entry %x: %w", key, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Records a problem in the report.
+func (c *checker) problem(kind ProblemType, collectionID ulid.ULID, key []byte, repaired bool, format string, args ...any) {
+	c.report.Problems = append(c.report.Problems, &Problem{
+		Type:       kind,
+		Collection: collectionID,
+		Key:        bytes.Clone(key),
+		Detail:     fmt.Sprintf(format, args...),
+		Repaired:   repaired,
+	})
+}
+
+// Checks periodically if the context has been canceled.
+func (c *checker) next() error {
+	if c.n++; c.n%backupCheckEvery == 0 {
+		return c.ctx.Err()
+	}
+	return nil
+}
+
+// Returns true if the latest version of the object is not a tombstone.
+func liveObject(bkt engine.Bucket, objectID ulid.ULID) bool {
+	prefix := keys.New(objectID, nil).ObjectPrefix()
+	cursor := bkt.Cursor()
+	for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+		if value == nil {
+			continue
+		}
+
+		meta, err := object.Object(value).Metadata()
+		return err == nil && !meta.IsTombstone()
+	}
+	return false
+}
+
+func isSystemCollection(collectionID ulid.ULID) bool {
+	return bytes.HasPrefix(collectionID[:], SystemPrefix[:])
+}
+
+func (t ProblemType) String() string {
+	switch t {
+	case MissingCollection:
+		return "missing collection"
+	case CorruptCollection:
+		return "corrupt collection"
+	case MissingName:
+		return "missing name"
+	case StaleName:
+		return "stale name"
+	case MissingBucket:
+		return "missing bucket"
+	case DroppedBucket:
+		return "dropped bucket"
+	case OrphanedBucket:
+		return "orphaned bucket"
+	case MissingIndex:
+		return "missing index"
+	case StaleIndex:
+		return "stale index"
+	case CorruptObject:
+		return "corrupt object"
+	case DanglingIndexEntry:
+		return "dangling index entry"
+	default:
+		return "unknown"
+	}
+}
+
+func (p *Problem) String() string {
+	if len(p.Key) > 0 {
+		return fmt.Sprintf("%s in %s (key %x): %s", p.Type, p.Collection, p.Key, p.Detail)
+	}
+	return fmt.Sprintf("%s in %s: %s", p.Type, p.Collection, p.Detail)
+}
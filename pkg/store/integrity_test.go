@@ -0,0 +1,209 @@
+package store_test
+
+import (
+	"context"
+	"strings"
+
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/lamport"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/ulid"
+)
+
+func (s *honuTestSuite) TestCheck() {
+	require := s.Require()
+	ctx := context.Background()
+
+	info := &metadata.Collection{
+		Name: "test_" + strings.ToLower(ulid.Make().String()),
+		Indexes: []*metadata.Index{
+			{Name: "by_name", Type: metadata.UNIQUE, Field: &metadata.Field{Name: "name", Type: metadata.StringField}},
+			{Name: "by_email", Type: metadata.UNIQUE, Field: &metadata.Field{Name: "email", Type: metadata.StringField}},
+		},
+	}
+	require.NoError(s.store.New(context.Background(), info))
+	missing, dropped := s.createCollection(), s.createCollection()
+
+	tx, c := s.openCollection(info.ID, false)
+	alpha, bravo := &metadata.Metadata{}, &metadata.Metadata{}
+	require.NoError(c.Create(alpha, []byte("alpha"), nil))
+	require.NoError(c.Create(bravo, []byte("bravo"), nil))
+	require.NoError(c.Delete(bravo.Key(), nil))
+	require.NoError(tx.Commit())
+	require.NoError(s.store.Drop(context.Background(), dropped.ID))
+
+	// A consistent store has no problems.
+	ghost, orphan := ulid.Make(), ulid.Make()
+	collections := []ulid.ULID{info.ID, missing.ID, dropped.ID, ghost, orphan}
+	require.Empty(s.problems(ctx, false, collections...))
+
+	// Corrupt the store directly in the database.
+	require.NoError(s.store.Engine().Update(func(tx engine.Tx) (err error) {
+		names := tx.Bucket(store.SystemCollections[:]).Bucket(store.SystemCollectionNames[:])
+		require.NoError(names.Delete([]byte(info.Name)))
+		require.NoError(names.Put([]byte("ghost"), ghost[:]))
+
+		bkt := tx.Bucket(info.ID[:])
+		require.NoError(bkt.DeleteBucket(info.Indexes[0].ID[:]))
+		stale := ulid.Make()
+		_, err = bkt.CreateBucket(stale[:])
+		require.NoError(err)
+
+		corrupt := keys.New(ulid.Make(), &lamport.Scalar{PID: 1, VID: 1})
+		require.NoError(bkt.Put(corrupt, []byte("not an object")))
+
+		index := bkt.Bucket(info.Indexes[1].ID[:])
+		require.NoError(index.Put([]byte("alpha@example.com"), alpha.ObjectID[:]))
+		require.NoError(index.Put([]byte("bravo@example.com"), bravo.ObjectID[:]))
+		require.NoError(index.Put([]byte("charlie@example.com"), ghost[:]))
+
+		require.NoError(tx.DeleteBucket(missing.ID[:]))
+		_, err = tx.CreateBucket(dropped.ID[:])
+		require.NoError(err)
+		_, err = tx.CreateBucket(orphan[:])
+		require.NoError(err)
+		return nil
+	}))
+
+	_, err := s.store.Collection(context.Background(), missing.ID)
+	require.NoError(err)
+
+	tx, err = s.store.Begin(context.Background(), &store.TxOptions{ReadOnly: true})
+	require.NoError(err)
+	_, err = tx.Collection(missing.ID)
+	require.ErrorIs(err, errors.ErrRepairCollection)
+	require.NoError(tx.Rollback())
+
+	expected := map[store.ProblemType]int{
+		store.MissingName:        1,
+		store.StaleName:          1,
+		store.MissingIndex:       1,
+		store.StaleIndex:         1,
+		store.CorruptObject:      1,
+		store.DanglingIndexEntry: 3,
+		store.MissingBucket:      1,
+		store.DroppedBucket:      1,
+		store.OrphanedBucket:     1,
+	}
+
+	// Checking the store does not modify it.
+	for i := 0; i < 2; i++ {
+		problems := s.problems(ctx, false, collections...)
+		require.Equal(expected, countProblems(problems))
+		for _, problem := range problems {
+			require.False(problem.Repaired, "problem %s should not be repaired", problem)
+		}
+	}
+
+	// Repairing the store fixes everything except the bucket without metadata.
+	problems := s.problems(ctx, true, collections...)
+	require.Equal(expected, countProblems(problems))
+	for _, problem := range problems {
+		require.Equal(problem.Type != store.OrphanedBucket, problem.Repaired, "unexpected repair of %s", problem)
+	}
+
+	problems = s.problems(ctx, false, collections...)
+	require.Len(problems, 1)
+	require.Equal(store.OrphanedBucket, problems[0].Type)
+	require.Equal(orphan, problems[0].Collection)
+
+	tx, c = s.openCollection(missing.ID, true)
+	require.NotNil(c)
+	require.NoError(tx.Rollback())
+
+	exists, err := s.store.Has(context.Background(), info.Name)
+	require.NoError(err)
+	require.True(exists)
+
+	require.NoError(s.store.Engine().Update(func(tx engine.Tx) error {
+		return tx.DeleteBucket(orphan[:])
+	}))
+
+	// Repairs cannot be made to a closed store.
+	closed := &store.Store{}
+	_, err = closed.Check(ctx, true)
+	require.ErrorIs(err, errors.ErrClosed)
+}
+
+func (s *honuTestSuite) TestCheckBlob() {
+	require := s.Require()
+	ctx := context.Background()
+
+	info := &metadata.Collection{
+		Name:    "test_" + strings.ToLower(ulid.Make().String()),
+		Indexes: []*metadata.Index{{Name: "by_name", Type: metadata.UNIQUE, Field: &metadata.Field{Name: "name", Type: metadata.StringField}}},
+	}
+	require.NoError(s.store.New(ctx, info))
+
+	tx, c := s.openCollection(info.ID, false)
+	alpha := &metadata.Metadata{}
+	require.NoError(c.Create(alpha, []byte(`{"name": "alpha"}`), nil))
+	created := alpha.Version.Scalar
+	require.NoError(c.Update(alpha, []byte(`{"name": "alpha-2"}`), nil))
+	require.NoError(tx.Commit())
+
+	// Delete the payload of the latest version directly in the database.
+	missing := alpha.Blob
+	require.NoError(s.store.Engine().Update(func(tx engine.Tx) error {
+		return s.nestedBucket(tx, info.ID, "objblobs").Delete(missing)
+	}))
+
+	// The index entry of the version without a payload cannot be verified.
+	expected := map[store.ProblemType]int{
+		store.CorruptObject:      1,
+		store.DanglingIndexEntry: 1,
+	}
+	require.Equal(expected, countProblems(s.problems(ctx, false, info.ID)))
+	require.Equal(expected, countProblems(s.problems(ctx, true, info.ID)))
+	require.Empty(s.problems(ctx, false, info.ID))
+
+	// The corrupt version is deleted with its payload reference and change log entry
+	// and the previous version of the object is indexed in its place.
+	require.Len(s.blobRefs(info.ID), 1)
+	require.NotContains(s.blobRefs(info.ID), string(missing))
+
+	tx, c = s.openCollection(info.ID, true)
+	obj, err := c.Retrieve(keys.New(alpha.ObjectID, nil), nil)
+	require.NoError(err)
+	data, err := obj.Data()
+	require.NoError(err)
+	require.Equal([]byte(`{"name": "alpha"}`), data)
+
+	stats, err := c.Stats()
+	require.NoError(err)
+	require.Equal(uint64(1), stats.Indexes[0].Entries)
+	require.NoError(tx.Rollback())
+
+	w, err := s.store.Watch(ctx, info.ID, 0)
+	require.NoError(err)
+	change := receive(s.T(), w)
+	require.Equal(uint64(1), change.Sequence)
+	require.Equal(created, change.Metadata.Version.Scalar)
+	require.NoError(w.Close())
+}
+
+// Checks the store and returns the problems found in the specified collections.
+func (s *honuTestSuite) problems(ctx context.Context, repair bool, collections ...ulid.ULID) (problems []*store.Problem) {
+	report, err := s.store.Check(ctx, repair)
+	s.Require().NoError(err, "could not check store")
+
+	for _, problem := range report.Problems {
+		for _, collectionID := range collections {
+			if problem.Collection == collectionID {
+				problems = append(problems, problem)
+			}
+		}
+	}
+	return problems
+}
+
+func countProblems(problems []*store.Problem) map[store.ProblemType]int {
+	counts := make(map[store.ProblemType]int)
+	for _, problem := range problems {
+		counts[problem.Type]++
+	}
+	return counts
+}
@@ -1,18 +1,18 @@
 package iterator
 
 import (
-	"go.etcd.io/bbolt"
+	"go.rtnl.ai/honu/pkg/store/engine"
 	"go.rtnl.ai/honu/pkg/store/keys"
 	"go.rtnl.ai/honu/pkg/store/object"
 )
 
-func New(cursor *bbolt.Cursor) Iterator {
+func New(cursor engine.Cursor) Iterator {
 	return &Cursor{cursor: cursor}
 }
 
-// Cursor is a wrapper around a bbolt cursor that implements the Iterator interface.
+// Cursor is a wrapper around an engine cursor that implements the Iterator interface.
 type Cursor struct {
-	cursor  *bbolt.Cursor
+	cursor  engine.Cursor
 	started bool
 	key     []byte
 	value   []byte
@@ -7,7 +7,9 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
-	"go.etcd.io/bbolt"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/engine/bolt"
+	"go.rtnl.ai/honu/pkg/store/engine/memory"
 	"go.rtnl.ai/honu/pkg/store/iterator"
 	"go.rtnl.ai/honu/pkg/store/keys"
 	"go.rtnl.ai/honu/pkg/store/lamport"
@@ -22,8 +24,17 @@ var (
 )
 
 func TestCursor(t *testing.T) {
-	// Generate a temporary BoltDB database and bucket, populated with test data.
-	db := setupDatabase(t)
+	// The cursor should behave the same for every storage engine.
+	t.Run("Bolt", func(t *testing.T) {
+		testCursor(t, setupDatabase(t, openBolt(t)))
+	})
+
+	t.Run("Memory", func(t *testing.T) {
+		testCursor(t, setupDatabase(t, memory.Open()))
+	})
+}
+
+func testCursor(t *testing.T, db engine.Engine) {
 
 	t.Run("List", func(t *testing.T) {
 		tx, err := db.Begin(false)
@@ -180,22 +191,25 @@ func TestCursor(t *testing.T) {
 
 }
 
-func setupDatabase(t *testing.T) *bbolt.DB {
-	// Create a bbolt database in a temporary file with 128 objects in it.
-	tempDir := t.TempDir()
-	db, err := bbolt.Open(filepath.Join(tempDir, "cursor_test.db"), 0644, nil)
-	if err != nil {
-		t.Fatalf("failed to create temporary bbolt database: %v", err)
-	}
+func setupDatabase(t *testing.T, db engine.Engine) engine.Engine {
+	// Populate the database with a bucket with 128 objects in it.
 	t.Cleanup(func() { db.Close() })
-
 	if err := db.Update(populateBucket); err != nil {
-		t.Fatalf("failed to populate temporary bbolt database: %v", err)
+		t.Fatalf("failed to populate temporary database: %v", err)
+	}
+	return db
+}
+
+func openBolt(t *testing.T) engine.Engine {
+	// Create a bbolt database in a temporary file.
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "cursor_test.db"), nil)
+	if err != nil {
+		t.Fatalf("failed to create temporary bbolt database: %v", err)
 	}
 	return db
 }
 
-func populateBucket(tx *bbolt.Tx) error {
+func populateBucket(tx engine.Tx) error {
 	bkt, err := tx.CreateBucketIfNotExists(testBucket)
 	if err != nil {
 		return err
@@ -0,0 +1,165 @@
+package iterator
+
+import (
+	"bytes"
+
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/object"
+)
+
+// Filter determines if a version of an object is visible to an iterator.
+type Filter func(key keys.Key, obj object.Object) bool
+
+// Latest returns an iterator that yields only one version of each object in the cursor's
+// bucket: the most recent version of the object that is visible to the filter. If the
+// deleted filter matches the most recent visible version (e.g. because it is a tombstone
+// or has expired) then the object is skipped. If either filter is nil, all versions are
+// visible or no objects are deleted respectively. Keys that are not object keys (e.g.
+// nested buckets) are skipped.
+//
+// NOTE: the Latest iterator relies on object versions being sorted from the most
+// recent version to the oldest version, e.g. it requires the v2 key layout.
+func Latest(cursor engine.Cursor, visible, deleted Filter) Iterator {
+	return &latestIterator{
+		Cursor:  Cursor{cursor: cursor},
+		visible: visible,
+		deleted: deleted,
+	}
+}
+
+type latestIterator struct {
+	Cursor
+	visible Filter
+	deleted Filter
+}
+
+var _ Iterator = &latestIterator{}
+
+// Seek to the first visible object version whose key is greater than or equal to the
+// specified key.
+func (i *latestIterator) Seek(key []byte) bool {
+	if i.released() {
+		return false
+	}
+
+	i.started = true
+	return i.forward(i.cursor.Seek(key))
+}
+
+// Move to the next object, skipping any other versions of the current object.
+func (i *latestIterator) Next() bool {
+	if i.released() {
+		return false
+	}
+
+	if !i.started || i.key == nil {
+		return i.First()
+	}
+
+	return i.forward(i.cursor.Seek(keys.Key(i.key).ObjectLimit()))
+}
+
+// Move to the previous object, skipping any other versions of the current object.
+func (i *latestIterator) Prev() bool {
+	if i.released() {
+		return false
+	}
+
+	if !i.started || i.key == nil {
+		return i.Last()
+	}
+
+	i.cursor.Seek(keys.Key(i.key).ObjectPrefix())
+	return i.backward(i.cursor.Prev())
+}
+
+func (i *latestIterator) First() bool {
+	if i.released() {
+		return false
+	}
+
+	i.started = true
+	return i.forward(i.cursor.First())
+}
+
+func (i *latestIterator) Last() bool {
+	if i.released() {
+		return false
+	}
+
+	i.started = true
+	return i.backward(i.cursor.Last())
+}
+
+// Scans forward from the specified key to find the first visible version of an object.
+func (i *latestIterator) forward(key, value []byte) bool {
+	for key != nil {
+		if !isObjectKey(key, value) {
+			key, value = i.cursor.Next()
+			continue
+		}
+
+		prefix := keys.Key(key).ObjectPrefix()
+		for ; key != nil && bytes.HasPrefix(key, prefix); key, value = i.cursor.Next() {
+			if i.visible != nil && !i.visible(keys.Key(key), object.Object(value)) {
+				continue
+			}
+
+			if i.deleted != nil && i.deleted(keys.Key(key), object.Object(value)) {
+				// Skip the remaining versions of the deleted object.
+				key, value = i.cursor.Seek(keys.Key(key).ObjectLimit())
+				break
+			}
+
+			i.key, i.value = key, value
+			return true
+		}
+	}
+
+	i.key, i.value = nil, nil
+	return false
+}
+
+// Scans backward from the specified key to find the latest visible version of the
+// previous object. Since versions are sorted latest first, the scan walks all of the
+// versions of an object from the oldest to the latest and keeps the last visible one.
+func (i *latestIterator) backward(key, value []byte) bool {
+	for key != nil {
+		if !isObjectKey(key, value) {
+			key, value = i.cursor.Prev()
+			continue
+		}
+
+		var found []byte
+		prefix := keys.Key(key).ObjectPrefix()
+		for ; key != nil && bytes.HasPrefix(key, prefix); key, value = i.cursor.Prev() {
+			if i.visible == nil || i.visible(keys.Key(key), object.Object(value)) {
+				found = bytes.Clone(key)
+			}
+		}
+
+		if found == nil {
+			continue
+		}
+
+		// Position the cursor on the found version before the next scan.
+		fkey, fvalue := i.cursor.Seek(found)
+		if i.deleted != nil && i.deleted(keys.Key(fkey), object.Object(fvalue)) {
+			i.cursor.Seek(prefix)
+			key, value = i.cursor.Prev()
+			continue
+		}
+
+		i.key, i.value = fkey, fvalue
+		return true
+	}
+
+	i.key, i.value = nil, nil
+	return false
+}
+
+// Nested buckets have nil values and non-object keys cannot be parsed as keys.
+func isObjectKey(key, value []byte) bool {
+	return value != nil && keys.Key(key).Check() == nil
+}
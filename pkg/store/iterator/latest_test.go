@@ -0,0 +1,191 @@
+package iterator_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/engine/memory"
+	"go.rtnl.ai/honu/pkg/store/iterator"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/lamport"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/object"
+	"go.rtnl.ai/ulid"
+)
+
+var (
+	alpha   = ulid.ULID{0x01}
+	bravo   = ulid.ULID{0x02}
+	charlie = ulid.ULID{0x03}
+	delta   = ulid.ULID{0x04}
+)
+
+func TestLatest(t *testing.T) {
+	// The latest iterator should behave the same for every storage engine.
+	t.Run("Bolt", func(t *testing.T) {
+		testLatest(t, setupLatest(t, openBolt(t)))
+	})
+
+	t.Run("Memory", func(t *testing.T) {
+		testLatest(t, setupLatest(t, memory.Open()))
+	})
+}
+
+func testLatest(t *testing.T, db engine.Engine) {
+	tx, err := db.Begin(false)
+	require.NoError(t, err)
+	t.Cleanup(func() { tx.Rollback() })
+
+	// Versions created up to VID 2 are visible, e.g. to a pinned transaction.
+	pinned := func(key keys.Key, _ object.Object) bool {
+		return key.Version().VID <= 2
+	}
+
+	tombstone := func(_ keys.Key, obj object.Object) bool {
+		return obj.Tombstone()
+	}
+
+	latest := func(visible, deleted iterator.Filter) iterator.Iterator {
+		iter := iterator.Latest(tx.Bucket(testBucket).Cursor(), visible, deleted)
+		t.Cleanup(iter.Release)
+		return iter
+	}
+
+	t.Run("Latest", func(t *testing.T) {
+		// Only the most recent version of each object is returned; tombstones are only
+		// skipped by the deleted filter.
+		iter := latest(nil, nil)
+		require.Equal(t, []string{"alpha-3", "bravo-2", "charlie-2", "delta-3"}, collect(t, iter, iter.Next))
+	})
+
+	t.Run("Deleted", func(t *testing.T) {
+		iter := latest(nil, tombstone)
+		require.Equal(t, []string{"alpha-3", "charlie-2", "delta-3"}, collect(t, iter, iter.Next))
+	})
+
+	t.Run("Visible", func(t *testing.T) {
+		// The latest visible version is returned and objects without a visible version
+		// are skipped; the deleted filter is only applied to the latest visible version.
+		iter := latest(pinned, tombstone)
+		require.Equal(t, []string{"alpha-2", "charlie-2"}, collect(t, iter, iter.Next))
+
+		iter = latest(func(key keys.Key, _ object.Object) bool {
+			return key.Version().VID <= 1
+		}, tombstone)
+		require.Equal(t, []string{"alpha-1", "bravo-1", "charlie-1"}, collect(t, iter, iter.Next))
+	})
+
+	t.Run("Prev", func(t *testing.T) {
+		iter := latest(nil, tombstone)
+		require.Equal(t, []string{"delta-3", "charlie-2", "alpha-3"}, collect(t, iter, iter.Prev))
+
+		iter = latest(pinned, tombstone)
+		require.Equal(t, []string{"charlie-2", "alpha-2"}, collect(t, iter, iter.Prev))
+	})
+
+	t.Run("Seek", func(t *testing.T) {
+		iter := latest(pinned, tombstone)
+		require.True(t, iter.Seek(keys.New(bravo, nil).ObjectPrefix()))
+		require.Equal(t, "charlie-2", data(t, iter))
+		require.False(t, iter.Next())
+
+		iter = latest(nil, nil)
+		require.True(t, iter.Seek(keys.New(bravo, nil).ObjectPrefix()))
+		require.Equal(t, "bravo-2", data(t, iter))
+		require.True(t, iter.Next())
+		require.Equal(t, "charlie-2", data(t, iter))
+		require.True(t, iter.Prev())
+		require.Equal(t, "bravo-2", data(t, iter))
+	})
+
+	t.Run("FirstLast", func(t *testing.T) {
+		iter := latest(pinned, tombstone)
+		require.True(t, iter.Last())
+		require.Equal(t, "charlie-2", data(t, iter))
+		require.True(t, iter.First())
+		require.Equal(t, "alpha-2", data(t, iter))
+	})
+}
+
+// Creates objects with the following version histories along with keys that are not
+// object keys (a nested bucket and an unparseable key) that the iterator must skip:
+//
+//	alpha:   1 -> 2 -> 3
+//	bravo:   1 -> 2 (tombstone)
+//	charlie: 1 -> 2
+//	delta:             3
+func setupLatest(t *testing.T, db engine.Engine) engine.Engine {
+	t.Cleanup(func() { db.Close() })
+
+	history := []struct {
+		oid  ulid.ULID
+		name string
+		vids []uint64
+		kind metadata.Kind
+	}{
+		{alpha, "alpha", []uint64{1, 2, 3}, metadata.LIVE},
+		{bravo, "bravo", []uint64{1, 2}, metadata.TOMBSTONE},
+		{charlie, "charlie", []uint64{1, 2}, metadata.LIVE},
+		{delta, "delta", []uint64{3}, metadata.LIVE},
+	}
+
+	require.NoError(t, db.Update(func(tx engine.Tx) error {
+		bkt, err := tx.CreateBucket(testBucket)
+		if err != nil {
+			return err
+		}
+
+		if _, err = bkt.CreateBucket([]byte("nested")); err != nil {
+			return err
+		}
+
+		if err = bkt.Put([]byte("not an object key"), []byte("value")); err != nil {
+			return err
+		}
+
+		for _, h := range history {
+			for i, vid := range h.vids {
+				// Only the latest version of the object has the kind of the history.
+				kind := metadata.LIVE
+				if i == len(h.vids)-1 {
+					kind = h.kind
+				}
+
+				meta := &metadata.Metadata{
+					ObjectID: h.oid,
+					Version:  &metadata.Version{Scalar: lamport.Scalar{PID: 1, VID: vid}, Kind: kind, Created: time.Now()},
+				}
+
+				obj, err := object.Marshal(meta, []byte(fmt.Sprintf("%s-%d", h.name, vid)))
+				if err != nil {
+					return err
+				}
+
+				if err = bkt.Put(meta.Key(), obj); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}))
+	return db
+}
+
+// Moves the iterator with the specified function until it is exhausted and returns
+// the data of each object that was visited.
+func collect(t *testing.T, iter iterator.Iterator, move func() bool) (objects []string) {
+	for move() {
+		objects = append(objects, data(t, iter))
+	}
+	require.NoError(t, iter.Error())
+	return objects
+}
+
+func data(t *testing.T, iter iterator.Iterator) string {
+	value, err := iter.Object().Data()
+	require.NoError(t, err)
+	return string(value)
+}
@@ -0,0 +1,190 @@
+package iterator
+
+import (
+	"bytes"
+	"sort"
+
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/lamport"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/object"
+)
+
+// VersionIterator iterates over all of the versions of a single object from the most
+// recent version to the oldest version. In addition to the standard iterator methods,
+// the version iterator exposes the version lineage of the object so that forks in the
+// version history (e.g. concurrent writes on different replicas) can be detected.
+type VersionIterator interface {
+	Iterator
+
+	// Parent returns the parent version of the current version or nil if the current
+	// version is the first version of the object (or the iterator is exhausted).
+	// Following the parent versions from the latest version traces the lineage of the
+	// object back to its creation.
+	Parent() *lamport.Scalar
+
+	// Forked returns true if the current version shares its parent with at least one
+	// other version of the object, e.g. the version was written concurrently with
+	// another version on a different replica.
+	Forked() bool
+
+	// Forks returns all of the points in the version history of the object where two or
+	// more versions share the same parent, ordered from the most recent parent.
+	Forks() []Fork
+}
+
+// A Fork describes a point in the version history of an object where two or more
+// versions were created from the same parent version. Versions are ordered from the
+// most recent version to the oldest.
+type Fork struct {
+	Parent   lamport.Scalar
+	Versions []lamport.Scalar
+}
+
+// Versions returns a version iterator over all keys in the cursor's bucket with the
+// specified object prefix. The versions are scanned when the iterator is created in
+// order to build the version lineage and detect forks; any errors decoding the object
+// metadata will be returned by the Error method.
+func Versions(cursor engine.Cursor, prefix []byte) VersionIterator {
+	iter := &versionIterator{
+		Cursor:   Cursor{cursor: cursor},
+		prefix:   prefix,
+		parents:  make(map[lamport.Scalar]lamport.Scalar),
+		children: make(map[lamport.Scalar][]lamport.Scalar),
+	}
+
+	if iter.err = iter.lineage(); iter.err != nil {
+		iter.Release()
+	}
+	return iter
+}
+
+type versionIterator struct {
+	Cursor
+	prefix   []byte
+	parents  map[lamport.Scalar]lamport.Scalar
+	children map[lamport.Scalar][]lamport.Scalar
+}
+
+var _ VersionIterator = &versionIterator{}
+
+// Seek to the specified key if it is within the object prefix, otherwise seek to the
+// first version if it is before the object prefix.
+func (i *versionIterator) Seek(key []byte) bool {
+	if bytes.Compare(key, i.prefix) < 0 {
+		key = i.prefix
+	}
+	return i.bound(i.Cursor.Seek(key))
+}
+
+// Iterate from the most recent version to the oldest. If Next() is the first call,
+// the iterator will seek to the most recent version of the object.
+func (i *versionIterator) Next() bool {
+	if !i.started {
+		return i.First()
+	}
+	return i.bound(i.Cursor.Next())
+}
+
+// Iterate from the oldest version to the most recent. If Prev() is the first call,
+// the iterator will seek to the oldest version of the object.
+func (i *versionIterator) Prev() bool {
+	if !i.started {
+		return i.Last()
+	}
+	return i.bound(i.Cursor.Prev())
+}
+
+// Move the cursor to the most recent version of the object.
+func (i *versionIterator) First() bool {
+	return i.bound(i.Cursor.Seek(i.prefix))
+}
+
+// Move the cursor to the oldest version of the object.
+func (i *versionIterator) Last() bool {
+	if i.released() {
+		return false
+	}
+
+	i.started = true
+	if i.key, i.value = i.cursor.Seek(i.limit()); i.key == nil {
+		i.key, i.value = i.cursor.Last()
+	} else {
+		i.key, i.value = i.cursor.Prev()
+	}
+	return i.bound(i.key != nil)
+}
+
+func (i *versionIterator) Parent() *lamport.Scalar {
+	if len(i.key) == 0 {
+		return nil
+	}
+
+	if parent, ok := i.parents[keys.Key(i.key).Version()]; ok {
+		return &parent
+	}
+	return nil
+}
+
+func (i *versionIterator) Forked() bool {
+	parent := i.Parent()
+	if parent == nil {
+		return false
+	}
+	return len(i.children[*parent]) > 1
+}
+
+func (i *versionIterator) Forks() (forks []Fork) {
+	for parent, children := range i.children {
+		if len(children) > 1 {
+			forks = append(forks, Fork{Parent: parent, Versions: children})
+		}
+	}
+
+	sort.Slice(forks, func(i, j int) bool {
+		return forks[j].Parent.Before(&forks[i].Parent)
+	})
+	return forks
+}
+
+// Ensures that the iterator is positioned within the object prefix; if not the key
+// and value are cleared and false is returned.
+func (i *versionIterator) bound(ok bool) bool {
+	if !ok || !bytes.HasPrefix(i.key, i.prefix) {
+		i.key, i.value = nil, nil
+		return false
+	}
+	return true
+}
+
+// Returns the first key after all of the versions of the object.
+func (i *versionIterator) limit() []byte {
+	limit := bytes.Clone(i.prefix)
+	for j := len(limit) - 1; j >= 0; j-- {
+		limit[j]++
+		if limit[j] != 0 {
+			break
+		}
+	}
+	return limit
+}
+
+// Scan all versions of the object to build the parent and children mappings.
+func (i *versionIterator) lineage() (err error) {
+	for key, value := i.cursor.Seek(i.prefix); key != nil && bytes.HasPrefix(key, i.prefix); key, value = i.cursor.Next() {
+		var meta *metadata.Metadata
+		if meta, err = object.Object(value).Metadata(); err != nil {
+			return err
+		}
+
+		if meta.Version == nil || meta.Version.Parent == nil {
+			continue
+		}
+
+		parent := *meta.Version.Parent
+		i.parents[meta.Version.Scalar] = parent
+		i.children[parent] = append(i.children[parent], meta.Version.Scalar)
+	}
+	return nil
+}
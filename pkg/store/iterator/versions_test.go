@@ -0,0 +1,145 @@
+package iterator_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/engine/memory"
+	"go.rtnl.ai/honu/pkg/store/iterator"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/lamport"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/object"
+	"go.rtnl.ai/ulid"
+)
+
+func TestVersions(t *testing.T) {
+	db := memory.Open()
+	t.Cleanup(func() { db.Close() })
+
+	// Create a version history with a fork at version 1.2 and neighboring objects.
+	//   1.1 -> 1.2 -> 1.3 -> 1.4
+	//             \-> 2.3
+	oid := ulid.Make()
+	history := []struct {
+		vers   lamport.Scalar
+		parent *lamport.Scalar
+	}{
+		{lamport.Scalar{PID: 1, VID: 1}, nil},
+		{lamport.Scalar{PID: 1, VID: 2}, &lamport.Scalar{PID: 1, VID: 1}},
+		{lamport.Scalar{PID: 1, VID: 3}, &lamport.Scalar{PID: 1, VID: 2}},
+		{lamport.Scalar{PID: 2, VID: 3}, &lamport.Scalar{PID: 1, VID: 2}},
+		{lamport.Scalar{PID: 1, VID: 4}, &lamport.Scalar{PID: 1, VID: 3}},
+	}
+
+	require.NoError(t, db.Update(func(tx engine.Tx) error {
+		bkt, err := tx.CreateBucket(testBucket)
+		if err != nil {
+			return err
+		}
+
+		// Add objects before and after the versioned object.
+		others := []ulid.ULID{ulid.ULID{}, ulid.ULID{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}}
+		for _, other := range others {
+			meta := &metadata.Metadata{ObjectID: other, Version: &metadata.Version{Scalar: lamport.Scalar{PID: 1, VID: 1}}}
+			obj, err := object.Marshal(meta, []byte("other"))
+			if err != nil {
+				return err
+			}
+			if err = bkt.Put(keys.New(other, &meta.Version.Scalar), obj); err != nil {
+				return err
+			}
+		}
+
+		for _, h := range history {
+			meta := &metadata.Metadata{
+				ObjectID: oid,
+				Version:  &metadata.Version{Scalar: h.vers, Parent: h.parent, Created: time.Now()},
+			}
+			obj, err := object.Marshal(meta, []byte(h.vers.String()))
+			if err != nil {
+				return err
+			}
+			if err = bkt.Put(meta.Key(), obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	tx, err := db.Begin(false)
+	require.NoError(t, err)
+	t.Cleanup(func() { tx.Rollback() })
+
+	prefix := keys.New(oid, nil).ObjectPrefix()
+
+	t.Run("Next", func(t *testing.T) {
+		iter := iterator.Versions(tx.Bucket(testBucket).Cursor(), prefix)
+		defer iter.Release()
+
+		expected := []struct {
+			vers   string
+			parent string
+			forked bool
+		}{
+			{"1.4", "1.3", false},
+			{"2.3", "1.2", true},
+			{"1.3", "1.2", true},
+			{"1.2", "1.1", false},
+			{"1.1", "", false},
+		}
+
+		idx := 0
+		for iter.Next() {
+			vers := iter.Key().Version()
+			require.Equal(t, expected[idx].vers, vers.String())
+			require.Equal(t, expected[idx].forked, iter.Forked())
+
+			if expected[idx].parent == "" {
+				require.Nil(t, iter.Parent())
+			} else {
+				require.Equal(t, expected[idx].parent, iter.Parent().String())
+			}
+			idx++
+		}
+
+		require.NoError(t, iter.Error())
+		require.Equal(t, len(expected), idx)
+		require.Nil(t, iter.Parent())
+		require.False(t, iter.Forked())
+	})
+
+	t.Run("Prev", func(t *testing.T) {
+		iter := iterator.Versions(tx.Bucket(testBucket).Cursor(), prefix)
+		defer iter.Release()
+
+		var versions []string
+		for iter.Prev() {
+			vers := iter.Key().Version()
+			versions = append(versions, vers.String())
+		}
+		require.Equal(t, []string{"1.1", "1.2", "1.3", "2.3", "1.4"}, versions)
+	})
+
+	t.Run("Forks", func(t *testing.T) {
+		iter := iterator.Versions(tx.Bucket(testBucket).Cursor(), prefix)
+		defer iter.Release()
+
+		forks := iter.Forks()
+		require.Len(t, forks, 1)
+		require.Equal(t, lamport.Scalar{PID: 1, VID: 2}, forks[0].Parent)
+		require.Equal(t, []lamport.Scalar{{PID: 2, VID: 3}, {PID: 1, VID: 3}}, forks[0].Versions)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		iter := iterator.Versions(tx.Bucket(testBucket).Cursor(), keys.New(ulid.Make(), nil).ObjectPrefix())
+		defer iter.Release()
+
+		require.False(t, iter.Next())
+		require.Nil(t, iter.Key())
+		require.Empty(t, iter.Forks())
+		require.NoError(t, iter.Error())
+	})
+}
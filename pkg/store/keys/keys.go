@@ -10,12 +10,16 @@ import (
 )
 
 const (
-	// The default size of a v1 object storage key.
+	// The default size of a v1 and v2 object storage key.
 	keySize int = 29
 
 	// The version of the key for compatibility indication; increment this number any time
 	// the underlying key data is no longer compatible with the previous version.
-	keyVersion byte = 0x1
+	keyVersion byte = 0x2
+
+	// Version 1 keys store the version in ascending order (oldest version first). These
+	// keys are still readable but are migrated to the current key version by the store.
+	keyVersionV1 byte = 0x1
 )
 
 var (
@@ -24,6 +28,17 @@ var (
 	ErrMalformed  = errors.New("key is malformed: cannot parse version components")
 )
 
+// CurrentVersion returns the key version that new keys are created with.
+func CurrentVersion() uint8 {
+	return keyVersion
+}
+
+// Supported returns true if keys with the specified version can be read by the store;
+// keys with an older version are migrated to the current key version when opened.
+func Supported(version uint8) bool {
+	return version == keyVersion || version == keyVersionV1
+}
+
 // Keys are used to store objects in the underlying key/value store. It is a 29 byte key
 // that is composed of a 16 byte object ID and a 4 byte uint32 and 8 byte uint64
 // representing the lamport scalar version number. The first byte indicates the key
@@ -33,7 +48,11 @@ var (
 // A key is structured as keyVersion::oid::vid::pid
 //
 // Note that the version is serialized differently than the lamport scalar in order to
-// maintain lexicographic sorting of the the data.
+// maintain lexicographic sorting of the the data. In v2 keys the bits of the vid and pid
+// are inverted so that the latest version of an object sorts first; seeking to the
+// object prefix will therefore position a cursor on the latest version of the object.
+// A key with all zero version bytes has no version (the inverse of the maximum scalar
+// is never assigned as a version).
 type Key []byte
 
 // Create a new key for the specified collection and object ID with the given version.
@@ -43,9 +62,9 @@ func New(oid ulid.ULID, vers *lamport.Scalar) Key {
 	key := make([]byte, keySize)
 	key[0] = keyVersion
 	copy(key[1:17], oid[:])
-	if vers != nil {
-		binary.BigEndian.PutUint64(key[17:25], vers.VID)
-		binary.BigEndian.PutUint32(key[25:29], vers.PID)
+	if vers != nil && !vers.IsZero() {
+		binary.BigEndian.PutUint64(key[17:25], ^vers.VID)
+		binary.BigEndian.PutUint32(key[25:29], ^vers.PID)
 	}
 	return Key(key)
 }
@@ -64,10 +83,17 @@ func (k Key) Version() lamport.Scalar {
 	if err := k.Check(); err != nil {
 		panic(err)
 	}
-	return lamport.Scalar{
+
+	vers := lamport.Scalar{
 		VID: binary.BigEndian.Uint64(k[17:25]),
 		PID: binary.BigEndian.Uint32(k[25:29]),
 	}
+
+	if k[0] == keyVersion && k.HasVersion() {
+		vers.VID = ^vers.VID
+		vers.PID = ^vers.PID
+	}
+	return vers
 }
 
 // ObjectPrefix returns object IDs without any version information.
@@ -78,16 +104,22 @@ func (k Key) ObjectPrefix() []byte {
 	return k[0:17]
 }
 
-// ObjectLimit returns a byte slice with the object ID with the last byte incremented by
-// one. This can be used to create a range query for all versions of an object where the
-// start is the ObjectPrefix.
+// ObjectLimit returns a byte slice with the object ID incremented by one. This can be
+// used to create a range query for all versions of an object where the start is the
+// ObjectPrefix. The increment carries into the preceding bytes so that the limit always
+// sorts after every version of the object, even if the object ID ends in 0xFF.
 func (k Key) ObjectLimit() []byte {
 	if err := k.Check(); err != nil {
 		panic(err)
 	}
 	limit := make([]byte, 17)
 	copy(limit, k[0:17])
-	limit[16]++
+	for i := len(limit) - 1; i >= 0; i-- {
+		limit[i]++
+		if limit[i] != 0 {
+			break
+		}
+	}
 	return limit
 }
 
@@ -112,13 +144,30 @@ func (k Key) Check() error {
 		return ErrBadSize
 	}
 
-	if k[0] != keyVersion {
+	if k[0] != keyVersion && k[0] != keyVersionV1 {
 		return ErrBadVersion
 	}
 
 	return nil
 }
 
+// Upgrade returns the key in the current key version layout. If the key is already
+// the current version then the key itself is returned without copying.
+func (k Key) Upgrade() Key {
+	if k[0] == keyVersion {
+		return k
+	}
+
+	vers := k.Version()
+	return New(k.ObjectID(), &vers)
+}
+
+// Outdated returns true if the key is valid but is not the current key version and
+// should be migrated by upgrading the key.
+func (k Key) Outdated() bool {
+	return len(k) == keySize && k[0] == keyVersionV1
+}
+
 //===========================================================================
 // Sort Interface
 //===========================================================================
@@ -3,6 +3,7 @@ package keys_test
 import (
 	"bytes"
 	crand "crypto/rand"
+	"encoding/binary"
 	"math"
 	"math/rand/v2"
 	"sort"
@@ -28,8 +29,8 @@ func TestNewKey(t *testing.T) {
 
 func TestKeyLexicographic(t *testing.T) {
 	// For the same collection and object ID the keys should be lexicographically sorted
-	// by the version to ensure that we can read the latest version either by choosing
-	// the first item in a list of sorted keys or the last item.
+	// in reverse version order to ensure that we can read the latest version by seeking
+	// to the first item in a list of sorted keys.
 	oid := ulid.Make()
 
 	t.Run("Static", func(t *testing.T) {
@@ -55,11 +56,11 @@ func TestKeyLexicographic(t *testing.T) {
 		}
 
 		// Ensure the keys are sorted both by monotonically increasing version and by
-		// lexicographic byte order.
+		// reverse lexicographic byte order.
 		for i := 1; i < len(keyset); i++ {
 			versa, versb := keyset[i-1].Version(), keyset[i].Version()
 			require.True(t, lamport.Compare(&versa, &versb) <= 0, "%s is not before %s version", versa.String(), versb.String())
-			require.True(t, bytes.Compare(keyset[i-1][:], keyset[i][:]) <= 0, "keys[%d] (%s) is not less than or equal to keys[%d] (%s)", i-1, versa.String(), i, versb.String())
+			require.True(t, bytes.Compare(keyset[i-1][:], keyset[i][:]) >= 0, "keys[%d] (%s) is not greater than or equal to keys[%d] (%s)", i-1, versa.String(), i, versb.String())
 		}
 	})
 
@@ -74,11 +75,11 @@ func TestKeyLexicographic(t *testing.T) {
 		}
 
 		// Ensure the keys are sorted both by monotonically increasing version and by
-		// lexicographic byte order.
+		// reverse lexicographic byte order.
 		for i := 1; i < len(keys); i++ {
 			versa, versb := keys[i-1].Version(), keys[i].Version()
 			require.True(t, lamport.Compare(&versa, &versb) <= 0, "%s is not before %s version", versa.String(), versb.String())
-			require.True(t, bytes.Compare(keys[i-1][:], keys[i][:]) <= 0, "keys[%d] (%s) is not less than or equal to keys[%d] (%s)", i-1, versa.String(), i, versb.String())
+			require.True(t, bytes.Compare(keys[i-1][:], keys[i][:]) >= 0, "keys[%d] (%s) is not greater than or equal to keys[%d] (%s)", i-1, versa.String(), i, versb.String())
 		}
 	})
 
@@ -92,14 +93,14 @@ func TestKeyLexicographic(t *testing.T) {
 			vers = randNextScalar(vers)
 		}
 
-		sort.Sort(keys)
+		sort.Sort(sort.Reverse(keys))
 
 		// Ensure the keys are sorted both by monotonically increasing version and by
-		// lexicographic byte order.
+		// reverse lexicographic byte order.
 		for i := 1; i < len(keys); i++ {
 			versa, versb := keys[i-1].Version(), keys[i].Version()
 			require.True(t, lamport.Compare(&versa, &versb) <= 0, "%s is not before %s version", versa.String(), versb.String())
-			require.True(t, bytes.Compare(keys[i-1][:], keys[i][:]) <= 0, "keys[%d] (%s) is not less than or equal to keys[%d] (%s)", i-1, versa.String(), i, versb.String())
+			require.True(t, bytes.Compare(keys[i-1][:], keys[i][:]) >= 0, "keys[%d] (%s) is not greater than or equal to keys[%d] (%s)", i-1, versa.String(), i, versb.String())
 		}
 	})
 }
@@ -125,6 +126,57 @@ func TestKeyCheck(t *testing.T) {
 	})
 }
 
+func TestKeyV1(t *testing.T) {
+	oid := ulid.Make()
+	vers := &lamport.Scalar{VID: 42, PID: 7}
+
+	// Create a v1 key by hand: versions are stored big endian without inversion.
+	v1 := make(Key, 29)
+	v1[0] = 0x1
+	copy(v1[1:17], oid[:])
+	binary.BigEndian.PutUint64(v1[17:25], vers.VID)
+	binary.BigEndian.PutUint32(v1[25:29], vers.PID)
+
+	require.NoError(t, v1.Check())
+	require.True(t, v1.Outdated())
+	require.True(t, v1.HasVersion())
+	require.Equal(t, oid, v1.ObjectID())
+	require.Equal(t, *vers, v1.Version())
+	require.Equal(t, uint8(0x01), v1.ObjectPrefix()[0])
+	require.Equal(t, uint8(0x01), v1.ObjectLimit()[0])
+
+	v2 := v1.Upgrade()
+	require.False(t, v2.Outdated())
+	require.Equal(t, New(oid, vers), v2)
+	require.Equal(t, *vers, v2.Version())
+	require.Equal(t, oid, v2.ObjectID())
+
+	// Upgrading a current key returns the key itself.
+	require.Equal(t, v2, v2.Upgrade())
+}
+
+func TestKeyLatestFirst(t *testing.T) {
+	// Seeking to the object prefix (or a key without a version) should return the
+	// latest version of the object before any other versions.
+	oid := ulid.Make()
+	keyset := Keys{
+		New(oid, &lamport.Scalar{VID: 1, PID: 1}),
+		New(oid, &lamport.Scalar{VID: 2, PID: 1}),
+		New(oid, &lamport.Scalar{VID: 2, PID: 8}),
+		New(oid, &lamport.Scalar{VID: 3, PID: 2}),
+	}
+	sort.Sort(keyset)
+
+	require.Equal(t, lamport.Scalar{VID: 3, PID: 2}, keyset[0].Version())
+	require.Equal(t, lamport.Scalar{VID: 2, PID: 8}, keyset[1].Version())
+	require.Equal(t, lamport.Scalar{VID: 2, PID: 1}, keyset[2].Version())
+	require.Equal(t, lamport.Scalar{VID: 1, PID: 1}, keyset[3].Version())
+
+	prefix := New(oid, nil)
+	require.True(t, bytes.Compare(prefix, keyset[0]) < 0, "expected unversioned key to sort before latest version")
+	require.True(t, bytes.Compare(prefix.ObjectPrefix(), keyset[0]) < 0, "expected object prefix to sort before latest version")
+}
+
 func TestObjectID(t *testing.T) {
 	oid := ulid.Make()
 	vers := &lamport.Scalar{VID: 80, PID: 122}
@@ -167,7 +219,7 @@ func TestObjectPrefix(t *testing.T) {
 		k := New(oid, vers)
 		prefix := k.ObjectPrefix()
 		require.Len(t, prefix, 17)
-		require.Equal(t, uint8(0x01), prefix[0])
+		require.Equal(t, uint8(0x02), prefix[0])
 		require.Equal(t, oid, ulid.ULID(prefix[1:17]))
 	})
 
@@ -180,18 +232,29 @@ func TestObjectPrefix(t *testing.T) {
 }
 
 func TestObjectLimit(t *testing.T) {
-	oid := ulid.Make()
+	oid := ulid.MustParse("01JDRX5K0Q2QJ0ZK7W8N3V5C4M")
 	vers := &lamport.Scalar{VID: 1, PID: 2}
 
 	t.Run("Ok", func(t *testing.T) {
 		k := New(oid, vers)
 		limit := k.ObjectLimit()
 		require.Len(t, limit, 17)
-		require.Equal(t, uint8(0x01), limit[0])
+		require.Equal(t, uint8(0x02), limit[0])
 		require.True(t, bytes.Equal(oid[:15], limit[1:16]))
 		require.Equal(t, oid[15]+1, limit[16])
 	})
 
+	t.Run("Carry", func(t *testing.T) {
+		// The limit must sort after all versions of an object whose ID ends in 0xFF.
+		oid := ulid.ULID{0x01, 0x8f, 0x2b, 0x3c, 0x4d, 0x5e, 0x6f, 0x70, 0x81, 0x92, 0xa3, 0xb4, 0xc5, 0xd6, 0xff, 0xff}
+		k := New(oid, vers)
+		limit := k.ObjectLimit()
+		require.Len(t, limit, 17)
+		require.Equal(t, []byte{0x02, 0x01, 0x8f, 0x2b, 0x3c, 0x4d, 0x5e, 0x6f, 0x70, 0x81, 0x92, 0xa3, 0xb4, 0xc5, 0xd7, 0x00, 0x00}, limit)
+		require.Equal(t, 1, bytes.Compare(limit, k))
+		require.Equal(t, 1, bytes.Compare(limit, New(oid, nil)))
+	})
+
 	t.Run("Panics", func(t *testing.T) {
 		badKey := Key(make([]byte, 42))
 		require.Panics(t, func() {
@@ -0,0 +1,64 @@
+package metadata
+
+import (
+	"go.rtnl.ai/honu/pkg/store/lani"
+)
+
+//===========================================================================
+// Chunks
+//===========================================================================
+
+// Chunks describes object data that is stored as a sequence of chunks separately from
+// the object metadata so that large objects can be streamed to and from the store
+// without being held in memory. The checksum is the SHA-256 hash of the complete data.
+type Chunks struct {
+	Length   uint64 `json:"length" msg:"length"`
+	Count    uint64 `json:"count" msg:"count"`
+	Checksum []byte `json:"checksum" msg:"checksum"`
+}
+
+var _ lani.Encodable = (*Chunks)(nil)
+var _ lani.Decodable = (*Chunks)(nil)
+
+// The static size of a zero valued Chunks object; see TestChunks for details.
+const chunksStaticSize = 30
+
+func (o *Chunks) Size() int {
+	return chunksStaticSize + len(o.Checksum)
+}
+
+func (o *Chunks) Encode(e *lani.Encoder) (n int, err error) {
+	var m int
+	if m, err = e.EncodeUint64(o.Length); err != nil {
+		return n + m, err
+	}
+	n += m
+
+	if m, err = e.EncodeUint64(o.Count); err != nil {
+		return n + m, err
+	}
+	n += m
+
+	if m, err = e.Encode(o.Checksum); err != nil {
+		return n + m, err
+	}
+	n += m
+
+	return
+}
+
+func (o *Chunks) Decode(d *lani.Decoder) (err error) {
+	if o.Length, err = d.DecodeUint64(); err != nil {
+		return err
+	}
+
+	if o.Count, err = d.DecodeUint64(); err != nil {
+		return err
+	}
+
+	if o.Checksum, err = d.Decode(); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package metadata_test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"go.rtnl.ai/honu/pkg/store/metadata"
+)
+
+func TestChunks(t *testing.T) {
+	var staticSize int
+	staticSize += binary.MaxVarintLen64 // Length (uint64)
+	staticSize += binary.MaxVarintLen64 // Count (uint64)
+	staticSize += binary.MaxVarintLen64 // Length of Checksum
+
+	// Create a test generic case and execute the tests
+	testCase := &TestCase{
+		Name:        "Chunks",
+		Fixture:     "chunks.json",
+		StaticSize:  staticSize,
+		FixtureSize: 62,
+		New:         func() TestObject { return &metadata.Chunks{} },
+	}
+
+	t.Run("StaticSize", testCase.TestStaticSize)
+	t.Run("VariableSize", testCase.TestVariableSize)
+	t.Run("Serialization", testCase.TestSerialization)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"time"
 
+	"go.rtnl.ai/honu/pkg/errors"
 	"go.rtnl.ai/honu/pkg/region"
 	"go.rtnl.ai/honu/pkg/store/lamport"
 	"go.rtnl.ai/honu/pkg/store/lani"
@@ -28,6 +29,8 @@ type Collection struct {
 	Compression  *Compression     `json:"compression,omitempty" msg:"compression,omitempty"`
 	Flags        uint8            `json:"flags,omitempty" msg:"flags,omitempty"`
 	Indexes      []*Index         `json:"indexes,omitempty" msg:"indexes,omitempty"`
+	Retention    *Retention       `json:"retention,omitempty" msg:"retention,omitempty"`
+	TTL          time.Duration    `json:"ttl,omitempty" msg:"ttl,omitempty"`
 	Created      time.Time        `json:"created" msg:"created"`
 	Modified     time.Time        `json:"modified" msg:"modified"`
 }
@@ -47,6 +50,16 @@ func (c *Collection) Validate() (err error) {
 	if err = ValidateName(c.Name); err != nil {
 		return err
 	}
+
+	if c.Retention != nil {
+		if err = c.Retention.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.TTL < 0 {
+		return errors.ErrInvalidTTL
+	}
 	return nil
 }
 
@@ -55,11 +68,11 @@ func (c *Collection) Validate() (err error) {
 // NOTE: ID, name, owner, group, created, and modified are preserved.
 func (c *Collection) Tombstone(pid lamport.PID, region region.Region) {
 	tombstone := &Version{
-		Scalar:    pid.Next(&c.Version.Scalar),
-		Region:    region,
-		Parent:    &c.Version.Scalar,
-		Tombstone: true,
-		Created:   time.Now(),
+		Scalar:  pid.Next(&c.Version.Scalar),
+		Region:  region,
+		Parent:  &c.Version.Scalar,
+		Kind:    TOMBSTONE,
+		Created: time.Now(),
 	}
 
 	c.Version = tombstone
@@ -72,11 +85,13 @@ func (c *Collection) Tombstone(pid lamport.PID, region region.Region) {
 	c.Compression = nil
 	c.Flags = 0
 	c.Indexes = nil
+	c.Retention = nil
+	c.TTL = 0
 	c.Modified = tombstone.Created
 }
 
 // The static size of a zero valued Collection object; see TestCollectionSize for details.
-const collectionStaticSize = 115
+const collectionStaticSize = 126
 
 func (c *Collection) Size() (s int) {
 	s = collectionStaticSize
@@ -131,6 +146,11 @@ func (c *Collection) Size() (s int) {
 		}
 	}
 
+	// Retention size
+	if c.Retention != nil {
+		s += c.Retention.Size()
+	}
+
 	return
 }
 
@@ -234,6 +254,18 @@ func (c *Collection) Encode(e *lani.Encoder) (n int, err error) {
 	}
 	n += m
 
+	// NOTE: fields added after the initial encoding are encoded last so that collections
+	// stored with a previous encoding can still be decoded.
+	if m, err = e.EncodeStruct(c.Retention); err != nil {
+		return n + m, err
+	}
+	n += m
+
+	if m, err = e.EncodeInt64(int64(c.TTL)); err != nil {
+		return n + m, err
+	}
+	n += m
+
 	return
 }
 
@@ -352,5 +384,20 @@ func (c *Collection) Decode(d *lani.Decoder) (err error) {
 		return err
 	}
 
+	// Collections stored with a previous encoding end before these fields.
+	c.Retention = &Retention{}
+	if isNil, err = d.DecodeStruct(c.Retention); err != nil || isNil {
+		c.Retention = nil
+		if err != nil {
+			return ignoreEOF(err)
+		}
+	}
+
+	var ttl int64
+	if ttl, err = d.DecodeInt64(); err != nil {
+		return ignoreEOF(err)
+	}
+	c.TTL = time.Duration(ttl)
+
 	return nil
 }
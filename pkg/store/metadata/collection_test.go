@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"testing"
 
+	"github.com/stretchr/testify/require"
+	"go.rtnl.ai/honu/pkg/store/lani"
 	"go.rtnl.ai/honu/pkg/store/metadata"
 )
 
@@ -19,13 +21,15 @@ func TestCollection(t *testing.T) {
 	staticSize += 1                         // Flags
 	staticSize += binary.MaxVarintLen64     // Length of Indexes list
 	staticSize += 2 * binary.MaxVarintLen64 // Created, and Modified (time.Time)
+	staticSize += 1                         // Retention not nil bool
+	staticSize += binary.MaxVarintLen64     // TTL (time.Duration)
 
 	// Create a test generic case and execute the tests
 	testCase := &TestCase{
 		Name:        "Collection",
 		Fixture:     "collection.json",
 		StaticSize:  staticSize,
-		FixtureSize: 637,
+		FixtureSize: 669,
 		New:         func() TestObject { return &metadata.Collection{} },
 	}
 
@@ -33,3 +37,23 @@ func TestCollection(t *testing.T) {
 	t.Run("VariableSize", testCase.TestVariableSize)
 	t.Run("Serialization", testCase.TestSerialization)
 }
+
+func TestCollectionPreviousEncoding(t *testing.T) {
+	// Collections stored before retention policies and TTLs were added must still be
+	// decodable; these fields are encoded at the end of the collection.
+	orig := &metadata.Collection{}
+	loadFixture(t, "collection.json", orig)
+	orig.Retention = nil
+	orig.TTL = 0
+
+	data, err := lani.Marshal(orig)
+	require.NoError(t, err, "could not marshal collection")
+
+	// Remove the trailing retention nil flag and zero TTL to simulate the previous encoding.
+	require.Equal(t, []byte{0x0, 0x0}, data[len(data)-2:])
+	data = data[:len(data)-2]
+
+	cmp := &metadata.Collection{}
+	require.NoError(t, lani.Unmarshal(data, cmp), "could not unmarshal previous collection encoding")
+	require.Equal(t, orig, cmp)
+}
@@ -0,0 +1,66 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Kind describes what a version of a record represents: a live version that contains
+// the data of the record, a tombstone that marks the record as deleted, or a truncated
+// record that marks the record as removed by truncating or destroying its collection.
+// The kind is stored in the version metadata so that empty records are not confused
+// with deleted records.
+//
+// NOTE: the kind is serialized in place of the previous tombstone boolean, so LIVE and
+// TOMBSTONE must remain 0 and 1 respectively for backwards compatibility.
+type Kind uint8
+
+const (
+	LIVE      Kind = iota // A live version of the record with data
+	TOMBSTONE             // The record has been deleted
+	TRUNCATED             // The record has been removed by a truncate or destroy
+)
+
+var kindNames = [3]string{"LIVE", "TOMBSTONE", "TRUNCATED"}
+
+func ParseKind(s string) (Kind, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	for i, name := range kindNames {
+		if s == name {
+			return Kind(i), nil
+		}
+	}
+	return Kind(0), fmt.Errorf("unknown record kind: %q", s)
+}
+
+// Returns true if the record version is live, e.g. it is not a tombstone or truncated.
+func (k Kind) Live() bool {
+	return k == LIVE
+}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "UNKNOWN"
+}
+
+func (k *Kind) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k.String())
+}
+
+func (k *Kind) UnmarshalJSON(data []byte) (err error) {
+	var kind string
+	if err := json.Unmarshal(data, &kind); err != nil {
+		return err
+	}
+	if *k, err = ParseKind(kind); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (k Kind) Value() uint8 {
+	return uint8(k)
+}
@@ -0,0 +1,33 @@
+package metadata_test
+
+import (
+	"testing"
+
+	"go.rtnl.ai/honu/pkg/store/metadata"
+)
+
+func TestKind(t *testing.T) {
+	testCase := &TestEnumCase{
+		Name: "Kind",
+		Values: []TestEnum{
+			metadata.LIVE,
+			metadata.TOMBSTONE,
+			metadata.TRUNCATED,
+		},
+		Strings: []string{
+			"LIVE",
+			"TOMBSTONE",
+			"TRUNCATED",
+		},
+		Unknowns: "UNKNOWN",
+		ICase:    true,
+		ISpace:   true,
+		Parse:    func(s string) (TestEnum, error) { return metadata.ParseKind(s) },
+		New:      func(i uint8) Serializable { val := metadata.Kind(i); return &val },
+	}
+
+	t.Run("String", testCase.TestString)
+	t.Run("StringBounds", testCase.TestStringBounds)
+	t.Run("Parse", testCase.TestParse)
+	t.Run("JSON", testCase.TestJSON)
+}
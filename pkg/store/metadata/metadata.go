@@ -2,10 +2,13 @@ package metadata
 
 import (
 	"encoding/binary"
+	"io"
 	"time"
 
+	"go.rtnl.ai/honu/pkg/errors"
 	"go.rtnl.ai/honu/pkg/region"
 	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/lamport"
 	"go.rtnl.ai/honu/pkg/store/lani"
 	"go.rtnl.ai/ulid"
 )
@@ -31,6 +34,9 @@ type Metadata struct {
 	Flags        uint8            `json:"flags" msg:"flags"`
 	Created      time.Time        `json:"created" msg:"created"`
 	Modified     time.Time        `json:"modified" msg:"modified"`
+	Expires      time.Time        `json:"expires,omitempty" msg:"expires,omitempty"`
+	Chunks       *Chunks          `json:"chunks,omitempty" msg:"chunks,omitempty"`
+	Blob         []byte           `json:"blob,omitempty" msg:"blob,omitempty"`
 	key          keys.Key         `json:"-" msg:"-"`
 }
 
@@ -39,7 +45,57 @@ type Metadata struct {
 //===========================================================================
 
 func (m *Metadata) IsTombstone() bool {
-	return m.Version != nil && m.Version.Tombstone
+	return m.Version.IsTombstone()
+}
+
+// Returns true if the object has an expiration time that is at or before now.
+func (m *Metadata) Expired(now time.Time) bool {
+	return !m.Expires.IsZero() && !now.Before(m.Expires)
+}
+
+// Returns the record kind of the metadata version; metadata without a version is live.
+func (m *Metadata) Kind() Kind {
+	if m.Version == nil {
+		return LIVE
+	}
+	return m.Version.Kind
+}
+
+// DecodeKind reads the record kind from encoded metadata without decoding the entire
+// metadata struct; only the fields preceding the version kind are decoded.
+func DecodeKind(d *lani.Decoder) (_ Kind, err error) {
+	var notNil bool
+	if notNil, err = d.DecodeBool(); err != nil || !notNil {
+		return LIVE, err
+	}
+
+	// Skip the ObjectID and the CollectionID
+	if _, err = d.DecodeFixed(2 * len(ulid.ULID{})); err != nil {
+		return LIVE, err
+	}
+
+	if notNil, err = d.DecodeBool(); err != nil || !notNil {
+		return LIVE, err
+	}
+
+	vers := &lamport.Scalar{}
+	if err = vers.Decode(d); err != nil {
+		return LIVE, err
+	}
+
+	if _, err = d.DecodeUint32(); err != nil {
+		return LIVE, err
+	}
+
+	if _, err = d.DecodeStruct(vers); err != nil {
+		return LIVE, err
+	}
+
+	var kind uint8
+	if kind, err = d.DecodeUint8(); err != nil {
+		return LIVE, err
+	}
+	return Kind(kind), nil
 }
 
 //===========================================================================
@@ -57,7 +113,7 @@ func (o *Metadata) Key() keys.Key {
 }
 
 // The static size of a zero valued Metadata object; see TestMetadataSize for details.
-const metadataStaticSize = 121
+const metadataStaticSize = 142
 
 func (o *Metadata) Size() (s int) {
 	s = metadataStaticSize
@@ -102,6 +158,14 @@ func (o *Metadata) Size() (s int) {
 		s += o.Compression.Size()
 	}
 
+	// Chunks size
+	if o.Chunks != nil {
+		s += o.Chunks.Size()
+	}
+
+	// Length of Blob reference
+	s += len(o.Blob)
+
 	return
 }
 
@@ -196,6 +260,23 @@ func (o *Metadata) Encode(e *lani.Encoder) (n int, err error) {
 	}
 	n += m
 
+	// NOTE: expires is encoded last so that objects stored before expiration was added
+	// can still be decoded.
+	if m, err = e.EncodeTime(o.Expires); err != nil {
+		return n + m, err
+	}
+	n += m
+
+	if m, err = e.EncodeStruct(o.Chunks); err != nil {
+		return n + m, err
+	}
+	n += m
+
+	if m, err = e.Encode(o.Blob); err != nil {
+		return n + m, err
+	}
+	n += m
+
 	return
 }
 
@@ -298,5 +379,33 @@ func (o *Metadata) Decode(d *lani.Decoder) (err error) {
 		return err
 	}
 
+	// Objects stored before expiration was added end after Modified.
+	if o.Expires, err = d.DecodeTime(); err != nil {
+		return ignoreEOF(err)
+	}
+
+	// Objects stored before chunked data was added end after Expires.
+	o.Chunks = &Chunks{}
+	if isNil, err = d.DecodeStruct(o.Chunks); err != nil || isNil {
+		o.Chunks = nil
+		if err != nil {
+			return ignoreEOF(err)
+		}
+	}
+
+	// Objects stored before payload deduplication was added end after Chunks.
+	if o.Blob, err = d.Decode(); err != nil {
+		return ignoreEOF(err)
+	}
+
 	return nil
 }
+
+// Fields that are added to the end of an encoding are optional when decoding so that
+// data stored with the previous encoding can still be decoded.
+func ignoreEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
@@ -3,8 +3,10 @@ package metadata_test
 import (
 	"encoding/binary"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"go.rtnl.ai/honu/pkg/store/lani"
 	. "go.rtnl.ai/honu/pkg/store/metadata"
 )
 
@@ -19,13 +21,16 @@ func TestMetadata(t *testing.T) {
 	staticSize += 3                         // Publisher, Encryption, and Compression not nil bool
 	staticSize += 1                         // Flags
 	staticSize += 2 * binary.MaxVarintLen64 // Created, and Modified (time.Time)
+	staticSize += binary.MaxVarintLen64     // Expires (time.Time)
+	staticSize += 1                         // Chunks not nil bool
+	staticSize += binary.MaxVarintLen64     // Length of Blob
 
 	// Create a test generic case and execute the tests
 	testCase := &TestCase{
 		Name:        "Metadata",
 		Fixture:     "metadata.json",
 		StaticSize:  staticSize,
-		FixtureSize: 557,
+		FixtureSize: 672,
 		New:         func() TestObject { return &Metadata{} },
 	}
 
@@ -42,3 +47,33 @@ func TestMetadataKey(t *testing.T) {
 	require.Equal(t, obj.ObjectID, key.ObjectID())
 	require.Equal(t, obj.Version.Scalar, key.Version())
 }
+
+func TestMetadataPreviousEncoding(t *testing.T) {
+	// Objects stored before expiration, chunked data, and blob references were added
+	// must still be decodable; these fields are encoded at the end of the metadata.
+	orig := &Metadata{}
+	loadFixture(t, "metadata.json", orig)
+	orig.Expires = time.Time{}
+	orig.Chunks = nil
+	orig.Blob = nil
+
+	data, err := lani.Marshal(orig)
+	require.NoError(t, err, "could not marshal metadata")
+
+	// Remove the trailing zero expires timestamp, chunks nil flag, and empty blob to
+	// simulate the previous encodings.
+	require.Equal(t, []byte{0x0, 0x0, 0x0}, data[len(data)-3:])
+	for _, trim := range []int{1, 2, 3} {
+		cmp := &Metadata{}
+		require.NoError(t, lani.Unmarshal(data[:len(data)-trim], cmp), "could not unmarshal previous metadata encoding")
+		require.Equal(t, orig, cmp)
+	}
+}
+
+func TestMetadataExpired(t *testing.T) {
+	now := time.Now()
+	require.False(t, (&Metadata{}).Expired(now), "metadata without expiration should not expire")
+	require.False(t, (&Metadata{Expires: now.Add(time.Minute)}).Expired(now))
+	require.True(t, (&Metadata{Expires: now}).Expired(now))
+	require.True(t, (&Metadata{Expires: now.Add(-time.Minute)}).Expired(now))
+}
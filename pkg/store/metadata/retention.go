@@ -0,0 +1,157 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store/lani"
+)
+
+//===========================================================================
+// Retention
+//===========================================================================
+
+type RetentionPolicy uint8
+
+const (
+	KEEP_ALL      RetentionPolicy = iota // Keep every version of every object
+	KEEP_VERSIONS                        // Keep the last N versions of every object
+	KEEP_DURATION                        // Keep versions younger than a duration
+)
+
+var retentionPolicyNames = [3]string{"KEEP_ALL", "KEEP_VERSIONS", "KEEP_DURATION"}
+
+// Retention defines how many superseded versions of each object in a collection are
+// kept by the compactor. The latest version of an object is always kept regardless of
+// the policy (even if it is a tombstone) so that it can be replicated.
+type Retention struct {
+	Policy   RetentionPolicy `json:"policy" msg:"policy"`
+	Versions uint64          `json:"versions,omitempty" msg:"versions,omitempty"`
+	Duration time.Duration   `json:"duration,omitempty" msg:"duration,omitempty"`
+}
+
+var _ lani.Encodable = (*Retention)(nil)
+var _ lani.Decodable = (*Retention)(nil)
+
+// Validate that the retention policy has the parameters it requires.
+func (o *Retention) Validate() error {
+	switch o.Policy {
+	case KEEP_ALL:
+		return nil
+	case KEEP_VERSIONS:
+		if o.Versions == 0 {
+			return errors.ErrInvalidRetention
+		}
+	case KEEP_DURATION:
+		if o.Duration <= 0 {
+			return errors.ErrInvalidRetention
+		}
+	default:
+		return errors.ErrInvalidRetention
+	}
+	return nil
+}
+
+// Keep returns true if an object version should be retained. The position is the index
+// of the version in the object's history where 0 is the latest version, and created
+// is the timestamp the version was created. A nil retention policy keeps everything.
+func (o *Retention) Keep(position int, created, now time.Time) bool {
+	if o == nil || position == 0 {
+		return true
+	}
+
+	switch o.Policy {
+	case KEEP_VERSIONS:
+		return uint64(position) < o.Versions
+	case KEEP_DURATION:
+		return now.Sub(created) < o.Duration
+	default:
+		return true
+	}
+}
+
+// The static size of a zero valued Retention object; see TestRetentionSize for details.
+const retentionStaticSize = 21
+
+func (o *Retention) Size() int {
+	return retentionStaticSize
+}
+
+func (o *Retention) Encode(e *lani.Encoder) (n int, err error) {
+	var m int
+	if m, err = e.EncodeUint8(uint8(o.Policy)); err != nil {
+		return n + m, err
+	}
+	n += m
+
+	if m, err = e.EncodeUint64(o.Versions); err != nil {
+		return n + m, err
+	}
+	n += m
+
+	if m, err = e.EncodeInt64(int64(o.Duration)); err != nil {
+		return n + m, err
+	}
+	n += m
+
+	return
+}
+
+func (o *Retention) Decode(d *lani.Decoder) (err error) {
+	var p uint8
+	if p, err = d.DecodeUint8(); err != nil {
+		return err
+	}
+	o.Policy = RetentionPolicy(p)
+
+	if o.Versions, err = d.DecodeUint64(); err != nil {
+		return err
+	}
+
+	var duration int64
+	if duration, err = d.DecodeInt64(); err != nil {
+		return err
+	}
+	o.Duration = time.Duration(duration)
+
+	return nil
+}
+
+func ParseRetentionPolicy(s string) (RetentionPolicy, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	for i, name := range retentionPolicyNames {
+		if s == name {
+			return RetentionPolicy(i), nil
+		}
+	}
+	return RetentionPolicy(0), fmt.Errorf("unknown retention policy: %q", s)
+}
+
+func (p RetentionPolicy) String() string {
+	if int(p) < len(retentionPolicyNames) {
+		return retentionPolicyNames[p]
+	}
+	return "UNKNOWN"
+}
+
+func (p *RetentionPolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+func (p *RetentionPolicy) UnmarshalJSON(data []byte) (err error) {
+	var policy string
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return err
+	}
+	if *p, err = ParseRetentionPolicy(policy); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p RetentionPolicy) Value() uint8 {
+	return uint8(p)
+}
@@ -0,0 +1,108 @@
+package metadata_test
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+)
+
+func TestRetention(t *testing.T) {
+	var staticSize int
+	staticSize += 1                     // Policy (uint8)
+	staticSize += binary.MaxVarintLen64 // Versions (uint64)
+	staticSize += binary.MaxVarintLen64 // Duration (int64)
+
+	// Create a test generic case and execute the tests
+	testCase := &TestCase{
+		Name:        "Retention",
+		Fixture:     "retention.json",
+		StaticSize:  staticSize,
+		FixtureSize: 21,
+		New:         func() TestObject { return &metadata.Retention{} },
+	}
+
+	t.Run("StaticSize", testCase.TestStaticSize)
+	t.Run("VariableSize", testCase.TestVariableSize)
+	t.Run("Serialization", testCase.TestSerialization)
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	testCase := &TestEnumCase{
+		Name: "RetentionPolicy",
+		Values: []TestEnum{
+			metadata.KEEP_ALL,
+			metadata.KEEP_VERSIONS,
+			metadata.KEEP_DURATION,
+		},
+		Strings: []string{
+			"KEEP_ALL",
+			"KEEP_VERSIONS",
+			"KEEP_DURATION",
+		},
+		Unknowns: "UNKNOWN",
+		ICase:    true,
+		ISpace:   true,
+		Parse:    func(s string) (TestEnum, error) { return metadata.ParseRetentionPolicy(s) },
+		New:      func(i uint8) Serializable { val := metadata.RetentionPolicy(i); return &val },
+	}
+
+	t.Run("String", testCase.TestString)
+	t.Run("StringBounds", testCase.TestStringBounds)
+	t.Run("Parse", testCase.TestParse)
+	t.Run("JSON", testCase.TestJSON)
+}
+
+func TestRetentionKeep(t *testing.T) {
+	now := time.Now()
+
+	t.Run("Nil", func(t *testing.T) {
+		var policy *metadata.Retention
+		require.True(t, policy.Keep(42, now.Add(-24*time.Hour), now))
+	})
+
+	t.Run("KeepAll", func(t *testing.T) {
+		policy := &metadata.Retention{Policy: metadata.KEEP_ALL}
+		require.True(t, policy.Keep(42, now.Add(-24*time.Hour), now))
+	})
+
+	t.Run("KeepVersions", func(t *testing.T) {
+		policy := &metadata.Retention{Policy: metadata.KEEP_VERSIONS, Versions: 3}
+		require.True(t, policy.Keep(0, now, now))
+		require.True(t, policy.Keep(2, now, now))
+		require.False(t, policy.Keep(3, now, now))
+	})
+
+	t.Run("KeepDuration", func(t *testing.T) {
+		policy := &metadata.Retention{Policy: metadata.KEEP_DURATION, Duration: time.Hour}
+		require.True(t, policy.Keep(0, now.Add(-2*time.Hour), now), "latest version must always be kept")
+		require.True(t, policy.Keep(1, now.Add(-time.Minute), now))
+		require.False(t, policy.Keep(1, now.Add(-2*time.Hour), now))
+	})
+}
+
+func TestRetentionValidate(t *testing.T) {
+	valid := []*metadata.Retention{
+		{Policy: metadata.KEEP_ALL},
+		{Policy: metadata.KEEP_VERSIONS, Versions: 1},
+		{Policy: metadata.KEEP_DURATION, Duration: time.Minute},
+	}
+
+	for i, policy := range valid {
+		require.NoError(t, policy.Validate(), "expected valid policy at index %d", i)
+	}
+
+	invalid := []*metadata.Retention{
+		{Policy: metadata.KEEP_VERSIONS},
+		{Policy: metadata.KEEP_DURATION},
+		{Policy: metadata.KEEP_DURATION, Duration: -time.Minute},
+		{Policy: metadata.RetentionPolicy(42)},
+	}
+
+	for i, policy := range invalid {
+		require.ErrorIs(t, policy.Validate(), errors.ErrInvalidRetention, "expected invalid policy at index %d", i)
+	}
+}
@@ -13,11 +13,17 @@ import (
 //===========================================================================
 
 type Version struct {
-	Scalar    lamport.Scalar  `json:"scalar" msg:"scalar"`
-	Region    region.Region   `json:"region" msg:"region"`
-	Parent    *lamport.Scalar `json:"parent,omitempty" msg:"parent,omitempty"`
-	Tombstone bool            `json:"tombstone,omitempty" msg:"tombstone,omitempty"`
-	Created   time.Time       `json:"created" msg:"created"`
+	Scalar  lamport.Scalar  `json:"scalar" msg:"scalar"`
+	Region  region.Region   `json:"region" msg:"region"`
+	Parent  *lamport.Scalar `json:"parent,omitempty" msg:"parent,omitempty"`
+	Kind    Kind            `json:"kind,omitempty" msg:"kind,omitempty"`
+	Created time.Time       `json:"created" msg:"created"`
+}
+
+// Returns true if the version is a tombstone or truncated version, e.g. the version
+// marks the record as removed rather than containing data.
+func (o *Version) IsTombstone() bool {
+	return o != nil && !o.Kind.Live()
 }
 
 var _ lani.Encodable = (*Version)(nil)
@@ -56,7 +62,7 @@ func (o *Version) Encode(e *lani.Encoder) (n int, err error) {
 	}
 	n += m
 
-	if m, err = e.EncodeBool(o.Tombstone); err != nil {
+	if m, err = e.EncodeUint8(uint8(o.Kind)); err != nil {
 		return n + m, err
 	}
 	n += m
@@ -91,9 +97,11 @@ func (o *Version) Decode(d *lani.Decoder) (err error) {
 		o.Parent = nil
 	}
 
-	if o.Tombstone, err = d.DecodeBool(); err != nil {
+	var kind uint8
+	if kind, err = d.DecodeUint8(); err != nil {
 		return err
 	}
+	o.Kind = Kind(kind)
 
 	if o.Created, err = d.DecodeTime(); err != nil {
 		return err
@@ -10,7 +10,7 @@ import (
 func TestVersion(t *testing.T) {
 	var staticSize int
 	staticSize += 1                     // Parent not nil bool
-	staticSize += 1                     // Tombstone bool
+	staticSize += 1                     // Kind uint8
 	staticSize += binary.MaxVarintLen64 // Timestamp int64
 
 	// Must also add the scalar size here because it is not nilable
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+)
+
+//===========================================================================
+// Key Migrations
+//===========================================================================
+
+// Migrate rewrites all of the keys stored with an older key layout into the current key
+// layout (e.g. v1 keys sorted oldest version first are rewritten as v2 keys that are
+// sorted newest version first). Only the buckets of the collections listed in the
+// system collections bucket and the system collections bucket itself hold object keys;
+// the keys of other system buckets (e.g. replicas and job cursors) are never migrated
+// even if they look like outdated keys. The migration is only performed once: outdated
+// keys in the system collections bucket mark that a migration is required, and the
+// system collections bucket is always migrated last. If the migration is interrupted it
+// will resume the next time the store is opened.
+func (s *Store) migrate() (err error) {
+	if s.conf.ReadOnly {
+		// If the store is read-only, do not attempt to migrate it.
+		return nil
+	}
+
+	var required bool
+	if required, err = s.migrationRequired(); err != nil || !required {
+		return err
+	}
+
+	// Collect the IDs of the collections so that each collection bucket can be migrated
+	// in its own transaction to limit the size of the write transaction.
+	var buckets [][]byte
+	if err = s.db.View(func(tx engine.Tx) error {
+		cursor := tx.Bucket(SystemCollections[:]).Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			// Skip nested buckets such as the collection names index.
+			if value == nil || keys.Key(key).Check() != nil {
+				continue
+			}
+
+			// Keys are sorted by collection ID so each collection is only added once.
+			collectionID := keys.Key(key).ObjectID()
+			if bytes.HasPrefix(collectionID[:], SystemPrefix[:]) || tx.Bucket(collectionID[:]) == nil {
+				continue
+			}
+
+			if n := len(buckets); n == 0 || !bytes.Equal(buckets[n-1], collectionID[:]) {
+				buckets = append(buckets, bytes.Clone(collectionID[:]))
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("could not list buckets for migration: %w", err)
+	}
+
+	// The system collections bucket is migrated last as it marks the migration.
+	buckets = append(buckets, SystemCollections[:])
+
+	for _, name := range buckets {
+		var nkeys int
+		if err = s.db.Update(func(tx engine.Tx) (err error) {
+			nkeys, err = migrateBucket(tx.Bucket(name))
+			return err
+		}); err != nil {
+			return fmt.Errorf("could not migrate bucket %x: %w", name, err)
+		}
+
+		if nkeys > 0 {
+			log.Info().Hex("bucket", name).Int("keys", nkeys).Msg("migrated keys to current key version")
+		}
+	}
+
+	return nil
+}
+
+// A migration is required if the system collections bucket contains outdated keys.
+// Because v1 keys sort before the v2 keys, only the first object key is checked.
+func (s *Store) migrationRequired() (required bool, err error) {
+	err = s.db.View(func(tx engine.Tx) error {
+		var collections engine.Bucket
+		if collections = tx.Bucket(SystemCollections[:]); collections == nil {
+			// The database is empty and will be created by initialize.
+			return nil
+		}
+
+		cursor := collections.Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			// Skip nested buckets such as the collection names index.
+			if value == nil {
+				continue
+			}
+
+			required = keys.Key(key).Outdated()
+			return nil
+		}
+		return nil
+	})
+	return required, err
+}
+
+// Rewrites all outdated keys in the bucket (but not in nested buckets such as indexes)
+// and returns the number of keys that were migrated.
+func migrateBucket(bkt engine.Bucket) (_ int, err error) {
+	if bkt == nil {
+		return 0, nil
+	}
+
+	// Collect the outdated keys first since modifying a bucket while iterating with a
+	// cursor will cause keys to be skipped.
+	var outdated [][]byte
+	cursor := bkt.Cursor()
+	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		// Nested buckets have nil values and are not migrated.
+		if value != nil && keys.Key(key).Outdated() {
+			outdated = append(outdated, bytes.Clone(key))
+		}
+	}
+
+	for _, key := range outdated {
+		value := bytes.Clone(bkt.Get(key))
+		if err = bkt.Delete(key); err != nil {
+			return 0, err
+		}
+
+		if err = bkt.Put(keys.Key(key).Upgrade(), value); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(outdated), nil
+}
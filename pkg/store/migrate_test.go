@@ -0,0 +1,135 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.rtnl.ai/honu/pkg/config"
+	"go.rtnl.ai/honu/pkg/region"
+	"go.rtnl.ai/honu/pkg/store"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/engine/bolt"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/lamport"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+)
+
+func TestMigrateV1Keys(t *testing.T) {
+	lamport.SetProcessID(8)
+	region.SetProcessRegion(region.TESTING)
+
+	conf := config.Config{
+		PID: uint32(8),
+		Store: config.StoreConfig{
+			DataPath:    filepath.Join(t.TempDir(), "honu-test.db"),
+			Concurrency: 16,
+		},
+	}
+
+	// Create a store with a collection and several object versions.
+	db, err := store.Open(conf)
+	require.NoError(t, err, "could not open store")
+
+	info := &metadata.Collection{Name: "migrations"}
+	require.NoError(t, db.New(context.Background(), info), "could not create collection")
+
+	tx, err := db.Begin(context.Background(), nil)
+	require.NoError(t, err, "could not begin transaction")
+
+	c, err := tx.Collection(info.ID)
+	require.NoError(t, err, "could not open collection")
+
+	meta := &metadata.Metadata{}
+	require.NoError(t, c.Create(meta, []byte("v1"), nil))
+	require.NoError(t, c.Update(meta, []byte("v2"), nil))
+	require.NoError(t, c.Update(meta, []byte("v3"), nil))
+	require.NoError(t, tx.Commit())
+	require.NoError(t, db.Close())
+
+	// Rewrite all of the keys in the database as v1 keys to simulate an old database.
+	// System buckets other than the collections bucket do not hold object keys, so add
+	// a key to the maintenance bucket that looks like a v1 key but must not be migrated.
+	cursor := append([]byte{0x1}, bytes.Repeat([]byte("cursor"), 5)[:28]...)
+	bdb, err := bolt.Open(conf.Store.DataPath, nil)
+	require.NoError(t, err, "could not open bbolt for testing")
+	require.NoError(t, bdb.Update(func(tx engine.Tx) error {
+		if err := tx.ForEach(func(_ []byte, b engine.Bucket) error {
+			return downgradeBucket(b)
+		}); err != nil {
+			return err
+		}
+		return tx.Bucket(store.SystemMaintenance[:]).Put(cursor, []byte("job"))
+	}))
+	require.Equal(t, 9, countKeys(t, bdb, 0x1), "expected v1 keys in the database")
+	require.NoError(t, bdb.Close())
+
+	// Opening the store should migrate all object keys to the current version.
+	db, err = store.Open(conf)
+	require.NoError(t, err, "could not reopen store")
+	require.Equal(t, 1, countKeys(t, db.Engine(), 0x1), "expected only the system key to remain a v1 key")
+
+	require.NoError(t, db.Engine().View(func(tx engine.Tx) error {
+		require.Equal(t, []byte("job"), tx.Bucket(store.SystemMaintenance[:]).Get(cursor), "expected system key to be unchanged")
+		return nil
+	}))
+
+	tx, err = db.Begin(context.Background(), &store.TxOptions{ReadOnly: true})
+	require.NoError(t, err, "could not begin transaction")
+	defer tx.Rollback()
+
+	c, err = tx.Collection("migrations")
+	require.NoError(t, err, "could not open collection after migration")
+	require.True(t, c.Exists(meta.ObjectID))
+
+	// The latest version should be the first version returned by the iterator.
+	iter := c.List()
+	defer iter.Release()
+	require.True(t, iter.Next())
+	require.Equal(t, meta.Version.Scalar, iter.Key().Version())
+}
+
+func downgradeBucket(b engine.Bucket) error {
+	var upgraded [][]byte
+	b.ForEach(func(k, v []byte) error {
+		if v != nil && len(k) == 29 && k[0] == 0x2 {
+			upgraded = append(upgraded, bytes.Clone(k))
+		}
+		return nil
+	})
+
+	for _, k := range upgraded {
+		vers := keys.Key(k).Version()
+		v1 := make([]byte, 29)
+		v1[0] = 0x1
+		copy(v1[1:17], k[1:17])
+		binary.BigEndian.PutUint64(v1[17:25], vers.VID)
+		binary.BigEndian.PutUint32(v1[25:29], vers.PID)
+
+		value := bytes.Clone(b.Get(k))
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+		if err := b.Put(v1, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func countKeys(t *testing.T, db engine.Engine, version byte) (n int) {
+	require.NoError(t, db.View(func(tx engine.Tx) error {
+		return tx.ForEach(func(_ []byte, b engine.Bucket) error {
+			return b.ForEach(func(k, v []byte) error {
+				if v != nil && len(k) == 29 && k[0] == version {
+					n++
+				}
+				return nil
+			})
+		})
+	}))
+	return n
+}
@@ -98,17 +98,31 @@ func (o Object) Data() ([]byte, error) {
 	}
 }
 
-// If true the object is a tombstone meaning that it only contains metadata and has no
-// associated data. Tombstones are used to indicate that a key has been deleted.
-func (o Object) Tombstone() bool {
+// Kind returns the record kind of the object (e.g. live, tombstone, or truncated) from
+// its metadata. Only the metadata fields preceding the kind are decoded, so this is
+// cheaper than decoding the full metadata when only the kind is required.
+func (o Object) Kind() (metadata.Kind, error) {
 	if o.StorageVersion() != StorageVersion {
-		return false
+		return metadata.LIVE, ErrBadVersion
+	}
+
+	d, b := o.dataLength()
+	if d < 0 || 1+d+b > len(o) {
+		return metadata.LIVE, ErrMalformed
 	}
 
-	// TODO: rather than checking the data length, check a specific flag in the metadata.
-	// otherwise any empty objects users store will be considered tombstones.
-	d, _ := o.dataLength()
-	return d == 0
+	return metadata.DecodeKind(lani.NewDecoder(o[1+d+b:]))
+}
+
+// If true the object is a tombstone meaning that it marks the object as deleted (or
+// truncated) rather than containing data. Tombstones are identified by the record kind
+// in the metadata, so objects with empty data are not considered tombstones.
+func (o Object) Tombstone() bool {
+	kind, err := o.Kind()
+	if err != nil {
+		return false
+	}
+	return !kind.Live()
 }
 
 func (o Object) dataLength() (int, int) {
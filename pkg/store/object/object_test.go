@@ -26,7 +26,7 @@ func TestObject(t *testing.T) {
 	obj, err := object.Marshal(meta, data)
 	require.NoError(t, err, "could not marshal object")
 
-	require.Len(t, obj, 1264, "unexpected length of encoded object")
+	require.Len(t, obj, 1267, "unexpected length of encoded object")
 	require.Equal(t, object.StorageVersion, obj.StorageVersion())
 
 	ometa, err := obj.Metadata()
@@ -46,17 +46,56 @@ func TestObject(t *testing.T) {
 
 func TestTombstone(t *testing.T) {
 	meta, _ := loadObjectFixture(t)
+	meta.Version.Kind = metadata.TOMBSTONE
 
 	obj, err := object.Marshal(meta, nil)
 	require.NoError(t, err, "could not marshal object")
 
 	require.True(t, obj.Tombstone(), "object should be a tombstone")
 
+	kind, err := obj.Kind()
+	require.NoError(t, err, "could not read object kind")
+	require.Equal(t, metadata.TOMBSTONE, kind)
+
 	odata, err := obj.Data()
 	require.NoError(t, err, "could not decode data")
 	require.Nil(t, odata, "data not correctly serialized")
 }
 
+func TestKind(t *testing.T) {
+	for _, kind := range []metadata.Kind{metadata.LIVE, metadata.TOMBSTONE, metadata.TRUNCATED} {
+		meta, data := loadObjectFixture(t)
+		meta.Version.Kind = kind
+
+		obj, err := object.Marshal(meta, data)
+		require.NoError(t, err, "could not marshal object")
+
+		actual, err := obj.Kind()
+		require.NoError(t, err, "could not read object kind")
+		require.Equal(t, kind, actual)
+		require.Equal(t, kind != metadata.LIVE, obj.Tombstone())
+	}
+
+	t.Run("Empty", func(t *testing.T) {
+		// Objects with empty data are not tombstones.
+		meta, _ := loadObjectFixture(t)
+		obj, err := object.Marshal(meta, []byte{})
+		require.NoError(t, err, "could not marshal object")
+		require.False(t, obj.Tombstone(), "empty object should not be a tombstone")
+	})
+
+	t.Run("NoVersion", func(t *testing.T) {
+		meta, data := loadObjectFixture(t)
+		meta.Version = nil
+		obj, err := object.Marshal(meta, data)
+		require.NoError(t, err, "could not marshal object")
+
+		kind, err := obj.Kind()
+		require.NoError(t, err, "could not read object kind")
+		require.Equal(t, metadata.LIVE, kind)
+	})
+}
+
 func TestNil(t *testing.T) {
 	obj := object.Object(nil)
 	require.Equal(t, uint8(0), obj.StorageVersion())
@@ -220,10 +259,10 @@ func generateRandomObject(size Size) (*metadata.Metadata, []byte) {
 
 func randVersion() *metadata.Version {
 	vers := &metadata.Version{
-		Scalar:    lamport.Scalar{PID: mrand.Uint32(), VID: mrand.Uint64()},
-		Region:    randRegion(),
-		Tombstone: mrand.Float32() < 0.25,
-		Created:   randTime(),
+		Scalar:  lamport.Scalar{PID: mrand.Uint32(), VID: mrand.Uint64()},
+		Region:  randRegion(),
+		Kind:    metadata.Kind(mrand.Intn(3)),
+		Created: randTime(),
 	}
 
 	// 10% chance of nil parent
@@ -1,5 +1,7 @@
 package opts
 
+import "go.rtnl.ai/honu/pkg/store/lamport"
+
 type TxOptions struct {
 	// Begin a read-only transaction that cannot modify the database and can be
 	// executed concurrently with other read-only transactions.
@@ -22,6 +24,48 @@ type WriteOptions struct {
 	// Require the object to exist before performing an update; otherwise an error
 	// is returned (by default, an object that doesn't exist when updated is created).
 	CheckUpdate bool
+
+	// If set, the write is only applied if the latest version of the object matches
+	// the precondition; otherwise a version conflict error is returned. This allows
+	// concurrent writers to perform compare-and-swap updates of an object.
+	Expect *Precondition
+}
+
+// Precondition is an expectation of the latest version of an object at the time of a
+// write: either that the latest version is exactly the specified version (including
+// tombstone versions), or that the object does not exist (or has been deleted). Use
+// ExpectVersion or ExpectNotExists to create a precondition.
+type Precondition struct {
+	Version   lamport.Scalar
+	NotExists bool
+}
+
+// ExpectVersion creates a precondition that the latest version of the object is the
+// specified version.
+func ExpectVersion(version lamport.Scalar) *Precondition {
+	return &Precondition{Version: version}
+}
+
+// ExpectNotExists creates a precondition that the object has no versions or that the
+// latest version of the object is a tombstone.
+func ExpectNotExists() *Precondition {
+	return &Precondition{NotExists: true}
+}
+
+// Match returns true if the latest version of the object satisfies the precondition.
+// The latest version is nil if the object has no versions and deleted is true if the
+// latest version is a tombstone. A nil precondition always matches.
+func (p *Precondition) Match(latest *lamport.Scalar, deleted bool) bool {
+	switch {
+	case p == nil:
+		return true
+	case p.NotExists:
+		return latest == nil || deleted
+	case latest == nil:
+		return false
+	default:
+		return p.Version.Equals(latest)
+	}
 }
 
 //===========================================================================
@@ -70,3 +114,10 @@ func (wo *WriteOptions) GetCheckUpdate() bool {
 	}
 	return wo.CheckUpdate
 }
+
+func (wo *WriteOptions) GetExpect() *Precondition {
+	if wo == nil {
+		return nil
+	}
+	return wo.Expect
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/ulid"
+)
+
+// The default number of objects that are scanned for expiration in each transaction;
+// this bounds how long other writers are blocked by the background reaper.
+const DefaultReapBatchSize = 1000
+
+//===========================================================================
+// Expiry Reaper
+//===========================================================================
+
+// Reap adds a tombstone version to every object whose expiration has passed, returning
+// the total number of objects that were tombstoned. Expired objects are already hidden
+// from reads, reaping them ensures that the deletion is replicated and that the object
+// is removed from list queries. Each collection is reaped in bounded batches of
+// separate write transactions so that reaping does not block other writers for long.
+func (s *Store) Reap(ctx context.Context) (reaped int, err error) {
+	if s.conf.ReadOnly {
+		return 0, errors.ErrReadOnlyDB
+	}
+
+	var collections []*metadata.Collection
+	if collections, err = s.Collections(ctx); err != nil {
+		return 0, err
+	}
+
+	// Objects can specify an expiration without a collection TTL, so every collection
+	// must be scanned for expired objects.
+	now := time.Now()
+	for _, info := range collections {
+		var n int
+		n, err = s.reapCollection(ctx, info.ID, now)
+		reaped += n
+
+		if err != nil {
+			// The collection may have been dropped while reaping.
+			if errors.Is(err, errors.ErrNoCollection) {
+				continue
+			}
+			return reaped, fmt.Errorf("could not reap collection %s: %w", info, err)
+		}
+	}
+
+	return reaped, nil
+}
+
+// Reaps a single collection in batches, reloading the collection metadata in each batch
+// in case the collection was dropped.
+func (s *Store) reapCollection(ctx context.Context, collectionID ulid.ULID, now time.Time) (reaped int, err error) {
+	var last keys.Key
+	expired := func(meta *metadata.Metadata) bool {
+		return meta.Expired(now)
+	}
+
+	for {
+		if err = s.update(ctx, func(tx engine.Tx) (err error) {
+			var info *metadata.Collection
+			if info, err = latestCollection(tx.Bucket(SystemCollections[:]), collectionID); err != nil {
+				return err
+			}
+
+			c := &Collection{Collection: *info, ctx: ctx}
+			if c.bkt = tx.Bucket(collectionID[:]); c.bkt == nil {
+				return errors.ErrRepairCollection
+			}
+
+			var n int
+			last, n, err = c.tombstone(last, DefaultReapBatchSize, expired)
+			reaped += n
+			return err
+		}); err != nil {
+			return reaped, err
+		}
+		s.notify()
+
+		if last == nil {
+			return reaped, nil
+		}
+	}
+}
+
+// Runs a reaper pass from the background scheduler; errors are logged rather than
+// returned since there is no caller to handle them.
+func (s *Store) reaper(ctx context.Context) {
+	reaped, err := s.Reap(ctx)
+	if err != nil {
+		// The pass was aborted because the store is closing.
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+
+		log.Warn().Err(err).Int("reaped", reaped).Msg("background reaper failed")
+		return
+	}
+
+	if reaped > 0 {
+		log.Info().Int("reaped", reaped).Msg("tombstoned expired objects")
+	}
+}
@@ -0,0 +1,169 @@
+package store_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.rtnl.ai/honu/pkg/config"
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/region"
+	"go.rtnl.ai/honu/pkg/store"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/lamport"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/opts"
+)
+
+func (s *honuTestSuite) TestCollectionTTL() {
+	require := s.Require()
+	info := s.createCollection()
+	info.TTL = time.Hour
+	require.NoError(s.store.Modify(context.Background(), info), "could not set collection ttl")
+
+	tx, c := s.openCollection(info.ID, false)
+	defer tx.Rollback()
+
+	// Objects default to the collection TTL.
+	meta := &metadata.Metadata{}
+	require.NoError(c.Create(meta, []byte("foo"), nil))
+	require.WithinDuration(meta.Modified.Add(time.Hour), meta.Expires, time.Second)
+
+	// An explicit expiration overrides the collection TTL.
+	explicit := &metadata.Metadata{Expires: time.Now().Add(time.Minute)}
+	require.NoError(c.Create(explicit, []byte("foo"), nil))
+	require.WithinDuration(time.Now().Add(time.Minute), explicit.Expires, time.Second)
+
+	// Tombstones do not expire.
+	require.NoError(c.Delete(meta.Key(), nil))
+	obj, err := c.Retrieve(keys.New(meta.ObjectID, nil), &opts.ReadOptions{Tombstones: true})
+	require.NoError(err)
+	tomb, err := obj.Metadata()
+	require.NoError(err)
+	require.True(tomb.IsTombstone())
+	require.True(tomb.Expires.IsZero())
+
+	// Negative TTLs are not allowed.
+	info.TTL = -1 * time.Second
+	require.ErrorIs(s.store.Modify(context.Background(), info), errors.ErrInvalidTTL)
+}
+
+func (s *honuTestSuite) TestRetrieveExpired() {
+	require := s.Require()
+	info := s.createCollection()
+
+	tx, c := s.openCollection(info.ID, false)
+	defer tx.Rollback()
+
+	meta := &metadata.Metadata{Expires: time.Now().Add(-time.Second)}
+	require.NoError(c.Create(meta, []byte("foo"), nil))
+
+	// Expired objects that have not been reaped are treated as not found.
+	_, err := c.Retrieve(keys.New(meta.ObjectID, nil), nil)
+	require.ErrorIs(err, errors.ErrNotFound)
+	require.False(c.Exists(meta.ObjectID))
+	require.True(c.Has(meta.ObjectID))
+
+	// But can still be fetched with tombstones or by version.
+	_, err = c.Retrieve(keys.New(meta.ObjectID, nil), &opts.ReadOptions{Tombstones: true})
+	require.NoError(err)
+
+	_, err = c.Retrieve(keys.New(meta.ObjectID, &meta.Version.Scalar), nil)
+	require.NoError(err)
+}
+
+func (s *honuTestSuite) TestReap() {
+	require := s.Require()
+	info := s.createCollection()
+	other := s.createCollection()
+
+	tx, c := s.openCollection(info.ID, false)
+	expired := &metadata.Metadata{Expires: time.Now().Add(-time.Second)}
+	require.NoError(c.Create(expired, []byte("foo"), nil))
+
+	live := &metadata.Metadata{Expires: time.Now().Add(time.Hour)}
+	require.NoError(c.Create(live, []byte("foo"), nil))
+
+	forever := &metadata.Metadata{}
+	require.NoError(c.Create(forever, []byte("foo"), nil))
+	require.NoError(tx.Commit())
+
+	tx, c = s.openCollection(other.ID, false)
+	otherExpired := &metadata.Metadata{Expires: time.Now().Add(-time.Minute)}
+	require.NoError(c.Create(otherExpired, []byte("foo"), nil))
+	require.NoError(tx.Commit())
+
+	reaped, err := s.store.Reap(context.Background())
+	require.NoError(err, "could not reap store")
+	require.Equal(2, reaped)
+
+	tx, c = s.openCollection(info.ID, true)
+	require.Equal(2, s.countVersions(c, expired.ObjectID), "expected a tombstone version to be added")
+	require.True(c.Has(expired.ObjectID))
+	require.False(c.Exists(expired.ObjectID))
+	require.True(c.Exists(live.ObjectID))
+	require.True(c.Exists(forever.ObjectID))
+	require.NoError(tx.Rollback())
+
+	tx, c = s.openCollection(other.ID, true)
+	require.False(c.Exists(otherExpired.ObjectID))
+
+	// Read transactions must be closed before writing in case bolt needs to remap.
+	require.NoError(tx.Rollback())
+
+	// Reaping again should not add more tombstones.
+	reaped, err = s.store.Reap(context.Background())
+	require.NoError(err, "could not reap store")
+	require.Equal(0, reaped)
+}
+
+func TestBackgroundReaper(t *testing.T) {
+	lamport.SetProcessID(8)
+	region.SetProcessRegion(region.TESTING)
+
+	conf := config.Config{
+		PID: uint32(8),
+		Store: config.StoreConfig{
+			DataPath:     filepath.Join(t.TempDir(), "honu-test.db"),
+			Concurrency:  16,
+			ReapInterval: 10 * time.Millisecond,
+		},
+	}
+
+	db, err := store.Open(conf)
+	require.NoError(t, err, "could not open store")
+	defer db.Close()
+
+	info := &metadata.Collection{Name: "ephemeral", TTL: 20 * time.Millisecond}
+	require.NoError(t, db.New(context.Background(), info), "could not create collection")
+
+	tx, err := db.Begin(context.Background(), nil)
+	require.NoError(t, err)
+	c, err := tx.Collection(info.ID)
+	require.NoError(t, err)
+
+	meta := &metadata.Metadata{}
+	require.NoError(t, c.Create(meta, []byte("foo"), nil))
+	require.NoError(t, tx.Commit())
+
+	require.Eventually(t, func() bool {
+		tx, err := db.Begin(context.Background(), &store.TxOptions{ReadOnly: true})
+		if err != nil {
+			return false
+		}
+		defer tx.Rollback()
+
+		c, err := tx.Collection(info.ID)
+		if err != nil {
+			return false
+		}
+
+		obj, err := c.Retrieve(keys.New(meta.ObjectID, nil), &opts.ReadOptions{Tombstones: true})
+		if err != nil {
+			return false
+		}
+		return obj.Tombstone()
+	}, time.Second, 10*time.Millisecond, "expected background reaper to tombstone expired object")
+}
@@ -0,0 +1,446 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/region"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/lamport"
+	"go.rtnl.ai/honu/pkg/store/lani"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/object"
+	"go.rtnl.ai/ulid"
+)
+
+// Replica records the replication progress of a peer. The horizon holds the change log
+// sequence of each collection that the peer has acknowledged receiving all versions at
+// or before, along with the sequence of the collection metadata changes under the
+// SystemCollections ID (see Store.Changes). Tombstones whose change log entries are at
+// or before the horizon of every known peer have been replicated everywhere and can be
+// garbage collected.
+//
+// Lamport versions cannot be used for the horizon since the VIDs of the versions of
+// different objects are not ordered with respect to each other (see ChangeVector).
+type Replica struct {
+	PID      uint32        // The process ID of the peer replica
+	Region   region.Region // The region the peer replica is running in
+	Horizon  ChangeVector  // All changes at or before the horizon are acknowledged
+	Modified time.Time     // The last time the peer acknowledged versions
+}
+
+var _ lani.Encodable = (*Replica)(nil)
+var _ lani.Decodable = (*Replica)(nil)
+
+// Replica records are stored as versioned objects in the replicas system collection
+// under an object ID derived from the PID of the peer. The IDs are prefixed like the
+// system IDs so that they can never collide with a generated ULID.
+var replicaPrefix = [12]byte{0x00, 0x68, 0x6f, 0x6e, 0x75, 0x01, 0x72, 0x65, 0x70, 0x6c, 0x69, 0x63}
+
+// Replicas returns all of the peers whose replication progress is tracked by the store.
+func (s *Store) Replicas(ctx context.Context) (replicas []*Replica, err error) {
+	err = s.view(ctx, func(tx engine.Tx) error {
+		bkt := tx.Bucket(SystemReplicas[:])
+		if bkt == nil {
+			return errors.ErrNotInitialized
+		}
+
+		return forEachReplica(bkt, func(_ *metadata.Metadata, replica *Replica) error {
+			replicas = append(replicas, replica)
+			return nil
+		})
+	})
+	return replicas, err
+}
+
+// Acknowledge records that the peer replica has received all of the changes at or
+// before the sequence of each collection in the horizon; collections that are not in
+// the horizon keep the sequence that was previously acknowledged. The peer is added to
+// the tracked replicas if it is not already known; the horizon of a peer never moves
+// backwards so acknowledgements that arrive out of order do not cause tombstones to be
+// retained longer than needed.
+func (s *Store) Acknowledge(ctx context.Context, pid uint32, peerRegion region.Region, horizon ChangeVector) (err error) {
+	if s.conf.ReadOnly {
+		return errors.ErrReadOnlyDB
+	}
+
+	return s.update(ctx, func(tx engine.Tx) (err error) {
+		bkt := tx.Bucket(SystemReplicas[:])
+		if bkt == nil {
+			return errors.ErrNotInitialized
+		}
+
+		var (
+			prev    *metadata.Metadata
+			replica *Replica
+		)
+
+		objectID := replicaID(pid)
+		if prev, replica, err = latestReplica(bkt, objectID); err != nil {
+			return err
+		}
+
+		if replica == nil {
+			replica = &Replica{PID: pid}
+		}
+
+		if replica.Horizon == nil {
+			replica.Horizon = make(ChangeVector, len(horizon))
+		}
+
+		for collectionID, sequence := range horizon {
+			if sequence > replica.Horizon[collectionID] {
+				replica.Horizon[collectionID] = sequence
+			}
+		}
+		replica.Region = peerRegion
+		replica.Modified = time.Now()
+
+		// Write the acknowledgement as a new version of the replica record.
+		meta := &metadata.Metadata{
+			ObjectID:     objectID,
+			CollectionID: SystemReplicas,
+			Version: &metadata.Version{
+				Scalar:  lamport.Next(nil),
+				Region:  region.ProcessRegion(),
+				Kind:    metadata.LIVE,
+				Created: replica.Modified,
+			},
+			Owner:    SystemHonuAgent,
+			Group:    SystemHonuAgent,
+			Created:  replica.Modified,
+			Modified: replica.Modified,
+		}
+
+		if prev != nil {
+			meta.Version.Scalar = lamport.Next(&prev.Version.Scalar)
+			meta.Version.Parent = &prev.Version.Scalar
+			meta.Created = prev.Created
+		}
+
+		var data []byte
+		if data, err = replica.MarshalBinary(); err != nil {
+			return fmt.Errorf("could not marshal replica %d: %w", pid, err)
+		}
+
+		var obj object.Object
+		if obj, err = object.Marshal(meta, data); err != nil {
+			return fmt.Errorf("could not marshal replica %d: %w", pid, err)
+		}
+
+		// Only the latest acknowledgement of the peer is kept.
+		if err = purgeReplica(bkt, objectID); err != nil {
+			return err
+		}
+		return bkt.Put(keys.New(objectID, &meta.Version.Scalar), obj)
+	})
+}
+
+// Changes returns the change vector of the store: the sequence of the latest change in
+// the change log of every collection along with the sequence of the latest change to
+// the collection metadata under the SystemCollections ID. A peer that has received all
+// of the changes in the vector acknowledges it with Acknowledge.
+func (s *Store) Changes(ctx context.Context) (vector ChangeVector, err error) {
+	err = s.view(ctx, func(tx engine.Tx) error {
+		collections := tx.Bucket(SystemCollections[:])
+		if collections == nil {
+			return errors.ErrNotInitialized
+		}
+
+		vector = ChangeVector{SystemCollections: collections.Sequence()}
+		return tx.ForEach(func(name []byte, bkt engine.Bucket) error {
+			if len(name) != 16 || bytes.HasPrefix(name, SystemPrefix[:]) {
+				return nil
+			}
+
+			if log := bkt.Bucket(changesBucket); log != nil {
+				vector[ulid.ULID(name)] = log.Sequence()
+			}
+			return nil
+		})
+	})
+	return vector, err
+}
+
+// RemoveReplica stops tracking the replication progress of the peer, e.g. when the
+// peer is permanently removed from the cluster. If the peer is not tracked, no error
+// is returned.
+func (s *Store) RemoveReplica(ctx context.Context, pid uint32) (err error) {
+	if s.conf.ReadOnly {
+		return errors.ErrReadOnlyDB
+	}
+
+	return s.update(ctx, func(tx engine.Tx) error {
+		bkt := tx.Bucket(SystemReplicas[:])
+		if bkt == nil {
+			return errors.ErrNotInitialized
+		}
+		return purgeReplica(bkt, replicaID(pid))
+	})
+}
+
+//===========================================================================
+// Replication Horizon
+//===========================================================================
+
+// The replication horizon is the point at or before which every version has been
+// replicated to all peers. If peers are tracked, the horizon holds the lowest change
+// log sequence of each collection acknowledged by any peer. Otherwise, the configured
+// tombstone grace period stands in for peer acknowledgements and versions created
+// before the grace period are treated as replicated. A zero horizon means nothing has
+// been replicated.
+type horizon struct {
+	vector  ChangeVector
+	created time.Time
+}
+
+// Returns the replication horizon of the store from the tracked peers.
+func replicationHorizon(tx engine.Tx, gracePeriod time.Duration, now time.Time) (h horizon, err error) {
+	bkt := tx.Bucket(SystemReplicas[:])
+	if bkt == nil {
+		return horizon{}, errors.ErrNotInitialized
+	}
+
+	if err = forEachReplica(bkt, func(_ *metadata.Metadata, replica *Replica) error {
+		// A collection that the peer has not acknowledged has a sequence of zero, so
+		// only the collections acknowledged by the first peer can be replicated.
+		if h.vector == nil {
+			h.vector = make(ChangeVector, len(replica.Horizon))
+			for collectionID, sequence := range replica.Horizon {
+				h.vector[collectionID] = sequence
+			}
+			return nil
+		}
+
+		for collectionID, sequence := range h.vector {
+			h.vector[collectionID] = min(sequence, replica.Horizon[collectionID])
+		}
+		return nil
+	}); err != nil {
+		return horizon{}, err
+	}
+
+	if h.vector == nil && gracePeriod > 0 {
+		h.created = now.Add(-gracePeriod)
+	}
+	return h, nil
+}
+
+// Returns true if no version can have been replicated to all peers.
+func (h horizon) IsZero() bool {
+	return h.vector == nil && h.created.IsZero()
+}
+
+// Returns true if the version has been replicated to all peers: the sequence of its
+// change in the collection (or zero if the change is not known) is at or before the
+// sequence acknowledged by every peer, or, if no peers are tracked, it was created
+// before the grace period.
+func (h horizon) replicated(collectionID ulid.ULID, sequence uint64, vers *metadata.Version) bool {
+	switch {
+	case vers == nil:
+		return false
+	case h.vector != nil:
+		return sequence > 0 && sequence <= h.vector[collectionID]
+	case !h.created.IsZero():
+		return !vers.Created.After(h.created)
+	default:
+		return false
+	}
+}
+
+// Returns true if the version of the object with the specified key has been replicated
+// to all peers according to the sequence of its entry in the change log.
+func (c *Collection) replicated(h horizon, key []byte, vers *metadata.Version) bool {
+	return h.replicated(c.ID, c.sequence(key), vers)
+}
+
+//===========================================================================
+// Replica Records
+//===========================================================================
+
+// Returns the object ID of the replica record of the peer with the specified PID.
+func replicaID(pid uint32) (id ulid.ULID) {
+	copy(id[:], replicaPrefix[:])
+	binary.BigEndian.PutUint32(id[len(replicaPrefix):], pid)
+	return id
+}
+
+// Calls fn with the latest version of each replica record in the bucket.
+func forEachReplica(bkt engine.Bucket, fn func(*metadata.Metadata, *Replica) error) (err error) {
+	cursor := bkt.Cursor()
+	for key, value := cursor.First(); key != nil; {
+		// Skip nested buckets and any keys that are not object keys.
+		if value == nil || keys.Key(key).Check() != nil {
+			key, value = cursor.Next()
+			continue
+		}
+
+		var (
+			meta    *metadata.Metadata
+			replica *Replica
+		)
+
+		if meta, replica, err = decodeReplica(key, value); err != nil {
+			return err
+		}
+
+		if err = fn(meta, replica); err != nil {
+			return err
+		}
+
+		// Versions are sorted latest first so skip the older versions of the record.
+		key, value = cursor.Seek(keys.Key(key).ObjectLimit())
+	}
+	return nil
+}
+
+// Returns the latest version of the replica record, or nil if the peer is not tracked.
+func latestReplica(bkt engine.Bucket, objectID ulid.ULID) (*metadata.Metadata, *Replica, error) {
+	prefix := keys.New(objectID, nil).ObjectPrefix()
+	key, value := bkt.Cursor().Seek(prefix)
+	if key == nil || !bytes.HasPrefix(key, prefix) {
+		return nil, nil, nil
+	}
+	return decodeReplica(key, value)
+}
+
+// Deletes all versions of the replica record.
+func purgeReplica(bkt engine.Bucket, objectID ulid.ULID) (err error) {
+	var versions [][]byte
+	prefix := keys.New(objectID, nil).ObjectPrefix()
+	cursor := bkt.Cursor()
+	for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+		versions = append(versions, bytes.Clone(key))
+	}
+
+	for _, key := range versions {
+		if err = bkt.Delete(key); err != nil {
+			return fmt.Errorf("could not delete replica record: %w", err)
+		}
+	}
+	return nil
+}
+
+func decodeReplica(key, value []byte) (meta *metadata.Metadata, replica *Replica, err error) {
+	obj := object.Object(value)
+	if meta, err = obj.Metadata(); err != nil || meta.Version == nil {
+		return nil, nil, fmt.Errorf("could not decode replica %x: %w", key, errors.ErrRepairCollection)
+	}
+
+	var data []byte
+	if data, err = obj.Data(); err != nil {
+		return nil, nil, fmt.Errorf("could not decode replica %x: %w", key, errors.ErrRepairCollection)
+	}
+
+	replica = &Replica{}
+	if err = replica.UnmarshalBinary(data); err != nil {
+		return nil, nil, fmt.Errorf("could not decode replica %x: %w", key, errors.ErrRepairCollection)
+	}
+	return meta, replica, nil
+}
+
+//===========================================================================
+// Serialization
+//===========================================================================
+
+func (r *Replica) Size() int {
+	return 3*binary.MaxVarintLen32 + len(r.Horizon)*(16+binary.MaxVarintLen64) + binary.MaxVarintLen64
+}
+
+func (r *Replica) Encode(e *lani.Encoder) (n int, err error) {
+	var m int
+	if m, err = e.EncodeUint32(r.PID); err != nil {
+		return n + m, err
+	}
+	n += m
+
+	if m, err = e.EncodeUint32(uint32(r.Region)); err != nil {
+		return n + m, err
+	}
+	n += m
+
+	// The horizon is encoded in the order of the collection IDs so that the encoding
+	// of a replica is deterministic.
+	if m, err = e.EncodeUint32(uint32(len(r.Horizon))); err != nil {
+		return n + m, err
+	}
+	n += m
+
+	collections := make([]ulid.ULID, 0, len(r.Horizon))
+	for collectionID := range r.Horizon {
+		collections = append(collections, collectionID)
+	}
+	slices.SortFunc(collections, func(a, b ulid.ULID) int { return a.Compare(b) })
+
+	for _, collectionID := range collections {
+		if m, err = e.EncodeULID(collectionID); err != nil {
+			return n + m, err
+		}
+		n += m
+
+		if m, err = e.EncodeUint64(r.Horizon[collectionID]); err != nil {
+			return n + m, err
+		}
+		n += m
+	}
+
+	if m, err = e.EncodeTime(r.Modified); err != nil {
+		return n + m, err
+	}
+	n += m
+
+	return
+}
+
+func (r *Replica) Decode(d *lani.Decoder) (err error) {
+	if r.PID, err = d.DecodeUint32(); err != nil {
+		return err
+	}
+
+	var reg uint32
+	if reg, err = d.DecodeUint32(); err != nil {
+		return err
+	}
+	r.Region = region.Region(reg)
+
+	var collections uint32
+	if collections, err = d.DecodeUint32(); err != nil {
+		return err
+	}
+
+	r.Horizon = make(ChangeVector, collections)
+	for i := uint32(0); i < collections; i++ {
+		var collectionID ulid.ULID
+		if collectionID, err = d.DecodeULID(); err != nil {
+			return err
+		}
+
+		if r.Horizon[collectionID], err = d.DecodeUint64(); err != nil {
+			return err
+		}
+	}
+
+	if r.Modified, err = d.DecodeTime(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Replica) MarshalBinary() ([]byte, error) {
+	e := &lani.Encoder{}
+	e.Grow(r.Size())
+	if _, err := r.Encode(e); err != nil {
+		return nil, err
+	}
+	return e.Bytes(), nil
+}
+
+func (r *Replica) UnmarshalBinary(data []byte) error {
+	return r.Decode(lani.NewDecoder(data))
+}
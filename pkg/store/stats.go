@@ -0,0 +1,201 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/object"
+	"go.rtnl.ai/ulid"
+)
+
+//===========================================================================
+// Storage Statistics
+//===========================================================================
+
+// Stats summarizes the storage used by all of the user collections in the store.
+type Stats struct {
+	Objects       uint64             `json:"objects"`
+	Versions      uint64             `json:"versions"`
+	Tombstones    uint64             `json:"tombstones"`
+	PayloadBytes  uint64             `json:"payload_bytes"`
+	MetadataBytes uint64             `json:"metadata_bytes"`
+	Collections   []*CollectionStats `json:"collections"`
+}
+
+// CollectionStats describes the storage used by a collection. Objects are counted if
+// their latest version is not a tombstone, whereas every stored version (including
+// tombstones) is counted in the versions and byte totals. The payload of a version is
+// the length of its data, whether it is stored inline, in chunks, or as a blob; shared
+// blobs are counted once for each version that refers to them.
+type CollectionStats struct {
+	ID               ulid.ULID         `json:"id"`
+	Name             string            `json:"name"`
+	Objects          uint64            `json:"objects"`
+	Versions         uint64            `json:"versions"`
+	Tombstones       uint64            `json:"tombstones"`
+	PayloadBytes     uint64            `json:"payload_bytes"`
+	MetadataBytes    uint64            `json:"metadata_bytes"`
+	AvgPayloadBytes  float64           `json:"avg_payload_bytes"`
+	AvgMetadataBytes float64           `json:"avg_metadata_bytes"`
+	Pages            *engine.PageStats `json:"pages,omitempty"`
+	Indexes          []*IndexStats     `json:"indexes,omitempty"`
+}
+
+// IndexStats describes the storage used by an index of a collection.
+type IndexStats struct {
+	ID      ulid.ULID         `json:"id"`
+	Name    string            `json:"name"`
+	Entries uint64            `json:"entries"`
+	Bytes   uint64            `json:"bytes"`
+	Pages   *engine.PageStats `json:"pages,omitempty"`
+}
+
+// Stats returns the storage statistics of every user collection in the store. All of
+// the collections are scanned in a single read-only transaction so the statistics are
+// consistent with each other, but the scan visits every object version in the store.
+func (s *Store) Stats(ctx context.Context) (stats *Stats, err error) {
+	if s.db == nil {
+		return nil, errors.ErrClosed
+	}
+
+	var collections []*metadata.Collection
+	if collections, err = s.Collections(ctx); err != nil {
+		return nil, err
+	}
+
+	var tx *Tx
+	if tx, err = s.Begin(ctx, &TxOptions{ReadOnly: true}); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stats = &Stats{Collections: make([]*CollectionStats, 0, len(collections))}
+	for _, info := range collections {
+		var c *Collection
+		if c, err = tx.Collection(info.ID); err != nil {
+			// Skip collections that were dropped before the transaction started.
+			if errors.Is(err, errors.ErrNoCollection) {
+				continue
+			}
+			return nil, err
+		}
+
+		var cs *CollectionStats
+		if cs, err = c.Stats(); err != nil {
+			return nil, err
+		}
+
+		stats.Objects += cs.Objects
+		stats.Versions += cs.Versions
+		stats.Tombstones += cs.Tombstones
+		stats.PayloadBytes += cs.PayloadBytes
+		stats.MetadataBytes += cs.MetadataBytes
+		stats.Collections = append(stats.Collections, cs)
+	}
+	return stats, nil
+}
+
+// Stats scans every object version in the collection and returns its storage
+// statistics. Page usage is only reported if the storage engine stores data in pages;
+// the pages of the collection include the pages of its indexes and stored payloads.
+//
+// NOTE: the statistics describe the data stored in the transaction, they are not
+// filtered by the point-in-time the transaction is pinned to.
+func (c *Collection) Stats() (stats *CollectionStats, err error) {
+	stats = &CollectionStats{
+		ID:   c.ID,
+		Name: c.Name,
+	}
+
+	blobs := c.bkt.Bucket(blobsBucket)
+
+	// Versions are sorted latest first, so the first key of each object ID is the latest
+	// version of the object, which determines if the object is counted.
+	var prev ulid.ULID
+	cursor := c.bkt.Cursor()
+	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		// Skip nested buckets (e.g. indexes, chunks, and blobs).
+		if value == nil {
+			continue
+		}
+
+		if err = c.canceled(); err != nil {
+			return nil, err
+		}
+
+		if err = keys.Key(key).Check(); err != nil {
+			return nil, fmt.Errorf("could not parse object key %x: %w", key, err)
+		}
+
+		obj := object.Object(value)
+		var meta *metadata.Metadata
+		if meta, err = obj.Metadata(); err != nil {
+			return nil, fmt.Errorf("could not decode object version %x: %w", key, err)
+		}
+
+		var data []byte
+		if data, err = obj.Data(); err != nil {
+			return nil, fmt.Errorf("could not decode object version %x: %w", key, err)
+		}
+
+		tombstone := !meta.Kind().Live()
+		if objectID := keys.Key(key).ObjectID(); objectID != prev {
+			prev = objectID
+			if !tombstone {
+				stats.Objects++
+			}
+		}
+
+		stats.Versions++
+		if tombstone {
+			stats.Tombstones++
+		}
+
+		payload := uint64(len(data))
+		switch {
+		case meta.Chunks != nil:
+			payload = meta.Chunks.Length
+		case meta.Blob != nil && blobs != nil:
+			payload = uint64(len(blobs.Get(meta.Blob)))
+		}
+
+		stats.PayloadBytes += payload
+		stats.MetadataBytes += uint64(len(obj) - len(data))
+	}
+
+	if stats.Versions > 0 {
+		stats.AvgPayloadBytes = float64(stats.PayloadBytes) / float64(stats.Versions)
+		stats.AvgMetadataBytes = float64(stats.MetadataBytes) / float64(stats.Versions)
+	}
+
+	stats.Pages = pageStats(c.bkt)
+
+	for _, idx := range c.Indexes {
+		is := &IndexStats{ID: idx.ID, Name: idx.Name}
+		if bkt := c.bkt.Bucket(idx.ID[:]); bkt != nil {
+			if err = bkt.ForEach(func(key, value []byte) error {
+				is.Entries++
+				is.Bytes += uint64(len(key) + len(value))
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+			is.Pages = pageStats(bkt)
+		}
+		stats.Indexes = append(stats.Indexes, is)
+	}
+	return stats, nil
+}
+
+// Returns the page usage of the bucket or nil if the engine does not use pages.
+func pageStats(bkt engine.Bucket) *engine.PageStats {
+	if statter, ok := bkt.(engine.PageStatter); ok {
+		stats := statter.PageStats()
+		return &stats
+	}
+	return nil
+}
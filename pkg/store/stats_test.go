@@ -0,0 +1,88 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"go.rtnl.ai/honu/pkg/config"
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/ulid"
+)
+
+func (s *honuTestSuite) TestStats() {
+	require := s.Require()
+
+	info := &metadata.Collection{
+		Name: "test_" + strings.ToLower(ulid.Make().String()),
+		Indexes: []*metadata.Index{
+			{Name: "by_name", Type: metadata.UNIQUE, Field: &metadata.Field{Name: "name", Type: metadata.StringField}},
+		},
+	}
+	require.NoError(s.store.New(context.Background(), info))
+	empty := s.createCollection()
+
+	tx, c := s.openCollection(info.ID, false)
+	alpha, bravo, charlie := &metadata.Metadata{}, &metadata.Metadata{}, &metadata.Metadata{}
+	require.NoError(c.Create(alpha, []byte("alpha-1"), nil))
+	require.NoError(c.Update(alpha, []byte("alpha-22"), nil))
+	require.NoError(c.Create(bravo, []byte("bravo"), nil))
+	require.NoError(c.Delete(bravo.Key(), nil))
+	require.NoError(tx.Commit())
+
+	_, err := s.store.PutStream(context.Background(), info.ID, charlie, bytes.NewReader(bytes.Repeat([]byte("charlie"), store.DefaultChunkSize)), nil)
+	require.NoError(err)
+
+	tx, c = s.openCollection(info.ID, true)
+	stats, err := c.Stats()
+	require.NoError(err)
+	require.NoError(tx.Rollback())
+
+	require.Equal(info.ID, stats.ID)
+	require.Equal(info.Name, stats.Name)
+	require.Equal(uint64(2), stats.Objects)
+	require.Equal(uint64(5), stats.Versions)
+	require.Equal(uint64(1), stats.Tombstones)
+	require.Equal(uint64(7+8+5+7*store.DefaultChunkSize), stats.PayloadBytes)
+	require.Greater(stats.MetadataBytes, uint64(0))
+	require.Equal(float64(stats.PayloadBytes)/5, stats.AvgPayloadBytes)
+	require.Equal(float64(stats.MetadataBytes)/5, stats.AvgMetadataBytes)
+
+	require.Len(stats.Indexes, 1)
+	require.Equal("by_name", stats.Indexes[0].Name)
+
+	// Page usage is only reported by engines that store data in pages.
+	if s.conf.Store.Engine == config.EngineMemory {
+		require.Nil(stats.Pages)
+		require.Nil(stats.Indexes[0].Pages)
+	} else {
+		require.NotNil(stats.Pages)
+		require.Greater(stats.Pages.Keys, 0)
+		require.NotNil(stats.Indexes[0].Pages)
+	}
+
+	// The store stats include every user collection.
+	all, err := s.store.Stats(context.Background())
+	require.NoError(err)
+
+	var found int
+	for _, cs := range all.Collections {
+		switch cs.ID {
+		case info.ID:
+			require.Equal(stats, cs)
+			found++
+		case empty.ID:
+			require.Zero(cs.Versions)
+			require.Zero(cs.AvgPayloadBytes)
+			found++
+		}
+	}
+	require.Equal(2, found)
+	require.GreaterOrEqual(all.Versions, stats.Versions)
+
+	closed := &store.Store{}
+	_, err = closed.Stats(context.Background())
+	require.ErrorIs(err, errors.ErrClosed)
+}
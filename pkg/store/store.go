@@ -2,16 +2,19 @@ package store
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"sync"
 	"time"
 
-	"go.etcd.io/bbolt"
 	"go.rtnl.ai/honu/pkg/config"
 	"go.rtnl.ai/honu/pkg/errors"
 	"go.rtnl.ai/honu/pkg/region"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/engine/bolt"
+	"go.rtnl.ai/honu/pkg/store/engine/memory"
 	"go.rtnl.ai/honu/pkg/store/keys"
 	"go.rtnl.ai/honu/pkg/store/lamport"
-	"go.rtnl.ai/honu/pkg/store/lani"
 	"go.rtnl.ai/honu/pkg/store/metadata"
 	"go.rtnl.ai/honu/pkg/store/object"
 	"go.rtnl.ai/ulid"
@@ -28,6 +31,7 @@ var (
 	SystemCollections     = ulid.ULID([16]byte{0x00, 0x68, 0x6f, 0x6e, 0x75, 0x00, 0x63, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e})
 	SystemReplicas        = ulid.ULID([16]byte{0x00, 0x68, 0x6f, 0x6e, 0x75, 0x01, 0x61, 0x63, 0x63, 0x65, 0x73, 0x73, 0x6c, 0x69, 0x73, 0x74})
 	SystemAccessControl   = ulid.ULID([16]byte{0x00, 0x68, 0x6f, 0x6e, 0x75, 0x02, 0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x69, 0x6e, 0x67})
+	SystemMaintenance     = ulid.ULID([16]byte{0x00, 0x68, 0x6f, 0x6e, 0x75, 0x03, 0x6a, 0x6f, 0x62, 0x63, 0x75, 0x72, 0x73, 0x6f, 0x72, 0x73})
 	SystemCollectionNames = ulid.ULID([16]byte{0x00, 0x68, 0x6f, 0x6e, 0x75, 0x00, 0x63, 0x6f, 0x6c, 0x6e, 0x61, 0x6d, 0x65, 0x69, 0x64, 0x78})
 )
 
@@ -38,14 +42,27 @@ var (
 //
 // The Store is thread-safe and can be used safely from multiple goroutines. Go
 // routines should provide a cancelable context to ensure database operations do not
-// proceed after cancellation.
+// proceed after cancellation: transactions check their context before each operation
+// and while scanning collections, and are rolled back rather than committed if their
+// context is canceled.
 //
 // The Store maintains the versioning of object accesses, so all writes must be
 // serialized through the store. Additionally the Store maintains all of the indexes
 // associated with the database, and maintains all constraints such as uniqueness.
 type Store struct {
-	conf config.StoreConfig
-	db   *bbolt.DB
+	conf    config.StoreConfig
+	db      engine.Engine
+	admit   *admission
+	feed    *feed
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	streams map[string]struct{}
+
+	// The change vector of the last backup taken since the store was opened; removals
+	// before the vector are pruned from the removal logs when the store is compacted.
+	lastBackup ChangeVector
 }
 
 // Open a new Store with the provided configuration. Only one Store can be opened for a
@@ -56,11 +73,18 @@ type Store struct {
 // to disk.
 func Open(conf config.Config) (s *Store, err error) {
 	s = &Store{
-		conf: conf.Store,
+		conf:  conf.Store,
+		admit: newAdmission(conf.Store.Concurrency, conf.Store.AdmissionTimeout),
+		feed:  newFeed(),
 	}
 
-	// TODO: better open with options.
-	if s.db, err = bbolt.Open(conf.Store.DataPath, 0600, nil); err != nil {
+	if s.db, err = openEngine(conf.Store); err != nil {
+		return nil, err
+	}
+
+	// Migrate any data stored with an older key layout to the current key version.
+	if err = s.migrate(); err != nil {
+		s.db.Close()
 		return nil, err
 	}
 
@@ -76,16 +100,75 @@ func Open(conf config.Config) (s *Store, err error) {
 		return nil, err
 	}
 
+	// Remove the staged chunks of any streams that were interrupted by a crash.
+	if !s.conf.ReadOnly {
+		if _, err = s.CollectStaged(context.Background()); err != nil {
+			s.db.Close()
+			return nil, err
+		}
+	}
+
+	// Start the background compactor to prune versions based on retention policies,
+	// the reaper to tombstone objects whose TTL has passed, and the collector to remove
+	// tombstones that have been replicated to all peers.
+	if !s.conf.ReadOnly {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+		s.schedule(s.conf.CompactionInterval, s.compactor)
+		s.schedule(s.conf.ReapInterval, s.reaper)
+		s.schedule(s.conf.GCInterval, s.collector)
+	}
+
 	return s, nil
 }
 
 // Close the store and release all resources associated with it.
 func (s *Store) Close() error {
+	// Stop any background routines and watchers before closing the database. The lock
+	// ensures that no watcher is added to the wait group once the feed is closed.
+	s.mu.Lock()
+	if s.feed != nil {
+		s.feed.close()
+	}
+
+	// Canceling the context aborts any background routines that are in progress.
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	s.feed, s.ctx, s.cancel = nil, nil, nil
+
 	err := s.db.Close()
 	s.db = nil
 	return err
 }
 
+// Runs the task in the background on the specified interval until the store is closed.
+// If the interval is not positive the task is disabled and is not scheduled. The task
+// is passed a context that is canceled when the store is closed.
+func (s *Store) schedule(interval time.Duration, task func(context.Context)) {
+	if interval <= 0 {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				task(s.ctx)
+			}
+		}
+	}()
+}
+
 //===========================================================================
 // Transactions Handling
 //===========================================================================
@@ -95,15 +178,27 @@ func (s *Store) Close() error {
 // write transactions will cause the calls to block and be serialized until the current
 // write transaction finishes.
 //
+// A read-only transaction can be pinned to a point in time using the AsOf or AsOfVersion
+// options, in which case collections and objects are resolved to the latest version
+// that existed at that point in time (e.g. to reproduce a dataset at a previous time).
+//
 // Transactions must be either committed or rolled back when they are no longer needed
 // to release the associated resources. If a transaction is not committed or rolled
 // back, pages in the database will not be freed and other transactions may be remain
 // deadlocked.
-func (s *Store) Begin(opts *TxOptions) (tx *Tx, err error) {
+//
+// The context is bound to the transaction: once it is canceled, opening collections,
+// reading and writing objects, and iterating return the error of the context, and the
+// transaction is rolled back when it is committed.
+func (s *Store) Begin(ctx context.Context, opts *TxOptions) (tx *Tx, err error) {
 	if s.db == nil {
 		return nil, errors.ErrClosed
 	}
 
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	if opts == nil {
 		opts = &TxOptions{}
 	}
@@ -113,17 +208,62 @@ func (s *Store) Begin(opts *TxOptions) (tx *Tx, err error) {
 		return nil, errors.ErrReadOnlyDB
 	}
 
+	// Point-in-time transactions cannot modify the database.
+	if opts.pinned() && !opts.ReadOnly {
+		return nil, errors.ErrPinnedWriteTx
+	}
+
 	tx = &Tx{
+		ctx:  ctx,
 		opts: opts,
+		feed: s.feed,
 	}
 
-	if tx.tx, err = s.db.Begin(!opts.ReadOnly); err != nil {
+	if tx.tx, err = s.begin(ctx, !opts.ReadOnly); err != nil {
 		return nil, err
 	}
 
 	return tx, nil
 }
 
+// Batch executes the function in a writable transaction that is committed together
+// with the transactions of other concurrent calls to Batch, so that many small writes
+// (e.g. concurrent calls to Collection.Create) share a single commit and fsync. Batch
+// returns once the transaction of the function has been committed.
+//
+// If the function returns an error, it is not committed but the other functions in the
+// batch may need to be retried; the function may therefore be called more than once and
+// must only modify the store through the transaction. The transaction is managed by the
+// batch and cannot be committed or rolled back by the function. If the engine does not
+// support batching, the function is executed in its own writable transaction.
+func (s *Store) Batch(ctx context.Context, fn func(*Tx) error) (err error) {
+	if s.db == nil {
+		return errors.ErrClosed
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if s.conf.ReadOnly {
+		return errors.ErrReadOnlyDB
+	}
+
+	if err = s.batch(ctx, func(etx engine.Tx) (err error) {
+		tx := &Tx{ctx: ctx, tx: etx, opts: &TxOptions{}, managed: true}
+		if err = fn(tx); err != nil {
+			return err
+		}
+		return ctx.Err()
+	}); err != nil {
+		return err
+	}
+
+	// Wake any watchers so that they can read the committed changes.
+	s.notify()
+	return nil
+}
+
 //===========================================================================
 // Collection Management
 //===========================================================================
@@ -133,31 +273,49 @@ func (s *Store) Begin(opts *TxOptions) (tx *Tx, err error) {
 // returned for each collection, so any collection operations must be performed after
 // opening the collection by its ID or name.
 // TODO: check permissions and ACLs to ensure the user is allowed to read collections.
-func (s *Store) Collections() (collections []*metadata.Collection, err error) {
-	var tx *bbolt.Tx
-	if tx, err = s.db.Begin(false); err != nil {
+func (s *Store) Collections(ctx context.Context) (collections []*metadata.Collection, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var tx engine.Tx
+	if tx, err = s.begin(ctx, false); err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
-	var bucket *bbolt.Bucket
+	var bucket engine.Bucket
 	if bucket = tx.Bucket(SystemCollections[:]); bucket == nil {
 		return nil, errors.ErrNotInitialized
 	}
 
-	bucket.ForEach(func(key, data []byte) error {
-		// Skip system collections.
-		if bytes.HasPrefix(key, SystemPrefix[:]) {
-			return nil
+	// Collection metadata versions are sorted latest first, so only the first key for
+	// each collection ID is the current version of the collection.
+	var prev ulid.ULID
+	cursor := bucket.Cursor()
+	for key, data := cursor.First(); key != nil; key, data = cursor.Next() {
+		// Skip nested buckets (e.g. the names index) and system collections.
+		if data == nil || keys.Key(key).Check() != nil {
+			continue
 		}
 
-		var c *metadata.Collection
-		if err := lani.Unmarshal(data, c); err != nil {
-			return err
+		collectionID := keys.Key(key).ObjectID()
+		if collectionID == prev || bytes.HasPrefix(collectionID[:], SystemPrefix[:]) {
+			continue
+		}
+		prev = collectionID
+
+		c := &metadata.Collection{}
+		if err = object.UnmarshalSystem(object.Object(data), c); err != nil {
+			return nil, fmt.Errorf("could not unmarshal collection metadata: %w", err)
+		}
+
+		// Skip collections that have been dropped.
+		if c.Version.IsTombstone() {
+			continue
 		}
 		collections = append(collections, c)
-		return nil
-	})
+	}
 
 	return collections, nil
 }
@@ -171,7 +329,11 @@ func (s *Store) Collections() (collections []*metadata.Collection, err error) {
 //
 // Any indexes defined on the collection will be created when the collection is created.
 // TODO: check permissions and ACLs to ensure the user is allowed to create the collection.
-func (s *Store) New(info *metadata.Collection) (err error) {
+func (s *Store) New(ctx context.Context, info *metadata.Collection) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
 	// Readonly checks
 	if s.conf.ReadOnly {
 		return errors.ErrReadOnlyDB
@@ -189,18 +351,19 @@ func (s *Store) New(info *metadata.Collection) (err error) {
 
 	// Update the collection info to set the ID and creation time.
 	info.ID = ulid.MakeSecure()
+	assignIndexIDs(info, nil)
 	info.Version = &metadata.Version{
-		Scalar:    lamport.Next(nil),
-		Region:    region.ProcessRegion(),
-		Parent:    nil,
-		Tombstone: false,
-		Created:   time.Now(),
+		Scalar:  lamport.Next(nil),
+		Region:  region.ProcessRegion(),
+		Parent:  nil,
+		Kind:    metadata.LIVE,
+		Created: time.Now(),
 	}
 	info.Created = info.Version.Created
 	info.Modified = info.Version.Created
 
-	var tx *bbolt.Tx
-	if tx, err = s.db.Begin(true); err != nil {
+	var tx engine.Tx
+	if tx, err = s.begin(ctx, true); err != nil {
 		return fmt.Errorf("could not begin transaction: %w", err)
 	}
 	defer tx.Rollback()
@@ -233,8 +396,12 @@ func (s *Store) New(info *metadata.Collection) (err error) {
 		return fmt.Errorf("could not store collection metadata %s: %w", info.Name, err)
 	}
 
+	if err = sequenceCollection(collections, key, false); err != nil {
+		return err
+	}
+
 	// Create the bucket for the collection itself to hold its objects.
-	var bucket *bbolt.Bucket
+	var bucket engine.Bucket
 	if bucket, err = tx.CreateBucketIfNotExists(info.ID[:]); err != nil {
 		return fmt.Errorf("could not create collection bucket %s: %w", info.Name, err)
 	}
@@ -246,12 +413,25 @@ func (s *Store) New(info *metadata.Collection) (err error) {
 		}
 	}
 
-	return tx.Commit()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	s.notify()
+	return nil
 }
 
 // Has returns true if the collection with the specified ID or name exists in the store.
 // TODO: check permissions and ACLs to ensure the user is allowed to read the collection.
-func (s *Store) Has(identifier any) (exists bool, err error) {
+func (s *Store) Has(ctx context.Context, identifier any) (exists bool, err error) {
+	if err = ctx.Err(); err != nil {
+		return false, err
+	}
+
 	var (
 		collectionID   ulid.ULID
 		collectionName string
@@ -263,8 +443,8 @@ func (s *Store) Has(identifier any) (exists bool, err error) {
 		return false, err
 	}
 
-	var tx *bbolt.Tx
-	if tx, err = s.db.Begin(false); err != nil {
+	var tx engine.Tx
+	if tx, err = s.begin(ctx, false); err != nil {
 		return false, fmt.Errorf("could not begin transaction: %w", err)
 	}
 	defer tx.Rollback()
@@ -281,12 +461,13 @@ func (s *Store) Has(identifier any) (exists bool, err error) {
 		}
 	}
 
-	// collectionID is the prefix, we need to look to see if any objects start with
-	// that prefix, because that will indicate that there is at least one version of
-	// the collection stored in the database.
+	// The object prefix of the collectionID is the prefix, we need to look to see if
+	// any objects start with that prefix, because that will indicate that there is at
+	// least one version of the collection stored in the database.
+	prefix := keys.New(collectionID, nil).ObjectPrefix()
 	cursor := collections.Cursor()
-	key, _ := cursor.Seek(collectionID[:])
-	exists = key != nil && bytes.HasPrefix(key, collectionID[:])
+	key, _ := cursor.Seek(prefix)
+	exists = key != nil && bytes.HasPrefix(key, prefix)
 
 	return exists, err
 }
@@ -295,14 +476,192 @@ func (s *Store) Has(identifier any) (exists bool, err error) {
 // identifier (e.g. either the collection ID or name). If the collection does not exist,
 // an ErrNoCollection error is returned.
 // TODO: check permissions and ACLs to ensure the user is allowed to read the collection.
-func (s *Store) Collection(identifier any) (info *metadata.Collection, err error) {
-	return info, nil
+func (s *Store) Collection(ctx context.Context, identifier any) (info *metadata.Collection, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var tx engine.Tx
+	if tx, err = s.begin(ctx, false); err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var collectionID ulid.ULID
+	collections := tx.Bucket(SystemCollections[:])
+	if collectionID, err = resolveCollection(collections, identifier); err != nil {
+		return nil, err
+	}
+
+	return latestCollection(collections, collectionID)
+}
+
+// CollectionHistory returns all of the metadata versions of the specified collection
+// from the most recent version to the oldest. The history is preserved across
+// modifications but not when the collection is dropped (in which case only the
+// tombstone version remains and ErrNoCollection is returned).
+// TODO: check permissions and ACLs to ensure the user is allowed to read the collection.
+func (s *Store) CollectionHistory(ctx context.Context, identifier any) (versions []*metadata.Collection, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var tx engine.Tx
+	if tx, err = s.begin(ctx, false); err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var collectionID ulid.ULID
+	collections := tx.Bucket(SystemCollections[:])
+	if collectionID, err = resolveCollection(collections, identifier); err != nil {
+		return nil, err
+	}
+	return collectionVersions(collections, collectionID)
 }
 
 // Modifies the metadata of an existing collection; the collection should either have
-// an ID or a name for reference and must already exist in the store.
-func (s *Store) Modify(info *metadata.Collection) error {
-	return errors.ErrNotImplemented
+// an ID or a name for reference and must already exist in the store. The ID of the
+// collection cannot be changed, but if the ID is specified the name of the collection
+// can be changed, in which case the name index is updated. Indexes that are added to
+// the collection will have their buckets created and indexes that are removed will be
+// dropped along with their buckets.
+//
+// A new metadata version is created for the collection whose parent is the previous
+// version, so the info will be modified to include the assigned version, ID, and
+// timestamps; the caller can use the modified instance after the call.
+// TODO: check permissions and ACLs to ensure the user is allowed to modify the collection.
+func (s *Store) Modify(ctx context.Context, info *metadata.Collection) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	// Readonly checks
+	if s.conf.ReadOnly {
+		return errors.ErrReadOnlyDB
+	}
+
+	// Validate the info to ensure the collection can be modified.
+	if err = info.Validate(); err != nil {
+		return err
+	}
+
+	var identifier any = info.Name
+	if !info.ID.IsZero() {
+		identifier = info.ID
+	}
+
+	var tx engine.Tx
+	if tx, err = s.begin(ctx, true); err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	collections := tx.Bucket(SystemCollections[:])
+	nameIndex := collections.Bucket(SystemCollectionNames[:])
+
+	var collectionID ulid.ULID
+	if collectionID, err = resolveCollection(collections, identifier); err != nil {
+		return err
+	}
+
+	var prev *metadata.Collection
+	if prev, err = latestCollection(collections, collectionID); err != nil {
+		return err
+	}
+
+	// If the name has changed, ensure the new name is unique and update the name index.
+	if info.Name != prev.Name {
+		if v := nameIndex.Get([]byte(info.Name)); v != nil {
+			return errors.ErrCollectionExists
+		}
+
+		if err = nameIndex.Delete([]byte(prev.Name)); err != nil {
+			return fmt.Errorf("could not remove collection name %s from index: %w", prev.Name, err)
+		}
+
+		if err = nameIndex.Put([]byte(info.Name), collectionID[:]); err != nil {
+			return fmt.Errorf("could not index collection name %s: %w", info.Name, err)
+		}
+	}
+
+	// Create or drop index buckets for indexes that were added or removed.
+	var bucket engine.Bucket
+	if bucket = tx.Bucket(collectionID[:]); bucket == nil {
+		return errors.ErrRepairCollection
+	}
+
+	assignIndexIDs(info, prev)
+	current := make(map[ulid.ULID]struct{}, len(info.Indexes))
+	for _, idx := range info.Indexes {
+		if idx == nil {
+			continue
+		}
+
+		current[idx.ID] = struct{}{}
+		if bucket.Bucket(idx.ID[:]) == nil {
+			if _, err = bucket.CreateBucket(idx.ID[:]); err != nil {
+				return fmt.Errorf("could not create index %s in %s: %w", idx.Name, info.Name, err)
+			}
+
+			// Index the objects that are already stored in the collection.
+			c := &Collection{Collection: *info, ctx: ctx, bkt: bucket}
+			if err = c.buildIndex(idx); err != nil {
+				return fmt.Errorf("could not build index %s in %s: %w", idx.Name, info.Name, err)
+			}
+		}
+	}
+
+	for _, idx := range prev.Indexes {
+		if idx == nil {
+			continue
+		}
+
+		if _, ok := current[idx.ID]; !ok {
+			if err = bucket.DeleteBucket(idx.ID[:]); err != nil && !errors.Is(err, engine.ErrBucketNotFound) {
+				return fmt.Errorf("could not drop index %s in %s: %w", idx.Name, info.Name, err)
+			}
+		}
+	}
+
+	// Create the new version of the collection metadata.
+	info.ID = collectionID
+	info.Version = &metadata.Version{
+		Scalar:  lamport.Next(&prev.Version.Scalar),
+		Region:  region.ProcessRegion(),
+		Parent:  &prev.Version.Scalar,
+		Kind:    metadata.LIVE,
+		Created: time.Now(),
+	}
+	info.Created = prev.Created
+	info.Modified = info.Version.Created
+
+	var data object.Object
+	if data, err = object.MarshalSystem(info); err != nil {
+		return fmt.Errorf("could not marshal collection metadata %s: %w", info.Name, err)
+	}
+
+	key := keys.New(info.ID, &info.Version.Scalar)
+	if err = collections.Put(key, data); err != nil {
+		return fmt.Errorf("could not store collection metadata %s: %w", info.Name, err)
+	}
+
+	if err = sequenceCollection(collections, key, false); err != nil {
+		return err
+	}
+
+	// Do not commit the modification if the request was canceled while the indexes
+	// were being created or dropped.
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	s.notify()
+	return nil
 }
 
 // Drop a collection, removing it from the store and deleting all of its contained
@@ -317,7 +676,7 @@ func (s *Store) Modify(info *metadata.Collection) error {
 //
 // Use this operation to free up space in the database, but note that data deletion,
 // history, and metadata will be lost. If you want to keep the version history of
-// the objects in the collection, use the Collection.Empty method instead which adds
+// the objects in the collection, use the Store.Empty method instead which adds
 // a tombstone version to each object in the collection but keeps the version history
 // intact.
 //
@@ -328,7 +687,11 @@ func (s *Store) Modify(info *metadata.Collection) error {
 // priority replica.
 //
 // TODO: check permissions and ACLs to ensure the user is allowed to drop the collection.
-func (s *Store) Drop(identifier any) (err error) {
+func (s *Store) Drop(ctx context.Context, identifier any) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
 	var (
 		collectionID   ulid.ULID
 		collectionName string
@@ -340,8 +703,8 @@ func (s *Store) Drop(identifier any) (err error) {
 		return err
 	}
 
-	var tx *bbolt.Tx
-	if tx, err = s.db.Begin(true); err != nil {
+	var tx engine.Tx
+	if tx, err = s.begin(ctx, true); err != nil {
 		return fmt.Errorf("could not begin transaction: %w", err)
 	}
 	defer tx.Rollback()
@@ -358,13 +721,15 @@ func (s *Store) Drop(identifier any) (err error) {
 		}
 	}
 
-	// Fetch the collection meta to get its current version.
+	// Fetch the collection meta to get its current version; the latest version is the
+	// first key with the collection prefix.
 	var meta metadata.Collection
+	prefix := keys.New(collectionID, nil).ObjectPrefix()
 	cursor := collections.Cursor()
-	if key, data := cursor.Seek(collectionID[:]); key == nil || !bytes.HasPrefix(key, collectionID[:]) {
+	if key, data := cursor.Seek(prefix); key == nil || !bytes.HasPrefix(key, prefix) {
 		return errors.ErrNoCollection
 	} else {
-		if err = lani.Unmarshal(data, &meta); err != nil {
+		if err = object.UnmarshalSystem(object.Object(data), &meta); err != nil {
 			return fmt.Errorf("could not unmarshal collection meta: %w", err)
 		}
 	}
@@ -375,8 +740,14 @@ func (s *Store) Drop(identifier any) (err error) {
 		return fmt.Errorf("could not remove collection name from index: %w", err)
 	}
 
-	// Delete all collection versions.
-	for key, _ := cursor.Seek(collectionID[:]); key != nil && bytes.HasPrefix(key, collectionID[:]); key, _ = cursor.Next() {
+	// Delete all collection versions; keys are collected first since deleting while
+	// iterating with a bolt cursor will skip keys.
+	var versions [][]byte
+	for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+		versions = append(versions, bytes.Clone(key))
+	}
+
+	for _, key := range versions {
 		if err = collections.Delete(key); err != nil {
 			return fmt.Errorf("could not delete collection version: %w", err)
 		}
@@ -396,6 +767,10 @@ func (s *Store) Drop(identifier any) (err error) {
 		return fmt.Errorf("could not store tombstone collection meta: %w", err)
 	}
 
+	if err = sequenceCollection(collections, tkey, true); err != nil {
+		return err
+	}
+
 	// Delete the collection bucket to remove all of its objects and indexes.
 	// NOTE: DeleteBucket removes the bucket and all nested buckets (including indexes)
 	// and marks the pages as free.
@@ -403,28 +778,223 @@ func (s *Store) Drop(identifier any) (err error) {
 		return fmt.Errorf("could not delete collection bucket: %w", err)
 	}
 
-	return tx.Commit()
+	// Do not commit the drop if the request was canceled while the bucket was deleted.
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	// Watchers of the collection are stopped once they observe the dropped bucket.
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	s.notify()
+	return nil
 }
 
 // Truncate a collection, removing all of its contained objects and versions, but
 // keeping the collection and its indexes intact. This is fundamenally different to the
-// Collection.Empty method which adds a new tombstone version to remove objects from
+// Store.Empty method which adds a new tombstone version to remove objects from
 // the collection but keeps the version history intact.
 //
 // NOTE: truncate adds a truncated record to every object in the collection, thereby
 // replicating the truncation operation to all replicas. Objects that are added after
 // the truncation operation will start from version 1, even if that truncation happens
 // concurrently with the creation of the new object.
-func (s *Store) Truncate(identifier any) error {
-	return errors.ErrNotImplemented
+//
+// Like Store.Empty, the collection is truncated in bounded batches of separate write
+// transactions and an interrupted truncation is resumed from the last committed batch
+// when Truncate is called again; objects created after the truncation started are not
+// truncated. The entries of the removed versions are removed from the indexes that are
+// maintained by the store and all other indexes are cleared.
+// TODO: check permissions and ACLs to ensure the user is allowed to truncate the collection.
+func (s *Store) Truncate(ctx context.Context, identifier any) (err error) {
+	if s.conf.ReadOnly {
+		return errors.ErrReadOnlyDB
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	return s.run(ctx, identifier, truncateJob, DefaultEmptyBatchSize, nil)
 }
 
 // Returns the underlying engine that the store is using for persistence. This is
 // primarily used for testing and debugging purposes, and should be used with caution.
-func (s *Store) DB() *bbolt.DB {
+func (s *Store) Engine() engine.Engine {
 	return s.db
 }
 
+// Opens the storage engine specified by the configuration; bolt is the default.
+func openEngine(conf config.StoreConfig) (engine.Engine, error) {
+	switch conf.Engine {
+	case "", config.EngineBolt:
+		return bolt.Open(conf.DataPath, boltOptions(conf))
+	case config.EngineMemory:
+		return memory.Open(), nil
+	default:
+		return nil, fmt.Errorf("could not open storage engine %q: %w", conf.Engine, errors.ErrNotSupported)
+	}
+}
+
+// Returns the options to open the bolt engine with from the store configuration.
+func boltOptions(conf config.StoreConfig) *bolt.Options {
+	return &bolt.Options{
+		ReadOnly:        conf.ReadOnly,
+		Timeout:         conf.LockTimeout,
+		NoSync:          conf.NoSync,
+		NoFreelistSync:  conf.NoFreelistSync,
+		InitialMmapSize: conf.InitialMmapSize,
+		FreelistType:    conf.FreelistType,
+		PageSize:        conf.PageSize,
+		MaxBatchSize:    conf.MaxBatchSize,
+		MaxBatchDelay:   conf.MaxBatchDelay,
+	}
+}
+
+//===========================================================================
+// Collection Metadata Helpers
+//===========================================================================
+
+// Returns the collection ID from the identifier, looking up the ID in the name index of
+// the collections bucket if a name is specified. Returns ErrNoCollection if the name is
+// not in the index.
+func resolveCollection(collections engine.Bucket, identifier any) (collectionID ulid.ULID, err error) {
+	var collectionName string
+	if collectionID, collectionName, err = collectionIdentifier(identifier); err != nil {
+		return ulid.Zero, err
+	}
+
+	if collectionID.IsZero() {
+		nameIndex := collections.Bucket(SystemCollectionNames[:])
+		if v := nameIndex.Get([]byte(collectionName)); v != nil {
+			copy(collectionID[:], v)
+		} else {
+			return ulid.Zero, errors.ErrNoCollection
+		}
+	}
+
+	return collectionID, nil
+}
+
+// Returns the latest metadata version of the collection from the collections bucket. If
+// the collection does not exist or has been dropped, ErrNoCollection is returned.
+func latestCollection(collections engine.Bucket, collectionID ulid.ULID) (info *metadata.Collection, err error) {
+	prefix := keys.New(collectionID, nil).ObjectPrefix()
+	cursor := collections.Cursor()
+
+	var key, data []byte
+	if key, data = cursor.Seek(prefix); key == nil || !bytes.HasPrefix(key, prefix) {
+		return nil, errors.ErrNoCollection
+	}
+
+	info = &metadata.Collection{}
+	if err = object.UnmarshalSystem(object.Object(data), info); err != nil {
+		return nil, fmt.Errorf("could not unmarshal collection metadata: %w", err)
+	}
+
+	if info.Version == nil || info.Version.IsTombstone() {
+		return nil, errors.ErrNoCollection
+	}
+	return info, nil
+}
+
+// Returns all of the metadata versions of the collection from the collections bucket
+// from the most recent version to the oldest. If the collection does not exist or has
+// been dropped, ErrNoCollection is returned.
+func collectionVersions(collections engine.Bucket, collectionID ulid.ULID) (versions []*metadata.Collection, err error) {
+	prefix := keys.New(collectionID, nil).ObjectPrefix()
+	cursor := collections.Cursor()
+	for key, data := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, data = cursor.Next() {
+		version := &metadata.Collection{}
+		if err = object.UnmarshalSystem(object.Object(data), version); err != nil {
+			return nil, fmt.Errorf("could not unmarshal collection metadata: %w", err)
+		}
+		versions = append(versions, version)
+	}
+
+	if len(versions) == 0 || versions[0].Version == nil || versions[0].Version.IsTombstone() {
+		return nil, errors.ErrNoCollection
+	}
+	return versions, nil
+}
+
+// Assigns the next sequence of the collections bucket to a new version of collection
+// metadata so that peers can acknowledge the collection changes they have received
+// under the SystemCollections ID of their horizon. The sequences of tombstones are
+// indexed so that the tombstones of dropped collections can be garbage collected once
+// every peer has acknowledged them.
+func sequenceCollection(collections engine.Bucket, key []byte, tombstone bool) (err error) {
+	var sequence uint64
+	if sequence, err = collections.NextSequence(); err != nil {
+		return fmt.Errorf("could not assign collection sequence: %w", err)
+	}
+
+	if !tombstone {
+		return nil
+	}
+
+	var index engine.Bucket
+	if index, err = collections.CreateBucketIfNotExists(changeIndexBucket); err != nil {
+		return fmt.Errorf("could not create collection change index: %w", err)
+	}
+
+	if err = index.Put(key, sequenceKey(sequence)); err != nil {
+		return fmt.Errorf("could not index collection tombstone: %w", err)
+	}
+	return nil
+}
+
+// Assigns an ID to any index on the collection that does not have an ID. If the index
+// matches an index of the previous version of the collection by name, type, and fields
+// it keeps the ID of that index so that its bucket is not rebuilt (e.g. when the caller
+// sends the indexes without their IDs); otherwise a new unique ID is assigned.
+func assignIndexIDs(info *metadata.Collection, prev *metadata.Collection) {
+	used := make(map[ulid.ULID]struct{}, len(info.Indexes))
+	for _, idx := range info.Indexes {
+		if idx != nil && !idx.ID.IsZero() {
+			used[idx.ID] = struct{}{}
+		}
+	}
+
+	for _, idx := range info.Indexes {
+		if idx == nil || !idx.ID.IsZero() {
+			continue
+		}
+
+		if prev != nil {
+			for _, existing := range prev.Indexes {
+				if _, ok := used[existing.ID]; ok || !sameIndex(existing, idx) {
+					continue
+				}
+
+				idx.ID = existing.ID
+				break
+			}
+		}
+
+		if idx.ID.IsZero() {
+			idx.ID = ulid.MakeSecure()
+		}
+		used[idx.ID] = struct{}{}
+	}
+}
+
+// Returns true if the indexes have the same name, type, and fields, ignoring their IDs.
+func sameIndex(a, b *metadata.Index) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	return a.Name == b.Name && a.Type == b.Type && sameField(a.Field, b.Field) && sameField(a.Ref, b.Ref)
+}
+
+func sameField(a, b *metadata.Field) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
 //===========================================================================
 // Initialization
 //===========================================================================
@@ -485,6 +1055,18 @@ func defaultCollections() []*metadata.Collection {
 			Created:  now,
 			Modified: now,
 		},
+		{
+			ID:   SystemMaintenance,
+			Name: "honu_maintenance",
+			Version: &metadata.Version{
+				Scalar:  lamport.Scalar{PID: 0, VID: 1}, // This is the default first version for system collections.
+				Created: now,
+			},
+			Owner:    SystemHonuAgent,
+			Group:    SystemHonuAgent,
+			Created:  now,
+			Modified: now,
+		},
 	}
 }
 
@@ -498,14 +1080,14 @@ func (s *Store) initialize() (err error) {
 	// that the system user is created with the correct permissions.
 	defaultCollections := defaultCollections()
 
-	var tx *bbolt.Tx
+	var tx engine.Tx
 	if tx, err = s.db.Begin(true); err != nil {
 		return fmt.Errorf("could not begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
 	// Get the collections bucket to create the systems collection info in.
-	var collectionsBucket *bbolt.Bucket
+	var collectionsBucket engine.Bucket
 	if collectionsBucket, err = tx.CreateBucketIfNotExists(SystemCollections[:]); err != nil {
 		return fmt.Errorf("could not create system collections bucket: %w", err)
 	}
@@ -533,7 +1115,7 @@ func (s *Store) initialize() (err error) {
 		}
 
 		// Create the bucket for the collection itself to hold its objects.
-		var cbckt *bbolt.Bucket
+		var cbckt engine.Bucket
 		if cbckt, err = tx.CreateBucketIfNotExists(collection.ID[:]); err != nil {
 			return fmt.Errorf("could not create collection bucket %s: %w", collection.Name, err)
 		}
@@ -556,14 +1138,14 @@ func (s *Store) check() (err error) {
 	// Ensure that the system collections and indexes exist in the database.
 	defaultCollections := defaultCollections()
 
-	var tx *bbolt.Tx
+	var tx engine.Tx
 	if tx, err = s.db.Begin(false); err != nil {
 		return fmt.Errorf("could not begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
 	// Get the collections bucket to check the systems collection info in.
-	var collectionsBucket *bbolt.Bucket
+	var collectionsBucket engine.Bucket
 	if collectionsBucket = tx.Bucket(SystemCollections[:]); collectionsBucket == nil {
 		return fmt.Errorf("missing bucket %s for system collections", SystemCollections)
 	}
@@ -579,7 +1161,7 @@ func (s *Store) check() (err error) {
 		}
 
 		// Ensure the bucket for the collection itself exists to hold its objects.
-		var cbckt *bbolt.Bucket
+		var cbckt engine.Bucket
 		if cbckt = tx.Bucket(collection.ID[:]); cbckt == nil {
 			err = errors.Join(err, fmt.Errorf("missing bucket for collection %s (%s)", collection.Name, collection.ID))
 			continue
@@ -2,21 +2,27 @@ package store_test
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
-	"go.etcd.io/bbolt"
 	"go.rtnl.ai/honu/pkg/config"
+	"go.rtnl.ai/honu/pkg/errors"
 	"go.rtnl.ai/honu/pkg/logger"
+	"go.rtnl.ai/honu/pkg/region"
 	"go.rtnl.ai/honu/pkg/store"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/engine/bolt"
 	"go.rtnl.ai/honu/pkg/store/keys"
 	"go.rtnl.ai/honu/pkg/store/lamport"
+	"go.rtnl.ai/honu/pkg/store/metadata"
 	"go.rtnl.ai/ulid"
 )
 
@@ -49,6 +55,10 @@ func TestWriteableStore(t *testing.T) {
 		},
 	}
 
+	// Versions and regions are assigned from the process globals.
+	lamport.SetProcessID(tests.conf.PID)
+	region.SetProcessRegion(region.TESTING)
+
 	var err error
 	tests.store, err = store.Open(tests.conf)
 	require.NoError(t, err, "failed to open store, could not start tests")
@@ -56,6 +66,37 @@ func TestWriteableStore(t *testing.T) {
 	suite.Run(t, tests)
 }
 
+func TestMemoryStore(t *testing.T) {
+	tests := &honuTestSuite{
+		conf: config.Config{
+			PID:          1,
+			Maintenance:  false,
+			LogLevel:     logger.LevelDecoder(zerolog.ErrorLevel),
+			ConsoleLog:   false,
+			BindAddr:     ":11111",
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 5 * time.Second,
+			IdleTimeout:  5 * time.Second,
+			Store: config.StoreConfig{
+				ReadOnly:    false,
+				Engine:      config.EngineMemory,
+				Concurrency: 128,
+			},
+		},
+	}
+
+	// Versions and regions are assigned from the process globals.
+	lamport.SetProcessID(tests.conf.PID)
+	region.SetProcessRegion(region.TESTING)
+
+	var err error
+	tests.store, err = store.Open(tests.conf)
+	require.NoError(t, err, "failed to open store, could not start tests")
+	defer tests.store.Close()
+
+	suite.Run(t, tests)
+}
+
 func TestReadonlyStore(t *testing.T) {
 	// Before implementing read-only stores, we need to create a database to read from.
 	// If the database does not exist, then it cannot create it in read-only mode.
@@ -95,13 +136,14 @@ func (s *honuTestSuite) TestInitialized() {
 	// In read-only mode, the collections should not be overwritten.
 	require := s.Require()
 
-	db := s.store.DB()
+	db := s.store.Engine()
 	require.NotNil(db, "store db should not be nil")
 
 	collections := []ulid.ULID{
 		store.SystemCollections,
 		store.SystemReplicas,
 		store.SystemAccessControl,
+		store.SystemMaintenance,
 	}
 
 	tx, err := db.Begin(false)
@@ -115,6 +157,246 @@ func (s *honuTestSuite) TestInitialized() {
 	}
 }
 
+func (s *honuTestSuite) TestCollections() {
+	require := s.Require()
+	info := s.createCollection()
+
+	// Modifying the collection should not add the collection to the list twice.
+	info.Permissions = 0x7
+	require.NoError(s.store.Modify(context.Background(), info), "could not modify collection")
+
+	collections, err := s.store.Collections(context.Background())
+	require.NoError(err, "could not list collections")
+
+	n := 0
+	for _, c := range collections {
+		require.False(bytes.HasPrefix(c.ID[:], store.SystemPrefix[:]), "system collections should not be listed")
+		if c.ID == info.ID {
+			n++
+			require.Equal(uint8(0x7), c.Permissions, "expected latest version of collection")
+		}
+	}
+	require.Equal(1, n, "expected collection to be listed exactly once")
+
+	// Dropped collections should not be listed.
+	require.NoError(s.store.Drop(context.Background(), info.ID), "could not drop collection")
+	collections, err = s.store.Collections(context.Background())
+	require.NoError(err, "could not list collections")
+	for _, c := range collections {
+		require.NotEqual(info.ID, c.ID, "dropped collection should not be listed")
+	}
+}
+
+func (s *honuTestSuite) TestCollection() {
+	require := s.Require()
+	info := s.createCollection()
+
+	byID, err := s.store.Collection(context.Background(), info.ID)
+	require.NoError(err, "could not get collection by ID")
+	require.Equal(info.Name, byID.Name)
+	require.Equal(info.Version.Scalar, byID.Version.Scalar)
+
+	byName, err := s.store.Collection(context.Background(), info.Name)
+	require.NoError(err, "could not get collection by name")
+	require.Equal(info.ID, byName.ID)
+
+	_, err = s.store.Collection(context.Background(), "does_not_exist")
+	require.ErrorIs(err, errors.ErrNoCollection)
+
+	_, err = s.store.Collection(context.Background(), ulid.Make())
+	require.ErrorIs(err, errors.ErrNoCollection)
+
+	require.NoError(s.store.Drop(context.Background(), info.ID), "could not drop collection")
+	_, err = s.store.Collection(context.Background(), info.ID)
+	require.ErrorIs(err, errors.ErrNoCollection)
+}
+
+func (s *honuTestSuite) TestModify() {
+	require := s.Require()
+	info := s.createCollection()
+	orig := *info.Version
+
+	// Rename the collection and add an index.
+	oldName := info.Name
+	info.Name = oldName + "_renamed"
+	info.Indexes = []*metadata.Index{{Name: "email", Type: metadata.UNIQUE}}
+	require.NoError(s.store.Modify(context.Background(), info), "could not modify collection")
+
+	require.True(info.Version.Scalar.After(&orig.Scalar), "expected a new version")
+	require.Equal(orig.Scalar, *info.Version.Parent, "expected parent to be previous version")
+	require.True(orig.Created.Equal(info.Created), "expected created timestamp to be unchanged")
+	require.False(info.Indexes[0].ID.IsZero(), "expected index ID to be assigned")
+
+	// The name index should have been updated.
+	_, err := s.store.Collection(context.Background(), oldName)
+	require.ErrorIs(err, errors.ErrNoCollection)
+
+	renamed, err := s.store.Collection(context.Background(), info.Name)
+	require.NoError(err, "could not get renamed collection")
+	require.Equal(info.ID, renamed.ID)
+	require.Len(renamed.Indexes, 1)
+
+	// The index bucket should have been created.
+	indexID := info.Indexes[0].ID
+	require.NoError(s.store.Engine().View(func(tx engine.Tx) error {
+		require.NotNil(tx.Bucket(info.ID[:]).Bucket(indexID[:]), "expected index bucket")
+		return nil
+	}))
+
+	// Removing the index should drop the index bucket.
+	info.Indexes = nil
+	require.NoError(s.store.Modify(context.Background(), info), "could not modify collection")
+	require.NoError(s.store.Engine().View(func(tx engine.Tx) error {
+		require.Nil(tx.Bucket(info.ID[:]).Bucket(indexID[:]), "expected index bucket to be dropped")
+		return nil
+	}))
+
+	// Cannot rename a collection to the name of another collection.
+	other := s.createCollection()
+	info.Name = other.Name
+	require.ErrorIs(s.store.Modify(context.Background(), info), errors.ErrCollectionExists)
+
+	// Cannot modify a collection that does not exist.
+	err = s.store.Modify(context.Background(), &metadata.Collection{ID: ulid.Make(), Name: "missing"})
+	require.ErrorIs(err, errors.ErrNoCollection)
+}
+
+func (s *honuTestSuite) TestModifyIndexes() {
+	require := s.Require()
+	info := s.createCollection()
+	info.Indexes = []*metadata.Index{{Name: "by_name", Type: metadata.UNIQUE, Field: &metadata.Field{Name: "name", Type: metadata.StringField}}}
+	require.NoError(s.store.Modify(context.Background(), info), "could not add index")
+	indexID := info.Indexes[0].ID
+
+	// Mark the index bucket to check that it is not rebuilt.
+	sentinel := []byte("sentinel")
+	require.NoError(s.store.Engine().Update(func(tx engine.Tx) error {
+		return tx.Bucket(info.ID[:]).Bucket(indexID[:]).Put(sentinel, sentinel)
+	}))
+
+	// Indexes sent without their IDs keep the IDs of the matching existing indexes;
+	// indexes with a different type or field are new indexes.
+	info.Indexes = []*metadata.Index{
+		{Name: "by_name", Type: metadata.UNIQUE, Field: &metadata.Field{Name: "name", Type: metadata.StringField}},
+		{Name: "by_name", Type: metadata.INDEX, Field: &metadata.Field{Name: "name", Type: metadata.StringField}},
+		{Name: "by_name", Type: metadata.UNIQUE, Field: &metadata.Field{Name: "email", Type: metadata.StringField}},
+	}
+	require.NoError(s.store.Modify(context.Background(), info), "could not modify collection")
+	require.Equal(indexID, info.Indexes[0].ID)
+	require.NotEqual(indexID, info.Indexes[1].ID)
+	require.NotEqual(indexID, info.Indexes[2].ID)
+	require.NotEqual(info.Indexes[1].ID, info.Indexes[2].ID)
+
+	require.NoError(s.store.Engine().View(func(tx engine.Tx) error {
+		require.Equal(sentinel, tx.Bucket(info.ID[:]).Bucket(indexID[:]).Get(sentinel), "expected index not to be rebuilt")
+		return nil
+	}))
+}
+
+func (s *honuTestSuite) TestCollectionHistory() {
+	require := s.Require()
+	info := s.createCollection()
+
+	for i := 0; i < 3; i++ {
+		info.Permissions = uint8(i + 1)
+		require.NoError(s.store.Modify(context.Background(), info), "could not modify collection")
+	}
+
+	history, err := s.store.CollectionHistory(context.Background(), info.Name)
+	require.NoError(err, "could not get collection history")
+	require.Len(history, 4)
+
+	// History should be ordered from the latest version to the oldest.
+	require.Equal(info.Version.Scalar, history[0].Version.Scalar)
+	require.Nil(history[3].Version.Parent, "expected first version to have no parent")
+	for i := 0; i < len(history)-1; i++ {
+		require.Equal(history[i+1].Version.Scalar, *history[i].Version.Parent, "expected parent to be previous version")
+	}
+
+	require.NoError(s.store.Drop(context.Background(), info.ID), "could not drop collection")
+	_, err = s.store.CollectionHistory(context.Background(), info.ID)
+	require.ErrorIs(err, errors.ErrNoCollection)
+}
+
+func (s *honuTestSuite) TestTruncate() {
+	require := s.Require()
+	info := s.createCollection()
+	info.Indexes = []*metadata.Index{
+		{Name: "color", Type: metadata.INDEX},
+		{Name: "by_name", Type: metadata.UNIQUE, Field: &metadata.Field{Name: "name", Type: metadata.StringField}},
+	}
+	require.NoError(s.store.Modify(context.Background(), info), "could not add index")
+
+	tx, c := s.openCollection(info.ID, false)
+	objects := make([]*metadata.Metadata, 3)
+	for i := range objects {
+		objects[i] = &metadata.Metadata{}
+		require.NoError(c.Create(objects[i], []byte("foo"), nil))
+		require.NoError(c.Update(objects[i], []byte(fmt.Sprintf(`{"name": "object %d"}`, i)), nil))
+	}
+	require.NoError(tx.Commit())
+
+	// Add a key to the index to ensure it is cleared.
+	indexID := info.Indexes[0].ID
+	require.NoError(s.store.Engine().Update(func(tx engine.Tx) error {
+		return tx.Bucket(info.ID[:]).Bucket(indexID[:]).Put([]byte("red"), objects[0].ObjectID[:])
+	}))
+
+	require.NoError(s.store.Truncate(context.Background(), info.Name), "could not truncate collection")
+
+	// The collection and its indexes should still exist.
+	_, err := s.store.Collection(context.Background(), info.ID)
+	require.NoError(err, "collection should exist after truncate")
+
+	require.NoError(s.store.Engine().View(func(tx engine.Tx) error {
+		idx := tx.Bucket(info.ID[:]).Bucket(indexID[:])
+		require.NotNil(idx, "index bucket should exist after truncate")
+		require.Nil(idx.Get([]byte("red")), "index should be cleared after truncate")
+
+		// The entries of the truncated objects are removed from maintained indexes.
+		byName := info.Indexes[1].ID
+		require.Equal(0, bucketKeys(tx.Bucket(info.ID[:]).Bucket(byName[:])), "expected index entries to be removed")
+
+		// The cursor of the truncate job is removed when the job completes.
+		require.Equal(0, bucketKeys(tx.Bucket(store.SystemMaintenance[:])), "expected job cursor to be removed")
+		return nil
+	}))
+
+	// Only the truncated records remain in the change log.
+	w, err := s.store.Watch(context.Background(), info.ID, 0)
+	require.NoError(err)
+	for i := range objects {
+		change := receive(s.T(), w)
+		require.Equal(uint64(len(objects)*2+i+1), change.Sequence)
+		require.Equal(store.Truncated, change.Type)
+	}
+	require.NoError(w.Close())
+
+	// Each object should only have a truncated record remaining.
+	_, c = s.openCollection(info.ID, true)
+	for _, meta := range objects {
+		require.False(c.Exists(meta.ObjectID))
+
+		iter := c.Versions(meta.ObjectID)
+		require.True(iter.Next())
+		kind, err := iter.Object().Kind()
+		require.NoError(err)
+		require.Equal(metadata.TRUNCATED, kind)
+		require.Equal(meta.Version.Scalar, *iter.Parent(), "expected truncated record parent to be latest version")
+		require.False(iter.Next(), "expected only one version after truncate")
+		iter.Release()
+	}
+
+	// Truncated objects are not listed.
+	iter := c.List()
+	defer iter.Release()
+	require.False(iter.Next(), "expected no objects to be listed after truncate")
+	require.NoError(iter.Error())
+
+	// Truncating a missing collection is an error.
+	require.ErrorIs(s.store.Truncate(context.Background(), "does_not_exist"), errors.ErrNoCollection)
+}
+
 //===========================================================================
 // Unit tests without underlying database
 //===========================================================================
@@ -126,6 +408,7 @@ func TestSystemCollections(t *testing.T) {
 		store.SystemCollections,
 		store.SystemReplicas,
 		store.SystemAccessControl,
+		store.SystemMaintenance,
 	}
 
 	require.True(t, sort.SliceIsSorted(collections, func(i, j int) bool {
@@ -162,6 +445,7 @@ func Example_system_names() {
 		store.SystemCollections,
 		store.SystemReplicas,
 		store.SystemAccessControl,
+		store.SystemMaintenance,
 		store.SystemCollectionNames,
 	}
 
@@ -177,6 +461,7 @@ func Example_system_names() {
 	// honu collection (1984-03-19T12:08:28Z)
 	// honu accesslist (1984-03-19T12:08:28Z)
 	// honu networking (1984-03-19T12:08:28Z)
+	// honu jobcursors (1984-03-19T12:08:28Z)
 	// honu colnameidx (1984-03-19T12:08:28Z)
 }
 
@@ -196,15 +481,16 @@ func TestInitializedEmpty(t *testing.T) {
 		store.SystemCollections,
 		store.SystemReplicas,
 		store.SystemAccessControl,
+		store.SystemMaintenance,
 	}
 
 	// Ensure the store is intialized when the database is empty.
-	bdb, err := bbolt.Open(conf.Store.DataPath, 0600, nil)
+	bdb, err := bolt.Open(conf.Store.DataPath, nil)
 	require.NoError(t, err, "could not open bbolt for testing")
 
 	// Helper method to check if a key exists in the database.
-	hasKey := func(bdb *bbolt.DB, key []byte) (exists bool, err error) {
-		err = bdb.View(func(tx *bbolt.Tx) error {
+	hasKey := func(bdb engine.Engine, key []byte) (exists bool, err error) {
+		err = bdb.View(func(tx engine.Tx) error {
 			b := tx.Bucket(store.SystemCollections[:])
 			if b == nil {
 				return nil
@@ -235,7 +521,7 @@ func TestInitializedEmpty(t *testing.T) {
 	// Ensure the collections now exist.
 	for _, collection := range collections {
 		cKey := keys.New(collection, &version)
-		exists, err := hasKey(db.DB(), cKey)
+		exists, err := hasKey(db.Engine(), cKey)
 		require.NoError(t, err, "failed to check if collection exists")
 		require.True(t, exists, "collection %s should exist", collection)
 	}
@@ -244,3 +530,34 @@ func TestInitializedEmpty(t *testing.T) {
 //===========================================================================
 // Fixtures Management
 //===========================================================================
+
+// Creates a new collection with a random name in the store and returns its metadata.
+func (s *honuTestSuite) createCollection() *metadata.Collection {
+	info := &metadata.Collection{
+		Name: "test_" + strings.ToLower(ulid.Make().String()),
+	}
+	s.Require().NoError(s.store.New(context.Background(), info), "could not create test collection")
+	return info
+}
+
+// Begins a transaction and opens the specified collection, registering a rollback of
+// the transaction when the test completes.
+func (s *honuTestSuite) openCollection(id ulid.ULID, readonly bool) (*store.Tx, *store.Collection) {
+	require := s.Require()
+	tx, err := s.store.Begin(context.Background(), &store.TxOptions{ReadOnly: readonly})
+	require.NoError(err, "could not begin transaction")
+	s.T().Cleanup(func() { tx.Rollback() })
+
+	c, err := tx.Collection(id)
+	require.NoError(err, "could not open collection")
+	return tx, c
+}
+
+// Returns the number of keys (including nested buckets) in the bucket.
+func bucketKeys(bkt engine.Bucket) (n int) {
+	bkt.ForEach(func(_, _ []byte) error {
+		n++
+		return nil
+	})
+	return n
+}
@@ -0,0 +1,671 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/object"
+	"go.rtnl.ai/honu/pkg/store/opts"
+	"go.rtnl.ai/ulid"
+)
+
+// The size of the chunks that streamed object data is split into.
+const DefaultChunkSize = 1 << 20
+
+// The key of the nested bucket in each collection that holds chunked object data. The
+// chunks of each object version are stored in a bucket nested under the version key.
+// The key is prefixed like the system IDs so that it is never a valid object key.
+var chunksBucket = []byte{0x00, 0x68, 0x6f, 0x6e, 0x75, 0x04, 0x6f, 0x62, 0x6a, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x73, 0x00}
+
+// The number of chunks that are written in each write transaction while streaming;
+// this bounds both the memory used to buffer the data and how long other writers are
+// blocked by a stream.
+const streamBatchChunks = 8
+
+// The key of the nested bucket in each collection that holds the markers of versions
+// whose chunks are being staged. The chunks of a version are written in several
+// transactions before the version itself is stored; the marker is removed when the
+// version is stored so that chunks that are left behind if the process crashes can
+// be found and removed.
+var stagingBucket = []byte{0x00, 0x68, 0x6f, 0x6e, 0x75, 0x04, 0x73, 0x74, 0x61, 0x67, 0x69, 0x6e, 0x67, 0x00, 0x00, 0x00}
+
+//===========================================================================
+// Streaming Objects
+//===========================================================================
+
+// PutStream creates a new version of the object in the specified collection (by name
+// or ID) whose data is read from the reader, creating the object if it does not exist
+// (the same semantics as Merge). Rather than holding the data in memory, it is split
+// into chunks that are stored separately from the object metadata; the length and
+// SHA-256 checksum of the data are stored in the metadata. Returns the number of bytes
+// read from the reader. The metadata pointer is modified in the same manner as Merge.
+//
+// The chunks are committed in bounded batches of separate write transactions while
+// the version is staged, and the version is only stored once all of the data has been
+// written, so other writers are not blocked by large objects and readers never see a
+// partially written version. If the object is modified while the data is streamed,
+// ErrVersionConflict is returned. The staged chunks are removed if an error occurs;
+// chunks left behind by a crash are removed when the store is opened or by the
+// background collector.
+func (s *Store) PutStream(ctx context.Context, collection any, meta *metadata.Metadata, r io.Reader, wo *opts.WriteOptions) (n int64, err error) {
+	if s.db == nil {
+		return 0, errors.ErrClosed
+	}
+
+	if s.conf.ReadOnly {
+		return 0, errors.ErrReadOnlyDB
+	}
+
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var (
+		st   *stage
+		prev *metadata.Metadata
+	)
+
+	defer func() {
+		if err != nil {
+			st.abort()
+		}
+	}()
+
+	// The version must be assigned before the chunks are written since the chunks are
+	// stored under the version key.
+	if err = s.update(ctx, func(tx engine.Tx) (err error) {
+		var c *Collection
+		if c, err = openCollection(ctx, tx, collection); err != nil {
+			return err
+		}
+
+		if meta.ObjectID.IsZero() {
+			meta.ObjectID = ulid.MakeSecure()
+		}
+		meta.CollectionID = c.ID
+
+		if prev, err = c.latest(meta.ObjectID); err != nil {
+			return err
+		}
+
+		if err = mergeable(prev, wo); err != nil {
+			return err
+		}
+
+		if err = c.assign(meta, prev, metadata.LIVE); err != nil {
+			return err
+		}
+
+		st, err = s.stage(c, keys.New(meta.ObjectID, &meta.Version.Scalar))
+		return err
+	}); err != nil {
+		return 0, err
+	}
+
+	if meta.Chunks, err = st.write(ctx, r); err != nil {
+		return 0, err
+	}
+
+	if err = s.update(ctx, func(tx engine.Tx) (err error) {
+		var c *Collection
+		if c, err = openCollection(ctx, tx, meta.CollectionID); err != nil {
+			return err
+		}
+
+		// The object must not have been modified while the data was streamed.
+		var latest *metadata.Metadata
+		if latest, err = c.latest(meta.ObjectID); err != nil {
+			return err
+		}
+
+		if !sameVersion(prev, latest) {
+			return errors.ErrVersionConflict
+		}
+
+		if err = st.publish(c); err != nil {
+			return err
+		}
+
+		// The chunks replace any deduplicated data described by the metadata, e.g. if
+		// the metadata was read from a previous version of the object.
+		meta.Blob = nil
+		return c.store(meta, nil)
+	}); err != nil {
+		return 0, err
+	}
+
+	st.release()
+	return int64(meta.Chunks.Length), nil
+}
+
+// ApplyStream stores a version of a chunked object that was received from a remote
+// replica in the specified collection (by name or ID), reading the data of the version
+// from the reader; see Collection.Apply for how replicated versions are applied. The
+// data is staged in the same manner as PutStream and must match the length and
+// checksum in the metadata of the object, otherwise ErrChecksum is returned. If the
+// object is not chunked, it is applied with its inline data and the reader is not read.
+func (s *Store) ApplyStream(ctx context.Context, collection any, obj object.Object, r io.Reader) (err error) {
+	if s.db == nil {
+		return errors.ErrClosed
+	}
+
+	if s.conf.ReadOnly {
+		return errors.ErrReadOnlyDB
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	var meta *metadata.Metadata
+	if meta, err = obj.Metadata(); err != nil {
+		return fmt.Errorf("could not parse replicated object metadata: %w", err)
+	}
+
+	if meta.Chunks == nil {
+		return s.update(ctx, func(tx engine.Tx) (err error) {
+			var c *Collection
+			if c, err = openCollection(ctx, tx, collection); err != nil {
+				return err
+			}
+			return c.Apply(obj)
+		})
+	}
+
+	if meta.ObjectID.IsZero() {
+		return errors.ErrMissingObjectID
+	}
+
+	if meta.Version == nil {
+		return errors.ErrMissingVersion
+	}
+
+	var st *stage
+	defer func() {
+		if err != nil {
+			st.abort()
+		}
+	}()
+
+	key := keys.New(meta.ObjectID, &meta.Version.Scalar)
+	if err = s.update(ctx, func(tx engine.Tx) (err error) {
+		var c *Collection
+		if c, err = openCollection(ctx, tx, collection); err != nil {
+			return err
+		}
+
+		if !meta.CollectionID.IsZero() && meta.CollectionID != c.ID {
+			return errors.ErrIDMismatch
+		}
+
+		// If the version is already stored, there is nothing to apply.
+		if c.bkt.Get(key) != nil {
+			return nil
+		}
+
+		st, err = s.stage(c, key)
+		return err
+	}); err != nil || st == nil {
+		return err
+	}
+
+	var chunks *metadata.Chunks
+	if chunks, err = st.write(ctx, r); err != nil {
+		return err
+	}
+
+	if chunks.Length != meta.Chunks.Length || !bytes.Equal(chunks.Checksum, meta.Chunks.Checksum) {
+		return errors.ErrChecksum
+	}
+
+	if err = s.update(ctx, func(tx engine.Tx) (err error) {
+		var c *Collection
+		if c, err = openCollection(ctx, tx, st.collectionID); err != nil {
+			return err
+		}
+
+		if err = st.publish(c); err != nil {
+			return err
+		}
+
+		if err = c.apply(meta, nil); err != nil {
+			return err
+		}
+
+		// The version is not stored if it was superseded by a truncation.
+		if c.bkt.Get(key) == nil {
+			return c.bkt.Bucket(chunksBucket).DeleteBucket(key)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	st.release()
+	return nil
+}
+
+// GetStream returns a reader of the data of the object with the given key; the same
+// rules as Retrieve are used to find the object version. The data of chunked objects
+// is streamed from the collection one chunk at a time and is verified against the
+// checksum in the metadata when the end of the data is reached. Objects with inline
+// data can also be read with GetStream.
+//
+// NOTE: the reader is only valid for the life of the transaction.
+func (c *Collection) GetStream(key keys.Key, ro *opts.ReadOptions) (_ *ObjectReader, err error) {
+	var obj object.Object
+	if obj, err = c.Retrieve(key, ro); err != nil {
+		return nil, err
+	}
+
+	r := &ObjectReader{c: c}
+	if r.meta, err = obj.Metadata(); err != nil {
+		return nil, fmt.Errorf("could not parse object metadata: %w", err)
+	}
+
+	if r.meta.Chunks == nil {
+		if r.chunk, err = obj.Data(); err != nil {
+			return nil, fmt.Errorf("could not read object data: %w", err)
+		}
+		r.done = true
+		return r, nil
+	}
+
+	var bkt engine.Bucket
+	if chunks := c.bkt.Bucket(chunksBucket); chunks != nil {
+		bkt = chunks.Bucket(keys.New(r.meta.ObjectID, &r.meta.Version.Scalar))
+	}
+
+	if bkt == nil {
+		return nil, errors.ErrChecksum
+	}
+
+	r.cursor = bkt.Cursor()
+	r.hash = sha256.New()
+	return r, nil
+}
+
+//===========================================================================
+// Staged Chunks
+//===========================================================================
+
+// A stage tracks the chunks of an object version that are being written in separate
+// transactions before the version is stored. Stages that are in progress are
+// registered with the store so that the collector does not remove their chunks.
+type stage struct {
+	store        *Store
+	collectionID ulid.ULID
+	key          keys.Key
+	chunks       *metadata.Chunks
+	hash         hash.Hash
+}
+
+// Creates the bucket for the chunks of the version and the staging marker in the
+// transaction of the collection and registers the stage with the store.
+func (s *Store) stage(c *Collection, key keys.Key) (st *stage, err error) {
+	var chunks, staging engine.Bucket
+	if chunks, err = c.bkt.CreateBucketIfNotExists(chunksBucket); err != nil {
+		return nil, fmt.Errorf("could not create chunks bucket: %w", err)
+	}
+
+	if _, err = chunks.CreateBucket(key); err != nil {
+		return nil, fmt.Errorf("could not create object chunks bucket: %w", err)
+	}
+
+	if staging, err = c.bkt.CreateBucketIfNotExists(stagingBucket); err != nil {
+		return nil, fmt.Errorf("could not create staging bucket: %w", err)
+	}
+
+	if err = staging.Put(key, binary.BigEndian.AppendUint64(nil, uint64(time.Now().UnixNano()))); err != nil {
+		return nil, fmt.Errorf("could not stage object: %w", err)
+	}
+
+	st = &stage{
+		store:        s,
+		collectionID: c.ID,
+		key:          key,
+		chunks:       &metadata.Chunks{},
+		hash:         sha256.New(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams == nil {
+		s.streams = make(map[string]struct{})
+	}
+	s.streams[st.id()] = struct{}{}
+	return st, nil
+}
+
+// Splits the data from the reader into chunks that are committed in batches of
+// separate write transactions, returning the chunks metadata.
+func (st *stage) write(ctx context.Context, r io.Reader) (_ *metadata.Chunks, err error) {
+	for done := false; !done; {
+		// A new buffer is required for each chunk since bolt references the value until
+		// the transaction is committed.
+		batch := make([][]byte, 0, streamBatchChunks)
+		for len(batch) < streamBatchChunks && !done {
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			buf := make([]byte, DefaultChunkSize)
+
+			var n int
+			n, err = io.ReadFull(r, buf)
+			if n > 0 {
+				batch = append(batch, buf[:n])
+			}
+
+			switch {
+			case err == io.EOF || err == io.ErrUnexpectedEOF:
+				done = true
+			case err != nil:
+				return nil, fmt.Errorf("could not read object data: %w", err)
+			}
+		}
+
+		if len(batch) == 0 {
+			break
+		}
+
+		if err = st.store.update(ctx, func(tx engine.Tx) (err error) {
+			var bkt engine.Bucket
+			if bkt = st.bucket(tx); bkt == nil {
+				return errors.ErrNoCollection
+			}
+
+			for i, chunk := range batch {
+				if err = bkt.Put(chunkKey(st.chunks.Count+uint64(i)), chunk); err != nil {
+					return fmt.Errorf("could not store object chunk: %w", err)
+				}
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+
+		for _, chunk := range batch {
+			st.hash.Write(chunk)
+			st.chunks.Count++
+			st.chunks.Length += uint64(len(chunk))
+		}
+	}
+
+	st.chunks.Checksum = st.hash.Sum(nil)
+	return st.chunks, nil
+}
+
+// Removes the staging marker in the transaction that stores the version.
+func (st *stage) publish(c *Collection) (err error) {
+	staging := c.bkt.Bucket(stagingBucket)
+	if staging == nil || staging.Get(st.key) == nil {
+		return fmt.Errorf("staged chunks of object were removed: %w", errors.ErrChecksum)
+	}
+
+	if err = staging.Delete(st.key); err != nil {
+		return fmt.Errorf("could not remove staging marker: %w", err)
+	}
+	return nil
+}
+
+// Removes the staged chunks and marker after an error and releases the stage. The
+// chunks are removed even if the context of the stream was canceled; if they cannot be
+// removed they are left for the collector.
+func (st *stage) abort() {
+	if st == nil {
+		return
+	}
+	defer st.release()
+
+	if err := st.store.update(context.Background(), func(tx engine.Tx) error {
+		bkt := tx.Bucket(st.collectionID[:])
+		if bkt == nil {
+			return nil
+		}
+		return unstage(bkt, st.key)
+	}); err != nil {
+		log.Warn().Err(err).Hex("key", st.key).Msg("could not remove staged object chunks")
+	}
+}
+
+// Unregisters the stage from the store.
+func (st *stage) release() {
+	st.store.mu.Lock()
+	defer st.store.mu.Unlock()
+	delete(st.store.streams, st.id())
+}
+
+// Returns the bucket that the chunks are staged in or nil if it has been removed.
+func (st *stage) bucket(tx engine.Tx) engine.Bucket {
+	if bkt := tx.Bucket(st.collectionID[:]); bkt != nil {
+		if chunks := bkt.Bucket(chunksBucket); chunks != nil {
+			return chunks.Bucket(st.key)
+		}
+	}
+	return nil
+}
+
+func (st *stage) id() string {
+	return string(st.collectionID[:]) + string(st.key)
+}
+
+// Removes the staged chunks of the version and its staging marker from the collection.
+func unstage(bkt engine.Bucket, key []byte) (err error) {
+	if chunks := bkt.Bucket(chunksBucket); chunks != nil {
+		if err = chunks.DeleteBucket(key); err != nil && !errors.Is(err, engine.ErrBucketNotFound) {
+			return fmt.Errorf("could not remove staged chunks: %w", err)
+		}
+	}
+
+	if staging := bkt.Bucket(stagingBucket); staging != nil {
+		if err = staging.Delete(key); err != nil {
+			return fmt.Errorf("could not remove staging marker: %w", err)
+		}
+	}
+	return nil
+}
+
+// CollectStaged removes the staged chunks of versions that were never stored because
+// the stream that was writing them was interrupted (e.g. by a crash), returning the
+// number of staged versions that were removed. Streams that are in progress are not
+// affected.
+func (s *Store) CollectStaged(ctx context.Context) (collected int, err error) {
+	if s.conf.ReadOnly {
+		return 0, errors.ErrReadOnlyDB
+	}
+
+	var collections []*metadata.Collection
+	if collections, err = s.Collections(ctx); err != nil {
+		return 0, err
+	}
+
+	for _, info := range collections {
+		if err = s.update(ctx, func(tx engine.Tx) (err error) {
+			var bkt, staging engine.Bucket
+			if bkt = tx.Bucket(info.ID[:]); bkt == nil {
+				return nil
+			}
+
+			if staging = bkt.Bucket(stagingBucket); staging == nil {
+				return nil
+			}
+
+			// Collect the orphaned keys first since deleting while iterating with a bolt
+			// cursor will cause keys to be skipped.
+			var orphaned [][]byte
+			s.mu.Lock()
+			err = staging.ForEach(func(key, _ []byte) error {
+				if _, ok := s.streams[string(info.ID[:])+string(key)]; !ok {
+					orphaned = append(orphaned, bytes.Clone(key))
+				}
+				return nil
+			})
+			s.mu.Unlock()
+
+			if err != nil {
+				return err
+			}
+
+			for _, key := range orphaned {
+				if err = unstage(bkt, key); err != nil {
+					return err
+				}
+			}
+			collected += len(orphaned)
+			return nil
+		}); err != nil {
+			return collected, fmt.Errorf("could not collect staged objects in collection %s: %w", info, err)
+		}
+	}
+	return collected, nil
+}
+
+// Returns true if both versions are the same version or both are nil.
+func sameVersion(a, b *metadata.Metadata) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Version.Scalar.Equals(&b.Version.Scalar)
+}
+
+// Opens the collection (by name or ID) in an engine transaction of the store.
+func openCollection(ctx context.Context, tx engine.Tx, identifier any) (c *Collection, err error) {
+	collections := tx.Bucket(SystemCollections[:])
+
+	var collectionID ulid.ULID
+	if collectionID, err = resolveCollection(collections, identifier); err != nil {
+		return nil, err
+	}
+
+	var info *metadata.Collection
+	if info, err = latestCollection(collections, collectionID); err != nil {
+		return nil, err
+	}
+
+	c = &Collection{Collection: *info, ctx: ctx}
+	if c.bkt = tx.Bucket(collectionID[:]); c.bkt == nil {
+		return nil, errors.ErrRepairCollection
+	}
+	return c, nil
+}
+
+func chunkKey(i uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, i)
+	return key
+}
+
+//===========================================================================
+// Object Reader
+//===========================================================================
+
+// ObjectReader streams the data of an object from the collection. It implements both
+// io.Reader and io.WriterTo so that it can be used with io.Copy without buffering.
+type ObjectReader struct {
+	c       *Collection
+	meta    *metadata.Metadata
+	cursor  engine.Cursor
+	chunk   []byte
+	hash    hash.Hash
+	read    uint64
+	started bool
+	done    bool
+	err     error
+}
+
+var _ io.Reader = (*ObjectReader)(nil)
+var _ io.WriterTo = (*ObjectReader)(nil)
+
+// Metadata returns the metadata of the object version being read.
+func (r *ObjectReader) Metadata() *metadata.Metadata {
+	return r.meta
+}
+
+// Read the next bytes of the object data into p.
+func (r *ObjectReader) Read(p []byte) (n int, err error) {
+	for len(r.chunk) == 0 {
+		if err = r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n = copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// WriteTo writes the remaining object data to w one chunk at a time.
+func (r *ObjectReader) WriteTo(w io.Writer) (n int64, err error) {
+	for {
+		for len(r.chunk) == 0 {
+			if err = r.next(); err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				return n, err
+			}
+		}
+
+		var m int
+		m, err = w.Write(r.chunk)
+		r.chunk = r.chunk[m:]
+		n += int64(m)
+
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// Loads the next chunk from the cursor, verifying the length and checksum of the data
+// once all of the chunks have been read.
+func (r *ObjectReader) next() error {
+	if r.err != nil {
+		return r.err
+	}
+
+	if r.done {
+		r.err = io.EOF
+		return r.err
+	}
+
+	// Stop streaming if the transaction the object is read in was canceled.
+	if err := r.c.canceled(); err != nil {
+		r.err = err
+		return r.err
+	}
+
+	var key []byte
+	if !r.started {
+		key, r.chunk = r.cursor.First()
+		r.started = true
+	} else {
+		key, r.chunk = r.cursor.Next()
+	}
+
+	if key == nil {
+		r.done = true
+		if r.read != r.meta.Chunks.Length || !bytes.Equal(r.hash.Sum(nil), r.meta.Chunks.Checksum) {
+			r.err = errors.ErrChecksum
+			return r.err
+		}
+		r.err = io.EOF
+		return r.err
+	}
+
+	r.hash.Write(r.chunk)
+	r.read += uint64(len(r.chunk))
+	return nil
+}
@@ -0,0 +1,387 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+	"go.rtnl.ai/honu/pkg/config"
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/region"
+	"go.rtnl.ai/honu/pkg/store"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/lamport"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/object"
+	"go.rtnl.ai/honu/pkg/store/opts"
+	"go.rtnl.ai/ulid"
+)
+
+func (s *honuTestSuite) TestStream() {
+	require := s.Require()
+	info := s.createCollection()
+
+	data := make([]byte, 2*store.DefaultChunkSize+store.DefaultChunkSize/2)
+	_, err := rand.Read(data)
+	require.NoError(err)
+	checksum := sha256.Sum256(data)
+
+	meta := &metadata.Metadata{MIME: "application/octet-stream"}
+	n, err := s.store.PutStream(context.Background(), info.ID, meta, bytes.NewReader(data), nil)
+	require.NoError(err, "could not put object stream")
+	require.Equal(int64(len(data)), n)
+
+	require.NotNil(meta.Chunks)
+	require.Equal(uint64(len(data)), meta.Chunks.Length)
+	require.Equal(uint64(3), meta.Chunks.Count)
+	require.Equal(checksum[:], meta.Chunks.Checksum)
+
+	_, c := s.openCollection(info.ID, true)
+
+	// The data is not stored inline with the object.
+	obj, err := c.Retrieve(keys.New(meta.ObjectID, nil), nil)
+	require.NoError(err)
+	inline, err := obj.Data()
+	require.NoError(err)
+	require.Empty(inline)
+
+	// The data can be streamed with a reader.
+	r, err := c.GetStream(keys.New(meta.ObjectID, nil), nil)
+	require.NoError(err)
+	require.Equal(meta.Chunks, r.Metadata().Chunks)
+
+	actual, err := io.ReadAll(r)
+	require.NoError(err)
+	require.Equal(data, actual)
+
+	// Or copied to a writer.
+	r, err = c.GetStream(keys.New(meta.ObjectID, nil), nil)
+	require.NoError(err)
+
+	buf := &bytes.Buffer{}
+	n, err = io.Copy(buf, r)
+	require.NoError(err)
+	require.Equal(int64(len(data)), n)
+	require.Equal(data, buf.Bytes())
+
+	// Missing objects are not found.
+	_, err = c.GetStream(keys.New(ulid.MakeSecure(), nil), nil)
+	require.ErrorIs(err, errors.ErrNotFound)
+}
+
+func (s *honuTestSuite) TestStreamVersions() {
+	require := s.Require()
+	info := s.createCollection()
+
+	meta := &metadata.Metadata{}
+	_, err := s.store.PutStream(context.Background(), info.ID, meta, bytes.NewReader([]byte("first")), nil)
+	require.NoError(err)
+	v1 := keys.New(meta.ObjectID, &meta.Version.Scalar)
+
+	// An empty stream is stored as an empty object.
+	_, err = s.store.PutStream(context.Background(), info.Name, meta, bytes.NewReader(nil), nil)
+	require.NoError(err)
+	require.Equal(uint64(0), meta.Chunks.Count)
+	v2 := keys.New(meta.ObjectID, &meta.Version.Scalar)
+
+	// Write options are checked against the latest version.
+	_, err = s.store.PutStream(context.Background(), info.ID, meta, bytes.NewReader(nil), &opts.WriteOptions{NoOverwrite: true})
+	require.ErrorIs(err, errors.ErrAlreadyExists)
+
+	// Inline versions can also be streamed and replace the chunks.
+	tx, c := s.openCollection(info.ID, false)
+	require.NoError(c.Update(meta, []byte("third"), nil))
+	require.Nil(meta.Chunks)
+
+	for key, expected := range map[string]string{string(v1): "first", string(v2): "", string(meta.Key()): "third"} {
+		r, err := c.GetStream(keys.Key(key), nil)
+		require.NoError(err)
+
+		actual, err := io.ReadAll(r)
+		require.NoError(err)
+		require.Equal(expected, string(actual))
+	}
+	require.NoError(tx.Commit())
+
+	// Destroying the object removes the chunks of every version.
+	require.Equal(2, s.countChunkBuckets(info.ID))
+
+	tx, c = s.openCollection(info.ID, false)
+	require.NoError(c.Destroy(meta.Key()))
+	require.NoError(tx.Commit())
+	require.Equal(0, s.countChunkBuckets(info.ID))
+}
+
+func (s *honuTestSuite) TestStreamDeduplicated() {
+	require := s.Require()
+	info := s.createCollection()
+
+	// Reuse the metadata of a version whose payload is deduplicated.
+	tx, c := s.openCollection(info.ID, false)
+	meta := &metadata.Metadata{}
+	require.NoError(c.Create(meta, []byte("inline"), nil))
+	require.NoError(tx.Commit())
+
+	blob := meta.Blob
+	require.NotEmpty(blob)
+	require.Equal(map[string]uint64{string(blob): 1}, s.blobRefs(info.ID))
+
+	_, err := s.store.PutStream(context.Background(), info.ID, meta, bytes.NewReader([]byte("streamed")), nil)
+	require.NoError(err)
+	require.Empty(meta.Blob)
+
+	// The streamed version does not refer to the payload of the previous version.
+	require.Equal(map[string]uint64{string(blob): 1}, s.blobRefs(info.ID))
+
+	tx, c = s.openCollection(info.ID, true)
+	obj, err := c.Retrieve(meta.Key(), nil)
+	require.NoError(err)
+
+	stored, err := obj.Metadata()
+	require.NoError(err)
+	require.Empty(stored.Blob)
+	require.NotNil(stored.Chunks)
+
+	r, err := c.GetStream(meta.Key(), nil)
+	require.NoError(err)
+	data, err := io.ReadAll(r)
+	require.NoError(err)
+	require.Equal("streamed", string(data))
+	require.NoError(tx.Rollback())
+
+	// Destroying the object releases the payload.
+	tx, c = s.openCollection(info.ID, false)
+	require.NoError(c.Destroy(meta.Key()))
+	require.NoError(tx.Commit())
+	require.Empty(s.blobRefs(info.ID))
+	require.Equal(0, s.countBlobs(info.ID))
+}
+
+func (s *honuTestSuite) TestStreamCorrupted() {
+	require := s.Require()
+	info := s.createCollection()
+
+	meta := &metadata.Metadata{}
+	_, err := s.store.PutStream(context.Background(), info.ID, meta, bytes.NewReader([]byte("the quick brown fox")), nil)
+	require.NoError(err)
+
+	// Corrupt the stored chunk directly in the database.
+	require.NoError(s.store.Engine().Update(func(tx engine.Tx) error {
+		chunks := s.chunksBucket(tx, info.ID)
+		bkt := chunks.Bucket(keys.New(meta.ObjectID, &meta.Version.Scalar))
+		return bkt.Put([]byte{0, 0, 0, 0, 0, 0, 0, 0}, []byte("the quick brown cat"))
+	}))
+
+	_, c := s.openCollection(info.ID, true)
+	r, err := c.GetStream(meta.Key(), nil)
+	require.NoError(err)
+
+	_, err = io.ReadAll(r)
+	require.ErrorIs(err, errors.ErrChecksum)
+}
+
+func (s *honuTestSuite) TestStreamStaged() {
+	require := s.Require()
+	info := s.createCollection()
+
+	// The version is not visible while its chunks are staged.
+	meta := &metadata.Metadata{}
+	data := bytes.Repeat([]byte("x"), 3*store.DefaultChunkSize)
+	r := &hookReader{r: bytes.NewReader(data), hook: func() {
+		tx, c := s.openCollection(info.ID, true)
+		defer tx.Rollback()
+		require.False(c.Has(meta.ObjectID))
+		require.Equal(1, s.countChunkBuckets(info.ID))
+	}}
+
+	_, err := s.store.PutStream(context.Background(), info.ID, meta, r, nil)
+	require.NoError(err)
+	require.True(r.called)
+	require.Equal(1, s.countChunkBuckets(info.ID))
+
+	// If the object is modified while the data is streamed, the stream is rejected and
+	// its staged chunks are removed.
+	r = &hookReader{r: bytes.NewReader(data), hook: func() {
+		tx, c := s.openCollection(info.ID, false)
+		require.NoError(c.Update(meta, []byte("concurrent"), nil))
+		require.NoError(tx.Commit())
+	}}
+
+	stale := &metadata.Metadata{ObjectID: meta.ObjectID}
+	_, err = s.store.PutStream(context.Background(), info.ID, stale, r, nil)
+	require.ErrorIs(err, errors.ErrVersionConflict)
+	require.Equal(1, s.countChunkBuckets(info.ID))
+
+	// Staged chunks are removed if the data cannot be read.
+	failed := &metadata.Metadata{}
+	_, err = s.store.PutStream(context.Background(), info.ID, failed, io.MultiReader(bytes.NewReader(data), iotest.ErrReader(io.ErrClosedPipe)), nil)
+	require.ErrorIs(err, io.ErrClosedPipe)
+	require.Equal(1, s.countChunkBuckets(info.ID))
+
+	tx, c := s.openCollection(info.ID, true)
+	require.False(c.Has(failed.ObjectID))
+	require.NoError(tx.Rollback())
+
+	// Streams cannot be written to collections that do not exist.
+	_, err = s.store.PutStream(context.Background(), ulid.Make(), &metadata.Metadata{}, bytes.NewReader(data), nil)
+	require.ErrorIs(err, errors.ErrNoCollection)
+}
+
+func (s *honuTestSuite) TestApplyStream() {
+	require := s.Require()
+	source, target := s.createCollection(), s.createCollection()
+
+	data := make([]byte, store.DefaultChunkSize+store.DefaultChunkSize/3)
+	_, err := rand.Read(data)
+	require.NoError(err)
+
+	meta := &metadata.Metadata{}
+	_, err = s.store.PutStream(context.Background(), source.ID, meta, bytes.NewReader(data), nil)
+	require.NoError(err)
+
+	tx, c := s.openCollection(source.ID, true)
+	obj, err := c.Retrieve(meta.Key(), nil)
+	require.NoError(err)
+
+	// The version cannot be applied without its data.
+	replicated := func(obj object.Object) object.Object {
+		meta, err := obj.Metadata()
+		require.NoError(err)
+		meta.CollectionID = target.ID
+		obj, err = object.Marshal(meta, nil)
+		require.NoError(err)
+		return obj
+	}(obj)
+	require.NoError(tx.Rollback())
+
+	tx, c = s.openCollection(target.ID, false)
+	require.ErrorIs(c.Apply(replicated), errors.ErrChunkedObject)
+	require.NoError(tx.Rollback())
+
+	// Data that does not match the checksum of the version is rejected.
+	corrupted := bytes.Clone(data)
+	corrupted[0] ^= 0xff
+	err = s.store.ApplyStream(context.Background(), target.ID, replicated, bytes.NewReader(corrupted))
+	require.ErrorIs(err, errors.ErrChecksum)
+
+	tx, c = s.openCollection(target.ID, true)
+	require.False(c.Has(meta.ObjectID))
+	require.NoError(tx.Rollback())
+
+	// The data is replicated with the version.
+	require.NoError(s.store.ApplyStream(context.Background(), target.ID, replicated, bytes.NewReader(data)))
+	require.NoError(s.store.ApplyStream(context.Background(), target.ID, replicated, bytes.NewReader(nil)), "expected applying the same version to be a no-op")
+	require.Equal(1, s.countChunkBuckets(target.ID))
+
+	_, c = s.openCollection(target.ID, true)
+	r, err := c.GetStream(meta.Key(), nil)
+	require.NoError(err)
+	actual, err := io.ReadAll(r)
+	require.NoError(err)
+	require.Equal(data, actual)
+	require.Equal(meta.Version.Scalar, r.Metadata().Version.Scalar)
+}
+
+func TestStreamInterrupted(t *testing.T) {
+	lamport.SetProcessID(8)
+	region.SetProcessRegion(region.TESTING)
+
+	conf := config.Config{
+		PID: uint32(8),
+		Store: config.StoreConfig{
+			DataPath:    filepath.Join(t.TempDir(), "honu-test.db"),
+			Concurrency: 16,
+		},
+	}
+
+	db, err := store.Open(conf)
+	require.NoError(t, err, "could not open store")
+
+	info := &metadata.Collection{Name: "streams"}
+	require.NoError(t, db.New(context.Background(), info))
+
+	// Close the store while the data is being streamed so that the staged chunks are
+	// left behind as though the process crashed.
+	r := &hookReader{r: bytes.NewReader(make([]byte, store.DefaultChunkSize)), hook: func() {
+		require.NoError(t, db.Close())
+	}}
+	_, err = db.PutStream(context.Background(), info.ID, &metadata.Metadata{}, r, nil)
+	require.ErrorIs(t, err, errors.ErrClosed)
+
+	chunks := func(db *store.Store) (n int) {
+		require.NoError(t, db.Engine().View(func(tx engine.Tx) error {
+			return tx.Bucket(info.ID[:]).ForEach(func(key, value []byte) error {
+				if value == nil && bytes.Contains(key, []byte("chunks")) {
+					n = bucketKeys(tx.Bucket(info.ID[:]).Bucket(key))
+				}
+				return nil
+			})
+		}))
+		return n
+	}
+
+	conf.Store.ReadOnly = true
+	db, err = store.Open(conf)
+	require.NoError(t, err, "could not open store")
+	require.Equal(t, 1, chunks(db))
+	require.NoError(t, db.Close())
+
+	// Opening the store removes the orphaned chunks.
+	conf.Store.ReadOnly = false
+	db, err = store.Open(conf)
+	require.NoError(t, err, "could not reopen store")
+	defer db.Close()
+	require.Equal(t, 0, chunks(db))
+
+	collected, err := db.CollectStaged(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, collected)
+}
+
+// Calls the hook the first time that data is read after the first chunk.
+type hookReader struct {
+	r      io.Reader
+	n      int
+	hook   func()
+	called bool
+}
+
+func (h *hookReader) Read(p []byte) (n int, err error) {
+	if h.n >= store.DefaultChunkSize && !h.called {
+		h.called = true
+		h.hook()
+	}
+
+	n, err = h.r.Read(p)
+	h.n += n
+	return n, err
+}
+
+// Returns the nested bucket in the collection that holds the chunked object data.
+func (s *honuTestSuite) chunksBucket(tx engine.Tx, collectionID ulid.ULID) (chunks engine.Bucket) {
+	cursor := tx.Bucket(collectionID[:]).Cursor()
+	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		if value == nil && bytes.Contains(key, []byte("chunks")) {
+			chunks = tx.Bucket(collectionID[:]).Bucket(key)
+		}
+	}
+	s.Require().NotNil(chunks, "could not find chunks bucket")
+	return chunks
+}
+
+// Counts the number of object versions that have chunked data in the collection.
+func (s *honuTestSuite) countChunkBuckets(collectionID ulid.ULID) (n int) {
+	s.Require().NoError(s.store.Engine().View(func(tx engine.Tx) error {
+		// The chunks bucket only contains a nested bucket for each object version.
+		n = bucketKeys(s.chunksBucket(tx, collectionID))
+		return nil
+	}))
+	return n
+}
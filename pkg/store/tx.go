@@ -2,26 +2,33 @@ package store
 
 import (
 	"bytes"
+	"context"
+	"time"
 
 	"github.com/rs/zerolog/log"
-	"go.etcd.io/bbolt"
-	berrors "go.etcd.io/bbolt/errors"
 	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/lamport"
 	"go.rtnl.ai/honu/pkg/store/metadata"
 	"go.rtnl.ai/honu/pkg/store/object"
+	"go.rtnl.ai/honu/pkg/store/opts"
 	"go.rtnl.ai/ulid"
 )
 
 type Tx struct {
-	tx          *bbolt.Tx
+	ctx         context.Context
+	tx          engine.Tx
 	opts        *TxOptions
+	feed        *feed
 	closed      bool
+	managed     bool
 	rollbackErr error
 	commitErr   error
 
 	// Collections metadata bucket and names index.
-	cmbkt   *bbolt.Bucket
-	cmnames *bbolt.Bucket
+	cmbkt   engine.Bucket
+	cmnames engine.Bucket
 
 	// Cache of opened buckets for collections.
 	collections map[ulid.ULID]*Collection
@@ -30,20 +37,103 @@ type Tx struct {
 type TxOptions struct {
 	ReadOnly    bool
 	ClosedError bool
+
+	// Pin a read-only transaction to a wall-clock time; objects and collections are
+	// resolved to their latest version that was created at or before this time.
+	AsOf time.Time
+
+	// Pin a read-only transaction to a version; objects and collections are resolved
+	// to their latest version that happens before or is equal to this version.
+	AsOfVersion *lamport.Scalar
+}
+
+// Returns true if the transaction is pinned to a point in time or version.
+func (o *TxOptions) pinned() bool {
+	return o != nil && (!o.AsOf.IsZero() || o.AsOfVersion != nil)
+}
+
+// Returns the time that expirations are checked against: the AsOf time if the options
+// are pinned to a wall-clock time, otherwise the current time.
+func (o *TxOptions) now() time.Time {
+	if o == nil || o.AsOf.IsZero() {
+		return time.Now()
+	}
+	return o.AsOf
+}
+
+// Returns true if the version is visible at the point in time the options are pinned
+// to. If both a time and a version are specified the version must satisfy both.
+func (o *TxOptions) visible(vers *metadata.Version) bool {
+	if !o.pinned() {
+		return true
+	}
+
+	if vers == nil {
+		return false
+	}
+
+	if o.AsOfVersion != nil && vers.Scalar.After(o.AsOfVersion) {
+		return false
+	}
+
+	if !o.AsOf.IsZero() && vers.Created.After(o.AsOf) {
+		return false
+	}
+
+	return true
+}
+
+// Returns true if the object version is visible at the point in time the options are
+// pinned to; the object metadata is only decoded if the AsOf time is specified.
+func (o *TxOptions) visibleObject(key keys.Key, obj object.Object) bool {
+	if !o.pinned() {
+		return true
+	}
+
+	if o.AsOf.IsZero() {
+		vers := key.Version()
+		return !vers.After(o.AsOfVersion)
+	}
+
+	meta, err := obj.Metadata()
+	if err != nil {
+		return false
+	}
+	return o.visible(meta.Version)
 }
 
 // Commits the transaction if it is writeable. If the transaction is read-only, then
 // this is a no-op and returns nil (unlike bolt which will return an error). Commit
 // can also be called multiple times safely without an error being returned.
+//
+// If the context the transaction was started with has been canceled, the transaction
+// is rolled back instead and the error of the context is returned.
+//
+// Transactions passed to Store.Batch are managed by the batch and cannot be committed.
 func (t *Tx) Commit() error {
+	if t.managed {
+		return errors.ErrManagedTx
+	}
+
 	if t.writeable() {
+		if err := t.ctx.Err(); err != nil {
+			t.Rollback()
+			t.commitErr = err
+			return t.commitErr
+		}
+
 		t.commitErr = t.tx.Commit()
 		t.closed = true
 
-		if !t.opts.ClosedError && errors.Is(t.commitErr, berrors.ErrTxClosed) {
+		if !t.opts.ClosedError && errors.Is(t.commitErr, engine.ErrTxClosed) {
 			t.commitErr = nil
 		}
 
+		// Wake any watchers so that they can read the committed changes.
+		if t.commitErr == nil && t.feed != nil {
+			t.feed.notify()
+		}
+
 		// Clear the cached collections after a commit.
 		t.collections = nil
 		t.opts = nil
@@ -54,13 +144,18 @@ func (t *Tx) Commit() error {
 // Rollback the transaction if it is still open. If the transaction has already been
 // committed or rolled back, then this is a no-op and returns nil (unlike bolt which
 // will return an error). Rollback can also be called multiple times safely without
-// an error being returned.
+// an error being returned. Transactions passed to Store.Batch cannot be rolled back;
+// return an error from the batch function instead.
 func (t *Tx) Rollback() error {
+	if t.managed {
+		return errors.ErrManagedTx
+	}
+
 	if !t.closed {
 		t.rollbackErr = t.tx.Rollback()
 		t.closed = true
 
-		if !t.opts.ClosedError && errors.Is(t.rollbackErr, berrors.ErrTxClosed) {
+		if !t.opts.ClosedError && errors.Is(t.rollbackErr, engine.ErrTxClosed) {
 			t.rollbackErr = nil
 		}
 
@@ -79,6 +174,10 @@ func (t *Tx) Collection(identifier any) (c *Collection, err error) {
 		return nil, errors.ErrTxClosed
 	}
 
+	if err = t.ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Initialize the collections management system if it hasn't already been.
 	if err = t.initialize(); err != nil {
 		return nil, err
@@ -111,18 +210,24 @@ func (t *Tx) Collection(identifier any) (c *Collection, err error) {
 
 	// Initialize the collection and cache it
 	c = &Collection{
+		ctx: t.ctx,
 		bkt: t.tx.Bucket(collectionID[:]),
 	}
 
+	if t.opts.pinned() {
+		c.pin = t.opts
+	}
+
 	if c.bkt == nil {
 		log.Error().Str("collectionID", collectionID.String()).Msg("collection bucket does not exist")
 		return nil, errors.ErrRepairCollection
 	}
 
 	// Get the latest metadata for the collection.
+	prefix := keys.New(collectionID, nil).ObjectPrefix()
 	cursor := t.cmbkt.Cursor()
-	key, meta := cursor.Seek(collectionID[:])
-	if key != nil && bytes.HasPrefix(key, collectionID[:]) {
+	key, meta := cursor.Seek(prefix)
+	if key != nil && bytes.HasPrefix(key, prefix) {
 		c.Collection = metadata.Collection{}
 		if err = object.UnmarshalSystem(object.Object(meta), &c.Collection); err != nil {
 			log.Error().Err(err).Msg("failed to unmarshal collection metadata")
@@ -133,28 +238,103 @@ func (t *Tx) Collection(identifier any) (c *Collection, err error) {
 		return nil, errors.ErrRepairCollection
 	}
 
+	// If the transaction is pinned, find the latest collection metadata that is visible
+	// at the pinned time; if none is visible the collection did not exist at that time.
+	for !t.opts.visible(c.Collection.Version) {
+		if key, meta = cursor.Next(); key == nil || !bytes.HasPrefix(key, prefix) {
+			return nil, errors.ErrNoCollection
+		}
+
+		c.Collection = metadata.Collection{}
+		if err = object.UnmarshalSystem(object.Object(meta), &c.Collection); err != nil {
+			log.Error().Err(err).Msg("failed to unmarshal collection metadata")
+			return nil, errors.ErrRepairCollection
+		}
+	}
+
 	t.collections[collectionID] = c
 	return c, nil
-
 }
 
 // Has returns true if the object with the specified ID has any version (including
 // tombstones) stored in the specified collection. See Exists() for checking if the
 // latest version of the object is not a tombstone.
-func (t *Tx) Has(collection ulid.ULID, id ulid.ULID) (exists bool, err error) {
-	if t.closed {
-		return false, errors.ErrTxClosed
+func (t *Tx) Has(collection any, id ulid.ULID) (exists bool, err error) {
+	var c *Collection
+	if c, err = t.Collection(collection); err != nil {
+		return false, err
 	}
-	panic("not implemented yet")
+	return c.Has(id), nil
 }
 
 // Exists returns true if the object with the specified ID exists in the specified
 // collection and the latest version is not a tombstone.
-func (t *Tx) Exists(collection ulid.ULID, id ulid.ULID) (exists bool, err error) {
+func (t *Tx) Exists(collection any, id ulid.ULID) (exists bool, err error) {
+	var c *Collection
+	if c, err = t.Collection(collection); err != nil {
+		return false, err
+	}
+	return c.Exists(id), nil
+}
+
+//===========================================================================
+// Multi-Collection Writes
+//===========================================================================
+
+// The write methods on the transaction allow objects in several collections to be
+// written atomically: all of the writes are applied in the same bolt transaction so
+// versions are assigned and any index maintenance is performed together and either all
+// of the writes are committed or none of them are. If any write returns an error, the
+// caller should Rollback the transaction rather than committing a partial set of writes.
+
+// Create a new object in the specified collection; see Collection.Create for details.
+func (t *Tx) Create(collection any, meta *metadata.Metadata, data []byte, wo *opts.WriteOptions) (err error) {
+	var c *Collection
+	if c, err = t.writeCollection(collection); err != nil {
+		return err
+	}
+	return c.Create(meta, data, wo)
+}
+
+// Update an object in the specified collection; see Collection.Update for details.
+func (t *Tx) Update(collection any, meta *metadata.Metadata, data []byte, wo *opts.WriteOptions) (err error) {
+	var c *Collection
+	if c, err = t.writeCollection(collection); err != nil {
+		return err
+	}
+	return c.Update(meta, data, wo)
+}
+
+// Merge an object into the specified collection; see Collection.Merge for details.
+func (t *Tx) Merge(collection any, meta *metadata.Metadata, data []byte, wo *opts.WriteOptions) (err error) {
+	var c *Collection
+	if c, err = t.writeCollection(collection); err != nil {
+		return err
+	}
+	return c.Merge(meta, data, wo)
+}
+
+// Delete an object from the specified collection; see Collection.Delete for details.
+func (t *Tx) Delete(collection any, key keys.Key, wo *opts.WriteOptions) (err error) {
+	var c *Collection
+	if c, err = t.writeCollection(collection); err != nil {
+		return err
+	}
+	return c.Delete(key, wo)
+}
+
+// Opens the collection for a write, returning an error if the transaction is closed
+// or read-only.
+func (t *Tx) writeCollection(collection any) (*Collection, error) {
 	if t.closed {
-		return false, errors.ErrTxClosed
+		return nil, errors.ErrTxClosed
+	}
+
+	if t.opts.ReadOnly {
+		return nil, errors.ErrReadOnlyTx
 	}
-	panic("not implemented yet")
+
+	return t.Collection(collection)
 }
 
 // writeable returns true if the transaction is not read-only and has not been closed.
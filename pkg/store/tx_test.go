@@ -0,0 +1,425 @@
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store"
+	"go.rtnl.ai/honu/pkg/store/iterator"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/lamport"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/opts"
+	"go.rtnl.ai/ulid"
+)
+
+func (s *honuTestSuite) TestPointInTime() {
+	require := s.Require()
+	info := s.createCollection()
+
+	// Write a history of objects, recording the time and version after each write.
+	write := func(fn func(c *store.Collection)) time.Time {
+		tx, c := s.openCollection(info.ID, false)
+		fn(c)
+		require.NoError(tx.Commit())
+
+		// Ensure the next write happens after the recorded timestamp.
+		ts := time.Now()
+		time.Sleep(2 * time.Millisecond)
+		return ts
+	}
+
+	alpha, bravo := &metadata.Metadata{}, &metadata.Metadata{}
+	t1 := write(func(c *store.Collection) {
+		require.NoError(c.Create(alpha, []byte("alpha-1"), nil))
+	})
+	v1 := alpha.Version.Scalar
+
+	t2 := write(func(c *store.Collection) {
+		require.NoError(c.Update(alpha, []byte("alpha-2"), nil))
+		require.NoError(c.Create(bravo, []byte("bravo-1"), nil))
+	})
+	v2 := alpha.Version.Scalar
+
+	// Charlie expires shortly after it is created and before the collection is read.
+	charlie := &metadata.Metadata{Expires: time.Now().Add(100 * time.Millisecond)}
+	t3 := write(func(c *store.Collection) {
+		require.NoError(c.Delete(alpha.Key(), nil))
+		require.NoError(c.Update(bravo, []byte("bravo-2"), nil))
+		require.NoError(c.Create(charlie, []byte("charlie-1"), nil))
+	})
+	time.Sleep(time.Until(charlie.Expires))
+
+	// Returns the data of all the objects returned by the iterator.
+	collect := func(iter iterator.Iterator) (data []string) {
+		defer iter.Release()
+		for iter.Next() {
+			d, err := iter.Object().Data()
+			require.NoError(err)
+			data = append(data, string(d))
+		}
+		require.NoError(iter.Error())
+		return data
+	}
+
+	list := func(c *store.Collection) []string {
+		return collect(c.List())
+	}
+
+	// Returns the data of the latest version of the object or the error.
+	retrieve := func(c *store.Collection, meta *metadata.Metadata) (string, error) {
+		obj, err := c.Retrieve(keys.New(meta.ObjectID, nil), nil)
+		if err != nil {
+			return "", err
+		}
+		d, err := obj.Data()
+		require.NoError(err)
+		return string(d), nil
+	}
+
+	pinned := func(opts *store.TxOptions) *store.Collection {
+		opts.ReadOnly = true
+		tx, err := s.store.Begin(context.Background(), opts)
+		require.NoError(err)
+		s.T().Cleanup(func() { tx.Rollback() })
+
+		c, err := tx.Collection(info.ID)
+		require.NoError(err)
+		return c
+	}
+
+	s.Run("AsOfTime", func() {
+		c := pinned(&store.TxOptions{AsOf: t1})
+		require.Equal([]string{"alpha-1"}, list(c))
+		d, err := retrieve(c, alpha)
+		require.NoError(err)
+		require.Equal("alpha-1", d)
+		_, err = retrieve(c, bravo)
+		require.ErrorIs(err, errors.ErrNotFound)
+		require.False(c.Has(bravo.ObjectID))
+
+		c = pinned(&store.TxOptions{AsOf: t2})
+		require.ElementsMatch([]string{"alpha-2", "bravo-1"}, list(c))
+		d, err = retrieve(c, alpha)
+		require.NoError(err)
+		require.Equal("alpha-2", d)
+
+		c = pinned(&store.TxOptions{AsOf: t3})
+		require.ElementsMatch([]string{"bravo-2", "charlie-1"}, list(c))
+		_, err = retrieve(c, alpha)
+		require.ErrorIs(err, errors.ErrNotFound)
+		require.True(c.Has(alpha.ObjectID))
+		require.False(c.Exists(alpha.ObjectID))
+
+		// Expirations are checked against the pinned time rather than the current time.
+		d, err = retrieve(c, charlie)
+		require.NoError(err)
+		require.Equal("charlie-1", d)
+		require.True(c.Exists(charlie.ObjectID))
+	})
+
+	s.Run("Query", func() {
+		c := pinned(&store.TxOptions{AsOf: t2})
+		require.ElementsMatch([]string{"alpha-2", "bravo-1"}, collect(c.Query()))
+	})
+
+	s.Run("AsOfVersion", func() {
+		c := pinned(&store.TxOptions{AsOfVersion: &v1})
+		d, err := retrieve(c, alpha)
+		require.NoError(err)
+		require.Equal("alpha-1", d)
+
+		// A version created after the pinned version does not exist.
+		_, err = c.Retrieve(keys.New(alpha.ObjectID, &v2), nil)
+		require.ErrorIs(err, errors.ErrVersionNotFound)
+
+		c = pinned(&store.TxOptions{AsOfVersion: &v2})
+		d, err = retrieve(c, alpha)
+		require.NoError(err)
+		require.Equal("alpha-2", d)
+	})
+
+	s.Run("Reverse", func() {
+		c := pinned(&store.TxOptions{AsOf: t2})
+		iter := c.List()
+		defer iter.Release()
+
+		var data []string
+		for iter.Prev() {
+			d, err := iter.Object().Data()
+			require.NoError(err)
+			data = append(data, string(d))
+		}
+		require.ElementsMatch([]string{"alpha-2", "bravo-1"}, data)
+	})
+
+	s.Run("BeforeCollection", func() {
+		tx, err := s.store.Begin(context.Background(), &store.TxOptions{ReadOnly: true, AsOfVersion: &lamport.Scalar{}})
+		require.NoError(err)
+		defer tx.Rollback()
+
+		_, err = tx.Collection(info.ID)
+		require.ErrorIs(err, errors.ErrNoCollection)
+	})
+
+	s.Run("Writeable", func() {
+		_, err := s.store.Begin(context.Background(), &store.TxOptions{AsOf: t1})
+		require.ErrorIs(err, errors.ErrPinnedWriteTx)
+	})
+
+	// The unpinned transaction should see the latest version of the live objects.
+	tx, c := s.openCollection(info.ID, true)
+	defer tx.Rollback()
+	require.Equal([]string{"bravo-2"}, list(c))
+	require.Equal([]string{"bravo-2"}, collect(c.Query()))
+
+	_, err := retrieve(c, charlie)
+	require.ErrorIs(err, errors.ErrNotFound)
+	require.False(c.Exists(charlie.ObjectID))
+	require.NoError(tx.Rollback())
+
+	// Drop the collection so the expired object is not reaped by other tests.
+	require.NoError(s.store.Drop(context.Background(), info.ID))
+}
+
+func (s *honuTestSuite) TestTxWrites() {
+	require := s.Require()
+	datasets, manifests := s.createCollection(), s.createCollection()
+
+	// Write a record and its manifest entry together.
+	tx, err := s.store.Begin(context.Background(), nil)
+	require.NoError(err)
+
+	record, entry := &metadata.Metadata{}, &metadata.Metadata{}
+	require.NoError(tx.Create(datasets.ID, record, []byte("record"), nil))
+	require.NoError(tx.Create(manifests.Name, entry, []byte("entry"), nil))
+	require.NoError(tx.Commit())
+
+	tx, err = s.store.Begin(context.Background(), &store.TxOptions{ReadOnly: true})
+	require.NoError(err)
+
+	exists, err := tx.Exists(datasets.ID, record.ObjectID)
+	require.NoError(err)
+	require.True(exists)
+
+	exists, err = tx.Exists(manifests.ID, entry.ObjectID)
+	require.NoError(err)
+	require.True(exists)
+
+	// Read-only transactions cannot write.
+	err = tx.Update(datasets.ID, record, []byte("update"), nil)
+	require.ErrorIs(err, errors.ErrReadOnlyTx)
+	require.NoError(tx.Rollback())
+
+	// If any write fails the whole set of writes is rolled back.
+	tx, err = s.store.Begin(context.Background(), nil)
+	require.NoError(err)
+
+	require.NoError(tx.Update(datasets.ID, record, []byte("record-2"), nil))
+	require.NoError(tx.Delete(datasets.ID, record.Key(), nil))
+	err = tx.Merge(manifests.ID, entry, []byte("entry-2"), &opts.WriteOptions{NoOverwrite: true})
+	require.ErrorIs(err, errors.ErrAlreadyExists)
+	require.NoError(tx.Rollback())
+
+	tx, err = s.store.Begin(context.Background(), &store.TxOptions{ReadOnly: true})
+	require.NoError(err)
+	defer tx.Rollback()
+
+	exists, err = tx.Exists(datasets.ID, record.ObjectID)
+	require.NoError(err)
+	require.True(exists, "expected delete to be rolled back")
+
+	c, err := tx.Collection(datasets.ID)
+	require.NoError(err)
+	require.Equal(1, s.countVersions(c, record.ObjectID), "expected update to be rolled back")
+
+	has, err := tx.Has(manifests.ID, ulid.MakeSecure())
+	require.NoError(err)
+	require.False(has)
+
+	_, err = tx.Has("does_not_exist", record.ObjectID)
+	require.ErrorIs(err, errors.ErrNoCollection)
+
+	// Closed transactions cannot write.
+	require.NoError(tx.Rollback())
+	err = tx.Create(datasets.ID, &metadata.Metadata{}, []byte("closed"), nil)
+	require.ErrorIs(err, errors.ErrTxClosed)
+}
+
+func (s *honuTestSuite) TestTxIndexes() {
+	require := s.Require()
+
+	// Each collection has an indexed field; the dataset name is unique.
+	indexed := func(idx *metadata.Index) *metadata.Collection {
+		info := &metadata.Collection{
+			Name:    "test_" + strings.ToLower(ulid.Make().String()),
+			Indexes: []*metadata.Index{idx},
+		}
+		require.NoError(s.store.New(context.Background(), info))
+		return info
+	}
+
+	datasets := indexed(&metadata.Index{Name: "by_name", Type: metadata.UNIQUE, Field: &metadata.Field{Name: "name", Type: metadata.StringField}})
+	manifests := indexed(&metadata.Index{Name: "by_dataset", Type: metadata.INDEX, Field: &metadata.Field{Name: "dataset.id", Type: metadata.ULIDField}})
+
+	// Returns the number of entries in the index of the collection.
+	entries := func(info *metadata.Collection) uint64 {
+		tx, c := s.openCollection(info.ID, true)
+		defer tx.Rollback()
+
+		stats, err := c.Stats()
+		require.NoError(err)
+		require.Len(stats.Indexes, 1)
+		return stats.Indexes[0].Entries
+	}
+
+	// The record and its manifest entries are indexed in the same commit.
+	tx, err := s.store.Begin(context.Background(), nil)
+	require.NoError(err)
+
+	record, first, second := &metadata.Metadata{}, &metadata.Metadata{}, &metadata.Metadata{}
+	require.NoError(tx.Create(datasets.ID, record, []byte(`{"name": "census"}`), nil))
+	manifest := fmt.Sprintf(`{"dataset": {"id": %q}}`, record.ObjectID)
+	require.NoError(tx.Create(manifests.ID, first, []byte(manifest), nil))
+	require.NoError(tx.Create(manifests.ID, second, []byte(manifest), nil))
+	require.NoError(tx.Commit())
+
+	require.Equal(uint64(1), entries(datasets))
+	require.Equal(uint64(2), entries(manifests))
+
+	// Writes that are rolled back do not change the indexes.
+	tx, err = s.store.Begin(context.Background(), nil)
+	require.NoError(err)
+	require.NoError(tx.Create(datasets.ID, &metadata.Metadata{}, []byte(`{"name": "survey"}`), nil))
+	require.NoError(tx.Delete(manifests.ID, first.Key(), nil))
+	require.NoError(tx.Rollback())
+
+	require.Equal(uint64(1), entries(datasets))
+	require.Equal(uint64(2), entries(manifests))
+
+	// Another object cannot have the same value of a unique field.
+	tx, err = s.store.Begin(context.Background(), nil)
+	require.NoError(err)
+	err = tx.Create(datasets.ID, &metadata.Metadata{}, []byte(`{"name": "census"}`), nil)
+	require.ErrorIs(err, errors.ErrUniqueIndex)
+	require.NoError(tx.Rollback())
+
+	// Updates replace the entries of the previous version and deletes remove them.
+	tx, err = s.store.Begin(context.Background(), nil)
+	require.NoError(err)
+	require.NoError(tx.Update(datasets.ID, record, []byte(`{"name": "census-2020"}`), nil))
+	require.NoError(tx.Create(datasets.ID, &metadata.Metadata{}, []byte(`{"name": "census"}`), nil))
+	require.NoError(tx.Delete(manifests.ID, first.Key(), nil))
+	require.NoError(tx.Update(manifests.ID, second, []byte(`{"dataset": {}}`), nil))
+	require.NoError(tx.Commit())
+
+	require.Equal(uint64(2), entries(datasets))
+	require.Equal(uint64(0), entries(manifests))
+
+	// The entries refer to live objects in the collection.
+	require.Empty(s.problems(context.Background(), false, datasets.ID, manifests.ID))
+
+	// Indexes added to a collection with objects are built from the existing objects.
+	datasets.Indexes = append(datasets.Indexes, &metadata.Index{Name: "by_year", Type: metadata.INDEX, Field: &metadata.Field{Name: "year", Type: metadata.IntField}})
+	tx, err = s.store.Begin(context.Background(), nil)
+	require.NoError(err)
+	require.NoError(tx.Update(datasets.ID, record, []byte(`{"name": "census-2020", "year": 2020}`), nil))
+	require.NoError(tx.Commit())
+	require.NoError(s.store.Modify(context.Background(), datasets))
+
+	tx, c := s.openCollection(datasets.ID, true)
+	stats, err := c.Stats()
+	require.NoError(err)
+	require.NoError(tx.Rollback())
+	require.Len(stats.Indexes, 2)
+	require.Equal(uint64(2), stats.Indexes[0].Entries)
+	require.Equal(uint64(1), stats.Indexes[1].Entries)
+}
+
+func (s *honuTestSuite) TestTxCanceled() {
+	require := s.Require()
+	info := s.createCollection()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.store.Begin(canceled, nil)
+	require.ErrorIs(err, context.Canceled)
+
+	// Writes are not committed if the context is canceled during the transaction.
+	ctx, cancel := context.WithCancel(context.Background())
+	tx, err := s.store.Begin(ctx, nil)
+	require.NoError(err)
+
+	c, err := tx.Collection(info.ID)
+	require.NoError(err)
+
+	alpha := &metadata.Metadata{}
+	require.NoError(c.Create(alpha, []byte("alpha"), nil))
+
+	cancel()
+	require.ErrorIs(c.Create(&metadata.Metadata{}, []byte("bravo"), nil), context.Canceled)
+	_, err = tx.Collection(info.ID)
+	require.ErrorIs(err, context.Canceled)
+	require.ErrorIs(tx.Commit(), context.Canceled)
+
+	tx, c = s.openCollection(info.ID, false)
+	require.False(c.Has(alpha.ObjectID), "expected canceled transaction to be rolled back")
+	for i := 0; i < 5; i++ {
+		require.NoError(c.Create(&metadata.Metadata{}, []byte("object"), nil))
+	}
+	require.NoError(tx.Commit())
+
+	// Iterators stop and report the error of the context when it is canceled.
+	ctx, cancel = context.WithCancel(context.Background())
+	tx, err = s.store.Begin(ctx, &store.TxOptions{ReadOnly: true})
+	require.NoError(err)
+
+	c, err = tx.Collection(info.ID)
+	require.NoError(err)
+
+	iter := c.List()
+	require.True(iter.Next())
+	cancel()
+	require.False(iter.Next())
+	require.ErrorIs(iter.Error(), context.Canceled)
+	iter.Release()
+
+	_, err = c.Stats()
+	require.ErrorIs(err, context.Canceled)
+	require.NoError(tx.Rollback())
+
+	// Long running store operations are not applied with a canceled context.
+	require.ErrorIs(s.store.Truncate(canceled, info.ID), context.Canceled)
+	require.ErrorIs(s.store.Drop(canceled, info.ID), context.Canceled)
+	require.ErrorIs(s.store.Empty(canceled, info.ID, nil), context.Canceled)
+
+	tx, c = s.openCollection(info.ID, true)
+	n := 0
+	iter = c.List()
+	for iter.Next() {
+		n++
+	}
+	require.NoError(iter.Error())
+	iter.Release()
+	require.NoError(tx.Rollback())
+	require.Equal(5, n)
+
+	// An empty job that is canceled between batches can be resumed.
+	ctx, cancel = context.WithCancel(context.Background())
+	err = s.store.Empty(ctx, info.ID, &store.EmptyOptions{
+		BatchSize: 2,
+		Progress:  func(store.EmptyProgress) { cancel() },
+	})
+	require.ErrorIs(err, context.Canceled)
+
+	var progress []store.EmptyProgress
+	require.NoError(s.store.Empty(context.Background(), info.ID, &store.EmptyOptions{
+		BatchSize: 2,
+		Progress:  func(p store.EmptyProgress) { progress = append(progress, p) },
+	}))
+	require.Equal(uint64(5), progress[len(progress)-1].Tombstoned)
+}
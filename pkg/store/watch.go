@@ -0,0 +1,465 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/store/engine"
+	"go.rtnl.ai/honu/pkg/store/keys"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/honu/pkg/store/object"
+	"go.rtnl.ai/ulid"
+)
+
+// The key of the nested bucket in each collection that holds the change log of the
+// collection. Every version that is written to the collection is appended to the log
+// under the next sequence number of the bucket, so the log is a durable, ordered record
+// of the changes to the collection that watchers can replay and resume from.
+//
+// The change index maps the key of each version to the sequence of its entry in the
+// change log so that the entry is removed when the version is physically deleted from
+// the collection (e.g. by compaction, truncation, or garbage collection); the log only
+// ever holds entries for versions that are still stored in the collection.
+//
+// The removal log records the keys of the versions that were physically deleted from
+// the collection under the sequence of the change log at the time of the deletion, so
+// that incremental backups can find the versions deleted since a previous backup.
+// Watchers only read the change log, so the removals before the last backup are pruned
+// when the store is compacted.
+var (
+	changesBucket     = []byte{0x00, 0x68, 0x6f, 0x6e, 0x75, 0x06, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x6c, 0x6f, 0x67, 0x00}
+	changeIndexBucket = []byte{0x00, 0x68, 0x6f, 0x6e, 0x75, 0x06, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x69, 0x64, 0x78, 0x00}
+	removalsBucket    = []byte{0x00, 0x68, 0x6f, 0x6e, 0x75, 0x06, 0x72, 0x65, 0x6d, 0x6f, 0x76, 0x61, 0x6c, 0x73, 0x00, 0x00}
+)
+
+// The maximum number of changes read from the change log in a single read transaction;
+// changes are buffered in memory so that no transaction is held open while a watcher
+// waits for its consumer.
+const watchBatchSize = 256
+
+//===========================================================================
+// Change Feed
+//===========================================================================
+
+// ChangeType describes how an object was modified by a change.
+type ChangeType uint8
+
+const (
+	UnknownChange ChangeType = iota
+	Created                  // A new object was created (or recreated after truncation)
+	Updated                  // A new version of an existing object was written
+	Deleted                  // The object was deleted by a tombstone version
+	Truncated                // The object and all of its versions were destroyed
+)
+
+var changeTypeNames = [5]string{"UNKNOWN", "CREATED", "UPDATED", "DELETED", "TRUNCATED"}
+
+func (t ChangeType) String() string {
+	if int(t) < len(changeTypeNames) {
+		return changeTypeNames[t]
+	}
+	return changeTypeNames[UnknownChange]
+}
+
+// Change is a committed modification of an object in a collection. The sequence is
+// assigned when the change is committed and is strictly increasing within the
+// collection; it can be passed to Store.Watch to resume watching after the change.
+type Change struct {
+	Sequence uint64
+	Type     ChangeType
+	Key      keys.Key
+	Metadata *metadata.Metadata
+}
+
+// Watch returns a watcher that delivers the committed changes to the objects in the
+// specified collection (by name or ID) in the order they were committed, starting with
+// the first change whose sequence is greater than from. Pass 0 to replay the entire
+// change log of the collection or the sequence of the last change that was processed
+// to resume watching, e.g. after a restart.
+//
+// Changes are delivered for every version written to the collection, including
+// replicated versions, tombstones, and truncated records. Like a compacted log, the
+// entries of versions that have been physically removed from the collection are dropped
+// from the change log, so a watcher that resumes from an earlier sequence only receives
+// the changes whose versions are still stored (e.g. the truncated record of an object
+// rather than the versions it replaced). The watcher must be closed
+// when it is no longer needed; it is closed automatically if the store is closed, the
+// collection is dropped, or the context is canceled, in which case Err returns the
+// reason the watcher stopped.
+func (s *Store) Watch(ctx context.Context, collection any, from uint64) (w *Watcher, err error) {
+	if s.db == nil {
+		return nil, errors.ErrClosed
+	}
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var collectionID ulid.ULID
+	if err = s.view(ctx, func(tx engine.Tx) (err error) {
+		collections := tx.Bucket(SystemCollections[:])
+		if collectionID, err = resolveCollection(collections, collection); err != nil {
+			return err
+		}
+
+		_, err = latestCollection(collections, collectionID)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	// The watcher is registered under the same lock that Close takes before waiting for
+	// the background routines so that it is never started after the store is closed.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.feed == nil || s.feed.stopped() {
+		return nil, errors.ErrClosed
+	}
+
+	w = &Watcher{
+		ctx:          ctx,
+		store:        s,
+		feed:         s.feed,
+		collectionID: collectionID,
+		sequence:     from,
+		changes:      make(chan *Change, watchBatchSize),
+		done:         make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+// Watcher delivers the changes to a collection on a channel; see Store.Watch.
+type Watcher struct {
+	ctx          context.Context
+	store        *Store
+	feed         *feed
+	collectionID ulid.ULID
+	sequence     uint64
+	changes      chan *Change
+	done         chan struct{}
+	once         sync.Once
+	err          error
+}
+
+// Changes returns the channel that changes are delivered on. The channel is closed when
+// the watcher stops, after which Err reports why the watcher stopped (if not closed).
+func (w *Watcher) Changes() <-chan *Change {
+	return w.changes
+}
+
+// Err returns the error that stopped the watcher, if any. It must only be called after
+// the changes channel has been closed.
+func (w *Watcher) Err() error {
+	return w.err
+}
+
+// Close stops the watcher; no more changes are delivered once Close returns, although
+// changes that were already buffered may still be read from the channel.
+func (w *Watcher) Close() error {
+	w.once.Do(func() { close(w.done) })
+	return nil
+}
+
+// Reads batches of changes from the change log and delivers them until the watcher
+// or the store is closed or the context of the watcher is canceled, waiting for new
+// changes to be committed when caught up.
+func (w *Watcher) run() {
+	defer w.store.wg.Done()
+	defer close(w.changes)
+
+	for {
+		// Get the signal before reading so that a commit during the read is not missed.
+		signal := w.feed.wait()
+
+		changes, err := w.fetch()
+		if err != nil {
+			w.err = err
+			return
+		}
+
+		for _, change := range changes {
+			select {
+			case w.changes <- change:
+				w.sequence = change.Sequence
+			case <-w.done:
+				return
+			case <-w.ctx.Done():
+				w.err = w.ctx.Err()
+				return
+			case <-w.feed.closed():
+				w.err = errors.ErrClosed
+				return
+			}
+		}
+
+		// Only wait for the next commit once the watcher has caught up.
+		if len(changes) == watchBatchSize {
+			continue
+		}
+
+		select {
+		case <-signal:
+		case <-w.done:
+			return
+		case <-w.ctx.Done():
+			w.err = w.ctx.Err()
+			return
+		case <-w.feed.closed():
+			w.err = errors.ErrClosed
+			return
+		}
+	}
+}
+
+// Reads the next batch of changes after the current sequence from the change log.
+func (w *Watcher) fetch() (changes []*Change, err error) {
+	err = w.store.view(w.ctx, func(tx engine.Tx) (err error) {
+		var bkt engine.Bucket
+		if bkt = tx.Bucket(w.collectionID[:]); bkt == nil {
+			return errors.ErrNoCollection
+		}
+
+		var log engine.Bucket
+		if log = bkt.Bucket(changesBucket); log == nil {
+			return nil
+		}
+
+		cursor := log.Cursor()
+		for key, value := cursor.Seek(sequenceKey(w.sequence + 1)); key != nil && len(changes) < watchBatchSize; key, value = cursor.Next() {
+			var change *Change
+			if change, err = decodeChange(key, value); err != nil {
+				return err
+			}
+			changes = append(changes, change)
+		}
+		return nil
+	})
+	return changes, err
+}
+
+//===========================================================================
+// Change Log
+//===========================================================================
+
+// Appends the version described by the metadata to the change log of the collection.
+// The change is only visible to watchers once the transaction is committed.
+func (c *Collection) record(meta *metadata.Metadata) (err error) {
+	var log engine.Bucket
+	if log, err = c.bkt.CreateBucketIfNotExists(changesBucket); err != nil {
+		return fmt.Errorf("could not create change log bucket: %w", err)
+	}
+
+	var sequence uint64
+	if sequence, err = log.NextSequence(); err != nil {
+		return fmt.Errorf("could not assign change sequence: %w", err)
+	}
+
+	// The change log only holds the metadata of the version, not the object data.
+	var entry object.Object
+	if entry, err = object.Marshal(meta, nil); err != nil {
+		return fmt.Errorf("could not marshal change: %w", err)
+	}
+
+	if err = log.Put(sequenceKey(sequence), entry); err != nil {
+		return fmt.Errorf("could not record change: %w", err)
+	}
+
+	var index engine.Bucket
+	if index, err = c.bkt.CreateBucketIfNotExists(changeIndexBucket); err != nil {
+		return fmt.Errorf("could not create change index bucket: %w", err)
+	}
+
+	if err = index.Put(keys.New(meta.ObjectID, &meta.Version.Scalar), sequenceKey(sequence)); err != nil {
+		return fmt.Errorf("could not index change: %w", err)
+	}
+	return nil
+}
+
+// Removes the change log entry of the version with the specified key when the version
+// is physically deleted from the collection.
+func (c *Collection) forget(key []byte) (err error) {
+	var index engine.Bucket
+	if index = c.bkt.Bucket(changeIndexBucket); index == nil {
+		return nil
+	}
+
+	var sequence []byte
+	if sequence = index.Get(key); sequence == nil {
+		return nil
+	}
+
+	if log := c.bkt.Bucket(changesBucket); log != nil {
+		if err = log.Delete(sequence); err != nil {
+			return fmt.Errorf("could not remove change: %w", err)
+		}
+	}
+
+	if err = index.Delete(key); err != nil {
+		return fmt.Errorf("could not remove change index: %w", err)
+	}
+	return nil
+}
+
+// Returns the sequence of the change log entry of the version with the specified key,
+// or zero if the version does not have an entry in the change log.
+func (c *Collection) sequence(key []byte) uint64 {
+	if index := c.bkt.Bucket(changeIndexBucket); index != nil {
+		if sequence := index.Get(key); len(sequence) == 8 {
+			return binary.BigEndian.Uint64(sequence)
+		}
+	}
+	return 0
+}
+
+// Appends the key of a version that was deleted from the collection to the removal log.
+// Removals are keyed by the current sequence of the change log followed by the version
+// key, so all of the removals since a change log sequence are found by seeking to it.
+func (c *Collection) remove(key []byte) (err error) {
+	var removals engine.Bucket
+	if removals, err = c.bkt.CreateBucketIfNotExists(removalsBucket); err != nil {
+		return fmt.Errorf("could not create removal log bucket: %w", err)
+	}
+
+	var sequence uint64
+	if log := c.bkt.Bucket(changesBucket); log != nil {
+		sequence = log.Sequence()
+	}
+
+	if err = removals.Put(append(sequenceKey(sequence), key...), key); err != nil {
+		return fmt.Errorf("could not record removal: %w", err)
+	}
+	return nil
+}
+
+// Prunes the removals recorded before the specified change log sequence of a backup;
+// they are not needed by the next incremental backup, which only reads the removals
+// since the vector of the backup. The sequence of the removal log bucket records the
+// sequence that the log was pruned to so that an incremental backup from an earlier
+// vector fails rather than silently missing removals.
+func (c *Collection) pruneRemovals(sequence uint64) (err error) {
+	var removals engine.Bucket
+	if removals = c.bkt.Bucket(removalsBucket); removals == nil || removals.Sequence() >= sequence {
+		return nil
+	}
+
+	// Collect the keys first since deleting while iterating with a bolt cursor will cause
+	// keys to be skipped.
+	var stale [][]byte
+	upto := sequenceKey(sequence)
+	cursor := removals.Cursor()
+	for key, _ := cursor.First(); key != nil && bytes.Compare(key, upto) < 0; key, _ = cursor.Next() {
+		stale = append(stale, bytes.Clone(key))
+	}
+
+	for _, key := range stale {
+		if err = removals.Delete(key); err != nil {
+			return fmt.Errorf("could not prune removal: %w", err)
+		}
+	}
+
+	if err = removals.SetSequence(sequence); err != nil {
+		return fmt.Errorf("could not prune removal log: %w", err)
+	}
+	return nil
+}
+
+func decodeChange(key, value []byte) (change *Change, err error) {
+	change = &Change{Sequence: binary.BigEndian.Uint64(key)}
+	if change.Metadata, err = object.Object(value).Metadata(); err != nil {
+		return nil, fmt.Errorf("could not parse change %d: %w", change.Sequence, err)
+	}
+
+	if change.Metadata.Version == nil {
+		return nil, fmt.Errorf("could not parse change %d: %w", change.Sequence, errors.ErrMissingVersion)
+	}
+
+	change.Key = keys.New(change.Metadata.ObjectID, &change.Metadata.Version.Scalar)
+	switch change.Metadata.Kind() {
+	case metadata.TOMBSTONE:
+		change.Type = Deleted
+	case metadata.TRUNCATED:
+		change.Type = Truncated
+	default:
+		if change.Metadata.Version.Parent == nil {
+			change.Type = Created
+		} else {
+			change.Type = Updated
+		}
+	}
+	return change, nil
+}
+
+func sequenceKey(sequence uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, sequence)
+	return key
+}
+
+//===========================================================================
+// Commit Notifications
+//===========================================================================
+
+// A feed broadcasts a signal to all waiting watchers whenever a write transaction is
+// committed to the store. Each signal is a channel that is closed on notify and then
+// replaced, so any number of watchers can wait on the same signal.
+type feed struct {
+	sync.Mutex
+	signal chan struct{}
+	done   chan struct{}
+}
+
+func newFeed() *feed {
+	return &feed{
+		signal: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Returns the channel that is closed on the next notification.
+func (f *feed) wait() <-chan struct{} {
+	f.Lock()
+	defer f.Unlock()
+	return f.signal
+}
+
+// Wakes all of the watchers that are waiting for a commit.
+func (f *feed) notify() {
+	f.Lock()
+	defer f.Unlock()
+	close(f.signal)
+	f.signal = make(chan struct{})
+}
+
+// Returns the channel that is closed when the store is closed.
+func (f *feed) closed() <-chan struct{} {
+	return f.done
+}
+
+// Returns true if the store has been closed and the watchers stopped.
+func (f *feed) stopped() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stops all watchers; must only be called once when the store is closed.
+func (f *feed) close() {
+	close(f.done)
+}
+
+// Wakes any watchers after the store commits a write transaction outside of a Tx.
+func (s *Store) notify() {
+	if s.feed != nil {
+		s.feed.notify()
+	}
+}
@@ -0,0 +1,250 @@
+package store_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.rtnl.ai/honu/pkg/config"
+	"go.rtnl.ai/honu/pkg/errors"
+	"go.rtnl.ai/honu/pkg/region"
+	"go.rtnl.ai/honu/pkg/store"
+	"go.rtnl.ai/honu/pkg/store/lamport"
+	"go.rtnl.ai/honu/pkg/store/metadata"
+	"go.rtnl.ai/ulid"
+)
+
+func (s *honuTestSuite) TestWatch() {
+	require := s.Require()
+	info := s.createCollection()
+
+	// Changes committed before the watcher is started are replayed.
+	tx, c := s.openCollection(info.ID, false)
+	alpha, bravo := &metadata.Metadata{}, &metadata.Metadata{}
+	require.NoError(c.Create(alpha, []byte("alpha-1"), nil))
+	require.NoError(c.Update(alpha, []byte("alpha-2"), nil))
+	require.NoError(c.Create(bravo, []byte("bravo-1"), nil))
+	require.NoError(tx.Commit())
+
+	w, err := s.store.Watch(context.Background(), info.Name, 0)
+	require.NoError(err)
+	defer w.Close()
+
+	change := receive(s.T(), w)
+	require.Equal(uint64(1), change.Sequence)
+	require.Equal(store.Created, change.Type)
+	require.Equal(alpha.ObjectID, change.Metadata.ObjectID)
+	require.Equal(alpha.ObjectID, change.Key.ObjectID())
+	require.Equal(uint64(1), change.Key.Version().VID)
+
+	change = receive(s.T(), w)
+	require.Equal(uint64(2), change.Sequence)
+	require.Equal(store.Updated, change.Type)
+	require.Equal(alpha.Version.Scalar, change.Key.Version())
+
+	change = receive(s.T(), w)
+	require.Equal(uint64(3), change.Sequence)
+	require.Equal(store.Created, change.Type)
+	require.Equal(bravo.ObjectID, change.Metadata.ObjectID)
+
+	// Changes committed after the watcher has caught up are delivered.
+	tx, c = s.openCollection(info.ID, false)
+	require.NoError(c.Delete(alpha.Key(), nil))
+	require.NoError(c.Destroy(bravo.Key()))
+	require.NoError(tx.Commit())
+
+	change = receive(s.T(), w)
+	require.Equal(uint64(4), change.Sequence)
+	require.Equal(store.Deleted, change.Type)
+	require.True(change.Metadata.IsTombstone())
+
+	change = receive(s.T(), w)
+	require.Equal(uint64(5), change.Sequence)
+	require.Equal(store.Truncated, change.Type)
+	require.Equal(bravo.ObjectID, change.Key.ObjectID())
+
+	// Uncommitted changes are not delivered.
+	tx, c = s.openCollection(info.ID, false)
+	require.NoError(c.Create(&metadata.Metadata{}, []byte("rolled back"), nil))
+	require.NoError(tx.Rollback())
+
+	// Changes made by store operations are delivered.
+	require.NoError(s.store.Empty(context.Background(), info.ID, nil))
+	tx, c = s.openCollection(info.ID, false)
+	charlie := &metadata.Metadata{}
+	require.NoError(c.Create(charlie, []byte("charlie-1"), nil))
+	require.NoError(tx.Commit())
+
+	change = receive(s.T(), w)
+	require.Equal(uint64(6), change.Sequence)
+	require.Equal(charlie.ObjectID, change.Metadata.ObjectID)
+
+	// Truncating the collection truncates the deleted object as well as the live one.
+	require.NoError(s.store.Truncate(context.Background(), info.ID))
+	var truncated []ulid.ULID
+	for i := uint64(7); i <= 8; i++ {
+		change = receive(s.T(), w)
+		require.Equal(i, change.Sequence)
+		require.Equal(store.Truncated, change.Type)
+		truncated = append(truncated, change.Metadata.ObjectID)
+	}
+	require.ElementsMatch([]ulid.ULID{alpha.ObjectID, charlie.ObjectID}, truncated)
+
+	// Closing the watcher closes the channel without an error.
+	require.NoError(w.Close())
+	closed(s.T(), w)
+	require.NoError(w.Err())
+
+	// Watching from a sequence resumes after that change.
+	w, err = s.store.Watch(context.Background(), info.ID, 6)
+	require.NoError(err)
+	defer w.Close()
+
+	require.Equal(uint64(7), receive(s.T(), w).Sequence)
+	require.Equal(uint64(8), receive(s.T(), w).Sequence)
+
+	// Canceling the context of a watcher stops it.
+	ctx, cancel := context.WithCancel(context.Background())
+	cw, err := s.store.Watch(ctx, info.ID, 8)
+	require.NoError(err)
+	defer cw.Close()
+
+	cancel()
+	closed(s.T(), cw)
+	require.ErrorIs(cw.Err(), context.Canceled)
+
+	_, err = s.store.Watch(ctx, info.ID, 0)
+	require.ErrorIs(err, context.Canceled)
+
+	// Dropping the collection stops the watcher.
+	require.NoError(s.store.Drop(context.Background(), info.ID))
+	closed(s.T(), w)
+	require.ErrorIs(w.Err(), errors.ErrNoCollection)
+
+	_, err = s.store.Watch(context.Background(), info.ID, 0)
+	require.ErrorIs(err, errors.ErrNoCollection)
+
+	_, err = s.store.Watch(context.Background(), ulid.Make(), 0)
+	require.ErrorIs(err, errors.ErrNoCollection)
+}
+
+func (s *honuTestSuite) TestWatchCompacted() {
+	require := s.Require()
+	info := s.createCollection()
+	info.Retention = &metadata.Retention{Policy: metadata.KEEP_VERSIONS, Versions: 1}
+	require.NoError(s.store.Modify(context.Background(), info))
+
+	tx, c := s.openCollection(info.ID, false)
+	alpha, bravo := &metadata.Metadata{}, &metadata.Metadata{}
+	require.NoError(c.Create(alpha, []byte("alpha-1"), nil))
+	require.NoError(c.Update(alpha, []byte("alpha-2"), nil))
+	require.NoError(c.Update(alpha, []byte("alpha-3"), nil))
+	require.NoError(c.Create(bravo, []byte("bravo-1"), nil))
+	require.NoError(c.Destroy(bravo.Key()))
+	require.NoError(tx.Commit())
+
+	// The changes of the versions removed by compaction and truncation are dropped from
+	// the change log so that the log does not grow without bound.
+	pruned, err := s.store.Compact(context.Background())
+	require.NoError(err)
+	require.Equal(2, pruned)
+
+	w, err := s.store.Watch(context.Background(), info.ID, 0)
+	require.NoError(err)
+	defer w.Close()
+
+	change := receive(s.T(), w)
+	require.Equal(uint64(3), change.Sequence)
+	require.Equal(alpha.Version.Scalar, change.Key.Version())
+
+	change = receive(s.T(), w)
+	require.Equal(uint64(5), change.Sequence)
+	require.Equal(store.Truncated, change.Type)
+	require.Equal(bravo.ObjectID, change.Key.ObjectID())
+}
+
+func TestWatchResume(t *testing.T) {
+	lamport.SetProcessID(8)
+	region.SetProcessRegion(region.TESTING)
+
+	conf := config.Config{
+		PID: uint32(8),
+		Store: config.StoreConfig{
+			DataPath:    filepath.Join(t.TempDir(), "honu-test.db"),
+			Concurrency: 16,
+		},
+	}
+
+	db, err := store.Open(conf)
+	require.NoError(t, err, "could not open store")
+
+	info := &metadata.Collection{Name: "watched"}
+	require.NoError(t, db.New(context.Background(), info), "could not create collection")
+
+	write := func(db *store.Store, n int) {
+		tx, err := db.Begin(context.Background(), nil)
+		require.NoError(t, err)
+		c, err := tx.Collection(info.ID)
+		require.NoError(t, err)
+
+		for i := 0; i < n; i++ {
+			require.NoError(t, c.Create(&metadata.Metadata{}, []byte("foo"), nil))
+		}
+		require.NoError(t, tx.Commit())
+	}
+
+	write(db, 3)
+	w, err := db.Watch(context.Background(), info.ID, 0)
+	require.NoError(t, err)
+
+	var last uint64
+	for i := 0; i < 2; i++ {
+		last = receive(t, w).Sequence
+	}
+
+	// Closing the store stops the watcher.
+	require.NoError(t, db.Close())
+	for range w.Changes() {
+	}
+	require.ErrorIs(t, w.Err(), errors.ErrClosed)
+
+	// The change log is durable, so the watcher can resume after a restart.
+	db, err = store.Open(conf)
+	require.NoError(t, err, "could not reopen store")
+	defer db.Close()
+	write(db, 1)
+
+	w, err = db.Watch(context.Background(), info.ID, last)
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.Equal(t, uint64(3), receive(t, w).Sequence)
+	require.Equal(t, uint64(4), receive(t, w).Sequence)
+}
+
+// Receives the next change from the watcher, failing the test if no change is
+// delivered within a second.
+func receive(t *testing.T, w *store.Watcher) *store.Change {
+	t.Helper()
+	select {
+	case change, ok := <-w.Changes():
+		require.True(t, ok, "watcher stopped unexpectedly: %v", w.Err())
+		return change
+	case <-time.After(time.Second):
+		require.FailNow(t, "timed out waiting for change")
+		return nil
+	}
+}
+
+// Asserts that the watcher's channel is closed without delivering any more changes.
+func closed(t *testing.T, w *store.Watcher) {
+	t.Helper()
+	select {
+	case _, ok := <-w.Changes():
+		require.False(t, ok, "expected watcher to be closed")
+	case <-time.After(time.Second):
+		require.FailNow(t, "timed out waiting for watcher to close")
+	}
+}